@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -19,28 +20,92 @@ type Acceptor struct {
 
 	reuseAddr        bool // SO_REUSEADDR
 	reusePort        bool // SO_REUSEPORT
+	ipv6Only         bool // IPV6_V6ONLY
 	fd               int
 	sockRcvBufSize   int // ignore equal 0
 	listenBacklog    int
+	acceptEvents     uint32
 	loopAcceptTimes  int
+	evPollIndex      int    // -1 means fd % evPollNum
+	spareFd          int    // reserved for EMFILE, -1 if not reserved
+	udsPath          string // unlinked on close
+	sockOpts         func(fd int) error
+	rate             acceptBucket // AcceptRate
+	stopAfter        int          // AcceptWithTimeout
+	stopTimeout      int64
+	onStop           func(accepted int)
+	accepted         int
+	stopped          bool
+	stopTimer        TimerID
 	newEvHanlderFunc func() EvHandler
 	reactor          *Reactor
+	group            *ReactorGroup // not nil if created by ReactorGroup.NewAcceptor
 }
 
 // NewAcceptor return an acceptor
 //
 // New socket has been set to non-blocking
 func NewAcceptor(acceptorBindReactor *Reactor, newEvHanlderFunc func() EvHandler,
+	addr string, opts ...Option) (*Acceptor, error) {
+	return newAcceptor(acceptorBindReactor, -1, newEvHanlderFunc, addr, opts...)
+}
+
+// NewReusePortAcceptors opens one SO_REUSEPORT listener per evpoll of the reactor, each listener
+// is registered on its own evpoll, so that the kernel spreads connections over all evpolls.
+//
+// newEvHanlderFunc is shared by all listeners, the port 0 binds all of them to the ephemeral port
+// of the first one. Requires kernel >= 3.9
+func NewReusePortAcceptors(r *Reactor, newEvHanlderFunc func() EvHandler,
+	addr string, opts ...Option) ([]*Acceptor, error) {
+	opts = append(opts, ReusePort(true))
+	acceptors := make([]*Acceptor, 0, r.evPollNum)
+	for i := 0; i < r.evPollNum; i++ {
+		a, err := newAcceptor(r, i, newEvHanlderFunc, addr, opts...)
+		if err != nil {
+			for _, a = range acceptors {
+				a.reactor.RemoveEvHandler(a, a.fd)
+				a.OnClose()
+			}
+			return nil, err
+		}
+		acceptors = append(acceptors, a)
+		if tcpAddr, ok := a.Addr().(*net.TCPAddr); i == 0 && ok {
+			addr = tcpAddr.String()
+		}
+	}
+	return acceptors, nil
+}
+
+func newAcceptor(acceptorBindReactor *Reactor, evPollIndex int, newEvHanlderFunc func() EvHandler,
 	addr string, opts ...Option) (*Acceptor, error) {
 	evOptions := setOptions(opts...)
 	a := &Acceptor{
 		fd:               -1,
+		spareFd:          -1,
+		evPollIndex:      evPollIndex,
 		reactor:          acceptorBindReactor,
 		newEvHanlderFunc: newEvHanlderFunc,
 		listenBacklog:    evOptions.listenBacklog,
 		sockRcvBufSize:   evOptions.sockRcvBufSize,
 		reuseAddr:        evOptions.reuseAddr,
 		reusePort:        evOptions.reusePort,
+		ipv6Only:         evOptions.ipv6Only,
+		sockOpts:         evOptions.acceptSockOpts,
+		rate: acceptBucket{
+			rate:   float64(evOptions.acceptRate),
+			burst:  float64(evOptions.acceptBurst),
+			tokens: float64(evOptions.acceptBurst),
+		},
+		stopAfter:   evOptions.stopAfter,
+		stopTimeout: evOptions.stopTimeout,
+		onStop:      evOptions.onStop,
+	}
+	a.acceptEvents = EvAccept
+	if evOptions.acceptExclusive == true && epollExclusiveSupported() {
+		a.acceptEvents = EvAcceptExclusive
+	}
+	if evOptions.acceptET == true {
+		a.acceptEvents |= EPOLLET
 	}
 	a.loopAcceptTimes = a.listenBacklog / 2
 	if a.loopAcceptTimes < 1 {
@@ -49,31 +114,63 @@ func NewAcceptor(acceptorBindReactor *Reactor, newEvHanlderFunc func() EvHandler
 	if err := a.open(addr); err != nil {
 		return nil, err
 	}
+	a.reserveSpareFd()
+	if a.stopTimeout > 0 { // the timer of evpoll is scheduled within its coroutine
+		a.getEvPoll().push(asyncWriteItem{fd: a.fd, fn: a.startStopTimer})
+	}
 	return a, nil
 }
 
 // open create a listen fd
-// The addr format 192.168.0.1:8080 or :8080 or unix:/tmp/xxxx.sock
+// The addr format 192.168.0.1:8080 or :8080 or [::]:8080 or unix:/tmp/xxxx.sock or unix:///tmp/xxxx.sock,
+// or @name (unix:@name) for the Linux abstract namespace
 func (a *Acceptor) open(addr string) error {
+	if path, ok := parseUdsAddr(addr); ok {
+		return a.udsListen(path)
+	}
 	p := strings.Index(addr, ":")
 	if p < 0 || p >= (len(addr)-1) {
 		return errors.New("Accetor open param:addr invalid")
 	}
-	if len(addr) > 5 {
-		s := addr[0:5]
-		if s == "unix:" {
-			return a.udsListen(addr[5:])
-		}
-	}
 	return a.tcpListen(addr)
 }
 
-// The addr format 192.168.0.1:8080 or :8080
+// parseUdsAddr returns the path of unix:/tmp/xxxx.sock or unix:///tmp/xxxx.sock, or @name of
+// @name or unix:@name. The leading '@' of a path is encoded as the null byte of an abstract
+// socket address by package syscall.
+func parseUdsAddr(addr string) (string, bool) {
+	if strings.HasPrefix(addr, "@") {
+		return addr, len(addr) > 1
+	}
+	if strings.HasPrefix(addr, "unix://") {
+		return addr[7:], len(addr) > 7
+	}
+	if strings.HasPrefix(addr, "unix:") {
+		return addr[5:], len(addr) > 5
+	}
+	return "", false
+}
+
+// The addr format 192.168.0.1:8080 or :8080 (IPv4), [::]:8080 or [::1]:8080 (IPv6)
 func (a *Acceptor) tcpListen(addr string) error {
-	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	sa, family, err := parseTCPAddr(addr)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM, 0)
 	if err != nil {
 		return errors.New("Socket in Acceptor.open: " + err.Error())
 	}
+	if family == syscall.AF_INET6 {
+		v6only := 0 // dual-stack, IPv4 is accepted as IPv4-mapped IPv6 address
+		if a.ipv6Only {
+			v6only = 1
+		}
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v6only); err != nil {
+			syscall.Close(fd)
+			return errors.New("Set IPV6_V6ONLY in Acceptor.open: " + err.Error())
+		}
+	}
 
 	if a.reuseAddr == true {
 		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
@@ -102,39 +199,51 @@ func (a *Acceptor) tcpListen(addr string) error {
 		}
 	}
 
-	ip := "0.0.0.0"
-	var port int64
-	ipp := strings.Split(addr, ":")
-	if len(ipp) != 2 {
+	if err := a.listen(fd, sa); err != nil {
 		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80 or :80")
+		return err
+	}
+	return nil
+}
+
+// parseTCPAddr returns the sockaddr and address family of 192.168.0.1:8080, :8080 (0.0.0.0:8080)
+// or [::]:8080, the port may be 0
+func parseTCPAddr(addr string) (syscall.Sockaddr, int, error) {
+	host, portS, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, errors.New("address is invalid! 192.168.1.1:80 or :80 or [::]:80")
 	}
-	if len(ipp[0]) > 0 {
-		ip = ipp[0]
+	port, err := strconv.ParseUint(portS, 10, 16) // 0 is an ephemeral port, see Acceptor.Addr
+	if err != nil {
+		return nil, 0, errors.New("port must in [0, 65536)")
 	}
-	ip4 := net.ParseIP(ip)
-	if ip4 == nil {
-		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80 or :80")
+	if host == "" {
+		host = "0.0.0.0"
 	}
-	port, _ = strconv.ParseInt(ipp[1], 10, 64)
-	if port < 1 || port > 65535 {
-		syscall.Close(fd)
-		return errors.New("port must in (0, 65536)")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, errors.New("address is invalid! 192.168.1.1:80 or :80 or [::]:80")
 	}
-	sa := syscall.SockaddrInet4{Port: int(port)}
-	copy(sa.Addr[:], ip4.To4())
-
-	if err := a.listen(fd, &sa); err != nil {
-		syscall.Close(fd)
-		return err
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(host, ":") {
+		sa := &syscall.SockaddrInet4{Port: int(port)}
+		copy(sa.Addr[:], ip4)
+		return sa, syscall.AF_INET, nil
 	}
-	return nil
+	sa := &syscall.SockaddrInet6{Port: int(port)}
+	copy(sa.Addr[:], ip.To16())
+	return sa, syscall.AF_INET6, nil
+}
+
+// isAbstractUds returns true if path is of the abstract namespace, which has no file to unlink
+func isAbstractUds(path string) bool {
+	return strings.HasPrefix(path, "@")
 }
 
-// The addr format /tmp/xxx.sock
+// The addr format /tmp/xxx.sock or @name
 func (a *Acceptor) udsListen(addr string) error {
-	os.RemoveAll(addr)
+	if !isAbstractUds(addr) {
+		os.RemoveAll(addr)
+	}
 
 	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
 	if err != nil {
@@ -147,10 +256,15 @@ func (a *Acceptor) udsListen(addr string) error {
 
 	rsu := syscall.SockaddrUnix{Name: addr}
 	if err = a.listen(fd, &rsu); err != nil {
-		os.RemoveAll(addr)
+		if !isAbstractUds(addr) {
+			os.RemoveAll(addr)
+		}
 		syscall.Close(fd)
 		return err
 	}
+	if !isAbstractUds(addr) {
+		a.udsPath = addr
+	}
 	return nil
 }
 
@@ -158,52 +272,303 @@ func (a *Acceptor) listen(fd int, sa syscall.Sockaddr) error {
 	if err := syscall.Bind(fd, sa); err != nil {
 		return errors.New("syscall bind: " + err.Error())
 	}
+	if max := somaxconn(); max > 0 && a.listenBacklog > max {
+		a.reactor.evPolls[0].logger.Warnf("goev: listen backlog %d exceeds net.core.somaxconn %d, "+
+			"truncated by the kernel", a.listenBacklog, max)
+	}
 	if err := syscall.Listen(fd, a.listenBacklog); err != nil {
 		return errors.New("syscall listen: " + err.Error())
 	}
 
-	if err := a.reactor.AddEvHandler(a, fd, EvAccept); err != nil {
+	if err := a.reactor.addEvHandlerTo(a.evPollIndex, a, fd, a.acceptEvents); err != nil {
 		return errors.New("AddEvHandler in Acceptor.Open: " + err.Error())
 	}
 	a.fd = fd
 	return nil
 }
 
+var (
+	somaxconnOnce sync.Once
+	somaxconnV    int
+)
+
+// somaxconn returns net.core.somaxconn, 0 if unknown
+func somaxconn() int {
+	somaxconnOnce.Do(func() {
+		bf, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+		if err != nil {
+			return
+		}
+		somaxconnV, _ = strconv.Atoi(strings.TrimSpace(string(bf)))
+	})
+	return somaxconnV
+}
+
 // OnRead handle listner accept event
 func (a *Acceptor) OnRead() bool {
-	for i := 0; i < a.loopAcceptTimes; i++ {
-		conn, _, err := syscall.Accept4(a.fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+	et := a.acceptEvents&EPOLLET != 0 // drain the backlog until EAGAIN
+	for i := 0; et || i < a.loopAcceptTimes; i++ {
+		if wait := a.rate.take(a.getEvPoll().nowMilli()); wait > 0 {
+			// out of tokens, stop accepting until the next one
+			if a.ScheduleTimer(a, wait, 0) == nil {
+				a.reactor.RemoveEvHandler(a, a.fd)
+			}
+			break
+		}
+		conn, _, err := accept(a.fd)
 		if err != nil {
-			if err == syscall.EINTR {
+			a.rate.refund()
+			if err == syscall.EINTR || err == syscall.ECONNABORTED {
 				continue
-			} else if err == syscall.EMFILE {
-				// The per-process limit on the number of open file descriptors has been reached
+			} else if err == syscall.EMFILE || err == syscall.ENFILE {
+				// The per-process (system-wide) limit on the number of open file descriptors has
+				// been reached, drop the connection with the spare fd, or back off instead of spinning
+				if a.dropOne() {
+					a.getEvPoll().logger.Warnf("goev: accept: %s, a connection is dropped", err.Error())
+					continue
+				}
+				a.getEvPoll().logger.Warnf("goev: accept: %s, back off 100ms", err.Error())
 				if a.ScheduleTimer(a, 100 /*msec*/, 0) == nil {
 					a.reactor.RemoveEvHandler(a, a.fd)
 				}
 			}
+			if err != syscall.EAGAIN {
+				a.getEvPoll().stats.acceptErrors.Add(1)
+			}
 			break
 		}
+		a.getEvPoll().stats.accepts.Add(1)
+		if a.getEvPoll().tracer != nil {
+			a.getEvPoll().trace(TraceAccepted, conn, ClosedByServer)
+		}
+		if a.sockOpts != nil && a.sockOpts(conn) != nil {
+			syscall.Close(conn)
+			continue
+		}
+		if !a.reactor.reserveConn() {
+			a.reject(conn)
+			continue
+		}
 		h := a.newEvHanlderFunc()
-		if h.OnOpen(conn) == false {
-			h.OnClose()
+		h.setConnCounter(&a.reactor.conns)
+		if a.group != nil {
+			h.setReactor(a.group.Next(conn))
+		}
+		a.openConn(h, conn)
+		if a.accepted++; a.stopAfter > 0 && a.accepted >= a.stopAfter {
+			a.stop()
+			break
 		}
 	}
 	return true
 }
 
+func (a *Acceptor) startStopTimer() {
+	if a.stopped {
+		return
+	}
+	a.stopTimer, _ = a.ScheduleTimerFunc(a, a.stopTimeout, 0, func(int64, any) bool {
+		a.stop()
+		return false
+	}, nil)
+}
+
+// stop removes and closes the listener, see AcceptWithTimeout
+func (a *Acceptor) stop() {
+	if a.stopped {
+		return
+	}
+	a.stopped = true
+	a.CancelTimerID(a.stopTimer)
+	a.CancelTimer(a) // backing off, see OnTimeout
+	if a.fd != -1 {
+		a.reactor.RemoveEvHandler(a, a.fd) // may have been removed while backing off
+	}
+	a.OnClose()
+	if a.onStop != nil {
+		a.onStop(a.accepted)
+	}
+}
+
+// accept returns a connection which is non-blocking and close-on-exec, set atomically by accept4,
+// or by fcntl where accept4 is unavailable (ENOSYS)
+func accept(fd int) (int, syscall.Sockaddr, error) {
+	conn, sa, err := syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+	if err != syscall.ENOSYS {
+		return conn, sa, err
+	}
+	// Hold ForkLock, so that conn is not inherited by a concurrent fork before FD_CLOEXEC is set
+	syscall.ForkLock.RLock()
+	conn, sa, err = syscall.Accept(fd)
+	if err == nil {
+		syscall.CloseOnExec(conn)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, nil, err
+	}
+	if err = syscall.SetNonblock(conn, true); err != nil {
+		syscall.Close(conn)
+		return -1, nil, err
+	}
+	return conn, sa, nil
+}
+
+// openConn calls OnOpen of the new connection, a panic of it does not affect the acceptor
+func (a *Acceptor) openConn(h EvHandler, conn int) {
+	ep := a.getEvPoll()
+	defer func() {
+		if !ep.panicRecovery {
+			return
+		}
+		if v := recover(); v != nil && !ep.onPanic(h, conn, v) {
+			h.releaseConn()
+			syscall.Close(conn) // not registered, OnClose can't be called
+		}
+	}()
+	if h.OnOpen(conn) == false {
+		h.releaseConn()
+		openFailed(h, conn)
+		return
+	}
+	if h.getEvPoll() == nil { // registered another handler instead, not counted then
+		h.releaseConn()
+	}
+	traceOpened(h, conn)
+}
+
+// openConnected passes the connected fd to eh, see Connector
+func openConnected(eh EvHandler, fd int) {
+	if eh.OnOpen(fd) == false {
+		openFailed(eh, fd)
+		return
+	}
+	traceOpened(eh, fd)
+}
+
+// openFailed cleans eh up after its OnOpen(fd) returned false, see EvHandler.OnOpen
+func openFailed(eh EvHandler, fd int) {
+	if ep := eh.getEvPoll(); ep != nil { // registered in OnOpen
+		if ed := ep.loadEvData(fd); ed != nil && ed.eh == eh {
+			if err := ep.remove(fd); err != nil {
+				ep.logger.Errorf("goev: close fd %d: %s", fd, err.Error())
+			}
+		}
+		ep.cancelTimer(eh)
+	}
+	eh.OnClose()
+}
+
+// reject closes conn beyond MaxConnections, after sending the reject message
+func (a *Acceptor) reject(conn int) {
+	if len(a.reactor.rejectMsg) > 0 {
+		syscall.Write(conn, a.reactor.rejectMsg) // non-blocking, best effort
+	}
+	syscall.Close(conn)
+	a.getEvPoll().stats.connRejects.Add(1)
+}
+
+// reserveSpareFd holds an fd, which is released to accept (and close) a connection when the fd
+// limit has been reached, otherwise the pending connection keeps the listener readable.
+func (a *Acceptor) reserveSpareFd() {
+	fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		fd = -1
+	}
+	a.spareFd = fd
+}
+
+// dropOne accepts a pending connection with the spare fd and closes it immediately
+func (a *Acceptor) dropOne() bool {
+	if a.spareFd == -1 {
+		return false
+	}
+	syscall.Close(a.spareFd)
+	conn, _, err := accept(a.fd)
+	if err == nil {
+		syscall.Close(conn)
+		a.getEvPoll().stats.fdLimitDrops.Add(1)
+	}
+	a.reserveSpareFd()
+	return err == nil
+}
+
+// acceptBucket is the token bucket of AcceptRate
+type acceptBucket struct {
+	rate   float64 // tokens per second, 0 means no limit
+	burst  float64
+	tokens float64
+	last   int64 // millisecond, the last refill
+}
+
+// take takes a token at now, returns 0 on success or the millisecond until the next token
+func (b *acceptBucket) take(now int64) int64 {
+	if b.rate <= 0 {
+		return 0
+	}
+	if b.last > 0 && now > b.last {
+		b.tokens += float64(now-b.last) * b.rate / 1000
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return int64((1-b.tokens)*1000/b.rate) + 1
+}
+
+// refund gives back the token taken for a failed accept
+func (b *acceptBucket) refund() {
+	if b.rate > 0 {
+		b.tokens++
+	}
+}
+
 // OnTimeout readd to evpoll
 func (a *Acceptor) OnTimeout(millisecond int64) bool {
 	if a.fd != -1 {
-		a.reactor.AddEvHandler(a, a.fd, EvAccept)
+		a.reactor.addEvHandlerTo(a.evPollIndex, a, a.fd, a.acceptEvents)
 	}
 	return false
 }
 
+// Addr returns the local address the listener is bound to (getsockname), e.g. to find out the port
+// chosen by the kernel for :0. It is a *net.TCPAddr or a *net.UnixAddr, nil if the acceptor has
+// been closed.
+func (a *Acceptor) Addr() net.Addr {
+	if a.fd == -1 {
+		return nil
+	}
+	sa, err := syscall.Getsockname(a.fd)
+	if err != nil {
+		return nil
+	}
+	switch v := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), v.Addr[:]...), Port: v.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), v.Addr[:]...), Port: v.Port}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: v.Name, Net: "unix"}
+	}
+	return nil
+}
+
 // OnClose will not happen
 func (a *Acceptor) OnClose() {
 	if a.fd != -1 {
 		syscall.Close(a.fd)
 		a.fd = -1
 	}
+	if a.udsPath != "" {
+		os.Remove(a.udsPath)
+		a.udsPath = ""
+	}
+	if a.spareFd != -1 {
+		syscall.Close(a.spareFd)
+		a.spareFd = -1
+	}
 }
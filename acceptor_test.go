@@ -0,0 +1,974 @@
+package goev
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shaovie/goev/netfd"
+	"golang.org/x/sys/unix"
+)
+
+type echoConn struct {
+	IOHandle
+
+	openC chan int
+}
+
+func (c *echoConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	if c.openC != nil {
+		c.openC <- fd
+	}
+	return true
+}
+func (c *echoConn) OnRead() bool {
+	bf, n, _ := c.Read()
+	if n > 0 {
+		c.Write(bf)
+		return true
+	}
+	return false
+}
+func (c *echoConn) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+// freeAddr returns a loopback address with a port unused for now, for the tests which need a
+// port nobody listens on (the listeners of the tests bind port 0 instead, see Acceptor.Addr)
+func freeAddr(t testing.TB) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return "127.0.0.1:" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func newTestReactor(t *testing.T, opts ...Option) *Reactor {
+	r, err := NewReactor(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	t.Cleanup(r.Stop)
+	return r
+}
+
+// echo writes msg to conn and checks it comes back
+func echo(t *testing.T, conn net.Conn, msg string) {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	bf := make([]byte, len(msg))
+	n := 0
+	for n < len(bf) {
+		m, err := conn.Read(bf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	if string(bf) != msg {
+		t.Fatalf("echo %q, want %q", bf, msg)
+	}
+}
+
+func TestAcceptorExclusive(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	openC := make(chan int, 4)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0", AcceptExclusive(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	if epollExclusiveSupported() && a.acceptEvents != EvAcceptExclusive {
+		t.Fatal("EvAcceptExclusive not used")
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-openC:
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnOpen not fired")
+		}
+		echo(t, conn, "hello")
+		conn.Close()
+	}
+}
+
+func TestReusePortAcceptors(t *testing.T) {
+	const n = 4
+	r := newTestReactor(t, EvPollNum(n))
+	connR := newTestReactor(t, EvPollNum(1))
+	acceptors, err := NewReusePortAcceptors(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(connR)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := acceptors[0].Addr().String()
+	defer func() {
+		for _, a := range acceptors {
+			a.OnClose()
+		}
+	}()
+	if len(acceptors) != n {
+		t.Fatalf("%d acceptors, want %d", len(acceptors), n)
+	}
+	for i, a := range acceptors {
+		if a.getEvPoll() != &r.evPolls[i] {
+			t.Fatalf("acceptor#%d not on evpoll#%d", i, i)
+		}
+	}
+
+	for i := 0; i < 64; i++ {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn, "hi")
+		conn.Close()
+	}
+	// connections are served by connR, so events in r are accept events
+	for i, s := range r.Stats() {
+		if s.EventsProcessed == 0 {
+			t.Fatalf("listener on evpoll#%d accepted nothing", i)
+		}
+	}
+}
+
+// unixClient sends msg once connected and reports what it reads back
+type unixClient struct {
+	IOHandle
+
+	msg   string
+	readC chan string
+}
+
+func (c *unixClient) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	c.Write([]byte(c.msg))
+	return true
+}
+func (c *unixClient) OnRead() bool {
+	bf, n, _ := c.Read()
+	if n > 0 {
+		c.readC <- string(bf)
+		return true
+	}
+	return false
+}
+func (c *unixClient) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+func TestAcceptorUnix(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	path := filepath.Join(t.TempDir(), "echo.sock")
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(r)
+		return c
+	}, "unix://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn, "hello")
+	conn.Close()
+
+	c, err := NewConnector(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &unixClient{msg: "world", readC: make(chan string, 1)}
+	if err = c.Connect("unix://"+path, cli, 1000); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-cli.readC:
+		if s != "world" {
+			t.Fatalf("echo %q, want %q", s, "world")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no echo over unix socket")
+	}
+
+	a.OnClose()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed: %v", err)
+	}
+}
+
+func TestAcceptorUnixAbstract(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	name := "@goev-test-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(r)
+		return c
+	}, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn, "hello")
+	conn.Close()
+
+	c, err := NewConnector(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &unixClient{msg: "world", readC: make(chan string, 1)}
+	if err = c.Connect("unix:"+name, cli, 1000); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-cli.readC:
+		if s != "world" {
+			t.Fatalf("echo %q, want %q", s, "world")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no echo over abstract unix socket")
+	}
+
+	// no file, the name is released with the fd
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("file %s created: %v", name, err)
+	}
+	a.OnClose()
+	if conn, err = net.Dial("unix", name); err == nil {
+		conn.Close()
+		t.Fatal("abstract name not released on close")
+	}
+	if _, err = NewAcceptor(r, func() EvHandler { return &echoConn{} }, "@"); err == nil {
+		t.Fatal("empty abstract name accepted")
+	}
+}
+
+func TestAcceptorEdgeTriggered(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	const n = 64
+	openC := make(chan int, n)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0", AcceptEdgeTriggered(true), ListenBacklog(n*2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	if a.acceptEvents&EPOLLET == 0 {
+		t.Fatal("listener not registered in EPOLLET mode")
+	}
+
+	var wg sync.WaitGroup
+	errC := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp4", addr)
+			if err != nil {
+				errC <- err
+				return
+			}
+			time.Sleep(200 * time.Millisecond) // keep the connection until all are accepted
+			conn.Close()
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-openC:
+		case err = <-errC:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d/%d connections accepted", i, n)
+		}
+	}
+	wg.Wait()
+}
+
+func TestAcceptorFdLimit(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	sa, _ := parseInet4Addr(addr)
+
+	// The client sockets are created before the limit is reached
+	const n = 3
+	var clients [n]int
+	for i := range clients {
+		if clients[i], err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer syscall.Close(clients[i])
+	}
+
+	var old syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &old); err != nil {
+		t.Fatal(err)
+	}
+	var fillers []int
+	func() {
+		lim := old
+		lim.Cur = uint64(a.spareFd + 64)
+		if err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+			t.Skip("setrlimit:", err)
+		}
+		defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &old)
+		for { // saturate
+			fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+			if err != nil {
+				break
+			}
+			fillers = append(fillers, fd)
+		}
+		before := r.Stats()[0]
+		for _, fd := range clients {
+			if err = syscall.Connect(fd, sa); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+		after := r.Stats()[0]
+		if d := after.FdLimitDrops - before.FdLimitDrops; d != n {
+			t.Fatalf("%d connections dropped, want %d", d, n)
+		}
+		if d := after.EpollWaitCalls - before.EpollWaitCalls; d > 50 {
+			t.Fatalf("evpoll is spinning, %d epoll_wait in 200ms", d)
+		}
+	}()
+	for _, fd := range fillers {
+		syscall.Close(fd)
+	}
+	for _, fd := range clients { // closed by the acceptor
+		var bf [1]byte
+		if n, _ := syscall.Read(fd, bf[:]); n > 0 {
+			t.Fatal("dropped connection is readable")
+		}
+	}
+
+	conn, err := net.Dial("tcp4", addr) // recovered
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, "recovered")
+}
+
+// sockOptsConn reports the socket options of the accepted fd, the fd is closed by the test
+type sockOptsConn struct {
+	IOHandle
+
+	optsC chan [3]int // fd, TCP_NODELAY, SO_RCVBUF
+}
+
+func (c *sockOptsConn) OnOpen(fd int) bool {
+	nodelay, _ := syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	rcvbuf, _ := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	c.optsC <- [3]int{fd, nodelay, rcvbuf}
+	return true
+}
+
+func TestAcceptorSockOpts(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	optsC := make(chan [3]int, 1)
+	fail := false
+	a, err := NewAcceptor(r, func() EvHandler {
+		return &sockOptsConn{optsC: optsC}
+	}, "127.0.0.1:0", AcceptSockOpts(func(fd int) error {
+		if fail {
+			return errors.New("fail")
+		}
+		if err := netfd.SetNoDelay(fd, 1); err != nil {
+			return err
+		}
+		if err := netfd.SetRecvBuffSize(fd, 16*1024); err != nil {
+			return err
+		}
+		return netfd.SetLinger(fd, 0)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.OnClose()
+	addr := a.Addr().String()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case opts := <-optsC:
+		if opts[1] == 0 || opts[2] < 16*1024 { // doubled by the kernel, clamped by rmem_max
+			t.Fatalf("TCP_NODELAY, SO_RCVBUF = %v", opts[1:])
+		}
+		syscall.Close(opts[0])
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnOpen not fired")
+	}
+	// zero linger, closed with RST instead of FIN
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read %v, want ECONNRESET", err)
+	}
+
+	// closed without OnOpen if the options fail
+	r.Post(a.Fd(), func() { fail = true })
+	conn2, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read %v, want EOF", err)
+	}
+	if len(optsC) != 0 {
+		t.Fatal("OnOpen called after AcceptSockOpts failed")
+	}
+}
+
+func TestAcceptorFdFlags(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	openC := make(chan int, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fd := <-openC
+	if fl, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0); err != nil || fl&unix.O_NONBLOCK == 0 {
+		t.Fatalf("accepted fd not non-blocking: %#x %v", fl, err)
+	}
+	if fl, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil || fl&unix.FD_CLOEXEC == 0 {
+		t.Fatalf("accepted fd not close-on-exec: %#x %v", fl, err)
+	}
+	echo(t, conn, "flags")
+}
+
+func TestAcceptorIPv6(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 unavailable: %v", err)
+	} else {
+		l.Close()
+	}
+	r := newTestReactor(t, EvPollNum(1))
+	listen := func(addr string, opts ...Option) (*Acceptor, chan int) {
+		openC := make(chan int, 1)
+		a, err := NewAcceptor(r, func() EvHandler {
+			c := &echoConn{openC: openC}
+			c.setReactor(r)
+			return c
+		}, addr, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			r.RemoveEvHandler(a, a.fd)
+			a.OnClose()
+		})
+		return a, openC
+	}
+	// v6 only
+	a, openC := listen("[::1]:0", IPv6Only(true))
+	_, port, _ := net.SplitHostPort(a.Addr().String())
+	conn, err := net.Dial("tcp6", "[::1]:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, "ipv6")
+	if ap, err := r.PeerAddr(<-openC); err != nil || ap.String() != conn.LocalAddr().String() {
+		t.Fatalf("PeerAddr %v %v, want %v", ap, err, conn.LocalAddr())
+	}
+	a, _ = listen("[::]:0", IPv6Only(true))
+	_, port, _ = net.SplitHostPort(a.Addr().String())
+	if c, err := net.Dial("tcp4", "127.0.0.1:"+port); err == nil {
+		c.Close()
+		t.Fatal("IPv4 accepted by a v6-only listener")
+	}
+
+	// dual-stack
+	a, openC = listen("[::]:0")
+	_, port, _ = net.SplitHostPort(a.Addr().String())
+	conn4, err := net.Dial("tcp4", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn4.Close()
+	echo(t, conn4, "ipv4")
+	fd := <-openC
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa6, ok := sa.(*syscall.SockaddrInet6); !ok || !netip.AddrFrom16(sa6.Addr).Is4In6() {
+		t.Fatalf("peer %#v, want an IPv4-mapped IPv6 address", sa)
+	}
+	if ap, err := r.PeerAddr(fd); err != nil || ap.String() != conn4.LocalAddr().String() {
+		t.Fatalf("PeerAddr %v %v, want %v", ap, err, conn4.LocalAddr())
+	}
+
+	for _, addr := range []string{"[::1]", "[:::80", "[zz::]:80"} {
+		if _, err := NewAcceptor(r, nil, addr); err == nil {
+			t.Fatalf("invalid address %q accepted", addr)
+		}
+	}
+}
+
+func TestAcceptorMaxConnections(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2), MaxConnections(2, []byte("busy\n")))
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	c1, c2 := dial(), dial()
+	echo(t, c1, "1")
+	echo(t, c2, "2")
+	if n := r.Connections(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+
+	c3 := dial()
+	c3.SetDeadline(time.Now().Add(2 * time.Second))
+	if got, err := io.ReadAll(c3); err != nil || string(got) != "busy\n" {
+		t.Fatalf("rejected connection read %q %v", got, err)
+	}
+	var rejects uint64
+	for _, s := range r.Stats() {
+		rejects += s.ConnRejects
+	}
+	if rejects != 1 {
+		t.Fatalf("%d rejects, want 1", rejects)
+	}
+
+	c1.Close() // below the limit again
+	for i := 0; r.Connections() != 1; i++ {
+		if i > 200 {
+			t.Fatalf("%d connections after one closed, want 1", r.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	echo(t, dial(), "4")
+	if n := r.Connections(); n != 2 {
+		t.Fatalf("%d connections, want 2", n)
+	}
+}
+
+// swapConn registers an echoConn instead of itself
+type swapConn struct {
+	IOHandle
+}
+
+func (c *swapConn) OnOpen(fd int) bool {
+	ec := &echoConn{}
+	ec.setReactor(c.GetReactor())
+	return ec.OnOpen(fd)
+}
+
+func TestAcceptorMaxConnectionsSwap(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2), MaxConnections(2, nil))
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &swapConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	for i := 0; i < 3; i++ { // beyond the limit, the connections are not counted
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn, "x")
+		conn.Close()
+	}
+	waitFor(t, "no connection counted", func() bool { return r.Connections() == 0 })
+}
+
+// rejectConn returns false in OnOpen, after registering the fd and a timer if register
+type rejectConn struct {
+	IOHandle
+
+	fd       int
+	register bool
+	fired    atomic.Int32
+	closeC   chan int
+}
+
+func (c *rejectConn) OnOpen(fd int) bool {
+	c.fd = fd
+	if c.register {
+		if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+			panic(err)
+		}
+		if err := c.ScheduleTimer(c, 50, 0); err != nil {
+			panic(err)
+		}
+	}
+	return false
+}
+func (c *rejectConn) OnTimeout(int64) bool {
+	c.fired.Add(1)
+	return false
+}
+func (c *rejectConn) OnClose() {
+	syscall.Close(c.fd)
+	c.closeC <- c.fd
+}
+
+func TestAcceptorOnOpenFalse(t *testing.T) {
+	for _, register := range []bool{false, true} {
+		r := newTestReactor(t, EvPollNum(1))
+		closeC := make(chan int, 1)
+		var c *rejectConn
+		a, err := NewAcceptor(r, func() EvHandler {
+			c = &rejectConn{register: register, closeC: closeC}
+			c.setReactor(r)
+			return c
+		}, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := a.Addr().String()
+		registered := r.registered()
+
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var fd int
+		select {
+		case fd = <-closeC:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("register=%v: OnClose not called", register)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("register=%v: read %v, want EOF", register, err)
+		}
+		time.Sleep(100 * time.Millisecond) // the timer of OnOpen would have fired
+		if n := r.registered(); n != registered {
+			t.Fatalf("register=%v: %d fds registered, want %d", register, n, registered)
+		}
+		if ed := r.evPolls[0].loadEvData(fd); ed != nil {
+			t.Fatalf("register=%v: evData of fd %d leaked", register, fd)
+		}
+		if n := c.fired.Load(); n != 0 {
+			t.Fatalf("register=%v: timer of OnOpen fired %d times", register, n)
+		}
+		if n := r.Connections(); n != 0 {
+			t.Fatalf("register=%v: %d open connections, want 0", register, n)
+		}
+	}
+}
+
+func TestAcceptorListenBacklog(t *testing.T) {
+	max := somaxconn()
+	if max < 1024 {
+		t.Skipf("net.core.somaxconn %d < 1024", max)
+	}
+	l := &captureLogger{}
+	r, err := NewReactor(EvPollNum(1), EvLogger(l)) // not running, the connections stay in the backlog
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAcceptor(r, func() EvHandler { return &echoConn{} }, "127.0.0.1:0", ListenBacklog(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	if l.find("W ") != "" {
+		t.Fatalf("unexpected warning: %s", l.find("W "))
+	}
+
+	const conns = 800 // a burst beyond the default backlog 512
+	var wg sync.WaitGroup
+	var refused atomic.Int32
+	clients := make(chan net.Conn, conns)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp4", addr, 2*time.Second)
+			if err != nil {
+				refused.Add(1)
+				return
+			}
+			clients <- conn
+		}()
+	}
+	wg.Wait()
+	close(clients)
+	for conn := range clients {
+		conn.Close()
+	}
+	if n := refused.Load(); n > 0 {
+		t.Fatalf("%d of %d connections refused", n, conns)
+	}
+
+	// beyond somaxconn
+	a2, err := NewAcceptor(r, func() EvHandler { return &echoConn{} }, "127.0.0.1:0", ListenBacklog(max+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a2.OnClose()
+	if !l.contains("W ", "somaxconn") {
+		t.Fatal("backlog beyond somaxconn not warned")
+	}
+}
+
+func TestAcceptorAcceptRate(t *testing.T) {
+	const rate, burst, conns = 50, 5, 60
+	r := newTestReactor(t, EvPollNum(1))
+	openC := make(chan int, conns)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0", AcceptRate(rate, burst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	// connected by the kernel at once, accepted at the rate
+	begin := time.Now()
+	for i := 0; i < conns; i++ {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	time.Sleep(500 * time.Millisecond)
+	if n := len(openC); n < burst+rate/4 || n > burst+rate*3/4 {
+		t.Fatalf("%d connections accepted in 500ms, want about %d", n, burst+rate/2)
+	}
+	for i := 0; i < conns; i++ {
+		select {
+		case <-openC:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%d of %d connections accepted", i, conns)
+		}
+	}
+	// (conns - burst) / rate = 1.1s
+	if d := time.Since(begin); d < 900*time.Millisecond {
+		t.Fatalf("%d connections accepted in %v, faster than the rate", conns, d)
+	}
+}
+
+func TestAcceptorAcceptWithTimeout(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	listen := func(n int, timeout int64) (string, chan int, chan int) {
+		openC, stopC := make(chan int, 2), make(chan int, 1)
+		a, err := NewAcceptor(r, func() EvHandler {
+			c := &echoConn{openC: openC}
+			c.setReactor(r)
+			return c
+		}, "127.0.0.1:0", AcceptWithTimeout(n, timeout, func(accepted int) { stopC <- accepted }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := a.Addr().String()
+		t.Cleanup(func() { a.OnClose() })
+		return addr, openC, stopC
+	}
+	waitStop := func(stopC chan int, want int) {
+		select {
+		case n := <-stopC:
+			if n != want {
+				t.Fatalf("stopped after %d accepts, want %d", n, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("acceptor not stopped")
+		}
+	}
+
+	// stops right after the first connection, which keeps working
+	addr, openC, stopC := listen(1, 5000)
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-openC
+	waitStop(stopC, 1)
+	echo(t, conn, "ping")
+	if c, err := net.Dial("tcp4", addr); err == nil {
+		c.Close()
+		t.Fatal("connected after the stop")
+	}
+
+	// stops after the deadline without any connection
+	begin := time.Now()
+	addr, _, stopC = listen(1, 100)
+	waitStop(stopC, 0)
+	if d := time.Since(begin); d < 90*time.Millisecond {
+		t.Fatalf("stopped after %v", d)
+	}
+	if c, err := net.Dial("tcp4", addr); err == nil {
+		c.Close()
+		t.Fatal("connected after the deadline")
+	}
+}
+
+func TestAcceptorAddr(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	listen := func(addr string) *Acceptor {
+		a, err := NewAcceptor(r, func() EvHandler {
+			c := &echoConn{}
+			c.setReactor(r)
+			return c
+		}, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			r.RemoveEvHandler(a, a.fd)
+			a.OnClose()
+		})
+		return a
+	}
+	dialEcho := func(network string, addr net.Addr) {
+		conn, err := net.Dial(network, addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		echo(t, conn, "hello "+network)
+	}
+
+	// ephemeral ports
+	addr, ok := listen("127.0.0.1:0").Addr().(*net.TCPAddr)
+	if !ok || addr.Port == 0 || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("Addr %v, want 127.0.0.1 and a port", addr)
+	}
+	dialEcho("tcp4", addr)
+	if l, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		l.Close()
+		addr, ok = listen("[::1]:0").Addr().(*net.TCPAddr)
+		if !ok || addr.Port == 0 || !addr.IP.Equal(net.IPv6loopback) {
+			t.Fatalf("Addr %v, want [::1] and a port", addr)
+		}
+		dialEcho("tcp6", addr)
+	}
+
+	// unix socket
+	path := filepath.Join(t.TempDir(), "addr.sock")
+	uaddr, ok := listen("unix:" + path).Addr().(*net.UnixAddr)
+	if !ok || uaddr.Name != path || uaddr.Network() != "unix" {
+		t.Fatalf("Addr %v, want %s", uaddr, path)
+	}
+	dialEcho("unix", uaddr)
+
+	// the reuseport listeners share the ephemeral port of the first one
+	acceptors, err := NewReusePortAcceptors(r, func() EvHandler {
+		c := &echoConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := acceptors[0].Addr().String()
+	for _, a := range acceptors {
+		if a.Addr().String() != want {
+			t.Fatalf("reuseport listener on %v, want %s", a.Addr(), want)
+		}
+		r.RemoveEvHandler(a, a.fd)
+		a.OnClose()
+		if a.Addr() != nil {
+			t.Fatalf("Addr %v after close", a.Addr())
+		}
+	}
+
+	for _, addr := range []string{":65536", ":-1", ":http"} {
+		if _, err := NewAcceptor(r, nil, addr); err == nil {
+			t.Fatalf("invalid port %q accepted", addr)
+		}
+	}
+}
@@ -16,6 +16,10 @@ type asyncWriteItem struct {
 	fd  int
 	eh  EvHandler
 	abf AsyncWriteBuf
+
+	filter func(eh EvHandler) bool // not nil for broadcast, eh is nil
+	fn     func()                  // not nil for Reactor.Post, eh is nil
+	posted bool                    // by Reactor.Post, see postLimit
 }
 
 // Using a double buffer queue, the 'writeq' is only responsible for receiving data blocks.
@@ -30,6 +34,7 @@ type asyncWrite struct {
 	readq  *RingBuffer[asyncWriteItem]
 	writeq *RingBuffer[asyncWriteItem]
 	mtx    sync.Mutex
+	limit  postLimit // of the closures of Reactor.Post, see EvPollPostQueue
 
 	evPoll *evPoll
 }
@@ -57,7 +62,11 @@ func (aw *asyncWrite) push(awi asyncWriteItem) {
 	aw.mtx.Lock()
 	aw.writeq.Push(awi)
 	aw.mtx.Unlock()
+	aw.notify()
+}
 
+// notify writes the eventfd once until OnRead reads it
+func (aw *asyncWrite) notify() {
 	if !aw.notified.CompareAndSwap(0, 1) {
 		return
 	}
@@ -76,6 +85,7 @@ func (aw *asyncWrite) OnRead() bool {
 	if aw.readq.IsEmpty() {
 		aw.mtx.Lock()
 		aw.writeq, aw.readq = aw.readq, aw.writeq // Swap read/write queues
+		aw.takenPosts()
 		aw.mtx.Unlock()
 	}
 
@@ -84,6 +94,14 @@ func (aw *asyncWrite) OnRead() bool {
 		if !ok {
 			break
 		}
+		if item.fn != nil {
+			item.fn()
+			continue
+		}
+		if item.filter != nil {
+			aw.evPoll.broadcast(item.abf, item.filter)
+			continue
+		}
 		ed := aw.evPoll.loadEvData(item.fd)
 		if ed != nil && ed.eh == item.eh { // TODO Comparing interfaces, the performance is not very good
 			item.eh.asyncOrderedWrite(item.eh, item.abf)
@@ -103,10 +121,18 @@ func (aw *asyncWrite) OnRead() bool {
 			} else if err == syscall.EAGAIN {
 				return true
 			}
-			return false // TODO add evOptions.debug? panic("Notify: read eventfd failed!")
+			aw.evPoll.logger.Errorf("goev: asyncWrite read eventfd: %s", err.Error())
+			return false
 		}
 		aw.notified.Store(0)
 		break
 	}
+	// the items pushed while processing (e.g. by the fns above) found notified set
+	aw.mtx.Lock()
+	pending := !aw.writeq.IsEmpty()
+	aw.mtx.Unlock()
+	if pending {
+		aw.notify()
+	}
 	return true
 }
@@ -0,0 +1,106 @@
+package goev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrFrameTooLarge is returned by LengthFieldCodec when the length field exceeds the max frame size
+var ErrFrameTooLarge = errors.New("goev: frame too large")
+
+// LengthFieldCodec decodes the frames prefixed with a fixed-size length field (the length of the
+// payload, not including the field itself) from a ReadBuffer.
+//
+// For example:
+//
+//	c.codec, _ = goev.NewLengthFieldCodec(4, binary.BigEndian, 1<<20, c.onMessage)
+//
+//	func (c *Conn) OnRead() bool {
+//	    _, err := c.rb.ReadFromHandler(c)
+//	    if c.codec.Decode(c.rb) != nil {
+//	        return false
+//	    }
+//	    return err == nil
+//	}
+type LengthFieldCodec struct {
+	lengthSize   int // 1, 2, 4, 8
+	order        binary.ByteOrder
+	maxFrameSize int
+	onMessage    func(payload []byte)
+}
+
+// NewLengthFieldCodec return an instance, onMessage is called with the payload of each frame,
+// the payload is valid only within onMessage.
+func NewLengthFieldCodec(lengthSize int, order binary.ByteOrder, maxFrameSize int,
+	onMessage func(payload []byte)) (*LengthFieldCodec, error) {
+	switch lengthSize {
+	case 1, 2, 4, 8:
+	default:
+		return nil, errors.New("LengthFieldCodec: length size must be 1, 2, 4 or 8")
+	}
+	if order == nil || maxFrameSize < 1 || onMessage == nil {
+		return nil, errors.New("LengthFieldCodec: invalid params")
+	}
+	return &LengthFieldCodec{
+		lengthSize:   lengthSize,
+		order:        order,
+		maxFrameSize: maxFrameSize,
+		onMessage:    onMessage,
+	}, nil
+}
+
+// Decode calls onMessage for each complete frame of rb and consumes it, an incomplete frame is kept
+// in rb. Returns ErrFrameTooLarge (wrapped) if a frame exceeds the max size, the connection should
+// be closed then.
+func (c *LengthFieldCodec) Decode(rb *ReadBuffer) error {
+	for {
+		field := rb.Peek(c.lengthSize)
+		if field == nil {
+			return nil
+		}
+		n := c.length(field)
+		if n > uint64(c.maxFrameSize) {
+			return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, n, c.maxFrameSize)
+		}
+		frame := rb.Peek(c.lengthSize + int(n))
+		if frame == nil {
+			return nil
+		}
+		c.onMessage(frame[c.lengthSize:])
+		rb.Discard(len(frame))
+	}
+}
+
+// Encode appends the length field and payload to dst
+func (c *LengthFieldCodec) Encode(dst, payload []byte) ([]byte, error) {
+	n := len(payload)
+	if n > c.maxFrameSize || (c.lengthSize < 8 && uint64(n) >= 1<<(8*c.lengthSize)) {
+		return dst, fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, n, c.maxFrameSize)
+	}
+	var field [8]byte
+	switch c.lengthSize {
+	case 1:
+		field[0] = byte(n)
+	case 2:
+		c.order.PutUint16(field[:], uint16(n))
+	case 4:
+		c.order.PutUint32(field[:], uint32(n))
+	case 8:
+		c.order.PutUint64(field[:], uint64(n))
+	}
+	dst = append(dst, field[:c.lengthSize]...)
+	return append(dst, payload...), nil
+}
+
+func (c *LengthFieldCodec) length(field []byte) uint64 {
+	switch c.lengthSize {
+	case 1:
+		return uint64(field[0])
+	case 2:
+		return uint64(c.order.Uint16(field))
+	case 4:
+		return uint64(c.order.Uint32(field))
+	}
+	return c.order.Uint64(field)
+}
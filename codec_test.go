@@ -0,0 +1,74 @@
+package goev
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLengthFieldCodec(t *testing.T) {
+	for _, c := range []struct {
+		size  int
+		order binary.ByteOrder
+	}{{1, binary.BigEndian}, {2, binary.LittleEndian}, {4, binary.BigEndian}, {8, binary.LittleEndian}} {
+		fd, peer := newSocketPair(t)
+		rb := NewReadBuffer(16, 0)
+		var msgs []string
+		codec, err := NewLengthFieldCodec(c.size, c.order, 200, func(payload []byte) {
+			msgs = append(msgs, string(payload))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := func(data []byte) {
+			syscall.Write(peer, data)
+			if _, err := rb.ReadFromFd(fd); err != nil {
+				t.Fatal(err)
+			}
+			if err := codec.Decode(rb); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// multiple frames in one read
+		var data []byte
+		for _, s := range []string{"a", "", strings.Repeat("b", 100)} {
+			data, _ = codec.Encode(data, []byte(s))
+		}
+		read(data)
+		if len(msgs) != 3 || msgs[0] != "a" || msgs[1] != "" || len(msgs[2]) != 100 {
+			t.Fatalf("size %d: decoded %q", c.size, msgs)
+		}
+
+		// a frame split across reads, the length field as well
+		msgs = msgs[:0]
+		data, _ = codec.Encode(nil, []byte("split frame"))
+		for _, part := range [][]byte{data[:c.size-c.size/2], data[c.size-c.size/2 : c.size+3], data[c.size+3:]} {
+			if len(msgs) != 0 {
+				t.Fatalf("size %d: incomplete frame decoded", c.size)
+			}
+			read(part)
+		}
+		if len(msgs) != 1 || msgs[0] != "split frame" || rb.Len() != 0 {
+			t.Fatalf("size %d: decoded %q, %d bytes left", c.size, msgs, rb.Len())
+		}
+
+		// oversize
+		if _, err = codec.Encode(nil, make([]byte, 201)); !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("size %d: encode oversize frame: %v", c.size, err)
+		}
+		big, _ := NewLengthFieldCodec(c.size, c.order, 1000, func([]byte) {})
+		data, _ = big.Encode(nil, make([]byte, 201))
+		syscall.Write(peer, data)
+		rb.ReadFromFd(fd)
+		if err = codec.Decode(rb); !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("size %d: decode oversize frame: %v", c.size, err)
+		}
+	}
+
+	if _, err := NewLengthFieldCodec(3, binary.BigEndian, 10, func([]byte) {}); err == nil {
+		t.Fatal("length size 3 accepted")
+	}
+}
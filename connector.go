@@ -2,7 +2,9 @@ package goev
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,15 +19,43 @@ var (
 
 	// ErrConnectInprogress means the process is ongoing and not immediately successful.
 	ErrConnectInprogress = errors.New("connect EINPROGRESS")
+
+	// ErrConnectRefused means no one is listening on the remote address (ECONNREFUSED)
+	ErrConnectRefused = errors.New("connect refused")
+
+	// ErrConnectReset means the connection was reset by the peer (ECONNRESET)
+	ErrConnectReset = errors.New("connect reset")
+
+	// ErrConnectUnreachable means the network or host is unreachable (ENETUNREACH, EHOSTUNREACH)
+	ErrConnectUnreachable = errors.New("connect unreachable")
+
+	// ErrConnectProxy means the handshake with the proxy (see ConnectProxy) failed
+	ErrConnectProxy = errors.New("connect proxy handshake fail")
 )
 
+// connectError maps the errno of connect(2) or SO_ERROR to ErrConnect*
+func connectError(err error) error {
+	switch err {
+	case syscall.ECONNREFUSED:
+		return ErrConnectRefused
+	case syscall.ECONNRESET:
+		return ErrConnectReset
+	case syscall.ENETUNREACH, syscall.EHOSTUNREACH:
+		return ErrConnectUnreachable
+	case syscall.ETIMEDOUT:
+		return ErrConnectTimeout
+	}
+	return ErrConnectFail
+}
+
 // Connector provides a fast asynchronous connector and can set a timeout.
 // It internally uses Reactor to achieve asynchronicity.
 // Connect success or failure will trigger specified methods for notification
 type Connector struct {
 	IOHandle
 
-	sockRcvBufSize int // ignore equal 0
+	sockRcvBufSize int      // ignore equal 0
+	proxy          *url.URL // nil if no proxy
 }
 
 // NewConnector return an instance
@@ -34,6 +64,16 @@ func NewConnector(r *Reactor, opts ...Option) (*Connector, error) {
 	c := &Connector{
 		sockRcvBufSize: evOptions.sockRcvBufSize,
 	}
+	if evOptions.connectProxy != "" {
+		u, err := url.Parse(evOptions.connectProxy)
+		if err != nil {
+			return nil, errors.New("ConnectProxy: " + err.Error())
+		}
+		if (u.Scheme != "socks5" && u.Scheme != "http") || u.Port() == "" {
+			return nil, errors.New("ConnectProxy: socks5://ip:port or http://ip:port")
+		}
+		c.proxy = u
+	}
 	c.setReactor(r)
 	return c, nil
 }
@@ -41,24 +81,26 @@ func NewConnector(r *Reactor, opts ...Option) (*Connector, error) {
 // Connect asynchronously to the specified address and there may also be an immediate result.
 // Please check the return value
 //
-// The addr format 192.168.0.1:8080 or unix:/tmp/xxxx.sock
-// The domain name format, such as qq.com:8080, is not supported.
-// You need to manually extract the IP address using gethostbyname.
+// The addr format 192.168.0.1:8080 or unix:/tmp/xxxx.sock or unix:///tmp/xxxx.sock, or @name
+// (unix:@name) for the Linux abstract namespace
+// The domain name format, such as qq.com:8080, is not supported (use Dialer) unless connecting
+// through a proxy (see ConnectProxy), which resolves it. You need to manually extract the IP
+// address using gethostbyname.
 //
 // Timeout is relative time measurements with millisecond accuracy, for example, delay=5msec.
 func (c *Connector) Connect(addr string, eh EvHandler, timeout int64) error {
 	if timeout < 0 {
 		return errors.New("Connector:Connect param:timeout < 0")
 	}
+	if path, ok := parseUdsAddr(addr); ok {
+		return c.udsConnect(path, eh, timeout)
+	}
 	p := strings.Index(addr, ":")
 	if p < 0 || p >= (len(addr)-1) {
 		return errors.New("Connector:Connect param:addr invalid")
 	}
-	if len(addr) > 5 {
-		s := addr[0:5]
-		if s == "unix:" {
-			return c.udsConnect(addr[5:], eh, timeout)
-		}
+	if c.proxy != nil {
+		return c.proxyConnect(addr, eh, timeout)
 	}
 	return c.tcpConnect(addr, eh, timeout)
 }
@@ -134,61 +176,92 @@ func (c *Connector) connect(fd int, sa syscall.Sockaddr, eh EvHandler, timeout i
 			return ErrConnectInprogress
 		}
 		inh := &inProgressConnect{eh: eh}
+		inh.setReactor(reactor) // passed to eh
 		if err = reactor.AddEvHandler(inh, fd, EvConnect); err != nil {
 			syscall.Close(fd)
 			return errors.New("InPorgress AddEvHandler in connector.Connect: " + err.Error())
 		}
-		inh.ScheduleTimer(inh, timeout, 0) // don't need to cancel it when conn error
+		inh.ScheduleTimer(inh, timeout, 0) // canceled once the result is known
 		return nil
 	} else if err == nil { // success
 		eh.setReactor(reactor)
-		if eh.OnOpen(fd) == false {
-			eh.OnClose()
-		}
+		openConnected(eh, fd)
 		return nil
 	}
 	syscall.Close(fd)
-	return errors.New("syscall connect: " + err.Error())
+	return fmt.Errorf("syscall connect: %w", connectError(err))
 }
 
 // nonblocking inprogress connection
 type inProgressConnect struct {
 	IOHandle
 
-	eh EvHandler
+	eh       EvHandler
+	notified bool  // eh.OnOpen or eh.OnConnectFail has been called
+	err      error // SO_ERROR, reading it clears the error of the socket
 }
 
 // Called by reactor when asynchronous connections fail.
 func (p *inProgressConnect) OnRead() bool {
-	p.eh.OnConnectFail(ErrConnectFail)
 	return false // goto p.OnClose()
 }
 
-// Called by reactor when asynchronous connections succeed.
+// Called by reactor when the connection completes, SO_ERROR tells the result.
 func (p *inProgressConnect) OnWrite() bool {
+	if p.err = p.sockError(); p.err != nil {
+		return false // goto p.OnClose()
+	}
+	p.CancelTimer(p)
+	p.notified = true
+
 	// From here on, the `fd` resources will be managed by h.
 	p.GetReactor().RemoveEvHandler(p, p.Fd()) // p will auto release
 	fd := p.Fd()
 	p.setFd(-1)
 
 	p.eh.setReactor(p.GetReactor())
-	if p.eh.OnOpen(fd) == false {
-		p.eh.OnClose()
-	}
+	openConnected(p.eh, fd)
 	return true
 }
 
 // Called if a connection times out before completing.
 func (p *inProgressConnect) OnTimeout(now int64) bool {
 	// i/o event not catched
-	p.eh.OnConnectFail(ErrConnectTimeout)
+	if p.notified == false {
+		p.notified = true
+		p.eh.OnConnectFail(ErrConnectTimeout)
+	}
+	if p.Fd() != -1 {
+		p.GetReactor().RemoveEvHandler(p, p.Fd())
+	}
 	p.OnClose()
 	return false
 }
 
+// OnClose is also reached on EPOLLERR/EPOLLHUP (e.g. connection refused) or SO_ERROR != 0
 func (p *inProgressConnect) OnClose() {
+	if p.notified == false {
+		p.notified = true
+		p.CancelTimer(p)
+		if p.err == nil && p.Fd() != -1 {
+			p.err = p.sockError()
+		}
+		p.eh.OnConnectFail(connectError(p.err))
+	}
 	if p.Fd() != -1 {
 		syscall.Close(p.Fd())
 		p.setFd(-1)
 	}
 }
+
+// sockError returns the pending error of the socket
+func (p *inProgressConnect) sockError() error {
+	errno, err := syscall.GetsockoptInt(p.Fd(), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return syscall.Errno(errno)
+	}
+	return nil
+}
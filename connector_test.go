@@ -1,10 +1,14 @@
 package goev
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/shaovie/goev/netfd"
 )
@@ -49,3 +53,138 @@ func TestConnector(t *testing.T) {
 
 	wg.Wait()
 }
+
+type connectResult struct {
+	IOHandle
+
+	openC chan int
+	failC chan error
+}
+
+func newConnectResult() *connectResult {
+	return &connectResult{openC: make(chan int, 1), failC: make(chan error, 2)}
+}
+func (c *connectResult) OnOpen(fd int) bool {
+	c.openC <- fd
+	return true
+}
+func (c *connectResult) OnConnectFail(err error) {
+	c.failC <- err
+}
+func (c *connectResult) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+// wait returns the error of OnConnectFail, or nil if OnOpen has been called
+func (c *connectResult) wait(t *testing.T) error {
+	select {
+	case fd := <-c.openC:
+		syscall.Close(fd)
+		return nil
+	case err := <-c.failC:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("connect result not notified")
+	}
+	return nil
+}
+
+// fullBacklogAddr returns an address whose accept queue is full, so SYNs are dropped and connect
+// hangs
+func fullBacklogAddr(t *testing.T) string {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err = syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	bound, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa := bound.(*syscall.SockaddrInet4)
+	addr := "127.0.0.1:" + strconv.Itoa(sa.Port)
+	if err = syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		cfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { syscall.Close(cfd) })
+		syscall.Connect(cfd, sa)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return addr
+}
+
+func TestConnectorResult(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	c, err := NewConnector(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := newConnectResult()
+	if err = c.Connect(l.Addr().String(), h, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != nil {
+		t.Fatalf("connect to listener: %v", err)
+	}
+
+	h = newConnectResult()
+	if err = c.Connect(freeAddr(t), h, 1000); err == nil { // refused immediately or asynchronously
+		err = h.wait(t)
+	}
+	if !errors.Is(err, ErrConnectRefused) {
+		t.Fatalf("connect to closed port: %v, want ErrConnectRefused", err)
+	}
+
+	h = newConnectResult()
+	begin := time.Now()
+	if err = c.Connect(fullBacklogAddr(t), h, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != ErrConnectTimeout {
+		t.Fatalf("connect to full backlog: %v, want ErrConnectTimeout", err)
+	}
+	if d := time.Since(begin); d < 150*time.Millisecond {
+		t.Fatalf("timed out after %v", d)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(h.failC) != 0 || len(h.openC) != 0 {
+		t.Fatal("notified more than once")
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	cases := []struct {
+		errno error
+		want  error
+	}{
+		{syscall.ECONNREFUSED, ErrConnectRefused},
+		{syscall.ECONNRESET, ErrConnectReset},
+		{syscall.ENETUNREACH, ErrConnectUnreachable},
+		{syscall.EHOSTUNREACH, ErrConnectUnreachable},
+		{syscall.ETIMEDOUT, ErrConnectTimeout},
+		{syscall.EACCES, ErrConnectFail},
+		{nil, ErrConnectFail},
+	}
+	for _, c := range cases {
+		if err := connectError(c.errno); err != c.want {
+			t.Fatalf("connectError(%v) = %v, want %v", c.errno, err, c.want)
+		}
+	}
+}
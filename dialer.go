@@ -0,0 +1,287 @@
+package goev
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Dialer connects to host:port where host may be a domain name, it resolves the A/AAAA records and
+// races the connections to the addresses (Happy Eyeballs, RFC 8305): the attempts start one after
+// another every DialAttemptDelay, alternating IPv6 and IPv4, or at once after the previous one
+// failed. The first connected one is passed to OnOpen, the others are closed.
+type Dialer struct {
+	r *Reactor
+
+	next           atomic.Uint64 // evpoll of the next dial
+	attemptDelay   int64
+	sockRcvBufSize int
+
+	lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// NewDialer return an instance
+func NewDialer(r *Reactor, opts ...Option) (*Dialer, error) {
+	evOptions := setOptions(opts...)
+	return &Dialer{
+		r:              r,
+		attemptDelay:   evOptions.dialAttemptDelay,
+		sockRcvBufSize: evOptions.sockRcvBufSize,
+		lookup: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+	}, nil
+}
+
+// Dial connects to addr (e.g. example.com:80, 192.168.1.1:80 or [::1]:80) asynchronously, the
+// name is resolved in another goroutine, then eh.OnOpen or eh.OnConnectFail is called within an
+// evpoll coroutine. The error of OnConnectFail is one of ErrConnect* (the one of the last
+// attempt), ErrConnectTimeout if no attempt succeeds within timeout (millisecond, including the
+// resolution). It returns an error only if the params are invalid.
+func (d *Dialer) Dial(addr string, eh EvHandler, timeout int64) error {
+	if timeout < 1 || eh == nil {
+		return errors.New("Dialer:Dial invalid params")
+	}
+	host, portS, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.New("Dialer:Dial param:addr invalid")
+	}
+	port, _ := strconv.ParseInt(portS, 10, 64)
+	if port < 1 || port > 65535 {
+		return errors.New("port must in (0, 65536)")
+	}
+	idx := d.r.route(int(d.next.Add(1) % uint64(d.r.evPollNum)))
+	ep := &d.r.evPolls[idx]
+	he := &happyEyeballs{d: d, eh: eh, idx: idx, port: int(port), attempts: make(map[*dialAttempt]struct{})}
+	he.setParams(-1, ep) // for the timers, not registered
+
+	deadline := time.Now().UnixMilli() + timeout
+	go func() {
+		var addrs []netip.Addr
+		var err error
+		if ip, perr := netip.ParseAddr(host); perr == nil {
+			addrs = []netip.Addr{ip}
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+			addrs, err = d.lookup(ctx, host)
+			cancel()
+		}
+		ep.push(asyncWriteItem{fn: func() {
+			if err != nil || len(addrs) == 0 {
+				he.done = true
+				eh.OnConnectFail(ErrConnectFail)
+				return
+			}
+			he.start(interleave(addrs), deadline-ep.nowMilli())
+		}})
+	}()
+	return nil
+}
+
+// interleave orders addrs alternating the families, starting with IPv6 (RFC 8305 section 4)
+func interleave(addrs []netip.Addr) []netip.Addr {
+	var v6, v4 []netip.Addr
+	for _, a := range addrs {
+		if a = a.Unmap(); a.Is4() {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	out := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// happyEyeballs is the state of a Dial, accessed only within its evpoll coroutine
+type happyEyeballs struct {
+	IOHandle
+
+	d        *Dialer
+	eh       EvHandler
+	idx      int // evpoll of the attempts
+	port     int
+	addrs    []netip.Addr
+	next     int // index of addrs of the next attempt
+	attempts map[*dialAttempt]struct{}
+	lastErr  error
+	done     bool
+
+	delayTimer   TimerID
+	timeoutTimer TimerID
+}
+
+func (he *happyEyeballs) start(addrs []netip.Addr, timeout int64) {
+	if timeout < 1 {
+		he.fail(ErrConnectTimeout)
+		return
+	}
+	he.addrs = addrs
+	he.timeoutTimer, _ = he.ScheduleTimerFunc(he, timeout, 0, func(int64, any) bool {
+		he.timeoutTimer = TimerID{}
+		he.fail(ErrConnectTimeout)
+		return false
+	}, nil)
+	he.attemptNext()
+}
+
+// attemptNext starts the connection to the next address, or fails if all attempts failed
+func (he *happyEyeballs) attemptNext() {
+	he.CancelTimerID(he.delayTimer)
+	he.delayTimer = TimerID{}
+	for !he.done && he.next < len(he.addrs) {
+		ip := he.addrs[he.next]
+		he.next++
+		fd, inProgress, err := he.connect(ip)
+		if err != nil {
+			he.lastErr = err
+			continue
+		}
+		if !inProgress {
+			he.win(fd)
+			return
+		}
+		a := &dialAttempt{he: he}
+		if err = he.d.r.addEvHandlerTo(he.idx, a, fd, EvConnect); err != nil {
+			syscall.Close(fd)
+			he.lastErr = ErrConnectFail
+			continue
+		}
+		he.attempts[a] = struct{}{}
+		if he.next < len(he.addrs) {
+			he.delayTimer, _ = he.ScheduleTimerFunc(he, he.d.attemptDelay, 0, func(int64, any) bool {
+				he.delayTimer = TimerID{}
+				he.attemptNext()
+				return false
+			}, nil)
+		}
+		return
+	}
+	if !he.done && len(he.attempts) == 0 {
+		he.fail(he.lastErr)
+	}
+}
+
+// connect starts a non-blocking connection to ip
+func (he *happyEyeballs) connect(ip netip.Addr) (fd int, inProgress bool, err error) {
+	var sa syscall.Sockaddr
+	family := syscall.AF_INET
+	if ip.Is4() {
+		sa = &syscall.SockaddrInet4{Port: he.port, Addr: ip.As4()}
+	} else {
+		family = syscall.AF_INET6
+		sa = &syscall.SockaddrInet6{Port: he.port, Addr: ip.As16()}
+	}
+	fd, err = syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, false, ErrConnectFail
+	}
+	if he.d.sockRcvBufSize > 0 {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, he.d.sockRcvBufSize)
+	}
+	for {
+		err = syscall.Connect(fd, sa)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == nil || err == syscall.EINPROGRESS {
+		return fd, err != nil, nil
+	}
+	syscall.Close(fd)
+	return -1, false, connectError(err)
+}
+
+// win passes the connected fd to eh, the other attempts are canceled
+func (he *happyEyeballs) win(fd int) {
+	he.finish()
+	he.eh.setReactor(he.d.r)
+	openConnected(he.eh, fd)
+}
+
+func (he *happyEyeballs) fail(err error) {
+	if he.done {
+		return
+	}
+	he.finish()
+	if err == nil {
+		err = ErrConnectFail
+	}
+	he.eh.OnConnectFail(err)
+}
+
+// finish cancels the timers and the pending attempts
+func (he *happyEyeballs) finish() {
+	he.done = true
+	he.CancelTimerID(he.delayTimer)
+	he.CancelTimerID(he.timeoutTimer)
+	for a := range he.attempts {
+		a.cancel()
+	}
+	he.attempts = nil
+}
+
+// dialAttempt is a pending connection of happyEyeballs
+type dialAttempt struct {
+	IOHandle
+
+	he *happyEyeballs
+}
+
+// OnRead the connection failed (EPOLLIN without EPOLLOUT)
+func (a *dialAttempt) OnRead() bool {
+	return false
+}
+
+// OnWrite the connection completed, SO_ERROR tells the result
+func (a *dialAttempt) OnWrite() bool {
+	errno, err := syscall.GetsockoptInt(a.Fd(), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil || errno != 0 {
+		return false
+	}
+	fd := a.Fd()
+	delete(a.he.attempts, a)
+	a.getEvPoll().remove(fd) // handed over to eh
+	a.setFd(-1)
+	a.he.win(fd)
+	return true
+}
+
+// OnClose the connection failed, the next address is attempted at once
+func (a *dialAttempt) OnClose() {
+	fd := a.Fd()
+	if fd == -1 {
+		return
+	}
+	errno, _ := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	syscall.Close(fd)
+	a.setFd(-1)
+	he := a.he
+	if he.done {
+		return
+	}
+	delete(he.attempts, a)
+	he.lastErr = connectError(syscall.Errno(errno))
+	he.attemptNext()
+}
+
+// cancel closes the pending connection
+func (a *dialAttempt) cancel() {
+	if fd := a.Fd(); fd != -1 {
+		a.getEvPoll().remove(fd)
+		syscall.Close(fd)
+		a.setFd(-1)
+	}
+}
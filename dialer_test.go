@@ -0,0 +1,141 @@
+package goev
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listenInet6 listens on [::1]:port, backlog 0 and the accept queue filled if full
+func listenInet6(t *testing.T, port int, full bool) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Skip("no IPv6: " + err.Error())
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1)
+	sa := &syscall.SockaddrInet6{Port: port, Addr: netip.IPv6Loopback().As16()}
+	if err = syscall.Bind(fd, sa); err != nil {
+		t.Skip("no IPv6 loopback: " + err.Error())
+	}
+	if err = syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !full {
+		return
+	}
+	for i := 0; i < 4; i++ {
+		cfd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { syscall.Close(cfd) })
+		syscall.Connect(cfd, sa)
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+// dualStackDialer resolves every name to ::1 and 127.0.0.1
+func dualStackDialer(t *testing.T, r *Reactor) *Dialer {
+	d, err := NewDialer(r, DialAttemptDelay(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.IPv6Loopback()}, nil
+	}
+	return d
+}
+
+// dialFamily dials the dual-stack host and returns the family of the connection passed to OnOpen
+func dialFamily(t *testing.T, d *Dialer, port int) (int, time.Duration) {
+	h := newConnectResult()
+	begin := time.Now()
+	if err := d.Dial("dual.test:"+strconv.Itoa(port), h, 2000); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case fd := <-h.openC:
+		defer syscall.Close(fd)
+		sa, err := syscall.Getpeername(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := sa.(*syscall.SockaddrInet6); ok {
+			return syscall.AF_INET6, time.Since(begin)
+		}
+		return syscall.AF_INET, time.Since(begin)
+	case err := <-h.failC:
+		t.Fatalf("dial failed: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("dial result not notified")
+	}
+	return 0, 0
+}
+
+func TestDialerHappyEyeballs(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	d := dualStackDialer(t, r)
+	registered := func() (n int) {
+		for i := range r.evPolls {
+			n += r.evPolls[i].evHandlerMap.count()
+		}
+		return n
+	}
+	internal := registered() // eventfd, timerfd
+
+	// both are fast, IPv6 is preferred
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	listenInet6(t, port, false)
+	if family, _ := dialFamily(t, d, port); family != syscall.AF_INET6 {
+		t.Fatal("IPv6 not preferred")
+	}
+
+	// SYNs to ::1 are dropped, IPv4 starts after the attempt delay and wins
+	l4, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l4.Close()
+	port = l4.Addr().(*net.TCPAddr).Port
+	listenInet6(t, port, true)
+	family, elapsed := dialFamily(t, d, port)
+	if family != syscall.AF_INET {
+		t.Fatal("the faster IPv4 did not win")
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("IPv4 connected after %v, want the attempt delay", elapsed)
+	}
+	time.Sleep(50 * time.Millisecond) // the losing attempt is closed
+	if n := registered(); n != internal {
+		t.Fatalf("%d fds registered after the dial, want %d", n, internal)
+	}
+
+	// all refused, the error of the last attempt
+	port, _ = strconv.Atoi(freeAddr(t)[len("127.0.0.1:"):])
+	h := newConnectResult()
+	if err = d.Dial("dual.test:"+strconv.Itoa(port), h, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != ErrConnectRefused {
+		t.Fatalf("got %v, want ErrConnectRefused", err)
+	}
+
+	// hangs, timeout
+	h = newConnectResult()
+	if err = d.Dial(fullBacklogAddr(t), h, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != ErrConnectTimeout {
+		t.Fatalf("got %v, want ErrConnectTimeout", err)
+	}
+}
@@ -25,4 +25,7 @@
 // and provides an elegant and concise solution for TCP network programming projects.
 // With goev, you can seamlessly integrate your projects without worrying about the
 // coroutine pressure introduced by the standard library (go net).
+//
+// goev is Linux only (epoll, eventfd, timerfd). The evpoll is readiness based, there is no
+// io_uring backend.
 package goev // import "github.com/shaovie/goev"
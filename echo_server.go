@@ -0,0 +1,105 @@
+package goev
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// EchoServer is a reference TCP server sending back what it receives, which shows how an acceptor,
+// the per-connection ReadBuffer/Writer and EvInET handlers fit together, and serves as a benchmark
+// target (e.g. with wrk-like tools or BenchmarkEchoServer).
+type EchoServer struct {
+	r    *Reactor
+	a    *Acceptor
+	runC chan error
+}
+
+// echoServerConn reads until EAGAIN (EvInET) into its ReadBuffer and echoes it through its Writer
+type echoServerConn struct {
+	IOHandle
+
+	rb *ReadBuffer
+	w  *Writer
+}
+
+// echoConnPool recycles the connections and their buffers
+var echoConnPool = NewPool(func() *echoServerConn {
+	c := &echoServerConn{rb: NewReadBuffer(4096, 1024*1024)}
+	c.w = NewWriter(c)
+	return c
+}, func(c *echoServerConn) {
+	c.w.Reset() // before Init, it cancels its timer
+	c.rb.Release()
+	c.Init()
+})
+
+// NewEchoServer creates a reactor with opts, listens on addr and runs it until Close.
+// The acceptor options (ListenBacklog, ReusePort...) are taken from opts as well.
+func NewEchoServer(addr string, opts ...Option) (*EchoServer, error) {
+	r, err := NewReactor(opts...)
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := echoConnPool.Get()
+		c.setReactor(r)
+		return c
+	}, addr, opts...)
+	if err != nil {
+		r.release() // not running yet
+		return nil, err
+	}
+	s := &EchoServer{r: r, a: a, runC: make(chan error, 1)}
+	go func() { s.runC <- r.Run() }()
+	return s, nil
+}
+
+// Addr returns the local address of the listener
+func (s *EchoServer) Addr() string {
+	switch addr := s.a.Addr().(type) {
+	case *net.TCPAddr:
+		return addr.String()
+	case *net.UnixAddr:
+		return addr.Name
+	}
+	return ""
+}
+
+// Close closes the listener and all connections, it returns after the reactor has exited
+func (s *EchoServer) Close() error {
+	err := s.r.Shutdown(context.Background())
+	if rerr := <-s.runC; !errors.Is(rerr, ErrReactorClosed) {
+		return rerr
+	}
+	return err
+}
+
+func (c *echoServerConn) OnOpen(fd int) bool {
+	return c.GetReactor().AddEvHandler(c, fd, EvInET) == nil
+}
+
+func (c *echoServerConn) OnRead() bool {
+	for {
+		_, err := c.rb.ReadFromHandler(c)
+		if c.rb.Len() > 0 {
+			if _, werr := c.w.Write(c.rb.Bytes()); werr != nil {
+				return false
+			}
+			c.rb.Discard(c.rb.Len())
+		}
+		if err != ErrReadBufferFull { // not until EAGAIN yet
+			return err == nil
+		}
+	}
+}
+
+func (c *echoServerConn) OnWrite() bool {
+	return c.w.Flush() == nil
+}
+
+func (c *echoServerConn) OnClose() {
+	syscall.Close(c.Fd())
+	echoConnPool.Put(c)
+}
@@ -0,0 +1,86 @@
+package goev
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoServer(t *testing.T) {
+	s, err := NewEchoServer("127.0.0.1:0", EvPollNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := s.Addr()
+	const clients = 8
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp4", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			// larger than the socket buffers, so the Writer backlog is exercised
+			msg := bytes.Repeat([]byte("client#"+strconv.Itoa(i)+" "), 64*1024)
+			go conn.Write(msg)
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(got, msg) {
+				t.Errorf("client#%d: echo mismatch", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp4", addr); err == nil {
+		conn.Close()
+		t.Fatal("still accepting after Close")
+	}
+}
+
+func TestEchoServerError(t *testing.T) {
+	before, _ := openFds(t)
+	if _, err := NewEchoServer("127.0.0.1:x", EvPollNum(2)); err == nil {
+		t.Fatal("invalid address accepted")
+	}
+	if after, _ := openFds(t); after != before {
+		t.Fatalf("%d fds open after the failure, %d before", after, before)
+	}
+}
+
+func BenchmarkEchoServer(b *testing.B) {
+	s, err := NewEchoServer("127.0.0.1:0", EvPollNum(2))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := net.Dial("tcp4", s.Addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	msg, got := make([]byte, 512), make([]byte, 512)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Write(msg)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,10 +2,24 @@ package goev
 
 import (
 	"errors"
+	"fmt"
 	"runtime"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// The initial number of events returned by a single epoll_wait
+	evPollSize = 256
+
+	// The events buffer shrinks after so many consecutive waits using less than 1/4 of it
+	evPollShrinkAfter = 1024
 )
 
 type evPoll struct {
@@ -16,49 +30,119 @@ type evPoll struct {
 	evPollWriteBuff []byte
 
 	evHandlerMap *evDataMap // Refer to https://zhuanlan.zhihu.com/p/640712548
-	timer        *timer4Heap
+	timer        timer
+	timerWheel   *timerWheel // not nil if timer is driven by the epoll_wait timeout
+
+	timerWheelDelay int64 // delay of the next timer wheel check, millisecond
 
 	// async write
 	asyncWrite *asyncWrite
+
+	// epoll_wait timeout in millisecond, -1 means blocking indefinitely
+	pollTimeout     int
+	pollTimeoutHook func(millisecond int64)
+
+	readyNum int // the maximum size of the events buffer
+
+	busyPollSpins int // non-blocking epoll_wait before blocking, see EvPollBusyPoll
+
+	now atomic.Int64 // millisecond, cached when epoll_wait returns, 0 while blocking
+
+	stats  evPollStats
+	logger Logger
+	tracer Tracer // nil if not tracing
+
+	panicRecovery bool // recover the panics of EvHandler callbacks
+
+	gracefulTimeout int64 // millisecond, see readClose
+	drainTimeout    int64 // millisecond, see closeDrain
+
+	overload        OverloadPolicy // nil if no load shedding, see EvPollOverload
+	overloadPending int
+	overloadBudget  int64 // millisecond
+
+	// stop
+	running atomic.Bool
+	stopped atomic.Bool
+	stopC   chan struct{} // closed by stop, releases the goroutines waiting for the evpoll
+	wakeup  *evPollWakeup
 }
 
-func (ep *evPoll) open(evFdMaxSize int, timer *timer4Heap,
-	evPollReadBuffSize, evPollWriteBuffSize int) error {
+func (ep *evPoll) open(evFdMaxSize, evFdGrowLimit int, timer timer,
+	evPollReadBuffSize, evPollWriteBuffSize int,
+	pollTimeout int, pollTimeoutHook func(int64)) error {
 	efd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
 	if err != nil {
 		return errors.New("goev: epoll_create1 " + err.Error())
 	}
 	ep.efd = efd
+	ep.stopC = make(chan struct{})
 	ep.timer = timer
+	ep.timer.setClock(ep.nowMilli)
+	ep.timerWheel, _ = timer.(*timerWheel)
+	ep.pollTimeout = pollTimeout
+	ep.pollTimeoutHook = pollTimeoutHook
 	ep.evPollReadBuff = make([]byte, evPollReadBuffSize)
 	ep.evPollWriteBuff = make([]byte, evPollWriteBuffSize)
-	ep.evHandlerMap = newEvDataMap(evFdMaxSize)
+	ep.evHandlerMap = newEvDataMap(evFdMaxSize, evFdGrowLimit)
 	ep.asyncWrite, err = newAsyncWrite(ep)
 	if err != nil {
 		return err
 	}
+	ep.wakeup, err = newEvPollWakeup(ep)
+	if err != nil {
+		return err
+	}
 
 	// process max fds
 	// show using `ulimit -Hn`
 	// $GOROOT/src/os/rlimit.go Go had raise the limit to 'Max Hard Limit'
 	return nil
 }
+
+// release closes the fds opened by open, the evpoll MUST NOT run
+func (ep *evPoll) release() {
+	if ep.stopC == nil { // not opened
+		return
+	}
+	if ep.wakeup != nil {
+		syscall.Close(ep.wakeup.efd)
+	}
+	if ep.asyncWrite != nil {
+		syscall.Close(ep.asyncWrite.efd)
+	}
+	if th, ok := ep.timer.(*timer4Heap); ok {
+		syscall.Close(th.timerfd())
+	}
+	syscall.Close(ep.efd)
+}
+
+// errTriggerMode the trigger mode (EPOLLET or level-triggered) is chosen when the fd is added, it
+// can't be changed by the later modifications
+var errTriggerMode = errors.New("epoll_ctl mod: can't change the trigger mode (EPOLLET) of a registered fd")
+
 func (ep *evPoll) loadEvData(fd int) *evData {
 	return ep.evHandlerMap.load(fd)
 }
 func (ep *evPoll) add(fd int, events uint32, eh EvHandler) error {
+	ed, ok := ep.evHandlerMap.register(fd, eh)
+	if !ok && ep.staleFd(fd) { // the fd registered has been closed out of band, the number reused
+		ep.reapDeadFd(fd)
+		ed, ok = ep.evHandlerMap.register(fd, eh)
+	}
+	if !ok {
+		return errors.New("epoll_ctl add: fd has been registered")
+	}
 	eh.setParams(fd, ep)
-
-	ev := syscall.EpollEvent{Events: events}
-	ed := ep.evHandlerMap.newOne(fd)
-	ed.fd = fd
+	ed.peerShut = false
 	ed.events = events
-	ed.eh = eh
-	ep.evHandlerMap.store(fd, ed) // 让evHandlerMap 来控制eh的生命周期, 不然会被gc回收的
+	ed.watched.Store(events)
+	ev := syscall.EpollEvent{Events: events}
 	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
 
 	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
 		// ENOSPC cat /proc/sys/fs/epoll/max_user_watches
+		ep.evHandlerMap.del(fd)
 		return errors.New("epoll_ctl add: " + err.Error())
 	}
 	return nil
@@ -66,8 +150,15 @@ func (ep *evPoll) add(fd int, events uint32, eh EvHandler) error {
 func (ep *evPoll) remove(fd int) error {
 	// The event argument is ignored and can be NULL (but see `man 2 epoll_ctl` BUGS)
 	// kernel versions > 2.6.9
+	err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_DEL, fd, nil)
+
+	if ed := ep.evHandlerMap.load(fd); ed != nil && ed.fd == fd {
+		ed.eh.releaseConn()
+		ed.eh.stopHeartbeat()
+	}
+	// The kernel no longer references evData, so it can be recycled
 	ep.evHandlerMap.del(fd)
-	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_DEL, fd, nil); err != nil {
+	if err != nil {
 		return errors.New("epoll_ctl del: " + err.Error())
 	}
 	return nil
@@ -78,14 +169,10 @@ func (ep *evPoll) append(fd int, events uint32) error {
 		return errors.New("append: not found")
 	}
 
-	ev := syscall.EpollEvent{Events: events | ed.events}
-	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
-
-	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, fd, &ev); err != nil {
-		return errors.New("epoll_ctl mod: " + err.Error())
+	if events&EPOLLET != 0 && ed.events&EPOLLET == 0 {
+		return errTriggerMode
 	}
-	ed.events |= events
-	return nil
+	return ep.modify(ed, ed.events|events)
 }
 func (ep *evPoll) subtract(fd int, events uint32) error {
 	ed := ep.evHandlerMap.load(fd)
@@ -93,21 +180,76 @@ func (ep *evPoll) subtract(fd int, events uint32) error {
 		return errors.New("subtract: not found")
 	}
 
-	ev := syscall.EpollEvent{Events: ed.events &^ events}
+	if events&EPOLLET != 0 {
+		return errTriggerMode
+	}
+	return ep.modify(ed, ed.events&^events)
+}
+func (ep *evPoll) rearm(fd int, events uint32) error {
+	ed := ep.evHandlerMap.load(fd)
+	if ed == nil {
+		return errors.New("rearm: not found")
+	}
+	if events&EPOLLET != ed.events&EPOLLET {
+		return errTriggerMode
+	}
+	return ep.modify(ed, events)
+}
+
+// modify replaces the events of the registered ed. The evData is reused, the kernel keeps
+// referencing it, only add takes one from the registry.
+func (ep *evPoll) modify(ed *evData, events uint32) error {
+	if ed.peerShut { // level-triggered, it would be reported again and again (e.g. with EvOut)
+		events &^= syscall.EPOLLRDHUP
+	}
+	ev := syscall.EpollEvent{Events: events}
 	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
 
-	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, fd, &ev); err != nil {
+	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, ed.fd, &ev); err != nil {
+		if isDeadFd(err) {
+			ep.reapDeadFd(ed.fd)
+		}
 		return errors.New("epoll_ctl mod: " + err.Error())
 	}
-	ed.events &= ^events
+	ed.events = events
+	ed.watched.Store(events)
 	return nil
 }
 func (ep *evPoll) scheduleTimer(eh EvHandler, delay, interval int64) (err error) {
 	err = ep.timer.schedule(eh, delay, interval)
 	return
 }
-func (ep *evPoll) cancelTimer(eh EvHandler) {
-	ep.timer.cancel(eh)
+func (ep *evPoll) cancelTimer(eh EvHandler) bool {
+	return ep.timer.cancel(eh)
+}
+func (ep *evPoll) scheduleTimerFunc(eh EvHandler, delay, interval int64,
+	fn func(int64, any) bool, arg any) (TimerID, error) {
+	if delay < 0 || interval < 0 || fn == nil {
+		return TimerID{}, errors.New("params are invalid")
+	}
+	ti := &timerItem{
+		expiredAt: ep.nowMilli() + delay,
+		interval:  interval,
+		eh:        eh,
+		fn:        fn,
+		arg:       arg,
+	}
+	ep.timer.scheduleItem(ti)
+	return TimerID{ti: ti}, nil
+}
+func (ep *evPoll) cancelTimerID(id TimerID) bool {
+	if id.ti == nil {
+		return false
+	}
+	return ep.timer.cancelItem(id.ti)
+}
+func (ep *evPoll) resetTimer(eh EvHandler, delay int64) error {
+	var interval int64
+	if ti := eh.getTimerItem(); ti != nil {
+		interval = ti.interval
+		ep.timer.cancel(eh)
+	}
+	return ep.timer.schedule(eh, delay, interval)
 }
 
 // io handle
@@ -127,49 +269,460 @@ func (ep *evPoll) push(awi asyncWriteItem) {
 }
 
 // end of `io handle'
+
+// broadcast writes abf to the handlers accepted by filter in the order of the other async writes
+func (ep *evPoll) broadcast(abf AsyncWriteBuf, filter func(eh EvHandler) bool) {
+	ep.evHandlerMap.walk(func(fd int, ed *evData) bool {
+		if eh := ed.eh; filter(eh) {
+			eh.asyncOrderedWrite(eh, abf)
+		}
+		return true
+	})
+}
+
+// nowMilli returns the time cached when epoll_wait returned, the handlers of a batch of events
+// see the same time. It is the current time while evpoll is blocking (or not running), so the
+// timers scheduled from the other goroutines are not based on a stale time.
+func (ep *evPoll) nowMilli() int64 {
+	if now := ep.now.Load(); now > 0 {
+		return now
+	}
+	return time.Now().UnixMilli()
+}
+
+// blockingTimeout returns the timeout of a blocking epoll_wait, considering the next expiration of
+// timer wheel
+func (ep *evPoll) blockingTimeout() int {
+	if ep.timerWheel == nil || ep.timerWheelDelay < 1 {
+		return ep.pollTimeout
+	}
+	if ep.pollTimeout < 0 || ep.timerWheelDelay < int64(ep.pollTimeout) {
+		return int(ep.timerWheelDelay)
+	}
+	return ep.pollTimeout
+}
+
+// onPollTimeout called when epoll_wait times out without any event
+func (ep *evPoll) onPollTimeout() {
+	now := ep.nowMilli()
+	ep.timer.handleExpired(now) // due timers, timerfd will be adjusted on its next readable event
+	if ep.pollTimeoutHook != nil {
+		ep.pollTimeoutHook(now)
+	}
+}
+
+// closeEvData removes the fd from epoll and then calls OnClose.
+//
+// ev.Fd has been overwritten by the *evData, so the fd MUST be taken from evData.
+func (ep *evPoll) closeEvData(ed *evData) {
+	eh, fd := ed.eh, ed.fd // ed may be recycled by remove
+	if fd < 1 || eh == nil {
+		return // reaped, see reapDeadFd
+	}
+	if !eh.setClosed() { // e.g. a stale event of the batch, the fd MUST NOT be removed again
+		return
+	}
+	// MUST before OnClose()
+	if err := ep.remove(fd); err != nil {
+		if !isDeadFd(err) {
+			ep.logger.Errorf("goev: close fd %d: %s", fd, err.Error())
+		} else { // closed out of band, OnClose must not close the number reused
+			ep.stats.deadFdReaps.Add(1)
+			eh.setFd(-1)
+		}
+	}
+	if ep.tracer != nil {
+		ep.traceClosed(eh, fd)
+	}
+	eh.OnClose()
+}
 func (ep *evPoll) run(wg *sync.WaitGroup) error {
 	if wg != nil {
 		defer wg.Done()
 	}
+	ep.running.Store(true)
+	defer ep.running.Store(false)
+	defer ep.now.Store(0)
 
-	var nfds, i, msec int
+	var nfds, i, msec, spins int
 	var err error
-	events := make([]syscall.EpollEvent, 256) // does not escape
-	msec = -1
+	var begin time.Time // of the batch, only for the overload budget
+	evs := newEvPollEvents(evPollSize, ep.readyNum)
+	ep.stats.eventsBufSize.Store(uint64(len(evs.events)))
+	if ep.timerWheel != nil {
+		ep.timerWheelDelay = ep.timerWheel.handleExpired(time.Now().UnixMilli())
+	}
+	msec = ep.blockingTimeout()
 	for {
+		events := evs.events
+		if msec != 0 {
+			ep.now.Store(0) // blocking, see nowMilli
+		}
 		nfds, err = syscall.EpollWait(ep.efd, events, msec)
-		if nfds > 0 {
-			msec = 0
+		ep.now.Store(time.Now().UnixMilli())
+		ep.stats.epollWaitCalls.Add(1)
+		if ep.stopped.Load() {
+			return nil
+		}
+		if ep.timerWheel != nil {
+			ep.timerWheelDelay = ep.timerWheel.handleExpired(ep.nowMilli())
+		}
+		switch {
+		case nfds > 0:
+			msec, spins = 0, 0
+			ep.stats.eventsReturned.Add(uint64(nfds))
+			if ep.overload != nil && ep.overloadBudget > 0 {
+				begin = time.Now()
+			}
 			for i = 0; i < nfds; i++ {
 				ev := &events[i]
 				ed := *(**evData)(unsafe.Pointer(&ev.Fd))
-				// EPOLLHUP refer to man 2 epoll_ctl
-				if ev.Events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
-					ep.remove(ed.fd) // MUST before OnClose()
-					ed.eh.OnClose()
+				if ed.fd < 1 { // removed by a previous handler in this batch
 					continue
 				}
-				if ev.Events&(syscall.EPOLLOUT) != 0 { // MUST before EPOLLIN (e.g. connect)
-					if ed.eh.OnWrite() == false {
-						ep.remove(ed.fd) // MUST before OnClose()
-						ed.eh.OnClose()
-						continue
-					}
-				}
-				if ev.Events&(syscall.EPOLLIN) != 0 {
-					if ed.eh.OnRead() == false {
-						ep.remove(ed.fd) // MUST before OnClose()
-						ed.eh.OnClose()
-						continue
-					}
+				if ep.overload != nil && ep.shed(ed, nfds, i, begin) {
+					continue
 				}
+				ep.stats.eventsProcessed.Add(1)
+				ep.handleEvent(ed, ev.Events)
 			} // end of `for i < nfds'
-		} else if nfds == 0 || (nfds < 0 && err == syscall.EINTR) { // timeout
-			msec = -1
+			if evs.adjust(nfds) {
+				ep.stats.eventsBufSize.Store(uint64(len(evs.events)))
+			}
+		case nfds == 0: // timeout
+			if msec == 0 && spins < ep.busyPollSpins { // busy poll before blocking
+				spins++
+				runtime.Gosched()
+				continue
+			}
+			if msec > 0 && msec == ep.pollTimeout { // not the msec=0 retry or timer wheel
+				ep.onPollTimeout()
+			}
+			if evs.adjust(0) {
+				ep.stats.eventsBufSize.Store(uint64(len(evs.events)))
+			}
+			msec = ep.blockingTimeout()
 			runtime.Gosched() // https://zhuanlan.zhihu.com/p/647958433
+		case err == syscall.EINTR: // a signal arrived during the wait, nothing is ready
+			ep.logger.Debugf("goev: epoll_wait interrupted (EINTR)")
+			msec = ep.blockingTimeout()
+		default:
+			return fmt.Errorf("syscall epoll_wait: %w", err) // fatal
+		}
+	}
+}
+
+// evPollEvents is the events buffer of epoll_wait, owned by the evpoll coroutine.
+// It doubles (up to maxSize) when a wait fills it up, so that a burst of ready fds is taken by fewer
+// epoll_wait, and halves (down to minSize) after sustained underutilization.
+type evPollEvents struct {
+	// EpollWait stores at most len(events) events, so the length (not only the capacity) matters
+	events  []syscall.EpollEvent
+	minSize int
+	maxSize int
+	idle    int // consecutive waits using less than 1/4 of events
+}
+
+func newEvPollEvents(minSize, maxSize int) *evPollEvents {
+	if maxSize < minSize {
+		minSize = maxSize
+	}
+	return &evPollEvents{
+		events:  make([]syscall.EpollEvent, minSize),
+		minSize: minSize,
+		maxSize: maxSize,
+	}
+}
+
+// adjust is called after the events returned by epoll_wait have been handled, returns true if
+// the buffer has been resized
+func (e *evPollEvents) adjust(nfds int) bool {
+	size := len(e.events)
+	if nfds == size {
+		e.idle = 0
+		if size >= e.maxSize {
+			return false
+		}
+		size *= 2
+		if size > e.maxSize {
+			size = e.maxSize
+		}
+		e.events = make([]syscall.EpollEvent, size)
+		return true
+	}
+	if nfds > size/4 || size == e.minSize {
+		e.idle = 0
+		return false
+	}
+	if e.idle++; e.idle < evPollShrinkAfter {
+		return false
+	}
+	e.idle = 0
+	size /= 2
+	if size < e.minSize {
+		size = e.minSize
+	}
+	e.events = make([]syscall.EpollEvent, size)
+	return true
+}
+
+// handleEvent dispatches the events of one fd to its EvHandler
+func (ep *evPoll) handleEvent(ed *evData, events uint32) {
+	defer ep.recoverPanic(ed.eh, ed.fd)
+
+	if ed.eh.draining() { // closing, see closeDrain
+		ep.drain(ed)
+		return
+	}
+	if events&syscall.EPOLLERR != 0 { // MSG_ZEROCOPY completions, see Writer.SetZeroCopy
+		eh, fd := ed.eh, ed.fd
+		if eh.zeroCopyNotified() {
+			if ed.fd != fd || ed.eh != eh { // removed in OnZeroCopyDone
+				return
+			}
+			events &^= syscall.EPOLLERR
+		}
+	}
+	// EPOLLHUP refer to man 2 epoll_ctl
+	if events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+		if events&syscall.EPOLLERR == 0 && ep.hangUp(ed) {
+			return
+		}
+		ep.stats.hupErrCloses.Add(1)
+		if events&syscall.EPOLLERR != 0 {
+			ep.closeReason(ed.eh, ClosedByError)
+		} else {
+			ep.closeReason(ed.eh, ClosedByPeer)
+		}
+		ep.closeEvData(ed)
+		return
+	}
+	if events&(syscall.EPOLLOUT) != 0 { // MUST before EPOLLIN (e.g. connect)
+		if ed.eh.OnWrite() == false {
+			ep.closeEvData(ed)
+			return
+		}
+		if ed.eh.gracefulDone() { // drained after CloseGracefully
+			ep.closeDrain(ed)
+			return
+		}
+	}
+	if events&(syscall.EPOLLRDHUP) != 0 && ep.peerShutdown(ed) {
+		return
+	}
+	if events&(syscall.EPOLLPRI) != 0 { // e.g. urgent data, before the in-band data
+		if h, ok := ed.eh.(PriorityHandler); ok {
+			eh, fd := ed.eh, ed.fd
+			h.OnPriority(fd)
+			if ed.fd != fd || ed.eh != eh { // removed in OnPriority
+				return
+			}
+		}
+	}
+	if events&(syscall.EPOLLIN) != 0 {
+		ed.eh.setInbound()
+		if ep.tracer != nil {
+			ep.traceFirstByte(ed)
+		}
+		if ed.eh.OnRead() == false {
+			ep.readClose(ed, events)
+			return
+		}
+	}
+	if ed.fd > 0 { // not removed in OnRead/OnWrite
+		ed.eh.setActive()
+	}
+}
+
+// readClose closes ed after OnRead returned false. If the peer has only shut down its writing side
+// (e.g. it sent a request and shutdown(SHUT_WR)) and the writes of the handler are pending (the
+// response is larger than the socket buffer), they are flushed first like CloseGracefully, so that
+// the response is not cut by the read-driven close.
+func (ep *evPoll) readClose(ed *evData, events uint32) {
+	if events&syscall.EPOLLRDHUP != 0 {
+		ep.closeReason(ed.eh, ClosedByPeer)
+	}
+	if events&syscall.EPOLLRDHUP != 0 && ed.eh.pendingOut() > 0 {
+		ep.closeGracefully(ed.fd, ed.eh, ep.gracefulTimeout)
+		return
+	}
+	ep.closeDrain(ed)
+}
+
+// recoverPanic is deferred around the callbacks of eh, see onPanic
+func (ep *evPoll) recoverPanic(eh EvHandler, fd int) {
+	if !ep.panicRecovery {
+		return
+	}
+	if v := recover(); v != nil {
+		ep.onPanic(eh, fd, v)
+	}
+}
+
+// onPanic logs the panic v of eh and closes eh (OnClose is called) so that the evpoll keeps serving
+// the other fds, the internal handlers are kept.
+// Returns false if eh is not registered with fd (e.g. it panicked in OnOpen before AddEvHandler)
+func (ep *evPoll) onPanic(eh EvHandler, fd int, v any) bool {
+	ep.stats.panics.Add(1)
+	ep.logger.Errorf("goev: panic in EvHandler of fd %d: %v\n%s", fd, v, debug.Stack())
+	if ep.internal(eh) {
+		return true
+	}
+	ed := ep.loadEvData(fd)
+	if ed == nil || ed.eh != eh {
+		return false
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			ep.logger.Errorf("goev: panic in OnClose of fd %d: %v", fd, v)
+		}
+	}()
+	ep.closeEvData(ed)
+	return true
+}
+
+// internal returns true if eh is a handler of the evpoll itself
+func (ep *evPoll) internal(eh EvHandler) bool {
+	switch eh.(type) {
+	case *asyncWrite, *evPollWakeup, *timer4Heap:
+		return true
+	}
+	return false
+}
+
+// closeAll closes the handlers accepted by filter, the internal handlers are kept
+func (ep *evPoll) closeAll(filter func(eh EvHandler) bool) {
+	ep.evHandlerMap.walk(func(fd int, ed *evData) bool {
+		if eh := ed.eh; !ep.internal(eh) && filter(eh) {
+			ep.closeEvData(ed)
+		}
+		return true
+	})
+}
+
+// pendingWrites returns the number of async write buffers waiting to be sent
+func (ep *evPoll) pendingWrites() (n int) {
+	ep.evHandlerMap.walk(func(fd int, ed *evData) bool {
+		if q, ok := ed.eh.(interface{ AsyncWaitWriteQLen() int }); ok {
+			n += q.AsyncWaitWriteQLen()
+		}
+		return true
+	})
+	return n
+}
+
+// hangUpHandler is implemented by the internal handlers which still read after EPOLLHUP, e.g. the
+// data received before both directions were shut down
+type hangUpHandler interface {
+	// onHangUp returns false to close as usual, otherwise it MUST remove the fd from the evpoll or
+	// close it (EPOLLHUP is reported until then)
+	onHangUp() bool
+}
+
+// hangUp calls onHangUp if eh implements hangUpHandler, returns false to close eh
+func (ep *evPoll) hangUp(ed *evData) bool {
+	h, ok := ed.eh.(hangUpHandler)
+	return ok && h.onHangUp()
+}
+
+// peerShutdown calls OnPeerShutdown if eh implements PeerShutdownHandler, returns false if not
+func (ep *evPoll) peerShutdown(ed *evData) bool {
+	h, ok := ed.eh.(PeerShutdownHandler)
+	if !ok {
+		return false
+	}
+	if ed.peerShut { // called already
+		return true
+	}
+	if h.OnPeerShutdown(ed.fd) == false {
+		ep.closeReason(ed.eh, ClosedByPeer)
+		ep.closeEvData(ed)
+		return true
+	}
+	if ed.fd > 0 { // EPOLLRDHUP is level-triggered
+		ed.peerShut = true
+		ep.subtract(ed.fd, syscall.EPOLLIN|syscall.EPOLLRDHUP)
+		ed.eh.setActive()
+	}
+	return true
+}
+
+// stop makes run return nil, it can be called from any goroutine
+func (ep *evPoll) stop() {
+	if ep.stopped.CompareAndSwap(false, true) {
+		if ep.stopC != nil {
+			close(ep.stopC)
+		}
+		if ep.asyncWrite != nil {
+			ep.asyncWrite.stoppedPosts()
+		}
+		ep.wakeup.notify()
+	}
+}
+
+// evPollWakeup interrupts a blocked epoll_wait through eventfd
+type evPollWakeup struct {
+	IOHandle
+
+	efd int
+}
+
+func newEvPollWakeup(ep *evPoll) (*evPollWakeup, error) {
+	fd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return nil, errors.New("goev: eventfd " + err.Error())
+	}
+	w := &evPollWakeup{efd: fd}
+	if err = ep.add(fd, EvEventfd, w); err != nil {
+		syscall.Close(fd)
+		return nil, errors.New("goev.evPollWakeup add to evpoll fail! " + err.Error())
+	}
+	return w, nil
+}
+func (w *evPollWakeup) notify() {
+	var v int64 = 1
+	for {
+		_, err := syscall.Write(w.efd, (*(*[8]byte)(unsafe.Pointer(&v)))[:]) // man 2 eventfd
+		if err != nil && err == syscall.EINTR {
+			continue
+		}
+		break
+	}
+}
+
+// OnRead reset eventfd counter
+func (w *evPollWakeup) OnRead() bool {
+	var bf [8]byte
+	for {
+		_, err := syscall.Read(w.efd, bf[:])
+		if err != nil && err == syscall.EINTR {
 			continue
-		} else if err != nil {
-			return errors.New("syscall epoll_wait: " + err.Error())
 		}
+		break
 	}
+	return true
+}
+
+var (
+	epollExclusiveOnce sync.Once
+	epollExclusiveOK   bool
+)
+
+// epollExclusiveSupported probes whether the running kernel accepts EPOLLEXCLUSIVE
+func epollExclusiveSupported() bool {
+	epollExclusiveOnce.Do(func() {
+		efd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			return
+		}
+		defer syscall.Close(efd)
+		fd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		if err != nil {
+			return
+		}
+		defer syscall.Close(fd)
+		ev := syscall.EpollEvent{Events: EvAcceptExclusive}
+		epollExclusiveOK = syscall.EpollCtl(efd, syscall.EPOLL_CTL_ADD, fd, &ev) == nil
+	})
+	return epollExclusiveOK
 }
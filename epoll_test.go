@@ -0,0 +1,910 @@
+package goev
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type pipeReader struct {
+	IOHandle
+
+	readC  chan []byte
+	closeC chan struct{}
+}
+
+func newPipeReader() *pipeReader {
+	return &pipeReader{
+		readC:  make(chan []byte, 16),
+		closeC: make(chan struct{}, 1),
+	}
+}
+func (p *pipeReader) OnRead() bool {
+	bf, n, _ := p.Read()
+	if n > 0 {
+		p.readC <- append([]byte(nil), bf...)
+		return true
+	}
+	return false
+}
+func (p *pipeReader) OnClose() {
+	if p.Fd() != -1 {
+		syscall.Close(p.Fd())
+		p.Destroy(p)
+	}
+	p.closeC <- struct{}{}
+}
+
+func newPipe(t *testing.T) (r, w int) {
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	return fds[0], fds[1]
+}
+
+func TestEvPollPipeOnRead(t *testing.T) {
+	r, err := NewReactor(EvPollNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(wfd)
+	h := newPipeReader()
+	if err = r.AddEvHandler(h, rfd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(wfd, []byte{'x'})
+
+	select {
+	case bf := <-h.readC:
+		if string(bf) != "x" {
+			t.Fatalf("read %q, want %q", bf, "x")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRead not fired")
+	}
+}
+
+func TestEvPollEvDataPoolReuse(t *testing.T) {
+	r, err := NewReactor(EvPollNum(1), EvFdMaxSize(1)) // all fds are stored in the map
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &r.evPolls[0]
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+
+	h := newPipeReader()
+	allocs := testing.AllocsPerRun(1000, func() {
+		if err := ep.add(rfd, EvIn, h); err != nil {
+			t.Fatal(err)
+		}
+		if err := ep.remove(rfd); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs >= 1 {
+		t.Fatalf("evData not reused, %v allocs per add/remove", allocs)
+	}
+	if ep.loadEvData(rfd) != nil {
+		t.Fatal("evData still registered after remove")
+	}
+}
+
+type errEventHandler struct {
+	IOHandle
+
+	closeC chan int
+}
+
+func (h *errEventHandler) OnRead() bool  { return true }
+func (h *errEventHandler) OnWrite() bool { return true }
+func (h *errEventHandler) OnClose()      { h.closeC <- h.Fd() }
+
+func TestEvPollRemoveOnErrEvent(t *testing.T) {
+	r, err := NewReactor(EvPollNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(wfd)
+	h := &errEventHandler{closeC: make(chan int, 1)}
+	if err = r.AddEvHandler(h, wfd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(rfd) // EPOLLERR on the write end
+
+	select {
+	case fd := <-h.closeC:
+		if fd != wfd {
+			t.Fatalf("OnClose fd %d, want %d", fd, wfd)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not fired")
+	}
+	ep := &r.evPolls[0]
+	if ep.loadEvData(wfd) != nil {
+		t.Fatal("evData still registered")
+	}
+	// Already deleted from epoll by the poller
+	if err = syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_DEL, wfd, nil); err != syscall.ENOENT {
+		t.Fatalf("epoll_ctl del: %v, want ENOENT", err)
+	}
+}
+
+func TestEvPollTimeout(t *testing.T) {
+	var n atomic.Int32
+	r, err := NewReactor(
+		EvPollNum(1),
+		EvPollTimeout(50),
+		EvPollTimeoutHook(func(int64) { n.Add(1) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	// every wakeup waits for the timeout, an upper bound would depend on the load of the machine
+	begin := time.Now()
+	for n.Load() < 5 {
+		if time.Since(begin) > 5*time.Second {
+			t.Fatalf("woke up %d times in 5s with 50ms timeout", n.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(begin); elapsed < 200*time.Millisecond {
+		t.Fatalf("woke up 5 times in %v with 50ms timeout", elapsed)
+	}
+}
+
+type oneShotHandler struct {
+	IOHandle
+
+	n atomic.Int32
+}
+
+func (h *oneShotHandler) OnRead() bool {
+	h.n.Add(1) // don't read, level-triggered would fire again
+	return true
+}
+func (h *oneShotHandler) OnClose() {}
+
+func TestEvPollOneShot(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+
+	h := &oneShotHandler{}
+	if err := r.AddEvHandler(h, rfd, EvInOneShot); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(wfd, []byte{'x'})
+	time.Sleep(100 * time.Millisecond)
+	if n := h.n.Load(); n != 1 {
+		t.Fatalf("oneshot fd fired %d times, want 1", n)
+	}
+	if err := r.ReArm(h, rfd, EvInOneShot); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := h.n.Load(); n != 2 {
+		t.Fatalf("oneshot fd fired %d times after ReArm, want 2", n)
+	}
+}
+
+func TestEvPollEdgeTriggered(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	for _, tc := range []struct {
+		name   string
+		events uint32
+		once   bool
+	}{
+		{"ET", EvInET, true},
+		{"LT", EvIn, false},
+	} {
+		rfd, wfd := newPipe(t)
+		defer syscall.Close(rfd)
+		defer syscall.Close(wfd)
+
+		h := &oneShotHandler{} // counts OnRead without reading
+		if err := r.AddEvHandler(h, rfd, tc.events); err != nil {
+			t.Fatal(err)
+		}
+		syscall.Write(wfd, []byte{'x'})
+		time.Sleep(50 * time.Millisecond)
+		n := h.n.Load()
+		if tc.once && n != 1 {
+			t.Fatalf("%s: fired %d times for one edge, want 1", tc.name, n)
+		} else if !tc.once && n < 2 {
+			t.Fatalf("%s: fired %d times, want repeatedly", tc.name, n)
+		}
+		if tc.once {
+			syscall.Write(wfd, []byte{'y'}) // a new edge
+			time.Sleep(50 * time.Millisecond)
+			if n = h.n.Load(); n != 2 {
+				t.Fatalf("%s: fired %d times for two edges, want 2", tc.name, n)
+			}
+		}
+		r.RemoveEvHandler(h, rfd)
+	}
+
+	// mixing modes on the same fd
+	ep := &r.evPolls[0]
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+	if err := ep.add(rfd, EvIn, &oneShotHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.remove(rfd)
+	if err := ep.append(rfd, EvOutET); err != errTriggerMode {
+		t.Fatalf("append ET to a LT fd: %v", err)
+	}
+	if err := ep.rearm(rfd, EvInET); err != errTriggerMode {
+		t.Fatalf("rearm a LT fd with ET: %v", err)
+	}
+	if err := ep.append(rfd, EvOut); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// halfCloseConn sends a large response once the peer has shut down its writing side
+type halfCloseConn struct {
+	IOHandle
+
+	w      *Writer
+	req    []byte
+	called int
+}
+
+func (c *halfCloseConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	c.w = NewWriter(c)
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096) // sent in OnWrite
+	return true
+}
+func (c *halfCloseConn) OnRead() bool {
+	bf, n, _ := c.Read()
+	if n == 0 {
+		panic("OnRead called for the half-close")
+	}
+	c.req = append(c.req, bf...)
+	return true
+}
+func (c *halfCloseConn) OnPeerShutdown(fd int) bool {
+	if c.called++; c.called > 1 {
+		panic("OnPeerShutdown called twice")
+	}
+	for { // the data before the shutdown
+		bf, n, _ := c.Read()
+		if n < 1 {
+			break
+		}
+		c.req = append(c.req, bf...)
+	}
+	resp := bytes.Repeat(c.req, (1<<20)/len(c.req))
+	if _, err := c.w.Write(resp); err != nil {
+		return false
+	}
+	return c.w.Buffered() > 0 // close now if all has been sent
+}
+func (c *halfCloseConn) OnWrite() bool {
+	return c.w.Flush() == nil && c.w.Buffered() > 0 // close once flushed
+}
+func (c *halfCloseConn) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+func TestEvPollPeerShutdown(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &halfCloseConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	conn.Write([]byte("request"))
+	conn.(*net.TCPConn).CloseWrite()
+	resp, err := io.ReadAll(conn) // until the server closes
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte("request"), (1<<20)/len("request"))
+	if !bytes.Equal(resp, want) {
+		t.Fatalf("got %d bytes, want %d", len(resp), len(want))
+	}
+}
+
+// lateReplyConn replies after the half-close of the peer, from a timer
+type lateReplyConn struct {
+	halfCloseConn
+}
+
+func (c *lateReplyConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	c.w = NewWriter(c)
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+	return true
+}
+func (c *lateReplyConn) OnPeerShutdown(fd int) bool {
+	return c.ScheduleTimer(c, 20, 0) == nil
+}
+func (c *lateReplyConn) OnTimeout(millisecond int64) bool {
+	c.w.Write(make([]byte, 1<<20)) // EvOut is added back
+	return false
+}
+
+func TestEvPollPeerShutdownRearm(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &lateReplyConn{}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.(*net.TCPConn).CloseWrite()
+	// the reply waits for the socket buffer, EPOLLRDHUP must not be reported again meanwhile
+	time.Sleep(200 * time.Millisecond)
+	if n := r.evPolls[0].stats.eventsProcessed.Load(); n > 100 {
+		t.Fatalf("%d events processed while the reply was pending", n)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if n, err := io.ReadFull(conn, make([]byte, 1<<20)); n != 1<<20 {
+		t.Fatalf("read %d bytes of the reply: %v", n, err)
+	}
+}
+
+// panicConn is an echo connection which panics on "read", "timer" or in OnOpen
+type panicConn struct {
+	echoConn
+
+	panicOpen bool
+}
+
+func (c *panicConn) OnOpen(fd int) bool {
+	if c.panicOpen {
+		panic("open")
+	}
+	return c.GetReactor().AddEvHandler(c, fd, EvIn) == nil
+}
+func (c *panicConn) OnRead() bool {
+	bf, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	switch string(bf) {
+	case "read":
+		panic("read")
+	case "timer":
+		c.ScheduleTimer(c, 1, 0)
+		return true
+	}
+	c.Write(bf)
+	return true
+}
+func (c *panicConn) OnTimeout(millisecond int64) bool {
+	panic("timer")
+}
+func (c *panicConn) OnClose() {
+	c.echoConn.OnClose()
+}
+
+func TestEvPollPanicRecovery(t *testing.T) {
+	l := &captureLogger{}
+	r := newTestReactor(t, EvPollNum(1), EvLogger(l))
+	var panicOpen atomic.Bool
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &panicConn{panicOpen: panicOpen.Load()}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	closed := func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("read %v, want EOF", err)
+		}
+	}
+	alive := dial()
+	echo(t, alive, "hello")
+
+	for _, s := range []string{"read", "timer"} {
+		conn := dial()
+		echo(t, conn, "hello")
+		conn.Write([]byte(s))
+		closed(conn)
+		echo(t, alive, "still alive after "+s)
+	}
+
+	panicOpen.Store(true)
+	closed(dial())
+	panicOpen.Store(false)
+	echo(t, dial(), "acceptor alive") // panic in OnOpen does not close the acceptor
+	echo(t, alive, "still alive after open")
+
+	if n := r.Stats()[0].Panics; n != 3 {
+		t.Fatalf("%d panics recovered, want 3", n)
+	}
+	for _, s := range []string{"read", "timer", "open"} {
+		if !l.contains("E goev: panic in EvHandler", ": "+s+"\n") {
+			t.Fatalf("panic %q not logged", s)
+		}
+	}
+}
+
+func TestEvPollEventsAdjust(t *testing.T) {
+	e := newEvPollEvents(4, 16)
+	for _, want := range []int{8, 16, 16} { // filled up
+		e.adjust(len(e.events))
+		if len(e.events) != want {
+			t.Fatalf("size %d, want %d", len(e.events), want)
+		}
+	}
+	for i := 1; i < evPollShrinkAfter; i++ {
+		if e.adjust(i % 2) { // underutilized
+			t.Fatal("shrunk too early")
+		}
+	}
+	e.adjust(8) // not underutilized, restart counting
+	for i := 1; i < evPollShrinkAfter; i++ {
+		e.adjust(0)
+	}
+	if !e.adjust(0) || len(e.events) != 8 {
+		t.Fatalf("size %d after sustained underutilization, want 8", len(e.events))
+	}
+	for i := 0; i < 4*evPollShrinkAfter; i++ {
+		e.adjust(0)
+	}
+	if len(e.events) != 4 {
+		t.Fatalf("size %d, want the min size 4", len(e.events))
+	}
+
+	if e = newEvPollEvents(256, 64); len(e.events) != 64 || e.adjust(64) {
+		t.Fatal("EvReadyNum less than the initial size")
+	}
+}
+
+// burstHandler reads its eventfd and counts the events
+type burstHandler struct {
+	IOHandle
+
+	efd   int
+	n     *atomic.Int64
+	doneC chan struct{}
+	total int64
+}
+
+func (h *burstHandler) OnRead() bool {
+	var bf [8]byte
+	syscall.Read(h.efd, bf[:])
+	if h.n.Add(1) == h.total {
+		h.doneC <- struct{}{}
+	}
+	return true
+}
+
+// BenchmarkEvPollBurst makes 2048 fds ready at once, and reports epoll_wait calls per burst.
+// After the events buffer has grown, a burst takes fewer epoll_wait.
+func BenchmarkEvPollBurst(b *testing.B) {
+	const fds = 2048
+	for _, c := range []struct {
+		name     string
+		readyNum int
+	}{{"fixed256", evPollSize}, {"autoGrow", 4096}} {
+		b.Run(c.name, func(b *testing.B) {
+			r, err := NewReactor(EvPollNum(1), EvReadyNum(c.readyNum))
+			if err != nil {
+				b.Fatal(err)
+			}
+			go r.Run()
+			defer r.Stop()
+
+			var n atomic.Int64
+			doneC := make(chan struct{}, 1)
+			efds := make([]int, fds)
+			for i := range efds {
+				efd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer syscall.Close(efd)
+				efds[i] = efd
+				h := &burstHandler{efd: efd, n: &n, doneC: doneC, total: fds}
+				if err = r.AddEvHandler(h, efd, EvIn); err != nil {
+					b.Fatal(err)
+				}
+			}
+			v := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+			burst := func() { // within evpoll, so all fds are ready at the next epoll_wait
+				n.Store(0)
+				r.Post(efds[0], func() {
+					for _, efd := range efds {
+						syscall.Write(efd, v)
+					}
+				})
+				<-doneC
+			}
+			burst() // warm up, the buffer grows
+			b.ResetTimer()
+			before := r.Stats()[0].EpollWaitCalls
+			for i := 0; i < b.N; i++ {
+				burst()
+			}
+			waits := r.Stats()[0].EpollWaitCalls - before
+			b.ReportMetric(float64(waits)/float64(b.N), "waits/burst")
+		})
+	}
+}
+
+func TestEvPollBusyPoll(t *testing.T) {
+	const spins = 1000
+	r := newTestReactor(t, EvPollNum(2), EvPollBusyPoll([]int{0, spins}))
+	for i, want := range []uint64{2, spins} { // epoll_wait calls after an event
+		doneC := make(chan struct{})
+		r.Post(2+i, func() { close(doneC) }) // evpoll#i
+		<-doneC
+		time.Sleep(50 * time.Millisecond)
+		before := r.Stats()[i].EpollWaitCalls
+		doneC = make(chan struct{})
+		r.Post(2+i, func() { close(doneC) })
+		<-doneC
+		time.Sleep(50 * time.Millisecond) // blocked again
+		if n := r.Stats()[i].EpollWaitCalls - before; n < want || n > want+4 {
+			t.Fatalf("evpoll#%d: %d epoll_wait calls after an event, want %d", i, n, want)
+		}
+	}
+}
+
+// latencyHandler reports the time of OnRead
+type latencyHandler struct {
+	IOHandle
+
+	readC chan time.Time
+}
+
+func (h *latencyHandler) OnRead() bool {
+	now := time.Now()
+	h.Read()
+	h.readC <- now
+	return true
+}
+
+// BenchmarkEvPollBusyPoll reports the p99 latency from a write to the OnRead of the peer under
+// light load (a message per 100us), with and without busy polling.
+func BenchmarkEvPollBusyPoll(b *testing.B) {
+	for _, c := range []struct {
+		name  string
+		spins int
+	}{{"blocking", 0}, {"spin", 100000}} {
+		b.Run(c.name, func(b *testing.B) {
+			r, err := NewReactor(EvPollNum(1), EvPollBusyPoll([]int{c.spins}))
+			if err != nil {
+				b.Fatal(err)
+			}
+			go r.Run()
+			defer r.Stop()
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer syscall.Close(fds[0])
+			defer syscall.Close(fds[1])
+			syscall.SetNonblock(fds[0], true)
+			h := &latencyHandler{readC: make(chan time.Time, 1)}
+			if err = r.AddEvHandler(h, fds[0], EvIn); err != nil {
+				b.Fatal(err)
+			}
+
+			lat := make([]time.Duration, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for begin := time.Now(); time.Since(begin) < 100*time.Microsecond; {
+				}
+				begin := time.Now()
+				syscall.Write(fds[1], []byte{'x'})
+				lat[i] = (<-h.readC).Sub(begin)
+			}
+			b.StopTimer()
+			sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+			b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds()), "p99-ns")
+		})
+	}
+}
+
+func TestReactorAddFd(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(wfd)
+	h := newPipeReader()
+
+	if err := r.AddFd(rfd, 0, h); err == nil {
+		t.Fatal("AddFd accepted invalid events")
+	}
+	if err := r.AddFd(1<<20, EvIn, h); err == nil {
+		t.Fatal("AddFd accepted a closed fd")
+	}
+	if err := r.AddFd(rfd, EvIn, h); err != nil {
+		t.Fatal(err)
+	}
+	if h.GetReactor() != r {
+		t.Fatal("reactor of the handler not set")
+	}
+	syscall.Write(wfd, []byte("ping"))
+	select {
+	case bf := <-h.readC:
+		if string(bf) != "ping" {
+			t.Fatalf("read %q", bf)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRead not fired")
+	}
+
+	if err := r.RemoveFd(rfd); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveFd(rfd); err == nil {
+		t.Fatal("RemoveFd of a removed fd succeeded")
+	}
+	syscall.Write(wfd, []byte("pong"))
+	select {
+	case <-h.readC:
+		t.Fatal("OnRead fired after RemoveFd")
+	case <-h.closeC:
+		t.Fatal("OnClose called by RemoveFd")
+	case <-time.After(50 * time.Millisecond):
+	}
+	bf := make([]byte, 8) // still owned and open
+	if n, err := syscall.Read(rfd, bf); err != nil || string(bf[:n]) != "pong" {
+		t.Fatalf("read %q, %v after RemoveFd", bf[:n], err)
+	}
+	syscall.Close(rfd)
+}
+
+// oobConn receives the urgent byte of a TCP connection by OnPriority
+type oobConn struct {
+	IOHandle
+
+	oobC  chan byte
+	dataC chan string
+}
+
+func (c *oobConn) OnPriority(fd int) {
+	var b [1]byte
+	if n, _, err := syscall.Recvfrom(fd, b[:], syscall.MSG_OOB); err == nil && n == 1 {
+		c.oobC <- b[0]
+	}
+}
+
+func (c *oobConn) OnRead() bool {
+	bf, n, err := c.Read()
+	if n > 0 {
+		c.dataC <- string(bf[:n])
+	}
+	return err == nil || err == syscall.EAGAIN
+}
+
+func (c *oobConn) OnClose() {
+	syscall.Close(c.Fd())
+}
+
+func TestReactorPriorityEvent(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := conn.(*net.TCPConn).File()
+	fd, _ := syscall.Dup(int(f.Fd())) // not closed by the finalizer of f
+	f.Close()
+	conn.Close()
+	syscall.SetNonblock(fd, true)
+
+	h := &oobConn{oobC: make(chan byte, 1), dataC: make(chan string, 4)}
+	if err = r.AddFd(fd, EvIn|EvPri|EPOLLWAKEUP, h); err != nil { // EPOLLWAKEUP may be ignored
+		t.Fatal(err)
+	}
+	cfd, _ := client.(*net.TCPConn).File()
+	defer cfd.Close()
+	if err = syscall.Sendto(int(cfd.Fd()), []byte("!"), syscall.MSG_OOB, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-h.oobC:
+		if b != '!' {
+			t.Fatalf("urgent byte %q", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPriority not called")
+	}
+	client.Write([]byte("abc")) // in-band data still goes to OnRead
+	select {
+	case s := <-h.dataC:
+		if s != "abc" {
+			t.Fatalf("OnRead got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRead not called")
+	}
+}
+
+func TestEvPollModifyReusesEvData(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1), EvFdMaxSize(1)) // fds in the map region, pooled evData
+	ep := &r.evPolls[0]
+	var allocs atomic.Int64
+	ep.evHandlerMap.pool.New = func() any {
+		allocs.Add(1)
+		return &evData{}
+	}
+	rfd, wfd := newPipe(t)
+	defer syscall.Close(wfd)
+	if ep.evHandlerMap.slot(rfd) != nil {
+		t.Fatal("fd in the array region")
+	}
+	h := newPipeReader()
+
+	errC := make(chan error, 1)
+	r.Post(rfd, func() {
+		if err := r.AddEvHandler(h, rfd, EvIn); err != nil {
+			errC <- err
+			return
+		}
+		ed := ep.loadEvData(rfd)
+		n := ep.evHandlerMap.count()
+		for i := 0; i < 100; i++ {
+			if err := ep.append(rfd, EvOut); err != nil {
+				errC <- err
+				return
+			}
+			if err := ep.subtract(rfd, syscall.EPOLLOUT); err != nil {
+				errC <- err
+				return
+			}
+			if err := ep.rearm(rfd, EvIn); err != nil {
+				errC <- err
+				return
+			}
+			if ep.loadEvData(rfd) != ed || ep.evHandlerMap.count() != n {
+				errC <- errors.New("evData replaced by a modification")
+				return
+			}
+		}
+		errC <- nil
+	})
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	if n := allocs.Load(); n > 1 {
+		t.Fatalf("%d evData allocated for one fd", n)
+	}
+	syscall.Write(wfd, []byte("x")) // still delivered with the final events
+	select {
+	case <-h.readC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRead not fired after the modifications")
+	}
+}
+
+// closeOnceConn counts its OnClose
+type closeOnceConn struct {
+	IOHandle
+
+	closes atomic.Int64
+}
+
+func (c *closeOnceConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n > 0 // false on EOF
+}
+func (c *closeOnceConn) OnClose() {
+	c.closes.Add(1)
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+func TestEvPollCloseOnce(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &closeOnceConn{}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	fd := fds[0]
+
+	// the read failure (EOF), the HUP and a close posted are all in the same batch
+	release := blockEvPoll(t, r)
+	syscall.Write(fds[1], []byte("x"))
+	syscall.Close(fds[1])
+	syscall.Shutdown(fd, syscall.SHUT_WR) // both directions shut down: EPOLLHUP
+	if err = r.CloseGracefully(fd); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	for begin := time.Now(); c.closes.Load() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(begin) > 2*time.Second {
+			t.Fatal("OnClose not called")
+		}
+	}
+
+	// a stale event of the batch for the closed handler
+	ep := &r.evPolls[0]
+	runIn(t, r, fd, func() { ep.closeEvData(&evData{fd: fd, eh: c}) })
+	time.Sleep(20 * time.Millisecond)
+	if n := c.closes.Load(); n != 1 {
+		t.Fatalf("OnClose called %d times", n)
+	}
+	if n := r.Stats()[0].DeadFdReaps; n != 0 {
+		t.Fatalf("fd removed %d times again", n)
+	}
+}
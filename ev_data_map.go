@@ -2,24 +2,55 @@ package goev
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type evData struct {
-	fd     int
-	events uint32
-	eh     EvHandler
+	fd       int
+	events   uint32
+	eh       EvHandler
+	peerShut bool // OnPeerShutdown has been called, EPOLLRDHUP is not watched any more
+
+	used    atomic.Bool   // claimed, only for the array region
+	watched atomic.Uint32 // events, for the other goroutines, see Reactor.WatchedEvents
 }
 
+const (
+	evDataChunkBits = 10
+	evDataChunkSize = 1 << evDataChunkBits
+	evDataChunkMask = evDataChunkSize - 1
+)
+
+// The array region is made of chunks, the kernel references the address of evData (epoll_event.data),
+// so growing appends chunks instead of reallocating the array.
+type evDataChunk [evDataChunkSize]evData
+
 type evDataMap struct {
-	arrSize int
-	arr     []evData // 如果针对fd, 这里应该可以不用atomic, 直接保存value
+	arrSize   atomic.Int64                   // fds < arrSize are stored in the array region
+	arr       atomic.Pointer[[]*evDataChunk] // replaced (not modified) when growing
+	initSize  int                            // fds >= initSize may be in the map after growing
+	growLimit int                            // the array region grows up to it, 0 means fixed
 
 	// sync.Map is not suitable for use in evpoll as it is write-only, without read support
 	sMap   map[int]*evData
 	mapMtx sync.Mutex
+
+	// evData of fds beyond arrSize is recycled after the fd has been removed from epoll
+	pool sync.Pool
+
+	// evData of the map region reaped after its fd was closed out of band. The kernel may still
+	// reference it (the file lives on if the fd was dup'ed), so it is never recycled and kept
+	// referenced from here, its events are ignored (fd 0).
+	tombs []*evData
+
+	n atomic.Int64 // number of registered fds of both regions
+
+	// held by register/del, so that handler can be called from any goroutine. The evpoll reads
+	// without it, the writers are serialized by it.
+	regMtx sync.RWMutex
 }
 
-func newEvDataMap(arrSize int) *evDataMap {
+func newEvDataMap(arrSize, growLimit int) *evDataMap {
 	if arrSize < 1 {
 		panic("evFdMaxSize < 1")
 	}
@@ -28,32 +59,159 @@ func newEvDataMap(arrSize int) *evDataMap {
 		mapPreSize = 128
 	}
 	amu := &evDataMap{
-		arrSize: arrSize,
-		arr:     make([]evData, arrSize),
-		sMap:    make(map[int]*evData, mapPreSize),
+		initSize:  arrSize,
+		growLimit: growLimit,
+		sMap:      make(map[int]*evData, mapPreSize),
+	}
+	chunks := make([]*evDataChunk, (arrSize+evDataChunkMask)>>evDataChunkBits)
+	for i := range chunks {
+		chunks[i] = new(evDataChunk)
+	}
+	amu.arr.Store(&chunks)
+	amu.arrSize.Store(int64(arrSize))
+	amu.pool.New = func() any {
+		return &evData{}
 	}
 	return amu
 }
 
-func (dm *evDataMap) newOne(i int) *evData {
-	if i < dm.arrSize {
-		p := &(dm.arr[i])
-		if p.fd > 0 { // fd MUST > 0
-			panic("fd release fail!")
+// slot returns the evData of the array region, nil if i is beyond it
+func (dm *evDataMap) slot(i int) *evData {
+	if int64(i) >= dm.arrSize.Load() {
+		return nil
+	}
+	return &((*dm.arr.Load())[i>>evDataChunkBits][i&evDataChunkMask])
+}
+
+// claim reserves the evData of fd i, returns false if i has been registered.
+// It is atomic in both regions, so of concurrent claims of the same fd exactly one succeeds.
+func (dm *evDataMap) claim(i int) (*evData, bool) {
+	if p := dm.slot(i); p != nil {
+		if !p.used.CompareAndSwap(false, true) {
+			return nil, false
 		}
-		return p
+		if dm.maybeInMap(i) && dm.loadFromMap(i) != nil { // stored in the map before growing
+			p.used.Store(false)
+			return nil, false
+		}
+		p.fd = i
+		dm.n.Add(1)
+		return p, true
+	}
+	dm.mapMtx.Lock()
+	if _, ok := dm.sMap[i]; ok {
+		dm.mapMtx.Unlock()
+		return nil, false
+	}
+	if int64(i) < dm.arrSize.Load() || dm.grow(i) { // grown by another claim or now
+		dm.mapMtx.Unlock()
+		return dm.claim(i)
+	}
+	v := dm.pool.Get().(*evData)
+	v.fd = i
+	dm.sMap[i] = v // 让evHandlerMap 来控制eh的生命周期, 不然会被gc回收的
+	dm.n.Add(1)
+	dm.mapMtx.Unlock()
+	return v, true
+}
+
+// register claims the evData of fd i for eh, returns false if i has been registered
+func (dm *evDataMap) register(i int, eh EvHandler) (*evData, bool) {
+	dm.regMtx.Lock()
+	defer dm.regMtx.Unlock()
+	ed, ok := dm.claim(i)
+	if ok {
+		ed.eh = eh
+	}
+	return ed, ok
+}
+
+// handler returns the EvHandler registered with fd i, nil if none. It can be called from any
+// goroutine.
+func (dm *evDataMap) handler(i int) EvHandler {
+	dm.regMtx.RLock()
+	defer dm.regMtx.RUnlock()
+	if ed := dm.load(i); ed != nil && ed.fd == i {
+		return ed.eh
+	}
+	return nil
+}
+
+// unregister deletes the registration of fd i and returns its EvHandler, nil if none. Of concurrent
+// calls for the same registration exactly one gets the EvHandler. It is for the fds closed out of
+// band: the evData of the map region is tombstoned, not recycled, see tombs.
+func (dm *evDataMap) unregister(i int) EvHandler {
+	dm.regMtx.Lock()
+	defer dm.regMtx.Unlock()
+	ed := dm.load(i)
+	if ed == nil || ed.fd != i || ed.eh == nil {
+		return nil
+	}
+	eh := ed.eh
+	if v := dm.delLocked(i); v != nil {
+		v.fd, v.events, v.eh = 0, 0, nil // release eh
+		dm.tombs = append(dm.tombs, v)
+	}
+	return eh
+}
+
+// watched returns the events of fd i, 0 if it is not registered. It can be called from any
+// goroutine.
+func (dm *evDataMap) watched(i int) uint32 {
+	dm.regMtx.RLock()
+	defer dm.regMtx.RUnlock()
+	if ed := dm.load(i); ed != nil && ed.fd == i {
+		return ed.watched.Load()
 	}
-	return &evData{}
+	return 0
+}
+
+// grow extends the array region to cover i if the map region holds too many fds (1/8 of the
+// array), MUST be called with mapMtx locked.
+//
+// The fds in the map region stay there until they are deleted, their evData is referenced by
+// the kernel and can not be moved.
+func (dm *evDataMap) grow(i int) bool {
+	arrSize := int(dm.arrSize.Load())
+	if i >= dm.growLimit || len(dm.sMap) < arrSize/8 {
+		return false
+	}
+	newSize := arrSize * 2
+	for newSize <= i {
+		newSize *= 2
+	}
+	if newSize > dm.growLimit {
+		newSize = dm.growLimit
+	}
+	old := *dm.arr.Load()
+	chunks := make([]*evDataChunk, (newSize+evDataChunkMask)>>evDataChunkBits)
+	copy(chunks, old)
+	for j := len(old); j < len(chunks); j++ {
+		chunks[j] = new(evDataChunk)
+	}
+	dm.arr.Store(&chunks) // before arrSize, so that slot never sees a short chunk list
+	dm.arrSize.Store(int64(newSize))
+	return true
 }
 
 func (dm *evDataMap) load(i int) *evData {
-	if i < dm.arrSize {
-		p := &(dm.arr[i])
-		if p.fd < 1 {
+	if p := dm.slot(i); p != nil {
+		if p.fd > 0 {
+			return p
+		}
+		if !dm.maybeInMap(i) {
 			return nil
 		}
-		return p
 	}
+	return dm.loadFromMap(i)
+}
+
+// maybeInMap returns true if i in the array region may have been stored in the map before growing
+func (dm *evDataMap) maybeInMap(i int) bool {
+	return dm.growLimit > 0 && i >= dm.initSize
+}
+
+func (dm *evDataMap) loadFromMap(i int) *evData {
 	dm.mapMtx.Lock()
 	if v, ok := dm.sMap[i]; ok {
 		dm.mapMtx.Unlock()
@@ -63,22 +221,75 @@ func (dm *evDataMap) load(i int) *evData {
 	return nil
 }
 
-func (dm *evDataMap) store(i int, v *evData) {
-	if i < dm.arrSize {
-		return
+func (dm *evDataMap) del(i int) {
+	dm.regMtx.Lock()
+	defer dm.regMtx.Unlock()
+	if v := dm.delLocked(i); v != nil {
+		v.fd, v.events, v.eh = 0, 0, nil // release eh
+		dm.pool.Put(v)
+	}
+}
+
+// delLocked MUST be called with regMtx locked, returns the evData deleted from the map region
+// (nil for the array region), the caller recycles it
+func (dm *evDataMap) delLocked(i int) *evData {
+	if p := dm.slot(i); p != nil && p.fd > 0 {
+		p.fd = -1
+		if p.used.CompareAndSwap(true, false) {
+			dm.n.Add(-1)
+		}
+		return nil
+	} else if p != nil && !dm.maybeInMap(i) {
+		p.fd = -1
+		return nil
 	}
 	dm.mapMtx.Lock()
-	dm.sMap[i] = v
+	v, ok := dm.sMap[i]
+	if ok {
+		delete(dm.sMap, i)
+		dm.n.Add(-1)
+	}
 	dm.mapMtx.Unlock()
+	if ok {
+		return v
+	}
+	return nil
 }
 
-func (dm *evDataMap) del(i int) {
-	if i < dm.arrSize {
-		p := &(dm.arr[i])
-		p.fd = -1
-		return
+// count returns the number of registered fds, it can be called from any goroutine
+func (dm *evDataMap) count() int {
+	return int(dm.n.Load())
+}
+
+// walk calls f for each registered fd, the array region first and then the map region,
+// stops if f returns false.
+//
+// The map region is a snapshot taken under the lock, so f may add or delete fds, it sees the
+// entries registered before walk is called. The array region is not locked, call walk within
+// the evpoll coroutine like the other accessors of the array.
+func (dm *evDataMap) walk(f func(fd int, ed *evData) bool) {
+	arrSize := int(dm.arrSize.Load())
+	for i := 0; i < arrSize; i++ {
+		p := dm.slot(i)
+		if p.fd < 1 {
+			continue
+		}
+		if f(i, p) == false {
+			return
+		}
 	}
 	dm.mapMtx.Lock()
-	delete(dm.sMap, i)
+	items := make([]*evData, 0, len(dm.sMap))
+	for _, v := range dm.sMap {
+		items = append(items, v)
+	}
 	dm.mapMtx.Unlock()
+	for _, v := range items {
+		if v.fd < 1 { // deleted by f
+			continue
+		}
+		if f(v.fd, v) == false {
+			return
+		}
+	}
 }
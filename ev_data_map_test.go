@@ -0,0 +1,184 @@
+package goev
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEvDataMapWalk(t *testing.T) {
+	const arrSize = 8
+	dm := newEvDataMap(arrSize, 0)
+	for fd := arrSize - 4; fd < arrSize+4; fd++ { // straddle the array/map boundary
+		dm.claim(fd)
+	}
+	dm.del(arrSize - 1)
+	dm.del(arrSize + 1)
+
+	seen := make(map[int]int)
+	dm.walk(func(fd int, ed *evData) bool {
+		if ed.fd != fd {
+			t.Fatalf("fd %d, evData.fd %d", fd, ed.fd)
+		}
+		seen[fd]++
+		return true
+	})
+	for fd := arrSize - 4; fd < arrSize+4; fd++ {
+		want := 1
+		if fd == arrSize-1 || fd == arrSize+1 {
+			want = 0
+		}
+		if seen[fd] != want {
+			t.Fatalf("fd %d visited %d times, want %d", fd, seen[fd], want)
+		}
+	}
+
+	n := 0
+	dm.walk(func(fd int, ed *evData) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Fatalf("walk did not stop, %d visited", n)
+	}
+}
+
+func TestEvDataMapUnregisterTombstone(t *testing.T) {
+	const arrSize = 8
+	dm := newEvDataMap(arrSize, 0)
+	eh := &deadConn{}
+	ed, _ := dm.register(arrSize+1, eh)
+	if got := dm.unregister(arrSize + 1); got != eh {
+		t.Fatalf("unregister returned %v", got)
+	}
+	if ed.fd != 0 || ed.eh != nil {
+		t.Fatalf("tombstone fd %d, eh %v", ed.fd, ed.eh)
+	}
+	for i := 0; i < 8; i++ { // the kernel may still deliver events with ed
+		v, _ := dm.register(arrSize+2, &deadConn{})
+		if v == ed {
+			t.Fatal("tombstone recycled")
+		}
+		dm.del(arrSize + 2)
+	}
+	if len(dm.tombs) != 1 || dm.tombs[0] != ed {
+		t.Fatalf("%d tombstones", len(dm.tombs))
+	}
+}
+
+func TestEvDataMapCount(t *testing.T) {
+	const arrSize, goroutines, perG = 64, 8, 32 // half of the fds are in the map region
+	dm := newEvDataMap(arrSize, 0)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perG; i++ {
+				fd := 1 + g*perG + i
+				dm.claim(fd)
+				if i%2 == 1 { // keep the even ones
+					dm.del(fd)
+					dm.del(fd) // deleting twice doesn't count
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := dm.count(); n != goroutines*perG/2 {
+		t.Fatalf("count %d, want %d", n, goroutines*perG/2)
+	}
+}
+
+func TestEvDataMapClaim(t *testing.T) {
+	const arrSize = 8
+	dm := newEvDataMap(arrSize, 0)
+	for _, fd := range []int{arrSize - 1, arrSize + 1} { // array and map region
+		for round := 0; round < 100; round++ {
+			var wins atomic.Int32
+			var wg sync.WaitGroup
+			for g := 0; g < 2; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, ok := dm.claim(fd); ok {
+						wins.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := wins.Load(); n != 1 {
+				t.Fatalf("fd %d claimed %d times", fd, n)
+			}
+			if ed := dm.load(fd); ed == nil || ed.fd != fd {
+				t.Fatalf("fd %d not loaded after claim", fd)
+			}
+			dm.del(fd)
+		}
+	}
+	if n := dm.count(); n != 0 {
+		t.Fatalf("count %d after del", n)
+	}
+}
+
+func TestEvDataMapGrow(t *testing.T) {
+	const arrSize, limit = 64, 4096
+	dm := newEvDataMap(arrSize, limit)
+	for fd := 1; fd < 3*arrSize; fd++ {
+		if _, ok := dm.claim(fd); !ok {
+			t.Fatalf("claim fd %d failed", fd)
+		}
+	}
+	if n := int(dm.arrSize.Load()); n <= arrSize {
+		t.Fatalf("array region not grown, size %d", n)
+	}
+	dm.mapMtx.Lock()
+	mapped := len(dm.sMap)
+	dm.mapMtx.Unlock()
+	if mapped == 0 || mapped >= 2*arrSize {
+		t.Fatalf("%d fds in the map", mapped)
+	}
+	for fd := 1; fd < 3*arrSize; fd++ { // wherever they are stored
+		if ed := dm.load(fd); ed == nil || ed.fd != fd {
+			t.Fatalf("fd %d lost after growing", fd)
+		}
+		if _, ok := dm.claim(fd); ok {
+			t.Fatalf("fd %d claimed twice", fd)
+		}
+	}
+	for fd := 1; fd < 3*arrSize; fd++ {
+		dm.del(fd)
+		if dm.load(fd) != nil {
+			t.Fatalf("fd %d loaded after del", fd)
+		}
+	}
+	if n := dm.count(); n != 0 {
+		t.Fatalf("count %d after del", n)
+	}
+	if _, ok := dm.claim(limit + 1); !ok { // beyond the limit, in the map
+		t.Fatal("claim beyond the limit failed")
+	}
+	if n := int(dm.arrSize.Load()); n > limit {
+		t.Fatalf("array region %d beyond the limit", n)
+	}
+}
+
+// BenchmarkEvDataMapLoad compares fds stored in the map with the grown array
+func BenchmarkEvDataMapLoad(b *testing.B) {
+	const arrSize, fds = 64, 8192
+	for _, c := range []struct {
+		name  string
+		limit int
+	}{{"map", 0}, {"grown", fds * 2}} {
+		b.Run(c.name, func(b *testing.B) {
+			dm := newEvDataMap(arrSize, c.limit)
+			for fd := arrSize; fd < fds; fd++ {
+				dm.claim(fd)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dm.load(fds - 1 - i%(fds/2))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package goev
 
 import (
+	"sync/atomic"
 	"syscall"
 )
 
@@ -8,6 +9,10 @@ const (
 	// EPOLLET Refer to sys/epoll.h
 	EPOLLET = 1 << 31
 
+	// EPOLLONESHOT Refer to sys/epoll.h
+	// The fd is disabled after one event is pulled out, call Reactor.ReArm to enable it again.
+	EPOLLONESHOT = syscall.EPOLLONESHOT
+
 	// EvIn is readable event
 	EvIn uint32 = syscall.EPOLLIN | syscall.EPOLLRDHUP
 
@@ -15,11 +20,19 @@ const (
 	EvOut uint32 = syscall.EPOLLOUT | syscall.EPOLLRDHUP
 
 	// EvInET is readable event in EPOLLET mode
+	//
+	// The trigger mode is chosen per fd by the events of AddEvHandler (e.g. EvAccept for acceptor,
+	// EvInET for connections), the later modifications (Writer, ReArm...) keep it and can't change it.
+	// In EPOLLET mode the event is reported once per readiness edge, OnRead MUST read until EAGAIN,
+	// otherwise the remaining data is not reported again until more data arrives.
 	EvInET uint32 = EvIn | EPOLLET
 
-	// EvOutET is readable event in EPOLLET mode
+	// EvOutET is writeable event in EPOLLET mode, OnWrite MUST write until EAGAIN or nothing is left
 	EvOutET uint32 = EvOut | EPOLLET
 
+	// EvInOneShot is readable event in EPOLLONESHOT mode
+	EvInOneShot uint32 = EvIn | EPOLLONESHOT
+
 	// EvEventfd used for eventfd
 	EvEventfd uint32 = syscall.EPOLLIN | syscall.EPOLLRDHUP // Not ET mode
 
@@ -27,10 +40,35 @@ const (
 	// 用水平触发, 循环Accept有可能会导致不可控
 	EvAccept uint32 = syscall.EPOLLIN | syscall.EPOLLRDHUP
 
+	// EPOLLEXCLUSIVE Refer to sys/epoll.h (kernel >= 4.5)
+	EPOLLEXCLUSIVE = 1 << 28
+
+	// EvAcceptExclusive used for acceptor, when multiple evpolls watch the same listener only one
+	// of them is woken up per connection.
+	// EPOLLEXCLUSIVE can not be combined with EPOLLRDHUP (EINVAL)
+	EvAcceptExclusive uint32 = syscall.EPOLLIN | EPOLLEXCLUSIVE
+
 	// EvConnect used for connector
 	EvConnect uint32 = syscall.EPOLLIN | syscall.EPOLLOUT | syscall.EPOLLRDHUP
+
+	// EPOLLWAKEUP Refer to sys/epoll.h (kernel >= 3.5)
+	// The system is kept from suspending while the event is pending or being handled (until the
+	// next epoll_wait), it requires CAP_BLOCK_SUSPEND and is silently ignored without it.
+	EPOLLWAKEUP = 1 << 29
+
+	// EvPri is the exceptional condition event (e.g. TCP urgent data), add it to the events of
+	// AddEvHandler and implement PriorityHandler
+	EvPri uint32 = syscall.EPOLLPRI
 )
 
+// PriorityHandler is optionally implemented by an EvHandler registered with EvPri
+type PriorityHandler interface {
+	// OnPriority is called before OnRead when EPOLLPRI is reported, e.g. read the urgent data of a
+	// TCP socket by recv(fd, buf, MSG_OOB). In level-triggered mode it is reported again until the
+	// condition is cleared.
+	OnPriority(fd int)
+}
+
 // EvHandler is the event handling interface of the Reactor core
 //
 // The same EvHandler is repeatedly registered with the Reactor
@@ -39,26 +77,76 @@ type EvHandler interface {
 	getEvPoll() *evPoll
 
 	setReactor(r *Reactor)
+
+	countRead(n int)
+	countWritten(n int)
+	BytesRead() uint64
+	BytesWritten() uint64
 	GetReactor() *Reactor
 
 	setTimerItem(ti *timerItem)
 	getTimerItem() *timerItem
 
+	// called by evpoll after OnRead/OnWrite succeeded
+	setActive()
+
+	// called by evpoll before OnRead, see SetHeartbeat
+	setInbound()
+	// called by evpoll when the fd is removed
+	stopHeartbeat()
+
+	// the open connections counter of the acceptor, released when the fd is removed
+	setConnCounter(c *atomic.Int64)
+	releaseConn()
+
+	// graceful close, see Reactor.CloseGracefully
+	setWriter(w *Writer)
+	pendingOut() int
+	setClosing(timerID TimerID)
+	gracefulDone() bool
+	gracefulClosing() bool
+	setDraining(timerID TimerID)
+	draining() bool
+	drainDone()
+
+	// see Tracer
+	getTrace() *traceState
+
+	// the connection the handler is registered for, it changes each time the handler is added, so
+	// that the timers of a previous connection are told apart when the handler is reused
+	connGen() uint32
+
+	// marks the handler closed by the reactor, returns false if it is already, so that OnClose is
+	// called once, see closeEvData
+	setClosed() bool
+
+	// reads the error queue on EPOLLERR, see Writer.SetZeroCopy
+	zeroCopyNotified() bool
+
 	// Fd return fd
 	Fd() int
+	setFd(fd int)
 
 	// OnOpen call by acceptor on `accept` a new fd or connector on `connect` successful
 	//
-	// Call OnClose() when return false
+	// Call OnClose() when return false, after removing fd from the reactor if OnOpen has registered
+	// it and canceling the timer of ScheduleTimer. The timers of ScheduleTimerFunc are not known to
+	// the reactor, cancel them in OnClose (CancelTimerID).
 	OnOpen(fd int) bool
 
 	// OnRead evpoll catch readable i/o event
 	//
-	// Call OnClose() when return false
+	// Call OnClose() when return false. If the peer has shut down its writing side (EPOLLRDHUP, e.g.
+	// OnRead reads EOF) and the Writer or the async write queue is not empty, the pending data is
+	// sent first like Reactor.CloseGracefully.
 	OnRead() bool
 
 	// OnWrite evpoll catch writeable i/o event
 	//
+	// The events reported together are handled in a fixed order: EPOLLOUT (OnWrite), EPOLLRDHUP
+	// (OnPeerShutdown), EPOLLPRI (OnPriority), EPOLLIN (OnRead). So the pending writes are flushed
+	// before the peer's shutdown or EOF is seen.
+	//
 	// Call OnClose() when return false
 	OnWrite() bool
 
@@ -66,7 +154,7 @@ type EvHandler interface {
 	// Only be asynchronously called after connector.Connect() returns nil
 	//
 	// Will not call OnClose() after OnConnectFail() (So you don't need to manually release the fd)
-	// The param err Refer to ev_handler.go: ErrConnect*
+	// The param err Refer to connector.go: ErrConnect*, use errors.Is to distinguish the causes
 	OnConnectFail(err error)
 
 	// OnTimeout evpoll catch timeout event
@@ -81,6 +169,9 @@ type EvHandler interface {
 	//
 	// You need to manually release the fd resource call fd.Close()
 	// You'd better only call fd.Close() here.
+	//
+	// If the fd has been closed out of band (e.g. by the handler itself, not through the reactor),
+	// Fd() returns -1 here, its number may have been reused by another file.
 	OnClose()
 
 	// Write
@@ -113,6 +204,18 @@ type EvHandler interface {
 	Destroy(eh EvHandler)
 }
 
+// PeerShutdownHandler is optionally implemented by an EvHandler to handle a half-close of the peer
+// (shutdown(SHUT_WR), EPOLLRDHUP without EPOLLHUP) distinctly from a full close.
+// An EvHandler without it sees the half-close as OnRead reading 0 bytes, and is usually closed.
+type PeerShutdownHandler interface {
+	// OnPeerShutdown is called instead of OnRead when the peer has shut down its writing side.
+	// The data sent before the shutdown can still be read here, and the response can still be sent
+	// (e.g. by Writer or async write), EvIn is disabled after it returns true.
+	//
+	// Call OnClose() when return false
+	OnPeerShutdown(fd int) bool
+}
+
 // Detecting illegal struct copies using `go vet`
 type noCopy struct{}
 
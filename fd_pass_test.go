@@ -0,0 +1,75 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shaovie/goev/netfd"
+)
+
+// fdReceiver writes its name to each fd received
+type fdReceiver struct {
+	IOHandle
+
+	gotC chan string
+}
+
+func (c *fdReceiver) OnOpen(fd int) bool {
+	return c.GetReactor().AddEvHandler(c, fd, EvIn) == nil
+}
+
+func (c *fdReceiver) OnRead() bool {
+	for {
+		fd, data, err := c.RecvFd()
+		if err == syscall.EAGAIN {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		if fd != -1 {
+			syscall.Write(fd, []byte("receiver"))
+			syscall.Close(fd)
+		}
+		c.gotC <- string(data)
+	}
+}
+
+func (c *fdReceiver) OnClose() {}
+
+func TestRecvFdInHandler(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	sender, receiver := newSocketPair(t)
+	syscall.SetNonblock(receiver, true)
+	c := &fdReceiver{gotC: make(chan string, 1)}
+	c.setReactor(r)
+	if !c.OnOpen(receiver) {
+		t.Fatal("add failed")
+	}
+
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+	err := netfd.SendFd(sender, p[1], []byte("hi"))
+	syscall.Close(p[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-c.gotC:
+		if data != "hi" {
+			t.Fatalf("received %q with the fd", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fd not received")
+	}
+	if got := string(readFull(t, p[0], len("receiver"))); got != "receiver" {
+		t.Fatalf("pipe got %q", got)
+	}
+	if c.BytesRead() != 2 {
+		t.Fatalf("bytes read %d", c.BytesRead())
+	}
+}
@@ -0,0 +1,159 @@
+package goev
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// feed appends p to rb as ReadFromFd would, false if the max size is reached
+func feed(rb *ReadBuffer, p []byte) bool {
+	for len(p) > 0 {
+		if rb.w == len(rb.buf) && rb.grow() == false {
+			return false
+		}
+		n := copy(rb.buf[rb.w:], p)
+		rb.w += n
+		p = p[n:]
+	}
+	return true
+}
+
+// splits returns data cut at the 2 offsets (modulo its length)
+func splits(data []byte, cut1, cut2 uint16) [][]byte {
+	if len(data) == 0 {
+		return [][]byte{data}
+	}
+	i, j := int(cut1)%len(data), int(cut2)%len(data)
+	if i > j {
+		i, j = j, i
+	}
+	return [][]byte{data[:i], data[i:j], data[j:]}
+}
+
+// lengthFieldDecode decodes the parts one after another, returns the payloads, the bytes left
+// and the error
+func lengthFieldDecode(t *testing.T, size int, parts [][]byte) ([]string, int, error) {
+	var msgs []string
+	codec, err := NewLengthFieldCodec(size, binary.BigEndian, 1024, func(payload []byte) {
+		msgs = append(msgs, string(payload))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := NewReadBuffer(16, 0)
+	defer rb.Release()
+	for _, p := range parts {
+		feed(rb, p)
+		if err = codec.Decode(rb); err != nil {
+			return msgs, rb.Len(), err
+		}
+	}
+	return msgs, rb.Len(), nil
+}
+
+func FuzzLengthFieldCodec(f *testing.F) {
+	codec, _ := NewLengthFieldCodec(2, binary.BigEndian, 1024, func([]byte) {})
+	data, _ := codec.Encode(nil, []byte("hello"))
+	data, _ = codec.Encode(data, nil)
+	data, _ = codec.Encode(data, make([]byte, 300))
+	f.Add(data, uint8(1), uint16(1), uint16(7))
+	f.Add([]byte{0, 0, 0, 3, 'a', 'b', 'c', 0xff}, uint8(2), uint16(2), uint16(5))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, uint8(3), uint16(0), uint16(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, sizeSel uint8, cut1, cut2 uint16) {
+		size := []int{1, 2, 4, 8}[sizeSel%4]
+		msgs, left, err := lengthFieldDecode(t, size, [][]byte{data})
+		if err != nil && !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		split, splitLeft, splitErr := lengthFieldDecode(t, size, splits(data, cut1, cut2))
+		if (err == nil) != (splitErr == nil) || !reflect.DeepEqual(msgs, split) {
+			t.Fatalf("split decoded %q, %v, want %q, %v", split, splitErr, msgs, err)
+		}
+		if err != nil {
+			return
+		}
+		if left != splitLeft {
+			t.Fatalf("split left %d bytes, want %d", splitLeft, left)
+		}
+		// the frames decoded are the input exactly, the incomplete one is left
+		var encoded []byte
+		for _, m := range msgs {
+			encoded, _ = (&LengthFieldCodec{lengthSize: size, order: binary.BigEndian,
+				maxFrameSize: 1024}).Encode(encoded, []byte(m))
+		}
+		if string(encoded) != string(data[:len(data)-left]) {
+			t.Fatalf("decoded frames %q differ from the input %q", encoded, data)
+		}
+	})
+}
+
+// httpParse parses the parts one after another, returns the complete requests and the error
+func httpParse(parts [][]byte) ([]*HTTPRequest, int, error) {
+	h := &HTTPHandler{rb: NewReadBuffer(4096, httpMaxHeaderSize+httpMaxBodySize)}
+	defer h.rb.Release()
+	var reqs []*HTTPRequest
+	for _, p := range parts {
+		if feed(h.rb, p) == false {
+			return reqs, h.rb.Len(), ErrReadBufferFull
+		}
+		for {
+			req, err := h.parse()
+			if err != nil {
+				return reqs, h.rb.Len(), err
+			}
+			if req == nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs, h.rb.Len(), nil
+}
+
+func FuzzHttpParser(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"), uint16(3), uint16(20))
+	f.Add([]byte("POST /p HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET /x HTTP/1.0\r\n\r\n"),
+		uint16(30), uint16(41))
+	f.Add([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;ext\r\nhello\r\n0\r\nTrailer: x\r\n\r\nGET / HTTP/1.1\r\n\r\n"), uint16(50), uint16(60))
+	f.Add([]byte("GET / HTTP/1.1\r\nContent-Length: -1\r\n\r\n"), uint16(0), uint16(1))
+	f.Add([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"), uint16(48), uint16(49))
+
+	// the terminator of the head (or of a chunk size line) right at the size limit, cut just before
+	// the end of the terminator
+	head := "GET / HTTP/1.1\r\nX: "
+	head += strings.Repeat("x", httpMaxHeaderSize-len(head))
+	f.Add([]byte(head+"\r\n\r\n"), uint16(len(head)+1), uint16(len(head)+1))
+	head = "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"
+	size := strings.Repeat("0", httpMaxHeaderSize) + "1"
+	f.Add([]byte(head+size+"\r\nx\r\n0\r\n\r\n"), uint16(len(head)+len(size)+1),
+		uint16(len(head)+len(size)+1))
+
+	f.Fuzz(func(t *testing.T, data []byte, cut1, cut2 uint16) {
+		reqs, left, err := httpParse([][]byte{data})
+		split, splitLeft, splitErr := httpParse(splits(data, cut1, cut2))
+		if (err == nil) != (splitErr == nil) {
+			t.Fatalf("split got error %v, want %v", splitErr, err)
+		}
+		if len(reqs) != len(split) {
+			t.Fatalf("split parsed %d requests, want %d", len(split), len(reqs))
+		}
+		for i := range reqs {
+			if !reflect.DeepEqual(reqs[i], split[i]) {
+				t.Fatalf("request %d split %+v, want %+v", i, split[i], reqs[i])
+			}
+		}
+		if err == nil && left != splitLeft {
+			t.Fatalf("split left %d bytes, want %d", splitLeft, left)
+		}
+		for _, req := range reqs {
+			if len(req.Body) > httpMaxBodySize {
+				t.Fatalf("body of %d bytes accepted", len(req.Body))
+			}
+		}
+	})
+}
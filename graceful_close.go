@@ -0,0 +1,154 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+)
+
+// CloseGracefully closes fd after its pending writes have been sent, e.g. to send a goodbye message
+// before disconnecting. It stops reading fd at once, keeps EvOut enabled while the Writer (see
+// NewWriter) or the async write queue of the handler is not empty, then removes fd and calls
+// OnClose like the other closes. The connection is closed anyway on GracefulCloseTimeout.
+// With CloseDrainTimeout the peer's data is drained after the writes before the close.
+//
+// OnWrite MUST flush the pending data (Writer.Flush or AsyncOrderedFlush) as usual.
+// It can be called from any goroutine, the data written (or AsyncWrite) before it is sent first.
+func (r *Reactor) CloseGracefully(fd int) error {
+	eh := r.GetHandler(fd)
+	if eh == nil {
+		return errors.New("CloseGracefully: fd not registered")
+	}
+	ep := eh.getEvPoll()
+	timeout := r.gracefulTimeout
+	ep.push(asyncWriteItem{fd: fd, fn: func() { ep.closeGracefully(fd, eh, timeout) }})
+	return nil
+}
+
+// closeGracefully is called within the evpoll coroutine by CloseGracefully
+func (ep *evPoll) closeGracefully(fd int, eh EvHandler, timeout int64) {
+	ed := ep.loadEvData(fd)
+	if ed == nil || ed.eh != eh { // closed in the meantime
+		return
+	}
+	if eh.pendingOut() == 0 {
+		ep.closeDrain(ed)
+		return
+	}
+	if err := ep.subtract(fd, ed.events&(syscall.EPOLLIN|syscall.EPOLLRDHUP)); err != nil {
+		ep.logger.Errorf("goev: close fd %d gracefully: %s", fd, err.Error())
+		ep.closeEvData(ed)
+		return
+	}
+	var id TimerID
+	if timeout > 0 { // not drained in time
+		id, _ = ep.scheduleTimerFunc(eh, timeout, 0, ep.closeDeadline(fd, eh), nil)
+	}
+	eh.setClosing(id)
+}
+
+// closeDeadline returns the timer func closing the connection of eh at the deadline of the
+// graceful close or of the drain, unless it has been closed in the meantime (eh may have been
+// added again for another connection since, even with the same fd)
+func (ep *evPoll) closeDeadline(fd int, eh EvHandler) func(int64, any) bool {
+	gen := eh.connGen()
+	return func(int64, any) bool {
+		if ed := ep.loadEvData(fd); ed != nil && ed.eh == eh && eh.connGen() == gen {
+			ep.closeEvData(ed)
+		}
+		return false
+	}
+}
+
+// closeDrain closes ed, see CloseDrainTimeout. The writing side is shut down and ed is kept for
+// reading until the peer's FIN, all its events are handled by drain then.
+func (ep *evPoll) closeDrain(ed *evData) {
+	if ep.drainTimeout < 1 {
+		ep.closeEvData(ed)
+		return
+	}
+	fd, eh := ed.fd, ed.eh
+	if err := syscall.Shutdown(fd, syscall.SHUT_WR); err != nil { // e.g. not a socket
+		ep.closeEvData(ed)
+		return
+	}
+	if err := ep.modify(ed, syscall.EPOLLIN|syscall.EPOLLRDHUP); err != nil {
+		ep.logger.Errorf("goev: drain fd %d: %s", fd, err.Error())
+		ep.closeEvData(ed)
+		return
+	}
+	// the peer keeps sending
+	id, _ := ep.scheduleTimerFunc(eh, ep.drainTimeout, 0, ep.closeDeadline(fd, eh), nil)
+	eh.setDraining(id)
+	ep.drain(ed) // what has arrived already
+}
+
+// drain reads and discards the data of ed, closes it on the peer's FIN or an error
+func (ep *evPoll) drain(ed *evData) {
+	for {
+		n, err := syscall.Read(ed.fd, ep.evPollReadBuff)
+		if n > 0 {
+			continue
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return
+		}
+		ed.eh.drainDone()
+		ep.closeEvData(ed)
+		return
+	}
+}
+
+func (h *IOHandle) setWriter(w *Writer) {
+	h._w = w
+}
+
+// pendingOut returns the number of the bytes of Writer and the bufs of the async write queue
+func (h *IOHandle) pendingOut() int {
+	n := h.AsyncWaitWriteQLen()
+	if h._w != nil {
+		n += h._w.Buffered()
+	}
+	return n
+}
+
+func (h *IOHandle) setClosing(timerID TimerID) {
+	h._closing, h._closeTimer = true, timerID
+}
+
+// gracefulClosing returns true while CloseGracefully (or the drain) is in progress
+func (h *IOHandle) gracefulClosing() bool {
+	return h._closing || h._draining
+}
+
+func (h *IOHandle) setDraining(timerID TimerID) {
+	h._draining, h._closeTimer = true, timerID
+}
+
+func (h *IOHandle) draining() bool {
+	return h._draining
+}
+
+// drainDone cancels the deadline of the drain
+func (h *IOHandle) drainDone() {
+	h._draining = false
+	if h._closeTimer.ti != nil {
+		h._ep.cancelTimerID(h._closeTimer)
+		h._closeTimer = TimerID{}
+	}
+}
+
+// gracefulDone returns true if the pending writes have been sent after CloseGracefully
+func (h *IOHandle) gracefulDone() bool {
+	if !h._closing || h.pendingOut() > 0 {
+		return false
+	}
+	h._closing = false
+	if h._closeTimer.ti != nil {
+		h._ep.cancelTimerID(h._closeTimer)
+		h._closeTimer = TimerID{}
+	}
+	return true
+}
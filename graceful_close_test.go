@@ -0,0 +1,332 @@
+package goev
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// goodbyeConn flushes its Writer in OnWrite and closes the fd in OnClose
+type goodbyeConn struct {
+	IOHandle
+
+	w      *Writer
+	closeC chan int
+}
+
+func (c *goodbyeConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n > 0
+}
+func (c *goodbyeConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *goodbyeConn) OnClose() {
+	c.w.Reset()
+	syscall.Close(c.Fd())
+	c.closeC <- 1
+}
+
+// newGoodbyeConn registers a goodbyeConn with one end of a socketpair, queues msg and calls
+// CloseGracefully, returns the other end
+func newGoodbyeConn(t *testing.T, r *Reactor, msg []byte) (*goodbyeConn, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, peer := fds[0], fds[1]
+	t.Cleanup(func() { syscall.Close(peer) }) // fd is closed by OnClose
+	syscall.SetNonblock(fd, true)
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	c := &goodbyeConn{closeC: make(chan int, 1)}
+	c.w = NewWriter(c)
+	errC := make(chan error, 1)
+	r.Post(fd, func() { // within the evpoll coroutine
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			errC <- err
+			return
+		}
+		if _, err := c.w.Write(msg); err != nil {
+			errC <- err
+			return
+		}
+		errC <- r.CloseGracefully(fd)
+	})
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	return c, peer
+}
+
+func TestReactorCloseGracefully(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1), GracefulCloseTimeout(200))
+
+	// the message is larger than the socket buffer, it is sent before EOF
+	msg := bytes.Repeat([]byte("goodbye "), 128*1024)
+	c, peer := newGoodbyeConn(t, r, msg)
+	time.Sleep(50 * time.Millisecond) // still pending, not closed
+	select {
+	case <-c.closeC:
+		t.Fatal("closed before the pending writes are sent")
+	default:
+	}
+	if got := readFull(t, peer, len(msg)); !bytes.Equal(got, msg) {
+		t.Fatal("message mismatch")
+	}
+	if n, err := syscall.Read(peer, make([]byte, 16)); n != 0 || err != nil {
+		t.Fatalf("read %d, %v after the message, want EOF", n, err)
+	}
+	select {
+	case <-c.closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+
+	// nothing pending, closed at once
+	c, peer = newGoodbyeConn(t, r, nil)
+	select {
+	case <-c.closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+
+	// the peer never reads, closed on the deadline
+	c, _ = newGoodbyeConn(t, r, msg)
+	begin := time.Now()
+	select {
+	case <-c.closeC:
+		if d := time.Since(begin); d < 100*time.Millisecond {
+			t.Fatalf("closed after %v, before the deadline", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not closed on the deadline")
+	}
+}
+
+func TestReadCloseFlushesWrites(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+
+	// the peer shuts down its writing side while the response is buffered, OnRead reads EOF
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, peer := fds[0], fds[1]
+	defer syscall.Close(peer) // fd is closed by OnClose
+	syscall.SetNonblock(fd, true)
+	c := &goodbyeConn{closeC: make(chan int, 1)}
+	c.w = NewWriter(c)
+	r.Post(fd, func() {
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			t.Error(err)
+			return
+		}
+		c.w.Write(msg) // buffered, the socket buffer is smaller
+	})
+	syscall.Shutdown(peer, syscall.SHUT_WR)
+	time.Sleep(20 * time.Millisecond) // OnRead returned false
+	if got := readFull(t, peer, len(msg)); !bytes.Equal(got, msg) {
+		t.Fatal("buffered data corrupted")
+	}
+	select {
+	case <-c.closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not closed after the buffered data was sent")
+	}
+}
+
+// orderConn records the callbacks
+type orderConn struct {
+	IOHandle
+
+	w     *Writer
+	order []string
+}
+
+func (c *orderConn) OnWrite() bool {
+	c.order = append(c.order, "write")
+	return c.w.Flush() == nil
+}
+func (c *orderConn) OnRead() bool {
+	c.order = append(c.order, "read")
+	return c.w.Buffered() > 0 // closed if the writes have been flushed
+}
+func (c *orderConn) OnClose() {}
+
+func TestEventOrder(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := newSocketPair(t)
+	c := &orderConn{}
+	c.w = NewWriter(c)
+	syscall.Shutdown(peer, syscall.SHUT_WR)
+
+	doneC := make(chan struct{})
+	r.Post(fd, func() {
+		defer close(doneC)
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			t.Error(err)
+			return
+		}
+		c.w.buf = append(c.w.buf, "pending"...)
+		// writable and half-closed at once
+		ep := c.getEvPoll()
+		ep.handleEvent(ep.loadEvData(fd), syscall.EPOLLOUT|syscall.EPOLLIN|syscall.EPOLLRDHUP)
+	})
+	<-doneC
+	if len(c.order) != 2 || c.order[0] != "write" || c.order[1] != "read" {
+		t.Fatalf("callbacks %v, want [write read]", c.order)
+	}
+	if got := readFull(t, peer, len("pending")); string(got) != "pending" {
+		t.Fatalf("got %q", got)
+	}
+	if r.GetHandler(fd) != nil {
+		t.Fatal("not closed after OnRead returned false")
+	}
+}
+
+// drainConn closes gracefully once it has read something
+type drainConn struct {
+	IOHandle
+
+	closeC chan int
+}
+
+func (c *drainConn) OnOpen(fd int) bool {
+	return c.GetReactor().AddEvHandler(c, fd, EvIn) == nil
+}
+func (c *drainConn) OnRead() bool {
+	if _, n, _ := c.Read(); n > 0 {
+		c.GetReactor().CloseGracefully(c.Fd())
+		return true
+	}
+	return false
+}
+func (c *drainConn) OnClose() {
+	syscall.Close(c.Fd())
+	c.closeC <- 1
+}
+
+// drainClose connects a client which keeps sending until it reads EOF, then shuts down its
+// writing side. Returns the errors of the client.
+func drainClose(t *testing.T, opts ...Option) (writeErr, readErr error) {
+	r := newTestReactor(t, append([]Option{EvPollNum(1)}, opts...)...)
+	closeC := make(chan int, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &drainConn{closeC: closeC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stopC, doneC := make(chan struct{}), make(chan struct{})
+	go func() { // the peer keeps sending during the close
+		defer close(doneC)
+		buf := make([]byte, 1024)
+		for {
+			select {
+			case <-stopC:
+				return
+			default:
+			}
+			if _, err := conn.Write(buf); err != nil {
+				writeErr = err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, readErr = conn.Read(make([]byte, 16))
+	time.Sleep(20 * time.Millisecond) // still sending after EOF
+	close(stopC)
+	<-doneC
+	if readErr == io.EOF {
+		conn.(*net.TCPConn).CloseWrite()
+		select {
+		case <-closeC:
+		case <-time.After(2 * time.Second):
+			t.Fatal("not closed after the peer's FIN")
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, readErr = conn.Read(make([]byte, 16))
+	}
+	return
+}
+
+func TestCloseDrain(t *testing.T) {
+	// no drain: the data sent during the close resets the connection
+	if writeErr, readErr := drainClose(t); !errors.Is(writeErr, syscall.ECONNRESET) &&
+		!errors.Is(writeErr, syscall.EPIPE) && !errors.Is(readErr, syscall.ECONNRESET) {
+		t.Fatalf("no RST without drain: %v, %v", writeErr, readErr)
+	}
+	// drain: FIN, the data is discarded until the peer's FIN
+	if writeErr, readErr := drainClose(t, CloseDrainTimeout(2000)); writeErr != nil || readErr != io.EOF {
+		t.Fatalf("drain close got %v, %v, want orderly EOF", writeErr, readErr)
+	}
+}
+
+func TestCloseDrainTimeout(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1), CloseDrainTimeout(100))
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, peer := fds[0], fds[1]
+	defer syscall.Close(peer) // fd is closed by OnClose
+	c := &drainConn{closeC: make(chan int, 1)}
+	c.setReactor(r)
+	if !c.OnOpen(fd) {
+		t.Fatal("add failed")
+	}
+	syscall.Write(peer, []byte("x"))
+	// the peer never shuts down, closed on the deadline
+	begin := time.Now()
+	select {
+	case <-c.closeC:
+		if d := time.Since(begin); d < 80*time.Millisecond {
+			t.Fatalf("closed after %v, before the deadline", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not closed on the deadline")
+	}
+	if n, err := syscall.Read(peer, make([]byte, 16)); n != 0 || err != nil {
+		t.Fatalf("read %d, %v, want EOF of the shutdown", n, err)
+	}
+}
+
+func TestCloseGracefullyReused(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1), GracefulCloseTimeout(100))
+	fd, _ := newSocketPair(t)
+	c := &corkConn{}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	ep := &r.evPolls[0]
+	runIn(t, r, fd, func() {
+		c.w.Write(make([]byte, 1<<20)) // the peer never reads
+		ep.closeGracefully(fd, c, 100)
+		ep.closeEvData(ep.loadEvData(fd)) // closed otherwise before the deadline, e.g. reset
+		c.Init()
+		c.w.Reset()
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil { // the handler and the fd reused
+			t.Error(err)
+		}
+	})
+	time.Sleep(300 * time.Millisecond)
+	if r.GetHandler(fd) != c {
+		t.Fatal("the new connection closed on the deadline of the previous one")
+	}
+}
@@ -0,0 +1,104 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+)
+
+type heartbeatState struct {
+	inbound  bool // set by evpoll when the connection is readable
+	ping     []byte
+	interval int64 // millisecond
+	timeout  int64 // millisecond
+	lastRecv int64 // millisecond, the last check which saw inbound data
+	lastPing int64 // millisecond
+	timerID  TimerID
+}
+
+func (h *IOHandle) setInbound() {
+	h._heartbeat.inbound = true
+}
+
+// stopHeartbeat cancels the heartbeat, the pings MUST NOT go to the fd once it is removed (the
+// number may be reused by another connection)
+func (h *IOHandle) stopHeartbeat() {
+	if h._heartbeat.timerID.ti != nil {
+		h.CancelTimerID(h._heartbeat.timerID)
+		h._heartbeat = heartbeatState{}
+	}
+}
+
+// SetHeartbeat sends ping to the peer every interval (millisecond) and closes the connection if
+// nothing is received within timeout (millisecond), i.e. neither the pong nor any other data: the
+// fd is removed from the reactor and OnClose is called within the evpoll coroutine. It detects a
+// dead peer much faster than TCP keepalive.
+//
+// The ping is written with the Writer of the handler if it has one (so that it does not cut a
+// message queued), otherwise with IOHandle.Write, and is skipped if the socket buffer is full.
+// The check is driven by a timer every min(interval, timeout/4), so the connection is closed after
+// timeout ~ timeout*5/4 without inbound data. Calling it again replaces the previous heartbeat,
+// interval 0 disables it. It MUST be called after the IOHandle is registered with the reactor,
+// within the evpoll coroutine.
+func (h *IOHandle) SetHeartbeat(eh EvHandler, ping []byte, interval, timeout int64) error {
+	if interval < 0 || timeout < 0 || (interval > 0 && (len(ping) == 0 || timeout == 0)) {
+		return errors.New("heartbeat params are invalid")
+	}
+	if h._ep == nil {
+		return errors.New("ev handler has not been added to the reactor yet")
+	}
+	h.CancelTimerID(h._heartbeat.timerID)
+	h._heartbeat = heartbeatState{}
+	if interval == 0 {
+		return nil
+	}
+	now := h._ep.nowMilli()
+	h._heartbeat = heartbeatState{
+		ping:     append([]byte(nil), ping...),
+		interval: interval,
+		timeout:  timeout,
+		lastRecv: now,
+		lastPing: now,
+	}
+	tick := timeout / idleCheckTimes
+	if tick > interval {
+		tick = interval
+	}
+	if tick < 1 {
+		tick = 1
+	}
+	id, err := h.ScheduleTimerFunc(eh, tick, tick, h.checkHeartbeat, eh)
+	if err != nil {
+		return err
+	}
+	h._heartbeat.timerID = id
+	return nil
+}
+
+func (h *IOHandle) checkHeartbeat(millisecond int64, arg any) bool {
+	if h._fd < 1 { // closed
+		return false
+	}
+	hb := &h._heartbeat
+	if hb.inbound {
+		hb.inbound, hb.lastRecv = false, millisecond
+	}
+	eh := arg.(EvHandler)
+	ed := h._ep.loadEvData(h._fd)
+	if ed == nil || ed.eh != eh { // removed, the fd may be another connection's
+		return false
+	}
+	if millisecond-hb.lastRecv >= hb.timeout {
+		h._ep.closeReason(eh, ClosedByHeartbeat)
+		h._ep.closeEvData(ed)
+		return false
+	}
+	if millisecond-hb.lastPing >= hb.interval {
+		hb.lastPing = millisecond
+		if h._w != nil {
+			h._w.Write(hb.ping)
+		} else if _, err := h.Write(hb.ping); err != nil && err != syscall.EAGAIN {
+			return true // closed by the evpoll on the next event
+		}
+	}
+	return true
+}
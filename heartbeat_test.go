@@ -0,0 +1,116 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// heartbeatConn discards what it reads, the heartbeat is set in OnOpen
+type heartbeatConn struct {
+	IOHandle
+
+	closedC chan struct{}
+}
+
+func (c *heartbeatConn) OnOpen(fd int) bool {
+	if c.GetReactor().AddEvHandler(c, fd, EvIn) != nil {
+		return false
+	}
+	return c.SetHeartbeat(c, []byte("ping"), 20, 100) == nil
+}
+
+func (c *heartbeatConn) OnRead() bool {
+	_, _, err := c.Read()
+	return err == nil || err == syscall.EAGAIN
+}
+
+func (c *heartbeatConn) OnClose() {
+	syscall.Close(c.Fd())
+	close(c.closedC)
+}
+
+func newHeartbeatPeer(t *testing.T, r *Reactor) (*heartbeatConn, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fds[1]) })
+	syscall.SetNonblock(fds[0], true)
+	c := &heartbeatConn{closedC: make(chan struct{})}
+	c.setReactor(r)
+	runIn(t, r, fds[0], func() {
+		if !c.OnOpen(fds[0]) {
+			t.Error("open failed")
+		}
+	})
+	return c, fds[1]
+}
+
+func TestHeartbeat(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+
+	// the silent peer gets pings and is closed after the timeout
+	silent, peer := newHeartbeatPeer(t, r)
+	begin := time.Now()
+	select {
+	case <-silent.closedC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("silent peer not closed")
+	}
+	if d := time.Since(begin); d < 90*time.Millisecond {
+		t.Fatalf("closed after %v", d)
+	}
+	bf := make([]byte, 64)
+	if n, err := syscall.Read(peer, bf); err != nil || n < 8 || string(bf[:4]) != "ping" {
+		t.Fatalf("peer got %q %v", bf[:n], err)
+	}
+
+	// the peer answering the pings survives
+	active, peer := newHeartbeatPeer(t, r)
+	for i := 0; i < 20; i++ {
+		time.Sleep(20 * time.Millisecond)
+		syscall.Read(peer, bf)
+		syscall.Write(peer, []byte("pong"))
+	}
+	select {
+	case <-active.closedC:
+		t.Fatal("active peer closed")
+	default:
+	}
+	runIn(t, r, active.Fd(), func() {
+		if ed := r.evPolls[0].loadEvData(active.Fd()); ed == nil || ed.eh != active {
+			t.Error("active peer removed")
+		}
+	})
+}
+
+func TestHeartbeatRemoved(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	c, peer := newHeartbeatPeer(t, r)
+	fd := c.Fd()
+	// the fd is handed over to another handler, the heartbeat of c stops
+	other := &corkConn{}
+	runIn(t, r, fd, func() {
+		if err := r.RemoveEvHandler(c, fd); err != nil {
+			t.Error(err)
+		}
+		if err := r.AddEvHandler(other, fd, EvIn); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Cleanup(func() { syscall.Close(fd) })
+	syscall.SetNonblock(peer, true)
+	for { // the pings sent before
+		if n, _ := syscall.Read(peer, make([]byte, 64)); n < 1 {
+			break
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n, _ := syscall.Read(peer, make([]byte, 64)); n > 0 {
+		t.Fatalf("%d bytes pinged after the removal", n)
+	}
+	if r.GetHandler(fd) != other {
+		t.Fatal("the new handler closed by the heartbeat")
+	}
+}
@@ -0,0 +1,377 @@
+package goev
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	httpMaxHeaderSize = 8 * 1024
+	httpMaxBodySize   = 8 * 1024 * 1024
+)
+
+// HTTPRequest is a parsed HTTP/1.x request, valid only within HTTPHandlerFunc
+type HTTPRequest struct {
+	Method string
+	URI    string
+	Proto  string // HTTP/1.1 or HTTP/1.0
+	Header http.Header
+	Body   []byte // decoded if chunked
+
+	keepAlive bool
+}
+
+// HTTPResponse is filled by HTTPHandlerFunc, Content-Length and Connection are set by HTTPHandler
+type HTTPResponse struct {
+	Status int // 200 if not set
+	Header http.Header
+	Body   []byte
+}
+
+// HTTPHandlerFunc serves a request within the evpoll coroutine, MUST NOT block.
+type HTTPHandlerFunc func(req *HTTPRequest, resp *HTTPResponse)
+
+// httpError is a request which can't be parsed, the response status is sent before closing
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+// HTTPHandler is a minimal HTTP/1.1 server connection, it parses the requests incrementally from
+// a ReadBuffer (Content-Length and chunked body, pipelining), calls HTTPHandlerFunc for each of
+// them and writes the responses by Writer in order. The connection is kept alive unless the
+// request asks to close (or is HTTP/1.0 without keep-alive), a malformed request is answered with
+// 400 and closed.
+//
+// For example:
+//
+//	goev.NewAcceptor(reactor, func() goev.EvHandler {
+//	    return goev.NewHTTPHandler(reactor, func(req *goev.HTTPRequest, resp *goev.HTTPResponse) {
+//	        resp.Body = []byte("hello")
+//	    })
+//	}, ":8080")
+type HTTPHandler struct {
+	IOHandle
+
+	handler  HTTPHandlerFunc
+	rb       *ReadBuffer
+	w        *Writer
+	closing  bool // close once the responses are sent
+	upgraded bool // switched to another protocol (e.g. websocket), stop parsing HTTP
+
+	// parsing state
+	req       *HTTPRequest // nil while parsing the head
+	bodyLeft  int          // bytes left of Content-Length body
+	chunked   bool
+	chunkLeft int // bytes left of the current chunk data, -1 for the size line, -2 for the CRLF
+	trailer   bool
+}
+
+// NewHTTPHandler return an instance
+func NewHTTPHandler(r *Reactor, handler HTTPHandlerFunc) *HTTPHandler {
+	h := &HTTPHandler{handler: handler}
+	h.setReactor(r)
+	return h
+}
+
+// OnOpen register the fd
+func (h *HTTPHandler) OnOpen(fd int) bool {
+	return h.open(h, fd)
+}
+
+// open registers eh, the outer handler embedding HTTPHandler
+func (h *HTTPHandler) open(eh EvHandler, fd int) bool {
+	if err := h.GetReactor().AddEvHandler(eh, fd, EvIn); err != nil {
+		syscall.Close(fd)
+		return false
+	}
+	h.rb = NewReadBuffer(4096, httpMaxHeaderSize+httpMaxBodySize)
+	h.w = NewWriter(h)
+	return true
+}
+
+// OnRead parses and serves the complete requests
+func (h *HTTPHandler) OnRead() bool {
+	if h.closing { // ignore the requests after `Connection: close`
+		return h.discard()
+	}
+	_, err := h.rb.ReadFromHandler(h)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	if h.serve() == false {
+		return false
+	}
+	if err == io.EOF {
+		h.closing = true
+	}
+	if h.closing {
+		return h.w.Buffered() > 0 // close after the responses are sent
+	}
+	return true
+}
+
+// discard reads and drops the input while the responses are sent before closing: it would be
+// reported again and again (level-triggered), and closing with unread data resets the connection
+func (h *HTTPHandler) discard() bool {
+	h.rb.Reset()
+	_, err := h.rb.ReadFromHandler(h)
+	h.rb.Reset()
+	if err != nil && err != io.EOF && err != ErrReadBufferFull { // the rest is read next time
+		return false
+	}
+	return h.w.Buffered() > 0
+}
+
+// OnPeerShutdown serves the requests sent before the peer shut down its writing side, the
+// connection is closed once the responses are sent
+func (h *HTTPHandler) OnPeerShutdown(fd int) bool {
+	return h.OnRead()
+}
+
+// serve parses and serves the complete requests of rb, returns false on write error
+func (h *HTTPHandler) serve() bool {
+	for !h.closing && !h.upgraded {
+		req, err := h.parse()
+		if err != nil {
+			status := http.StatusBadRequest
+			if e, ok := err.(*httpError); ok {
+				status = e.status
+			}
+			h.closing = true
+			return h.respond(&HTTPRequest{}, &HTTPResponse{Status: status}) == nil
+		}
+		if req == nil { // incomplete
+			return true
+		}
+		resp := &HTTPResponse{Status: http.StatusOK, Header: make(http.Header)}
+		h.handler(req, resp)
+		if !req.keepAlive {
+			h.closing = true
+		}
+		if h.respond(req, resp) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// OnWrite sends the pending responses
+func (h *HTTPHandler) OnWrite() bool {
+	if h.w.Flush() != nil {
+		return false
+	}
+	return !h.closing || h.w.Buffered() > 0
+}
+
+// OnClose close the fd
+func (h *HTTPHandler) OnClose() {
+	if h.w == nil { // OnOpen failed, the fd has been closed
+		return
+	}
+	if h.Fd() != -1 {
+		syscall.Close(h.Fd())
+		h.setFd(-1)
+	}
+	h.w.Reset()
+	h.Destroy(h)
+}
+
+// parse returns a complete request, nil if more data is needed
+func (h *HTTPHandler) parse() (*HTTPRequest, error) {
+	if h.req == nil {
+		bf := h.rb.Bytes()
+		end := bytes.Index(bf, []byte("\r\n\r\n"))
+		if end < 0 {
+			// the terminator can't end within the limit any more, the same whether the head
+			// arrives at once or split
+			if len(bf) >= httpMaxHeaderSize+4 {
+				return nil, &httpError{http.StatusRequestHeaderFieldsTooLarge, "header too large"}
+			}
+			return nil, nil
+		}
+		if end > httpMaxHeaderSize {
+			return nil, &httpError{http.StatusRequestHeaderFieldsTooLarge, "header too large"}
+		}
+		req, err := h.parseHead(bf[:end+2])
+		if err != nil {
+			return nil, err
+		}
+		h.rb.Discard(end + 4)
+		h.req = req
+	}
+	if h.chunked {
+		if done, err := h.parseChunked(); !done {
+			return nil, err
+		}
+	} else if h.bodyLeft > 0 {
+		bf := h.rb.Bytes()
+		if len(bf) > h.bodyLeft {
+			bf = bf[:h.bodyLeft]
+		}
+		h.req.Body = append(h.req.Body, bf...)
+		h.rb.Discard(len(bf))
+		if h.bodyLeft -= len(bf); h.bodyLeft > 0 {
+			return nil, nil
+		}
+	}
+	req := h.req
+	h.req = nil
+	return req, nil
+}
+
+// parseHead parses the request line and headers, each line ends with CRLF
+func (h *HTTPHandler) parseHead(head []byte) (*HTTPRequest, error) {
+	line, rest, _ := bytes.Cut(head, []byte("\r\n"))
+	parts := strings.Split(string(line), " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("malformed request line")
+	}
+	req := &HTTPRequest{Method: parts[0], URI: parts[1], Proto: parts[2], Header: make(http.Header)}
+	switch req.Proto {
+	case "HTTP/1.1":
+		req.keepAlive = true
+	case "HTTP/1.0":
+	default:
+		return nil, errors.New("unsupported protocol")
+	}
+	for len(rest) > 0 {
+		line, rest, _ = bytes.Cut(rest, []byte("\r\n"))
+		k, v, ok := bytes.Cut(line, []byte(":"))
+		if !ok || len(k) == 0 || bytes.ContainsAny(k, " \t") {
+			return nil, errors.New("malformed header")
+		}
+		req.Header.Add(textproto.CanonicalMIMEHeaderKey(string(k)), string(bytes.TrimSpace(v)))
+	}
+
+	if conn := strings.ToLower(req.Header.Get("Connection")); conn == "close" {
+		req.keepAlive = false
+	} else if conn == "keep-alive" {
+		req.keepAlive = true
+	}
+	h.bodyLeft, h.chunked = 0, false
+	te := req.Header.Get("Transfer-Encoding")
+	cl := req.Header.Values("Content-Length")
+	if te != "" {
+		if !strings.EqualFold(te, "chunked") || len(cl) > 0 {
+			return nil, errors.New("unsupported transfer encoding")
+		}
+		h.chunked, h.chunkLeft, h.trailer = true, -1, false
+	} else if len(cl) > 0 {
+		n, err := strconv.Atoi(cl[0])
+		if err != nil || n < 0 || len(cl) > 1 {
+			return nil, errors.New("invalid content length")
+		}
+		if n > httpMaxBodySize {
+			return nil, &httpError{http.StatusRequestEntityTooLarge, "body too large"}
+		}
+		h.bodyLeft = n
+	}
+	return req, nil
+}
+
+// parseChunked decodes the chunks of rb into req.Body, returns true when the last chunk and the
+// trailer have been consumed
+func (h *HTTPHandler) parseChunked() (bool, error) {
+	for {
+		bf := h.rb.Bytes()
+		if h.chunkLeft > 0 { // chunk data
+			n := h.chunkLeft
+			if n > len(bf) {
+				n = len(bf)
+			}
+			h.req.Body = append(h.req.Body, bf[:n]...)
+			h.rb.Discard(n)
+			if h.chunkLeft -= n; h.chunkLeft > 0 {
+				return false, nil
+			}
+			h.chunkLeft = -2
+			continue
+		}
+		if h.chunkLeft == -2 { // CRLF after chunk data
+			if len(bf) < 2 {
+				return false, nil
+			}
+			if bf[0] != '\r' || bf[1] != '\n' {
+				return false, errors.New("malformed chunk")
+			}
+			h.rb.Discard(2)
+			h.chunkLeft = -1
+			continue
+		}
+		i := bytes.Index(bf, []byte("\r\n"))
+		if i > httpMaxHeaderSize || (i < 0 && len(bf) >= httpMaxHeaderSize+2) { // like the head
+			return false, errors.New("malformed chunk")
+		}
+		if i < 0 {
+			return false, nil
+		}
+		line := bf[:i]
+		h.rb.Discard(i + 2)
+		if h.trailer { // trailer fields are ignored, ends with an empty line
+			if len(line) == 0 {
+				return true, nil
+			}
+			continue
+		}
+		size, _, _ := bytes.Cut(line, []byte(";")) // chunk extensions are ignored
+		n, err := strconv.ParseInt(string(bytes.TrimSpace(size)), 16, 64)
+		if err != nil || n < 0 {
+			return false, errors.New("invalid chunk size")
+		}
+		if n == 0 {
+			h.trailer = true
+			continue
+		}
+		if int64(len(h.req.Body))+n > httpMaxBodySize {
+			return false, &httpError{http.StatusRequestEntityTooLarge, "body too large"}
+		}
+		h.chunkLeft = int(n)
+	}
+}
+
+// respond writes the response of req
+func (h *HTTPHandler) respond(req *HTTPRequest, resp *HTTPResponse) error {
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	var head bytes.Buffer
+	head.WriteString("HTTP/1.1 ")
+	head.WriteString(strconv.Itoa(resp.Status))
+	head.WriteByte(' ')
+	head.WriteString(http.StatusText(resp.Status))
+	head.WriteString("\r\n")
+	for k, vs := range resp.Header {
+		if k == "Content-Length" || k == "Connection" {
+			continue
+		}
+		for _, v := range vs {
+			head.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	if resp.Status == http.StatusSwitchingProtocols { // no body, Connection: Upgrade
+		head.WriteString("Connection: " + resp.Header.Get("Connection") + "\r\n\r\n")
+		_, err := h.w.Write(head.Bytes())
+		return err
+	}
+	head.WriteString("Content-Length: " + strconv.Itoa(len(resp.Body)) + "\r\n")
+	if h.closing {
+		head.WriteString("Connection: close\r\n\r\n")
+	} else {
+		head.WriteString("Connection: keep-alive\r\n\r\n")
+	}
+	if req.Method == http.MethodHead {
+		_, err := h.w.Write(head.Bytes())
+		return err
+	}
+	_, err := h.w.Writev([][]byte{head.Bytes(), resp.Body})
+	return err
+}
@@ -0,0 +1,162 @@
+package goev
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpTestServer returns the address of an HTTPHandler server echoing the method, uri and body
+func httpTestServer(t *testing.T) string {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		return NewHTTPHandler(r, func(req *HTTPRequest, resp *HTTPResponse) {
+			resp.Header.Set("Content-Type", "text/plain")
+			resp.Body = []byte(req.Method + " " + req.URI + " " + string(req.Body))
+		})
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	t.Cleanup(func() { a.OnClose() })
+	return addr
+}
+
+// httpRoundTrip writes raw requests and reads n responses
+func httpRoundTrip(t *testing.T, conn net.Conn, br *bufio.Reader, raw string, n int) []string {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for i := 0; i < n; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			bodies = append(bodies, resp.Status)
+			continue
+		}
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestHTTPHandler(t *testing.T) {
+	addr := httpTestServer(t)
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	for _, c := range []struct {
+		raw  string
+		want []string
+	}{
+		{"GET /hello HTTP/1.1\r\nHost: goev\r\n\r\n", []string{"GET /hello "}},
+		{"POST /p HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world", []string{"POST /p hello world"}},
+		{"POST /c HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n",
+			[]string{"POST /c hello world"}},
+		{"GET /1 HTTP/1.1\r\n\r\nPOST /2 HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc", // pipelined
+			[]string{"GET /1 ", "POST /2 abc"}},
+	} {
+		if got := httpRoundTrip(t, conn, br, c.raw, len(c.want)); strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Fatalf("%q: got %q, want %q", c.raw, got, c.want)
+		}
+	}
+
+	// a request split across reads
+	conn.Write([]byte("POST /s HTTP/1.1\r\nContent-Le"))
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("ngth: 4\r\n\r\nab"))
+	time.Sleep(20 * time.Millisecond)
+	if got := httpRoundTrip(t, conn, br, "cd", 1); got[0] != "POST /s abcd" {
+		t.Fatalf("split request: %q", got)
+	}
+
+	// Connection: close
+	got := httpRoundTrip(t, conn, br, "GET /bye HTTP/1.1\r\nConnection: close\r\n\r\n", 1)
+	if got[0] != "GET /bye " {
+		t.Fatalf("got %q", got)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after Connection: close: %v, want EOF", err)
+	}
+}
+
+func TestHTTPHandlerBadRequest(t *testing.T) {
+	addr := httpTestServer(t)
+	for _, raw := range []string{
+		"GET /\r\n\r\n",
+		"GET / HTTP/2.0\r\n\r\n",
+		"GET / HTTP/1.1\r\nbad header\r\n\r\n",
+		"POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+	} {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(conn)
+		if got := httpRoundTrip(t, conn, br, raw, 1); got[0] != "400 Bad Request" {
+			t.Fatalf("%q: got %q", raw, got)
+		}
+		if _, err = br.ReadByte(); err != io.EOF {
+			t.Fatalf("%q: not closed after 400: %v", raw, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestHTTPHandlerClosingInput(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	body := make([]byte, 8<<20) // larger than the socket buffers
+	a, err := NewAcceptor(r, func() EvHandler {
+		return NewHTTPHandler(r, func(req *HTTPRequest, resp *HTTPResponse) {
+			resp.Body = body
+		})
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the input after `Connection: close` is ignored while the response is pending
+	conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("GET /ignored HTTP/1.1\r\n\r\n"))
+	time.Sleep(200 * time.Millisecond)
+	if n := r.evPolls[0].stats.eventsProcessed.Load(); n > 100 {
+		t.Fatalf("%d events processed while the response was pending", n)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, resp.Body); err != nil || n != int64(len(body)) {
+		t.Fatalf("read %d bytes of the body: %v", n, err)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after the response: %v, want EOF", err)
+	}
+}
@@ -0,0 +1,70 @@
+package goev
+
+import (
+	"errors"
+)
+
+// The idle timer checks idleCheckTimes times per timeout
+const idleCheckTimes = 4
+
+type idleState struct {
+	active    bool  // set by evpoll after OnRead/OnWrite succeeded
+	idleTimes int   // number of consecutive checks without activity
+	timeout   int64 // millisecond
+	timerID   TimerID
+}
+
+func (h *IOHandle) setActive() {
+	h._idle.active = true
+}
+
+// SetIdleTimeout closes the connection if neither OnRead nor OnWrite succeeded within timeout
+// (millisecond): the fd is removed from the reactor and OnClose is called within the evpoll coroutine.
+// Calling it again replaces the previous timeout, 0 disables it.
+//
+// The check is driven by a timer every timeout/4, so the connection is closed after being idle
+// for timeout ~ timeout*5/4. It MUST be called after the IOHandle is registered with the reactor,
+// within the evpoll coroutine.
+func (h *IOHandle) SetIdleTimeout(eh EvHandler, timeout int64) error {
+	if timeout < 0 {
+		return errors.New("idle timeout < 0")
+	}
+	if h._ep == nil {
+		return errors.New("ev handler has not been added to the reactor yet")
+	}
+	h.CancelTimerID(h._idle.timerID)
+	h._idle = idleState{timeout: timeout}
+	if timeout == 0 {
+		return nil
+	}
+	interval := timeout / idleCheckTimes
+	if interval < 1 {
+		interval = 1
+	}
+	id, err := h.ScheduleTimerFunc(eh, interval, interval, h.checkIdle, eh)
+	if err != nil {
+		return err
+	}
+	h._idle.timerID = id
+	return nil
+}
+
+func (h *IOHandle) checkIdle(millisecond int64, arg any) bool {
+	if h._fd < 1 { // closed
+		return false
+	}
+	if h._idle.active {
+		h._idle.active, h._idle.idleTimes = false, 0
+		return true
+	}
+	h._idle.idleTimes++
+	if h._idle.idleTimes < idleCheckTimes {
+		return true
+	}
+	eh := arg.(EvHandler)
+	if ed := h._ep.loadEvData(h._fd); ed != nil && ed.eh == eh {
+		h._ep.closeReason(eh, ClosedByIdle)
+		h._ep.closeEvData(ed)
+	}
+	return false
+}
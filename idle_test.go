@@ -0,0 +1,41 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	r, _ := NewReactor(EvPollNum(1))
+	fd, peer := newSocketPair(t)
+	h := newPipeReader()
+	if err := r.AddEvHandler(h, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetIdleTimeout(h, 100); err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	var lastActive time.Time
+	for i := 0; i < 8; i++ { // active for 320ms
+		syscall.Write(peer, []byte{'x'})
+		lastActive = time.Now()
+		time.Sleep(40 * time.Millisecond)
+		select {
+		case <-h.closeC:
+			t.Fatal("active connection closed")
+		default:
+		}
+	}
+	select {
+	case <-h.closeC:
+		if d := time.Since(lastActive); d < 100*time.Millisecond-5*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("closed after %v idle, want 100~125ms", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not closed")
+	}
+}
@@ -0,0 +1,144 @@
+package goev
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// FsEventHandler receives the events of an InotifyWatcher
+type FsEventHandler interface {
+	// OnFsEvent is called within the evpoll coroutine, path is the watched path or the file in the
+	// watched directory (the name of the inotify event joined), mask is the inotify mask
+	// (unix.IN_MODIFY...) of the event
+	OnFsEvent(path string, mask uint32)
+}
+
+// InotifyWatcher watches the filesystem through an inotify fd registered with the reactor
+// (see Reactor.AddFd), e.g. to reload a config file on the event loop.
+type InotifyWatcher struct {
+	IOHandle
+
+	h   FsEventHandler
+	buf []byte
+
+	mtx   sync.Mutex
+	paths map[int]string // watch descriptor -> path
+}
+
+// NewInotifyWatcher creates an inotify fd and registers it with r, add the paths by AddWatch
+func NewInotifyWatcher(r *Reactor, h FsEventHandler) (*InotifyWatcher, error) {
+	if h == nil {
+		return nil, errors.New("NewInotifyWatcher: FsEventHandler is nil")
+	}
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.New("inotify_init1: " + err.Error())
+	}
+	w := &InotifyWatcher{
+		h:     h,
+		buf:   make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1)),
+		paths: make(map[int]string),
+	}
+	if err = r.AddFd(fd, EvIn, w); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddWatch watches path for the events of mask (unix.IN_MODIFY|unix.IN_CREATE...), adding a path
+// again replaces its mask. It can be called from any goroutine.
+func (w *InotifyWatcher) AddWatch(path string, mask uint32) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	wd, err := unix.InotifyAddWatch(w.Fd(), path, mask)
+	if err != nil {
+		return errors.New("inotify_add_watch: " + err.Error())
+	}
+	w.paths[wd] = path
+	return nil
+}
+
+// RemoveWatch stops watching path, it can be called from any goroutine
+func (w *InotifyWatcher) RemoveWatch(path string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for wd, p := range w.paths {
+		if p == path {
+			delete(w.paths, wd)
+			if _, err := unix.InotifyRmWatch(w.Fd(), uint32(wd)); err != nil {
+				return errors.New("inotify_rm_watch: " + err.Error())
+			}
+			return nil
+		}
+	}
+	return errors.New("RemoveWatch: path not watched")
+}
+
+// Close unregisters the watcher and closes its inotify fd, it can be called from any goroutine
+func (w *InotifyWatcher) Close() error {
+	fd, r := w.Fd(), w.GetReactor()
+	return r.Post(fd, func() {
+		if eh := r.GetHandler(fd); eh == w {
+			r.RemoveFd(fd)
+			w.OnClose()
+		}
+	})
+}
+
+// OnRead parses the variable-length inotify events until EAGAIN
+func (w *InotifyWatcher) OnRead() bool {
+	for {
+		n, err := syscall.Read(w.Fd(), w.buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return true
+		}
+		if err != nil || n < unix.SizeofInotifyEvent {
+			return false
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&w.buf[off]))
+			name := w.buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+			w.dispatch(int(ev.Wd), ev.Mask, name)
+		}
+	}
+}
+
+// dispatch calls OnFsEvent of the event of watch descriptor wd, name is NUL-padded
+func (w *InotifyWatcher) dispatch(wd int, mask uint32, name []byte) {
+	w.mtx.Lock()
+	path, ok := w.paths[wd]
+	if mask&unix.IN_IGNORED != 0 { // removed (explicitly or the file was deleted)
+		delete(w.paths, wd)
+	}
+	w.mtx.Unlock()
+	if !ok {
+		return
+	}
+	for i, c := range name {
+		if c == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	if len(name) > 0 {
+		path = filepath.Join(path, string(name))
+	}
+	w.h.OnFsEvent(path, mask)
+}
+
+// OnClose closes the inotify fd
+func (w *InotifyWatcher) OnClose() {
+	if fd := w.Fd(); fd > 0 {
+		syscall.Close(fd)
+	}
+}
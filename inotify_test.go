@@ -0,0 +1,82 @@
+package goev
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type fsEvent struct {
+	path string
+	mask uint32
+}
+
+type fsEventRecorder struct {
+	eventC chan fsEvent
+}
+
+func (r *fsEventRecorder) OnFsEvent(path string, mask uint32) {
+	r.eventC <- fsEvent{path, mask}
+}
+
+func TestInotifyWatcher(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(file, []byte("a=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &fsEventRecorder{eventC: make(chan fsEvent, 16)}
+	w, err := NewInotifyWatcher(r, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.AddWatch(file, unix.IN_MODIFY); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddWatch(dir, unix.IN_CREATE); err != nil {
+		t.Fatal(err)
+	}
+	wait := func(want fsEvent) {
+		t.Helper()
+		select {
+		case ev := <-h.eventC:
+			if ev.path != want.path || ev.mask&want.mask == 0 {
+				t.Fatalf("got %s %#x, want %s %#x", ev.path, ev.mask, want.path, want.mask)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s %#x not delivered", want.path, want.mask)
+		}
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("b=2\n"))
+	f.Close()
+	wait(fsEvent{file, unix.IN_MODIFY})
+
+	// the name of a file in the watched directory is joined
+	created := filepath.Join(dir, "new-file-with-a-long-name.conf")
+	if err := os.WriteFile(created, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait(fsEvent{created, unix.IN_CREATE})
+
+	if err := w.RemoveWatch(file); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(file, []byte("c=3\n"), 0644)
+	select {
+	case ev := <-h.eventC:
+		if ev.path == file && ev.mask&unix.IN_MODIFY != 0 {
+			t.Fatal("event delivered after RemoveWatch")
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
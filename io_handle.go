@@ -2,7 +2,10 @@ package goev
 
 import (
 	"errors"
+	"sync/atomic"
 	"syscall"
+
+	"github.com/shaovie/goev/netfd"
 )
 
 // IOHandle is the base class of io event handling objects
@@ -19,17 +22,59 @@ type IOHandle struct {
 
 	_ti *timerItem
 
+	_idle idleState
+
+	_heartbeat heartbeatState // see SetHeartbeat
+
+	_readLimit *readLimiter // nil if not limited
+
+	_conns *atomic.Int64 // counted as an open connection of Reactor, see MaxConnections
+
+	_trace traceState // see Tracer
+
+	_bytesRead    atomic.Uint64 // see BytesRead
+	_bytesWritten atomic.Uint64
+
+	_w          *Writer // the last Writer created for the handler
+	_closing    bool    // Reactor.CloseGracefully is waiting for the pending writes
+	_draining   bool    // shut down, reading until the peer's FIN, see CloseDrainTimeout
+	_closeTimer TimerID // deadline of the graceful close or of the drain
+	_closed     bool    // OnClose has been called by the reactor, until added again
+	_gen        uint32  // incremented each time the handler is added, see connGen
+
 	_asyncWriteBufQ *RingBuffer[AsyncWriteBuf] // 保存未直接发送完成的
 }
 
 // Init IOHandle must be called when reusing it.
 func (h *IOHandle) Init() {
 	h._fd, h._r, h._ep, h._ti = -1, nil, nil, nil
+	h._idle = idleState{}
+	h._heartbeat = heartbeatState{}
+	h._readLimit = nil
+	h._trace = traceState{}
+	h._closing, h._draining, h._closeTimer = false, false, TimerID{}
+	h._closed = false
+	h._bytesRead.Store(0)
+	h._bytesWritten.Store(0)
 }
 
 func (h *IOHandle) setParams(fd int, ep *evPoll) {
 	h._fd = fd
 	h._ep = ep
+	h._closed = false
+	h._gen++
+}
+
+func (h *IOHandle) connGen() uint32 {
+	return h._gen
+}
+
+func (h *IOHandle) setClosed() bool {
+	if h._closed {
+		return false
+	}
+	h._closed = true
+	return true
 }
 
 func (h *IOHandle) getEvPoll() *evPoll {
@@ -53,6 +98,17 @@ func (h *IOHandle) getTimerItem() *timerItem {
 	return h._ti
 }
 
+func (h *IOHandle) setConnCounter(c *atomic.Int64) {
+	h._conns = c
+}
+
+func (h *IOHandle) releaseConn() {
+	if h._conns != nil {
+		h._conns.Add(-1)
+		h._conns = nil
+	}
+}
+
 // Fd return fd
 func (h *IOHandle) Fd() int {
 	return h._fd
@@ -79,16 +135,82 @@ func (h *IOHandle) CancelTimer(eh EvHandler) {
 	}
 }
 
+// TimerID identifies a timer scheduled by ScheduleTimerFunc, the zero value is an invalid id
+type TimerID struct {
+	ti *timerItem
+}
+
+// ScheduleTimerFunc adds an independent timer to an IOHandle that is already registered with the
+// reactor, an IOHandle can hold any number of them (besides the one of ScheduleTimer).
+// fn is called with arg within the evpoll coroutine, a repeating timer (interval > 0) stops
+// when fn returns false.
+//
+// delay and interval are in millisecond.
+func (h *IOHandle) ScheduleTimerFunc(eh EvHandler, delay, interval int64,
+	fn func(millisecond int64, arg any) bool, arg any) (TimerID, error) {
+	if h._ep != nil {
+		return h._ep.scheduleTimerFunc(eh, delay, interval, fn, arg)
+	}
+	return TimerID{}, errors.New("ev handler has not been added to the reactor yet")
+}
+
+// CancelTimerID cancels the timer identified by id, returns false if it is not pending
+// (fired or canceled already).
+func (h *IOHandle) CancelTimerID(id TimerID) bool {
+	if h._ep != nil {
+		return h._ep.cancelTimerID(id)
+	}
+	return false
+}
+
+// BytesRead returns the number of bytes read from the connection by IOHandle.Read and
+// ReadBuffer.ReadFromHandler, it can be called from any goroutine (see Reactor.BytesRead)
+func (h *IOHandle) BytesRead() uint64 {
+	return h._bytesRead.Load()
+}
+
+// BytesWritten returns the number of bytes written to the connection by IOHandle.Write, the async
+// write and Writer, it can be called from any goroutine (see Reactor.BytesWritten)
+func (h *IOHandle) BytesWritten() uint64 {
+	return h._bytesWritten.Load()
+}
+
+// countRead counts n bytes read in the handler and its evpoll
+func (h *IOHandle) countRead(n int) {
+	if n > 0 {
+		h._bytesRead.Add(uint64(n))
+		if h._ep != nil {
+			h._ep.stats.bytesRead.Add(uint64(n))
+		}
+	}
+}
+
+// countWritten counts n bytes written in the handler and its evpoll
+func (h *IOHandle) countWritten(n int) {
+	if n > 0 {
+		h._bytesWritten.Add(uint64(n))
+		if h._ep != nil {
+			h._ep.stats.bytesWritten.Add(uint64(n))
+		}
+	}
+}
+
 // Read use evPollReadBuff, buf size can set by options.EvPollReadBuffSize
 func (h *IOHandle) Read() (bf []byte, n int, err error) {
 	if h._fd < 1 {
 		return nil, 0, syscall.EBADF
 	}
-	if h._ep != nil {
+	if h._readLimit != nil {
+		bf, n, err = h.limitedRead()
+	} else if h._ep != nil {
 		bf, n, err = h._ep.read(h._fd)
 	} else {
 		panic("goev: IOHandle.Read fd not register to evpoll")
 	}
+	h.countRead(n)
+	if err != nil {
+		h.reapDead(err)
+	}
 	return
 }
 
@@ -104,11 +226,39 @@ func (h *IOHandle) WriteBuff() []byte {
 func (h *IOHandle) Write(bf []byte) (n int, err error) {
 	if h._fd > 0 { // NOTE fd must > 0
 		n, err = syscall.Write(h._fd, bf)
+		h.countWritten(n)
+		if err != nil {
+			h.reapDead(err)
+		}
 		return
 	}
 	return 0, syscall.EBADF
 }
 
+// SendFd passes payloadFd with data to the peer of the unix socket, see netfd.SendFd
+func (h *IOHandle) SendFd(payloadFd int, data []byte) error {
+	if h._fd < 1 {
+		return syscall.EBADF
+	}
+	err := netfd.SendFd(h._fd, payloadFd, data)
+	if err == nil {
+		h.countWritten(len(data))
+	}
+	return err
+}
+
+// RecvFd receives an fd passed by the peer of the unix socket with the data sent along, call it
+// in OnRead instead of Read. The fd is -1 if the data carries none, the handler owns the fd
+// received. It returns syscall.EAGAIN when there is nothing to read, see netfd.RecvFd
+func (h *IOHandle) RecvFd() (fd int, data []byte, err error) {
+	if h._fd < 1 {
+		return -1, nil, syscall.EBADF
+	}
+	fd, data, err = netfd.RecvFd(h._fd)
+	h.countRead(len(data))
+	return
+}
+
 //
 //= EvHandler interface
 
@@ -43,6 +43,7 @@ func (h *IOHandle) asyncOrderedWrite(eh EvHandler, abf AsyncWriteBuf) {
 	}
 	n, _ := syscall.Write(h._fd, abf.Buf[abf.Writen:abf.Len])
 	if n > 0 {
+		h.countWritten(n)
 		if n == (abf.Len - abf.Writen) {
 			h._asyncLastPartialWriteTime = 0
 			eh.OnAsyncWriteBufDone(abf.Buf, abf.Flag) // send completely
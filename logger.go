@@ -0,0 +1,18 @@
+package goev
+
+// Logger receives the internal conditions which are handled by the framework itself and are not
+// returned to the caller (e.g. a failed epoll_ctl del while closing a handler, EMFILE backoff).
+//
+// It is called within the evpoll coroutine, MUST NOT block.
+type Logger interface {
+	Errorf(format string, v ...any)
+	Warnf(format string, v ...any)
+	Debugf(format string, v ...any)
+}
+
+// nopLogger is the default Logger
+type nopLogger struct{}
+
+func (nopLogger) Errorf(format string, v ...any) {}
+func (nopLogger) Warnf(format string, v ...any)  {}
+func (nopLogger) Debugf(format string, v ...any) {}
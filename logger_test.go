@@ -0,0 +1,90 @@
+package goev
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// captureLogger records the messages of all levels
+type captureLogger struct {
+	mtx  sync.Mutex
+	msgs []string
+}
+
+func (l *captureLogger) logf(level, format string, v ...any) {
+	l.mtx.Lock()
+	l.msgs = append(l.msgs, level+" "+fmt.Sprintf(format, v...))
+	l.mtx.Unlock()
+}
+func (l *captureLogger) Errorf(format string, v ...any) { l.logf("E", format, v...) }
+func (l *captureLogger) Warnf(format string, v ...any)  { l.logf("W", format, v...) }
+func (l *captureLogger) Debugf(format string, v ...any) { l.logf("D", format, v...) }
+
+// find returns the first message which has the prefix
+func (l *captureLogger) find(prefix string) string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, m := range l.msgs {
+		if strings.HasPrefix(m, prefix) {
+			return m
+		}
+	}
+	return ""
+}
+
+// contains returns true if a message has the prefix and contains sub
+func (l *captureLogger) contains(prefix, sub string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, m := range l.msgs {
+		if strings.HasPrefix(m, prefix) && strings.Contains(m, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// selfCloseConn closes its fd in OnRead, so that evpoll fails to remove it
+type selfCloseConn struct {
+	IOHandle
+
+	closedC chan struct{}
+}
+
+func (c *selfCloseConn) OnRead() bool {
+	syscall.Close(c.Fd())
+	return false
+}
+func (c *selfCloseConn) OnClose() {
+	close(c.closedC)
+}
+
+func TestLoggerRemoveFailed(t *testing.T) {
+	l := &captureLogger{}
+	r := newTestReactor(t, EvPollNum(1), EvLogger(l))
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	syscall.SetNonblock(fds[0], true)
+	c := &selfCloseConn{closedC: make(chan struct{})}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(fds[1], []byte("x"))
+	select {
+	case <-c.closedC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+	want := fmt.Sprintf("E goev: close fd %d: epoll_ctl del: %s", fds[0], syscall.EBADF.Error())
+	if m := l.find("E "); m != want {
+		t.Fatalf("logged %q, want %q", m, want)
+	}
+}
@@ -0,0 +1,84 @@
+package goev
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// metricDesc describes a series exported by WriteMetrics, value reads it from the stats snapshot of
+// an evpoll
+type metricDesc struct {
+	name  string
+	typ   string // counter or gauge
+	help  string
+	value func(s *EvPollStats) uint64
+}
+
+var evPollMetrics = []metricDesc{
+	{"goev_accepts_total", "counter", "Connections accepted by acceptors.",
+		func(s *EvPollStats) uint64 { return s.Accepts }},
+	{"goev_accept_errors_total", "counter", "Accept failures.",
+		func(s *EvPollStats) uint64 { return s.AcceptErrors }},
+	{"goev_conn_rejects_total", "counter", "Connections rejected due to MaxConnections.",
+		func(s *EvPollStats) uint64 { return s.ConnRejects }},
+	{"goev_fd_limit_drops_total", "counter", "Connections dropped due to EMFILE/ENFILE.",
+		func(s *EvPollStats) uint64 { return s.FdLimitDrops }},
+	{"goev_bytes_read_total", "counter", "Bytes read by IOHandle.Read and ReadBuffer.ReadFromHandler.",
+		func(s *EvPollStats) uint64 { return s.BytesRead }},
+	{"goev_bytes_written_total", "counter", "Bytes written by IOHandle.Write, AsyncWrite and Writer.",
+		func(s *EvPollStats) uint64 { return s.BytesWritten }},
+	{"goev_epoll_wait_calls_total", "counter", "epoll_wait calls.",
+		func(s *EvPollStats) uint64 { return s.EpollWaitCalls }},
+	{"goev_events_returned_total", "counter", "Events returned by epoll_wait.",
+		func(s *EvPollStats) uint64 { return s.EventsReturned }},
+	{"goev_events_processed_total", "counter", "Events dispatched to handlers.",
+		func(s *EvPollStats) uint64 { return s.EventsProcessed }},
+	{"goev_timer_fires_total", "counter", "Timer callbacks called.",
+		func(s *EvPollStats) uint64 { return s.TimerFires }},
+	{"goev_overload_defers_total", "counter", "Events deferred by the overload policy.",
+		func(s *EvPollStats) uint64 { return s.OverloadDefers }},
+	{"goev_overload_drops_total", "counter", "Fds dropped by the overload policy.",
+		func(s *EvPollStats) uint64 { return s.OverloadDrops }},
+	{"goev_dead_fd_reaps_total", "counter", "Registrations of fds closed out of band cleaned up.",
+		func(s *EvPollStats) uint64 { return s.DeadFdReaps }},
+	{"goev_post_overflows_total", "counter", "Posts finding the post queue full.",
+		func(s *EvPollStats) uint64 { return s.PostOverflows }},
+	{"goev_hup_err_closes_total", "counter", "Fds closed due to EPOLLHUP/EPOLLERR.",
+		func(s *EvPollStats) uint64 { return s.HupErrCloses }},
+	{"goev_panics_total", "counter", "Panics of handler callbacks recovered.",
+		func(s *EvPollStats) uint64 { return s.Panics }},
+	{"goev_restarts_total", "counter", "Evpoll restarts after epoll_wait failed.",
+		func(s *EvPollStats) uint64 { return s.Restarts }},
+	{"goev_events_buf_size", "gauge", "Size of the epoll_wait events buffer.",
+		func(s *EvPollStats) uint64 { return s.EventsBufSize }},
+}
+
+// WriteMetrics writes the statistics of the reactor to w in the Prometheus text exposition format,
+// the series of the evpolls are labeled by evpoll="<index>". It can be called from any goroutine
+// (e.g. in a http.Handler of the metrics endpoint), the evpolls update them without locking.
+func (r *Reactor) WriteMetrics(w io.Writer) error {
+	stats := r.Stats()
+	var b bytes.Buffer
+	header := func(name, typ, help string) {
+		b.WriteString("# HELP " + name + " " + help + "\n")
+		b.WriteString("# TYPE " + name + " " + typ + "\n")
+	}
+	header("goev_connections_open", "gauge", "Open connections accepted by acceptors.")
+	b.WriteString("goev_connections_open " + strconv.Itoa(r.Connections()) + "\n")
+
+	header("goev_registered_fds", "gauge", "Fds registered with the evpoll.")
+	for i := 0; i < r.evPollNum; i++ {
+		b.WriteString("goev_registered_fds{evpoll=\"" + strconv.Itoa(i) + "\"} " +
+			strconv.Itoa(r.evPolls[i].evHandlerMap.count()) + "\n")
+	}
+	for _, m := range evPollMetrics {
+		header(m.name, m.typ, m.help)
+		for i := range stats {
+			b.WriteString(m.name + "{evpoll=\"" + strconv.Itoa(i) + "\"} " +
+				strconv.FormatUint(m.value(&stats[i]), 10) + "\n")
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,92 @@
+package goev
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReactorWriteMetrics(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	openC := make(chan int, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-openC
+	for i := 0; i < 3; i++ {
+		echo(t, conn, "hello")
+	}
+
+	fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	t.Cleanup(func() { unix.Close(fd) })
+	h := &countTimer{}
+	firedC := make(chan int, 1)
+	r.Post(fd, func() {
+		r.AddEvHandler(h, fd, EvIn)
+		h.ScheduleTimerFunc(h, 1, 0, func(int64, any) bool {
+			firedC <- 1
+			return false
+		}, nil)
+	})
+	select {
+	case <-firedC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timer not fired")
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	sample := regexp.MustCompile(`^([a-z_]+)(\{evpoll="0"\})? ([0-9]+)$`)
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		m := sample.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid exposition line %q", line)
+		}
+		values[m[1]], _ = strconv.ParseUint(m[3], 10, 64)
+	}
+	for name, min := range map[string]uint64{
+		"goev_connections_open":       1,
+		"goev_accepts_total":          1,
+		"goev_bytes_read_total":       15,
+		"goev_bytes_written_total":    15,
+		"goev_epoll_wait_calls_total": 1,
+		"goev_timer_fires_total":      1,
+		"goev_registered_fds":         2,
+	} {
+		v, ok := values[name]
+		if !ok {
+			t.Fatalf("series %s missing:\n%s", name, out)
+		}
+		if v < min {
+			t.Fatalf("%s = %d, want >= %d", name, v, min)
+		}
+	}
+	if !strings.Contains(out, "# TYPE goev_accepts_total counter\n") ||
+		!strings.Contains(out, "# TYPE goev_connections_open gauge\n") {
+		t.Fatalf("TYPE lines missing:\n%s", out)
+	}
+}
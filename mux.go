@@ -0,0 +1,265 @@
+package goev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Mux frame types
+const (
+	MuxData  byte = 0x0 // payload of a stream
+	MuxOpen  byte = 0x1 // opens a stream, no payload
+	MuxClose byte = 0x2 // closes a stream, no payload
+)
+
+// The frame header of Mux: stream id (4 bytes), type (1 byte), payload length (4 bytes), big endian
+const muxHeaderSize = 9
+
+var (
+	// ErrMuxProtocol is returned by Mux.Decode when the peer violates the framing, the connection
+	// should be closed then
+	ErrMuxProtocol = errors.New("goev: mux protocol error")
+
+	// ErrMuxStreamClosed is returned when writing to a stream which is not open
+	ErrMuxStreamClosed = errors.New("goev: mux stream closed")
+)
+
+// MuxHandler receives the streams of a Mux, it is called within the evpoll coroutine
+type MuxHandler interface {
+	// OnStreamOpen is called when the peer opens the stream id, returning false refuses (closes) it
+	OnStreamOpen(id uint32) bool
+
+	// OnStreamData is called with the payload of a frame of the stream id, payload is valid only
+	// within it
+	OnStreamData(id uint32, payload []byte)
+
+	// OnStreamClose is called when the peer closes the stream id, the data not sent yet is dropped
+	OnStreamClose(id uint32)
+}
+
+// Mux multiplexes logical streams over a single connection, for custom RPC protocols. Each frame
+// carries a stream id, a type (MuxOpen, MuxData, MuxClose) and a payload of at most maxFrameSize.
+//
+// The incoming frames are decoded from a ReadBuffer and demultiplexed to MuxHandler. The outgoing
+// data is queued per stream and written to the Writer a frame at a time, round-robin across the
+// streams while the socket takes it, so that a large message does not hold back the other streams.
+// The streams opened by the client side have odd ids, those opened by the server side even ids.
+//
+// Mux is not thread-safe, use it only within the evpoll coroutine of the connection like Writer.
+//
+// For example:
+//
+//	c.mux, _ = goev.NewMux(goev.NewWriter(c), c, 16*1024, false) // c implements MuxHandler
+//
+//	func (c *Conn) OnRead() bool {
+//	    _, err := c.rb.ReadFromHandler(c)
+//	    if c.mux.Decode(c.rb) != nil {
+//	        return false
+//	    }
+//	    return err == nil
+//	}
+//
+//	func (c *Conn) OnWrite() bool {
+//	    return c.mux.Flush() == nil
+//	}
+type Mux struct {
+	w            *Writer
+	h            MuxHandler
+	maxFrameSize int
+	nextID       uint32 // of the next stream opened by this side
+
+	streams map[uint32]*muxStream // open streams
+	ready   []*muxStream          // streams with data queued, in round-robin order
+}
+
+type muxStream struct {
+	id      uint32
+	queue   []byte // data not framed yet
+	queued  bool   // in Mux.ready
+	closing bool   // Close called, the close frame follows the queued data
+	closed  bool   // removed from Mux.streams
+}
+
+// NewMux return an instance, w is the Writer of the connection, client selects the ids of the
+// streams opened by this side
+func NewMux(w *Writer, h MuxHandler, maxFrameSize int, client bool) (*Mux, error) {
+	if w == nil || h == nil || maxFrameSize < 1 {
+		return nil, errors.New("Mux: invalid params")
+	}
+	m := &Mux{
+		w:            w,
+		h:            h,
+		maxFrameSize: maxFrameSize,
+		nextID:       2,
+		streams:      make(map[uint32]*muxStream),
+	}
+	if client {
+		m.nextID = 1
+	}
+	return m, nil
+}
+
+// OpenStream opens a new stream and returns its id
+func (m *Mux) OpenStream() (uint32, error) {
+	id := m.nextID
+	if id > id+2 {
+		return 0, errors.New("Mux: stream ids exhausted")
+	}
+	if err := m.writeFrame(id, MuxOpen, nil); err != nil {
+		return 0, err
+	}
+	m.nextID += 2
+	m.streams[id] = &muxStream{id: id}
+	return id, nil
+}
+
+// Write queues p (copied) to the stream id and writes what the socket takes
+func (m *Mux) Write(id uint32, p []byte) error {
+	s := m.streams[id]
+	if s == nil || s.closing {
+		return ErrMuxStreamClosed
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	s.queue = append(s.queue, p...)
+	if !s.queued {
+		s.queued = true
+		m.ready = append(m.ready, s)
+	}
+	return m.schedule()
+}
+
+// Close closes the stream id once its queued data has been sent, OnStreamClose is not called
+func (m *Mux) Close(id uint32) error {
+	s := m.streams[id]
+	if s == nil || s.closing {
+		return ErrMuxStreamClosed
+	}
+	s.closing = true
+	if s.queued {
+		return nil // by schedule
+	}
+	m.remove(s)
+	return m.writeFrame(id, MuxClose, nil)
+}
+
+// Flush sends the data buffered by the Writer and then the queued data of the streams, call it in
+// OnWrite instead of Writer.Flush.
+func (m *Mux) Flush() error {
+	if err := m.w.Flush(); err != nil {
+		return err
+	}
+	return m.schedule()
+}
+
+// Decode handles each complete frame of rb and consumes it, an incomplete frame is kept in rb.
+// Returns ErrMuxProtocol or ErrFrameTooLarge (wrapped) if the peer violates the framing.
+func (m *Mux) Decode(rb *ReadBuffer) error {
+	for {
+		head := rb.Peek(muxHeaderSize)
+		if head == nil {
+			return nil
+		}
+		id := binary.BigEndian.Uint32(head)
+		typ := head[4]
+		n := binary.BigEndian.Uint32(head[5:])
+		if n > uint32(m.maxFrameSize) {
+			return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, n, m.maxFrameSize)
+		}
+		frame := rb.Peek(muxHeaderSize + int(n))
+		if frame == nil {
+			return nil
+		}
+		if err := m.handleFrame(id, typ, frame[muxHeaderSize:]); err != nil {
+			return err
+		}
+		rb.Discard(len(frame))
+	}
+}
+
+func (m *Mux) handleFrame(id uint32, typ byte, payload []byte) error {
+	s := m.streams[id]
+	switch typ {
+	case MuxData:
+		// data of a stream just closed by this side may still arrive
+		if s != nil && len(payload) > 0 {
+			m.h.OnStreamData(id, payload)
+		}
+	case MuxOpen:
+		if len(payload) != 0 || s != nil || id == 0 || id&1 == m.nextID&1 {
+			return fmt.Errorf("%w: open stream %d", ErrMuxProtocol, id)
+		}
+		m.streams[id] = &muxStream{id: id}
+		if m.h.OnStreamOpen(id) == false {
+			m.remove(m.streams[id])
+			return m.writeFrame(id, MuxClose, nil)
+		}
+	case MuxClose:
+		if len(payload) != 0 {
+			return fmt.Errorf("%w: close stream %d", ErrMuxProtocol, id)
+		}
+		if s != nil {
+			m.remove(s)
+			if !s.closing {
+				m.h.OnStreamClose(id)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: frame type %d", ErrMuxProtocol, typ)
+	}
+	return nil
+}
+
+// schedule frames the queued data round-robin while the Writer has nothing buffered, i.e. the
+// socket takes it, the rest waits for Flush
+func (m *Mux) schedule() error {
+	for len(m.ready) > 0 && m.w.Buffered() == 0 {
+		s := m.ready[0]
+		m.ready[0] = nil
+		m.ready = m.ready[1:]
+		if s.closed {
+			continue
+		}
+		n := len(s.queue)
+		if n > m.maxFrameSize {
+			n = m.maxFrameSize
+		}
+		if err := m.writeFrame(s.id, MuxData, s.queue[:n]); err != nil {
+			return err
+		}
+		s.queue = s.queue[n:]
+		if len(s.queue) > 0 {
+			m.ready = append(m.ready, s)
+			continue
+		}
+		s.queue, s.queued = nil, false
+		if s.closing {
+			m.remove(s)
+			if err := m.writeFrame(s.id, MuxClose, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mux) remove(s *muxStream) {
+	s.closed = true
+	s.queue = nil
+	delete(m.streams, s.id)
+}
+
+func (m *Mux) writeFrame(id uint32, typ byte, payload []byte) error {
+	var head [muxHeaderSize]byte
+	binary.BigEndian.PutUint32(head[:], id)
+	head[4] = typ
+	binary.BigEndian.PutUint32(head[5:], uint32(len(payload)))
+	if len(payload) == 0 {
+		_, err := m.w.Write(head[:])
+		return err
+	}
+	_, err := m.w.Writev([][]byte{head[:], payload})
+	return err
+}
@@ -0,0 +1,200 @@
+package goev
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// muxConn is an end of a Mux connection, it records what its peer sends per stream
+type muxConn struct {
+	IOHandle
+
+	rb  *ReadBuffer
+	mux *Mux
+
+	opened  []uint32
+	data    map[uint32][]byte
+	closed  []uint32
+	order   []uint32 // stream of each data frame received
+	closedC chan uint32
+}
+
+func newMuxConn(r *Reactor, client bool) *muxConn {
+	c := &muxConn{
+		rb:      NewReadBuffer(4096, 0),
+		data:    make(map[uint32][]byte),
+		closedC: make(chan uint32, 4),
+	}
+	c.setReactor(r)
+	c.mux, _ = NewMux(NewWriter(c), c, 1024, client)
+	return c
+}
+
+func (c *muxConn) OnOpen(fd int) bool {
+	return c.GetReactor().AddEvHandler(c, fd, EvIn) == nil
+}
+
+func (c *muxConn) OnRead() bool {
+	_, err := c.rb.ReadFromHandler(c)
+	if c.mux.Decode(c.rb) != nil {
+		return false
+	}
+	return err == nil
+}
+
+func (c *muxConn) OnWrite() bool {
+	return c.mux.Flush() == nil
+}
+
+func (c *muxConn) OnClose() {
+	syscall.Close(c.Fd())
+}
+
+func (c *muxConn) OnStreamOpen(id uint32) bool {
+	c.opened = append(c.opened, id)
+	return id != 5 // refused
+}
+
+func (c *muxConn) OnStreamData(id uint32, payload []byte) {
+	c.data[id] = append(c.data[id], payload...)
+	c.order = append(c.order, id)
+}
+
+func (c *muxConn) OnStreamClose(id uint32) {
+	c.closed = append(c.closed, id)
+	c.closedC <- id
+}
+
+// runIn runs fn within the evpoll of fd and waits for it
+func runIn(t *testing.T, r *Reactor, fd int, fn func()) {
+	done := make(chan struct{})
+	if err := r.Post(fd, func() {
+		fn()
+		close(done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("posted func not run")
+	}
+}
+
+func waitStreamClose(t *testing.T, c *muxConn, want uint32) {
+	select {
+	case id := <-c.closedC:
+		if id != want {
+			t.Fatalf("stream %d closed, want %d", id, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stream %d close not received", want)
+	}
+}
+
+func TestMux(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := newMuxConn(r, true), newMuxConn(r, false)
+	if !client.OnOpen(fds[0]) || !server.OnOpen(fds[1]) {
+		t.Fatal("add failed")
+	}
+
+	// two streams interleaved, a message larger than a frame is split
+	large := bytes.Repeat([]byte("0123456789"), 300)
+	var a, b uint32
+	runIn(t, r, fds[0], func() {
+		a, _ = client.mux.OpenStream()
+		b, _ = client.mux.OpenStream()
+		client.mux.Write(a, []byte("a1"))
+		client.mux.Write(b, []byte("b1"))
+		client.mux.Write(a, []byte("a2"))
+		client.mux.Write(b, large)
+		client.mux.Close(a)
+		client.mux.Close(b)
+		if err := client.mux.Write(a, []byte("a3")); !errors.Is(err, ErrMuxStreamClosed) {
+			t.Errorf("write to a closed stream got %v", err)
+		}
+	})
+	waitStreamClose(t, server, a)
+	waitStreamClose(t, server, b)
+	runIn(t, r, fds[0], func() {
+		if a != 1 || b != 3 || len(server.opened) != 2 || server.opened[0] != a || server.opened[1] != b {
+			t.Fatalf("streams %d %d, opened %v", a, b, server.opened)
+		}
+		if string(server.data[a]) != "a1a2" || !bytes.Equal(server.data[b], append([]byte("b1"), large...)) {
+			t.Fatalf("demux got %q, %d bytes", server.data[a], len(server.data[b]))
+		}
+	})
+
+	// a stream of the server, and a stream refused by the server
+	runIn(t, r, fds[1], func() {
+		id, _ := server.mux.OpenStream()
+		if id != 2 {
+			t.Errorf("server stream %d, want 2", id)
+		}
+		server.mux.Write(id, []byte("from server"))
+		server.mux.Close(id)
+	})
+	waitStreamClose(t, client, 2)
+	var refused uint32
+	runIn(t, r, fds[0], func() {
+		refused, _ = client.mux.OpenStream()
+	})
+	waitStreamClose(t, client, refused)
+	runIn(t, r, fds[0], func() {
+		if string(client.data[2]) != "from server" {
+			t.Fatalf("client got %q", client.data[2])
+		}
+		if err := client.mux.Write(refused, []byte("x")); !errors.Is(err, ErrMuxStreamClosed) {
+			t.Fatalf("write to a refused stream got %v", err)
+		}
+	})
+}
+
+func TestMuxFairScheduling(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := newMuxConn(r, true), newMuxConn(r, false)
+	if !client.OnOpen(fds[0]) {
+		t.Fatal("add failed")
+	}
+	// the server does not read yet, so the large message fills up the socket and is queued
+	large := make([]byte, 4<<20)
+	var a, b uint32
+	runIn(t, r, fds[0], func() {
+		a, _ = client.mux.OpenStream()
+		b, _ = client.mux.OpenStream()
+		client.mux.Write(a, large)
+		client.mux.Write(b, []byte("small"))
+		client.mux.Close(a)
+		client.mux.Close(b)
+	})
+	if !server.OnOpen(fds[1]) {
+		t.Fatal("add failed")
+	}
+	waitStreamClose(t, server, b)
+	waitStreamClose(t, server, a)
+	runIn(t, r, fds[0], func() {
+		if len(server.data[a]) != len(large) || string(server.data[b]) != "small" {
+			t.Fatalf("got %d, %q", len(server.data[a]), server.data[b])
+		}
+		// b is sent right after the frames of a already buffered, not after all of a
+		i := 0
+		for i < len(server.order) && server.order[i] != b {
+			i++
+		}
+		if i == len(server.order) || i > len(server.order)/2 {
+			t.Fatalf("small stream received at frame %d of %d", i, len(server.order))
+		}
+	})
+}
@@ -2,6 +2,7 @@ package netfd
 
 import (
 	"errors"
+	"io"
 	"net"
 	"strconv"
 	"syscall"
@@ -86,15 +87,50 @@ func RemoteAddr(fd int) string {
 // 在accept/connnect之后调用
 // must < `sysctl -a | grep net.core.wmem_max`
 func SetSendBuffSize(fd, bytes int) error {
+	if bytes < 1 {
+		return errors.New("SO_SNDBUF size invalid")
+	}
 	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, bytes); err != nil {
 		return errors.New("Set SO_SNDBUF: " + err.Error())
 	}
 	return nil
 }
 
+// SetRecvBuffSize set SO_RCVBUF
+//
+// 在accept/connnect之后调用, the kernel stops auto-tuning the buffer of this socket
+// must < `sysctl -a | grep net.core.rmem_max`
+func SetRecvBuffSize(fd, bytes int) error {
+	if bytes < 1 {
+		return errors.New("SO_RCVBUF size invalid")
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, bytes); err != nil {
+		return errors.New("Set SO_RCVBUF: " + err.Error())
+	}
+	return nil
+}
+
+// SetLinger set SO_LINGER, sec is in second
+//
+// sec > 0: close() blocks until the pending data is sent or sec expires (blocking fd only)
+// sec = 0: close() discards the pending data and sends RST instead of FIN
+func SetLinger(fd, sec int) error {
+	if sec < 0 {
+		return errors.New("linger seconds invalid")
+	}
+	l := syscall.Linger{Onoff: 1, Linger: int32(sec)}
+	if err := syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &l); err != nil {
+		return errors.New("Set SO_LINGER: " + err.Error())
+	}
+	return nil
+}
+
 // SetNonblock set fd nonblocking
 func SetNonblock(fd int, v bool) error {
-	return syscall.SetNonblock(fd, v)
+	if err := syscall.SetNonblock(fd, v); err != nil {
+		return errors.New("Set O_NONBLOCK: " + err.Error())
+	}
+	return nil
 }
 
 // SetNoDelay set fd TCP_NODELAY
@@ -113,8 +149,8 @@ func SetNoDelay(fd, v int) error {
 // interval: The interval period after the start of probing
 // times: If there is no response after "times" attempts, the connection will be closed.
 func SetKeepAlive(fd, idle, interval, times int) error {
-	if interval < 1 {
-		return errors.New("keepalive interval invalid")
+	if idle < 1 || interval < 1 || times < 1 {
+		return errors.New("keepalive params invalid")
 	}
 	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
 		return errors.New("Set SO_KEEPALIVE: " + err.Error())
@@ -131,12 +167,141 @@ func SetKeepAlive(fd, idle, interval, times int) error {
 	return nil
 }
 
-// SetQuickACK set TCP_QUICKAC
+// SetQuickACK set TCP_QUICKACK
 //
 // 0:delay 1:quick
-func SetQuickACK(fd, bytes int) error {
-	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, 1); err != nil {
+func SetQuickACK(fd, v int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, v); err != nil {
 		return errors.New("Set TCP_QUICKACK: " + err.Error())
 	}
 	return nil
 }
+
+// Cork sets TCP_CORK: partial segments are held until Uncork (or 200ms at most), so that the
+// pieces of a response written one after another leave in full segments.
+//
+// TCP_CORK takes precedence over TCP_NODELAY while it is set, Uncork sends the partial segment
+// held at once whether TCP_NODELAY is set or not.
+func Cork(fd int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_CORK, 1); err != nil {
+		return errors.New("Set TCP_CORK: " + err.Error())
+	}
+	return nil
+}
+
+// Uncork clears TCP_CORK, the data held is sent, see Cork
+func Uncork(fd int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_CORK, 0); err != nil {
+		return errors.New("Clear TCP_CORK: " + err.Error())
+	}
+	return nil
+}
+
+// SendFd passes payloadFd to the peer of the unix socket unixFd (SCM_RIGHTS) along with data,
+// e.g. a listener or a connection handed over to a worker process. The peer gets its own fd
+// referring to the same open file, payloadFd can be closed after it has been sent.
+// A stream socket carries the fd with at least one byte, a zero byte is sent if data is empty.
+// The errors of sendmsg are returned as is (EINTR is ignored), e.g. syscall.EAGAIN.
+func SendFd(unixFd, payloadFd int, data []byte) error {
+	if len(data) == 0 {
+		data = []byte{0}
+	}
+	rights := syscall.UnixRights(payloadFd)
+	for {
+		n, err := syscall.SendmsgN(unixFd, data, rights, nil, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n < len(data) { // the fd has been sent with the first bytes
+			_, err = Write(unixFd, data[n:])
+		}
+		return err
+	}
+}
+
+// RecvFd receives an fd passed by SendFd from the unix socket unixFd, with the data sent along
+// (at most 4096 bytes per call). The fd is close-on-exec. It returns -1 if the data received
+// carries no fd (the extra fds of a message are closed), and io.EOF if the socket is closed.
+// The errors of recvmsg are returned as is (EINTR is ignored), e.g. syscall.EAGAIN.
+func RecvFd(unixFd int) (int, []byte, error) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(4*4)) // room for a few fds, the extra ones are closed
+	for {
+		n, oobn, _, _, err := syscall.Recvmsg(unixFd, buf, oob, syscall.MSG_CMSG_CLOEXEC)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return -1, nil, err
+		}
+		fd := -1
+		if oobn > 0 {
+			fd = parseRights(oob[:oobn])
+		}
+		if n == 0 && fd == -1 {
+			return -1, nil, io.EOF
+		}
+		return fd, buf[:n], nil
+	}
+}
+
+// parseRights returns the first fd of the SCM_RIGHTS messages, the others are closed
+func parseRights(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	fd := -1
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, f := range fds {
+			if fd == -1 {
+				fd = f
+			} else {
+				syscall.Close(f)
+			}
+		}
+	}
+	return fd
+}
+
+// ErrAgain is returned by Fd.Read/Fd.Write instead of syscall.EAGAIN
+var ErrAgain = errors.New("netfd: resource temporarily unavailable")
+
+// Fd is a non-blocking file descriptor, e.g. netfd.Fd(h.Fd()).Write(bf)
+type Fd int
+
+// Read reads from fd (ignoring EINTR).
+//
+// Zero with nil error indicates socket closed, ErrAgain indicates no data at the moment.
+func (fd Fd) Read(buf []byte) (int, error) {
+	n, err := Read(int(fd), buf)
+	return result(n, err)
+}
+
+// Write writes to fd (ignoring EINTR), ErrAgain indicates the socket buffer is full.
+func (fd Fd) Write(buf []byte) (int, error) {
+	n, err := Write(int(fd), buf)
+	return result(n, err)
+}
+
+// Close the fd
+func (fd Fd) Close() error {
+	return Close(int(fd))
+}
+
+func result(n int, err error) (int, error) {
+	if err != nil {
+		if err == syscall.EAGAIN {
+			err = ErrAgain
+		}
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,261 @@
+package netfd
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFd(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := Fd(fds[0]), Fd(fds[1])
+	defer a.Close()
+
+	bf := make([]byte, 16)
+	if n, err := a.Read(bf); n != 0 || err != ErrAgain {
+		t.Fatalf("read empty socket: %d %v, want ErrAgain", n, err)
+	}
+	if n, err := b.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatalf("write: %d %v", n, err)
+	}
+	if n, err := a.Read(bf); err != nil || string(bf[:n]) != "hello" {
+		t.Fatalf("read: %q %v", bf[:n], err)
+	}
+
+	big := make([]byte, 1<<20)
+	for { // fill the socket buffer
+		if _, err = b.Write(big); err != nil {
+			break
+		}
+	}
+	if err != ErrAgain {
+		t.Fatalf("write full socket: %v, want ErrAgain", err)
+	}
+
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for { // drain, then EOF
+		n, err := a.Read(big)
+		if err != nil {
+			t.Fatalf("read after peer close: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if _, err = b.Write(bf); err != syscall.EBADF {
+		t.Fatalf("write closed fd: %v, want EBADF", err)
+	}
+}
+
+// tcpSocket returns a blocking TCP socket, closed in Cleanup
+func tcpSocket(t *testing.T) int {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	return fd
+}
+
+func getsockopt(t *testing.T, fd, level, opt int) int {
+	v, err := syscall.GetsockoptInt(fd, level, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSocketOptions(t *testing.T) {
+	fd := tcpSocket(t)
+
+	if err := SetNonblock(fd, true); err != nil {
+		t.Fatal(err)
+	}
+	fl, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 || fl&syscall.O_NONBLOCK == 0 {
+		t.Fatalf("O_NONBLOCK not set: %x %v", fl, errno)
+	}
+	if err := SetNonblock(-1, true); err == nil {
+		t.Fatal("SetNonblock on bad fd succeeded")
+	}
+
+	for _, v := range []int{1, 0} {
+		if err := SetNoDelay(fd, v); err != nil {
+			t.Fatal(err)
+		}
+		if got := getsockopt(t, fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); (got != 0) != (v != 0) {
+			t.Fatalf("TCP_NODELAY = %d, want %d", got, v)
+		}
+	}
+
+	if err := SetKeepAlive(fd, 30, 5, 4); err != nil {
+		t.Fatal(err)
+	}
+	if getsockopt(t, fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) == 0 {
+		t.Fatal("SO_KEEPALIVE not set")
+	}
+	for opt, want := range map[int]int{syscall.TCP_KEEPIDLE: 30, syscall.TCP_KEEPINTVL: 5, syscall.TCP_KEEPCNT: 4} {
+		if got := getsockopt(t, fd, syscall.IPPROTO_TCP, opt); got != want {
+			t.Fatalf("keepalive opt %d = %d, want %d", opt, got, want)
+		}
+	}
+	for _, p := range [][3]int{{0, 5, 4}, {30, 0, 4}, {30, 5, 0}} {
+		if err := SetKeepAlive(fd, p[0], p[1], p[2]); err == nil {
+			t.Fatalf("SetKeepAlive%v succeeded", p)
+		}
+	}
+	if err := SetNoDelay(-1, 1); err == nil {
+		t.Fatal("SetNoDelay on bad fd succeeded")
+	}
+}
+
+func TestSocketBuffAndLinger(t *testing.T) {
+	fd := tcpSocket(t)
+
+	if err := SetRecvBuffSize(fd, 32*1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSendBuffSize(fd, 32*1024); err != nil {
+		t.Fatal(err)
+	}
+	// the kernel doubles the value for bookkeeping overhead (man 7 socket), within rmem_max/wmem_max
+	if got := getsockopt(t, fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF); got < 32*1024 {
+		t.Fatalf("SO_RCVBUF = %d", got)
+	}
+	if got := getsockopt(t, fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF); got < 32*1024 {
+		t.Fatalf("SO_SNDBUF = %d", got)
+	}
+	if SetRecvBuffSize(fd, 0) == nil || SetSendBuffSize(fd, -1) == nil {
+		t.Fatal("invalid buff size accepted")
+	}
+
+	if err := SetLinger(fd, 3); err != nil {
+		t.Fatal(err)
+	}
+	l, err := unix.GetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Onoff != 1 || l.Linger != 3 {
+		t.Fatalf("SO_LINGER = %+v", *l)
+	}
+	if SetLinger(fd, -1) == nil {
+		t.Fatal("negative linger accepted")
+	}
+}
+
+func TestSendRecvFd(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p [2]int
+	if err = syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+
+	if _, _, err = RecvFd(fds[1]); err != syscall.EAGAIN {
+		t.Fatalf("recv empty socket: %v, want EAGAIN", err)
+	}
+	// the write end of the pipe is passed, then closed by the sender
+	if err = SendFd(fds[0], p[1], []byte("pipe")); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(p[1])
+	fd, data, err := RecvFd(fds[1])
+	if err != nil || fd < 0 || string(data) != "pipe" {
+		t.Fatalf("recv: %d %q %v", fd, data, err)
+	}
+	if _, err = Write(fd, []byte("through")); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd)
+	bf := make([]byte, 16)
+	if n, err := syscall.Read(p[0], bf); err != nil || string(bf[:n]) != "through" {
+		t.Fatalf("read pipe: %q %v", bf[:n], err)
+	}
+
+	// data without an fd, then the close of the peer
+	if _, err = Write(fds[0], []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if fd, data, err = RecvFd(fds[1]); fd != -1 || string(data) != "x" || err != nil {
+		t.Fatalf("recv data: %d %q %v", fd, data, err)
+	}
+	syscall.Close(fds[0])
+	if _, _, err = RecvFd(fds[1]); err != io.EOF {
+		t.Fatalf("recv closed socket: %v, want EOF", err)
+	}
+	syscall.Close(fds[1])
+}
+
+func TestTcpInfo(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	bf := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write(bf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, bf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, _ := conn.(*net.TCPConn).SyscallConn()
+	var info TCPInfo
+	var want *unix.TCPInfo
+	raw.Control(func(fd uintptr) {
+		info, err = TcpInfo(int(fd))
+		want, _ = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != 1 /*TCP_ESTABLISHED*/ || info.Rtt == 0 || info.SndCwnd == 0 || info.SndMss == 0 {
+		t.Fatalf("implausible tcp info %+v", info)
+	}
+	if want != nil && (info.Rto != want.Rto || info.SndMss != want.Snd_mss ||
+		info.TotalRetrans != want.Total_retrans || info.BytesAcked != want.Bytes_acked ||
+		info.MinRtt != want.Min_rtt || info.BytesSent != want.Bytes_sent) {
+		t.Fatalf("tcp info %+v differs from %+v", info, *want)
+	}
+	if info.BytesAcked != 0 && info.BytesAcked < 10*1024 {
+		t.Fatalf("%d bytes acked", info.BytesAcked)
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	if _, err = TcpInfo(fds[0]); err == nil {
+		t.Fatal("tcp info of a unix socket")
+	}
+}
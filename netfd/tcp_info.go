@@ -0,0 +1,124 @@
+package netfd
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// TCPInfo is the part of the kernel's struct tcp_info (see linux/tcp.h) returned by TcpInfo.
+// The times are in microseconds unless noted, the sizes of the window and of the MSS in bytes.
+//
+// The fields added by a kernel later than 2.6 are 0 when the running kernel does not report them.
+type TCPInfo struct {
+	State       uint8 // TCP_ESTABLISHED 1, TCP_SYN_SENT 2 ... TCP_CLOSE 7 ...
+	CaState     uint8 // congestion avoidance state, TCP_CA_Open 0 ... TCP_CA_Loss 4
+	Retransmits uint8 // consecutive retransmits of the unacked segment
+	Probes      uint8 // zero window probes (or keepalive probes) sent unanswered
+	Backoff     uint8 // exponential backoff of the retransmission timer
+
+	Rto          uint32 // retransmission timeout
+	Ato          uint32 // delayed ACK timeout
+	SndMss       uint32
+	RcvMss       uint32
+	Unacked      uint32 // segments sent and not acked
+	Sacked       uint32
+	Lost         uint32
+	Retrans      uint32 // segments being retransmitted
+	LastDataSent uint32 // millisecond since the last data sent
+	LastDataRecv uint32 // millisecond since the last data received
+	LastAckRecv  uint32 // millisecond since the last ACK received
+	Pmtu         uint32
+	RcvSsthresh  uint32
+	Rtt          uint32 // smoothed round trip time
+	Rttvar       uint32 // round trip time variation
+	SndSsthresh  uint32
+	SndCwnd      uint32 // congestion window, in segments
+	Advmss       uint32
+	Reordering   uint32
+	RcvRtt       uint32 // round trip time estimated by the receiver
+	RcvSpace     uint32
+	TotalRetrans uint32 // segments retransmitted over the connection
+
+	PacingRate    uint64 // bytes per second, kernel >= 3.15
+	BytesAcked    uint64 // kernel >= 4.1
+	BytesReceived uint64 // kernel >= 4.1
+	SegsOut       uint32 // kernel >= 4.2
+	SegsIn        uint32 // kernel >= 4.2
+	NotsentBytes  uint32 // kernel >= 4.6
+	MinRtt        uint32 // kernel >= 4.6
+	DeliveryRate  uint64 // bytes per second, kernel >= 4.9
+	BytesSent     uint64 // kernel >= 4.19
+	BytesRetrans  uint64 // kernel >= 4.19
+}
+
+// The offsets of struct tcp_info, the size of the struct grows with the kernel version
+const (
+	tcpInfoBaseSize      = 104 // up to tcpi_total_retrans, kernel 2.6
+	tcpInfoPacingRate    = 104
+	tcpInfoBytesAcked    = 120
+	tcpInfoBytesReceived = 128
+	tcpInfoSegsOut       = 136
+	tcpInfoSegsIn        = 140
+	tcpInfoNotsentBytes  = 144
+	tcpInfoMinRtt        = 148
+	tcpInfoDeliveryRate  = 160
+	tcpInfoBytesSent     = 200
+	tcpInfoBytesRetrans  = 208
+	tcpInfoBuffSize      = 512 // larger than any kernel's, the kernel copies min(its size, len)
+)
+
+// TcpInfo returns the TCP_INFO of the TCP socket fd, e.g. the RTT and the retransmits of a
+// connection for the metrics. Only the fields reported by the running kernel are set, see TCPInfo.
+func TcpInfo(fd int) (TCPInfo, error) {
+	var info TCPInfo
+	buf := make([]uint64, tcpInfoBuffSize/8) // aligned for the 64-bit fields
+	n := uint32(tcpInfoBuffSize)
+	_, _, e := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.IPPROTO_TCP,
+		syscall.TCP_INFO, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n)), 0)
+	if e != 0 {
+		return info, errors.New("Get TCP_INFO: " + e.Error())
+	}
+	if n < tcpInfoBaseSize {
+		return info, errors.New("Get TCP_INFO: " + strconv.Itoa(int(n)) + " bytes returned")
+	}
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&buf[0])), n)
+	info.State, info.CaState, info.Retransmits = b[0], b[1], b[2]
+	info.Probes, info.Backoff = b[3], b[4]
+	u32s := []*uint32{&info.Rto, &info.Ato, &info.SndMss, &info.RcvMss, &info.Unacked,
+		&info.Sacked, &info.Lost, &info.Retrans, nil /*fackets*/, &info.LastDataSent,
+		nil /*last_ack_sent*/, &info.LastDataRecv, &info.LastAckRecv, &info.Pmtu,
+		&info.RcvSsthresh, &info.Rtt, &info.Rttvar, &info.SndSsthresh, &info.SndCwnd,
+		&info.Advmss, &info.Reordering, &info.RcvRtt, &info.RcvSpace, &info.TotalRetrans}
+	for i, p := range u32s {
+		if p != nil {
+			*p = u32(b, 8+i*4)
+		}
+	}
+	for _, f := range []struct {
+		p   *uint64
+		off int
+	}{{&info.PacingRate, tcpInfoPacingRate}, {&info.BytesAcked, tcpInfoBytesAcked},
+		{&info.BytesReceived, tcpInfoBytesReceived}, {&info.DeliveryRate, tcpInfoDeliveryRate},
+		{&info.BytesSent, tcpInfoBytesSent}, {&info.BytesRetrans, tcpInfoBytesRetrans}} {
+		if f.off+8 <= len(b) {
+			*f.p = *(*uint64)(unsafe.Pointer(&b[f.off]))
+		}
+	}
+	for _, f := range []struct {
+		p   *uint32
+		off int
+	}{{&info.SegsOut, tcpInfoSegsOut}, {&info.SegsIn, tcpInfoSegsIn},
+		{&info.NotsentBytes, tcpInfoNotsentBytes}, {&info.MinRtt, tcpInfoMinRtt}} {
+		if f.off+4 <= len(b) {
+			*f.p = u32(b, f.off)
+		}
+	}
+	return info, nil
+}
+
+// u32 reads the native endian uint32 at off of b, aligned
+func u32(b []byte, off int) uint32 {
+	return *(*uint32)(unsafe.Pointer(&b[off]))
+}
@@ -0,0 +1,91 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// NotifyHandler receives the notifications of a Notifier
+type NotifyHandler interface {
+	// OnNotify is called within the evpoll coroutine, count is the number of Notify calls since
+	// the last OnNotify (the notifications are coalesced)
+	OnNotify(count uint64)
+}
+
+// Notifier wakes the reactor up from any goroutine through an eventfd, e.g. to do the deferred
+// work of a handler within its evpoll.
+type Notifier struct {
+	IOHandle
+
+	h NotifyHandler
+}
+
+// NewNotifier creates an eventfd and registers it with r, h.OnNotify is called when it is notified
+func NewNotifier(r *Reactor, h NotifyHandler) (*Notifier, error) {
+	if h == nil {
+		return nil, errors.New("NewNotifier: NotifyHandler is nil")
+	}
+	fd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return nil, errors.New("eventfd: " + err.Error())
+	}
+	n := &Notifier{h: h}
+	n.setReactor(r)
+	if err = r.AddEvHandler(n, fd, EvEventfd); err != nil {
+		syscall.Close(fd)
+		return nil, errors.New("Notifier add to reactor: " + err.Error())
+	}
+	return n, nil
+}
+
+// Notify adds 1 to the eventfd counter, it can be called from any goroutine
+func (n *Notifier) Notify() error {
+	var v uint64 = 1
+	for {
+		_, err := syscall.Write(n.Fd(), (*(*[8]byte)(unsafe.Pointer(&v)))[:]) // man 2 eventfd
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return errors.New("Notify: " + err.Error())
+		}
+		return nil
+	}
+}
+
+// Close unregisters the notifier and closes its eventfd, it can be called from any goroutine.
+// OnNotify is not called after Close, Notify MUST not be called after Close (the fd may be reused).
+func (n *Notifier) Close() error {
+	fd, r := n.Fd(), n.GetReactor()
+	return r.Post(fd, func() {
+		if eh := r.GetHandler(fd); eh == n {
+			r.RemoveEvHandler(n, fd)
+			n.OnClose()
+		}
+	})
+}
+
+// OnRead reads (and resets) the eventfd counter
+func (n *Notifier) OnRead() bool {
+	var v uint64
+	for {
+		_, err := syscall.Read(n.Fd(), (*(*[8]byte)(unsafe.Pointer(&v)))[:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == nil && v > 0 {
+			n.h.OnNotify(v)
+		}
+		return true
+	}
+}
+
+// OnClose closes the eventfd
+func (n *Notifier) OnClose() {
+	if fd := n.Fd(); fd > 0 {
+		syscall.Close(fd)
+	}
+}
@@ -0,0 +1,62 @@
+package goev
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type notifyCounter struct {
+	total atomic.Uint64
+	calls atomic.Int64
+}
+
+func (c *notifyCounter) OnNotify(count uint64) {
+	c.total.Add(count)
+	c.calls.Add(1)
+}
+
+func TestNotifier(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	h := &notifyCounter{}
+	n, err := NewNotifier(r, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const goroutines, notifies = 8, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < notifies; j++ {
+				if err := n.Notify(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.total.Load() < goroutines*notifies && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := h.total.Load(); got != goroutines*notifies {
+		t.Fatalf("delivered %d notifications, want %d", got, goroutines*notifies)
+	}
+	t.Logf("%d notifications coalesced into %d OnNotify calls", goroutines*notifies, h.calls.Load())
+
+	fd := n.Fd()
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for r.GetHandler(fd) != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r.GetHandler(fd) != nil {
+		t.Fatal("notifier still registered after Close")
+	}
+}
@@ -5,24 +5,57 @@ type Options struct {
 	noCopy
 
 	// acceptor options
-	reuseAddr     bool // SO_REUSEADDR
-	reusePort     bool // SO_REUSEPORT
-	listenBacklog int  //
+	reuseAddr       bool // SO_REUSEADDR
+	reusePort       bool // SO_REUSEPORT
+	ipv6Only        bool // IPV6_V6ONLY
+	listenBacklog   int  //
+	acceptExclusive bool // EPOLLEXCLUSIVE
+	acceptET        bool // EPOLLET
+	acceptSockOpts  func(fd int) error
+	acceptRate      int
+	acceptBurst     int
+	stopAfter       int
+	stopTimeout     int64
+	onStop          func(accepted int)
+	maxConns        int
+	rejectMsg       []byte
+	gracefulTimeout int64
+	drainTimeout    int64
 
 	// connector options
+	dialAttemptDelay int64
+	connectProxy     string
 
 	// acceptor and connector options
 	sockRcvBufSize int // ignore equal 0
 
 	// reactor options
 	evPollNum           int //
+	pollMode            PollMode
+	evReadyNum          int
 	evFdMaxSize         int
+	evFdGrowLimit       int
 	evPollLockOSThread  bool
+	evPollCPUs          []int
+	evPollBusyPoll      []int
+	evPollRestarts      int
+	evPollRestartDelay  int64
 	evPollReadBuffSize  int
 	evPollWriteBuffSize int
+	evPollTimeout       int
+	evPollTimeoutHook   func(millisecond int64)
+	logger              Logger
+	tracer              Tracer
+	panicRecovery       bool
+	overloadPolicy      OverloadPolicy
+	overloadPending     int
+	overloadBudget      int64
+	postQueueCap        int
+	postOverflow        PostOverflowPolicy
 
 	// timer
 	timerHeapInitSize int //
+	timerWheel        bool
 }
 
 // Option function
@@ -34,12 +67,18 @@ func setOptions(optL ...Option) *Options {
 		reuseAddr:           true,
 		reusePort:           false,
 		evPollNum:           1,
+		evReadyNum:          4096,
 		evFdMaxSize:         8192,
 		listenBacklog:       512, // go default 128
 		timerHeapInitSize:   1024,
 		evPollLockOSThread:  false,
 		evPollReadBuffSize:  8192,
 		evPollWriteBuffSize: 16 * 1024,
+		evPollTimeout:       -1,
+		logger:              nopLogger{},
+		panicRecovery:       true,
+		gracefulTimeout:     5000,
+		dialAttemptDelay:    250,
 	}
 
 	for _, opt := range optL {
@@ -55,6 +94,52 @@ func ReuseAddr(v bool) Option {
 	}
 }
 
+// IPv6Only for IPV6_V6ONLY of an IPv6 listener (e.g. [::]:8080), the listener accepts only IPv6 if
+// true, otherwise (default) it is dual-stack and accepts IPv4 as IPv4-mapped IPv6 addresses.
+func IPv6Only(v bool) Option {
+	return func(o *Options) {
+		o.ipv6Only = v
+	}
+}
+
+// MaxConnections caps the number of the open connections accepted by the acceptors of the reactor,
+// the connections accepted beyond it are closed immediately (after sending msg if not nil, best
+// effort) until some of the open ones are closed. 0 (default) means no limit.
+// A connection is counted as long as the handler returned by the new func of the acceptor is
+// registered, not if its OnOpen registers another handler instead.
+func MaxConnections(n int, msg []byte) Option {
+	return func(o *Options) {
+		if n >= 0 {
+			o.maxConns = n
+			o.rejectMsg = msg
+		}
+	}
+}
+
+// GracefulCloseTimeout is the deadline in millisecond of Reactor.CloseGracefully, the connection is
+// closed even if its pending writes have not been flushed by then. It also bounds the Shutdown
+// started by HandleSignals. Default 5000, 0 means no deadline.
+func GracefulCloseTimeout(msec int64) Option {
+	return func(o *Options) {
+		if msec >= 0 {
+			o.gracefulTimeout = msec
+		}
+	}
+}
+
+// CloseDrainTimeout makes the closes of Reactor.CloseGracefully and of OnRead returning false
+// orderly: the writing side is shut down (FIN) once the pending writes are sent, and what the peer
+// still sends is read and discarded until its FIN or for up to msec millisecond, before the fd is
+// removed and OnClose called. Closing a socket with unread data sends RST instead of FIN, which
+// makes some clients lose the response. 0 (default) means closing at once.
+func CloseDrainTimeout(msec int64) Option {
+	return func(o *Options) {
+		if msec >= 0 {
+			o.drainTimeout = msec
+		}
+	}
+}
+
 // ReusePort for SO_REUSEPORT
 //
 // Requires kernel >= 3.9
@@ -67,9 +152,73 @@ func ReusePort(v bool) Option {
 }
 
 // ListenBacklog For syscall.listen(fd, backlog), also affect `for i < backlog/2 { syscall.accept() }`
+// Default 512, a too small backlog drops the connections of a burst. The kernel truncates it to
+// net.core.somaxconn, the acceptor warns in that case.
 func ListenBacklog(v int) Option {
 	return func(o *Options) {
-		o.listenBacklog = v
+		if v > 0 {
+			o.listenBacklog = v
+		}
+	}
+}
+
+// AcceptExclusive registers the listener with EvAcceptExclusive to avoid thundering-herd wakeups.
+//
+// Falls back to EvAccept if the kernel does not support EPOLLEXCLUSIVE (kernel < 4.5)
+func AcceptExclusive(v bool) Option {
+	return func(o *Options) {
+		o.acceptExclusive = v
+	}
+}
+
+// AcceptEdgeTriggered registers the listener in EPOLLET mode, each OnRead accepts until EAGAIN
+// (ListenBacklog does not limit it), so that no connection of the backlog is left behind.
+func AcceptEdgeTriggered(v bool) Option {
+	return func(o *Options) {
+		o.acceptET = v
+	}
+}
+
+// AcceptSockOpts is applied to every accepted fd before OnOpen, e.g. a set of netfd.SetNoDelay,
+// netfd.SetLinger, netfd.SetRecvBuffSize... The connection is closed if it returns an error.
+func AcceptSockOpts(f func(fd int) error) Option {
+	return func(o *Options) {
+		o.acceptSockOpts = f
+	}
+}
+
+// AcceptRate limits the connections accepted by each listener to perSecond, with bursts of up to
+// burst (perSecond if < 1), by a token bucket. Once the tokens run out, the listener is removed from
+// evpoll until the next token, so that a flood of connections waits in the listen backlog (and is
+// refused by the kernel beyond it) instead of costing the CPU to accept and close.
+// 0 (default) means no limit.
+func AcceptRate(perSecond, burst int) Option {
+	return func(o *Options) {
+		if perSecond >= 0 {
+			o.acceptRate = perSecond
+			o.acceptBurst = burst
+			if burst < 1 {
+				o.acceptBurst = perSecond
+			}
+		}
+	}
+}
+
+// AcceptWithTimeout makes the acceptor stop once it has accepted n connections (n < 1 means no
+// limit), or timeout (millisecond, 0 means no timeout) after it started listening, whichever comes
+// first, e.g. for a test harness or a short-lived daemon waiting for a single peer. The listener
+// is removed from evpoll and closed (the connections accepted are not affected), then onStop (may
+// be nil) is called with the number of connections accepted within the evpoll coroutine of the
+// acceptor, so accepted < n means the timeout.
+//
+// The timeout is a timer of the evpoll of the acceptor.
+func AcceptWithTimeout(n int, timeout int64, onStop func(accepted int)) Option {
+	return func(o *Options) {
+		if n >= 0 && timeout >= 0 {
+			o.stopAfter = n
+			o.stopTimeout = timeout
+			o.onStop = onStop
+		}
 	}
 }
 
@@ -80,6 +229,24 @@ func SockRcvBufSize(n int) Option {
 	}
 }
 
+// DialAttemptDelay is the delay in millisecond between the connection attempts of Dialer
+// (the Connection Attempt Delay of RFC 8305), default 250
+func DialAttemptDelay(msec int64) Option {
+	return func(o *Options) {
+		if msec > 0 {
+			o.dialAttemptDelay = msec
+		}
+	}
+}
+
+// ConnectProxy tunnels the connections of Connector through a proxy, proxyURL is
+// socks5://[user:pass@]ip:port or http://[user:pass@]ip:port (HTTP CONNECT)
+func ConnectProxy(proxyURL string) Option {
+	return func(o *Options) {
+		o.connectProxy = proxyURL
+	}
+}
+
 // EvFdMaxSize for ArrayMapUnion数据结构中array的容量, 性能不会线性增长,
 // 主要根据自己的服务中fd并发数量(fd=0~n的范围)来定
 // fd数量超过此值并不会拒绝服务, 只是存储结构切换到map
@@ -91,6 +258,17 @@ func EvFdMaxSize(n int) Option {
 	}
 }
 
+// EvFdGrowLimit allows the array of EvFdMaxSize to grow up to n when the map holds too many fds
+// (1/8 of the array), 0 (default) means the array is fixed.
+// The fds already in the map stay there until they are closed.
+func EvFdGrowLimit(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.evFdGrowLimit = n
+		}
+	}
+}
+
 // EvPollLockOSThread Whether binds to a fixed thread.
 // please refer to the go doc runtime.LockOSThread (After testing, it is found to
 // decrease performance by approximately 2%)
@@ -102,6 +280,106 @@ func EvPollLockOSThread(v bool) Option {
 	}
 }
 
+// EvPollCPUAffinity binds the thread of evpoll i to cpus[i % len(cpus)] by sched_setaffinity, which
+// reduces the cache-line bouncing between CPUs. It implies EvPollLockOSThread(true).
+// The cpus MUST be in [0, runtime.NumCPU()), otherwise NewReactor fails.
+func EvPollCPUAffinity(cpus []int) Option {
+	return func(o *Options) {
+		o.evPollCPUs = cpus
+		if len(cpus) > 0 {
+			o.evPollLockOSThread = true
+		}
+	}
+}
+
+// EvPollBusyPoll makes evpoll i call epoll_wait with 0 timeout up to spins[i % len(spins)] times
+// after handling the events, before falling back to the blocking wait. Under steady load the next
+// events are taken without the wakeup latency of a blocking syscall, at the cost of CPU.
+// 0 (default) means no spinning.
+func EvPollBusyPoll(spins []int) Option {
+	return func(o *Options) {
+		o.evPollBusyPoll = spins
+	}
+}
+
+// PollMode tells how the fds are spread over the evpolls (each with its own epoll instance and
+// coroutine), see EvPollMode
+type PollMode int
+
+const (
+	// PollModeSharded routes each fd to an evpoll (fd % EvPollNum, or the evpoll chosen by
+	// NewReusePortAcceptors, Dialer...), the evpolls wait and dispatch in parallel without sharing
+	// any lock. It is the default, and the way the reactor has always worked.
+	PollModeSharded PollMode = iota
+
+	// PollModeShared registers all the fds with the epoll instance of evpoll 0, which dispatches
+	// every event in a single coroutine: no fd is pinned to another core, and all the handlers can
+	// share state without locks. The other evpolls run only their own timers and posted closures.
+	PollModeShared
+)
+
+func (m PollMode) String() string {
+	switch m {
+	case PollModeSharded:
+		return "sharded"
+	case PollModeShared:
+		return "shared"
+	}
+	return "unknown"
+}
+
+// EvPollMode chooses how the fds are spread over the evpolls, PollModeSharded by default.
+//
+// The default is sharded, not shared: the reactor has always given each evpoll its own epoll fd
+// (there is no single epoll fd guarded by a mutex to stay compatible with), so sharded is the
+// existing behaviour. Shared is not several coroutines waiting on one epoll fd either, that would
+// run the callbacks and timers of a handler in different coroutines; evpoll 0 dispatches all the
+// events instead.
+func EvPollMode(m PollMode) Option {
+	return func(o *Options) {
+		o.pollMode = m
+	}
+}
+
+// EvPollOverload makes evpoll consult p before dispatching each event once it falls behind, i.e.
+// more than maxPending events of the epoll_wait batch are not dispatched yet, or the batch has taken
+// more than budget millisecond. p decides to process, defer or drop the event, see OverloadPolicy.
+// 0 disables either threshold. No policy (default) means all the events are processed.
+func EvPollOverload(p OverloadPolicy, maxPending int, budget int64) Option {
+	return func(o *Options) {
+		o.overloadPolicy = p
+		o.overloadPending = maxPending
+		o.overloadBudget = budget
+	}
+}
+
+// EvPollPostQueue bounds the closures of Reactor.Post waiting for each evpoll to capacity, so that a
+// runaway producer can not exhaust the memory. Once it is full, policy decides to block the
+// submitter until evpoll takes the queue, to drop the oldest closure waiting (it never runs), or to
+// return ErrPostQueueFull, see PostOverflowPolicy. The async writes and the closures queued by goev
+// itself are not bounded. 0 (default) means unbounded.
+func EvPollPostQueue(capacity int, policy PostOverflowPolicy) Option {
+	return func(o *Options) {
+		if capacity >= 0 {
+			o.postQueueCap = capacity
+			o.postOverflow = policy
+		}
+	}
+}
+
+// EvPollRestart restarts an evpoll whose epoll_wait failed (e.g. a transient kernel error), so that
+// the reactor does not silently lose an evpoll. The reactor restarts up to retries times in total,
+// the first restart after delay millisecond, doubled for each next one. The registered fds are kept.
+// 0 (default) means a failed evpoll is not restarted, Run returns its error once the others exit.
+func EvPollRestart(retries int, delay int64) Option {
+	return func(o *Options) {
+		if retries >= 0 && delay >= 0 {
+			o.evPollRestarts = retries
+			o.evPollRestartDelay = delay
+		}
+	}
+}
+
 // EvPollNum is the number of evPoll instances, with each evPoll instance running in an independent thread.
 // It is recommended to use CPUx2-1 (taking into account other goroutines' CPU usage) for network
 // programs that are I/O intensive and involve frequent CPU switching.
@@ -121,14 +399,17 @@ func EvPollNum(n int) Option {
 // batch processing capability. However, if the quantity is too large,
 // it can easily impact the processing of new events.
 //
+// The events buffer starts at 256 (or n if less), doubles up to n when an epoll_wait fills it up,
+// and shrinks back after sustained underutilization.
+//
 // EvReadyNum evpoll一次轮询获取数量n的Ready I/O事件, 有利于提高批量处理能力, 太大容易影响新事件的处理
-//func EvReadyNum(n int) Option {
-//	return func(o *Options) {
-//		if n > 0 {
-//			o.evReadyNum = n
-//		}
-//	}
-//}
+func EvReadyNum(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.evReadyNum = n
+		}
+	}
+}
 
 // EvPollReadBuffSize is the global shared memory within a single evpoll,
 // which is friendly to CPU cache and highly efficient when reading data from socket buffers.
@@ -154,6 +435,55 @@ func EvPollWriteBuffSize(n int) Option {
 	}
 }
 
+// EvPollTimeout is the epoll_wait timeout in millisecond when evpoll is idle, so that evpoll
+// wakes up periodically for housekeeping (expired timers and EvPollTimeoutHook) even if there
+// is no I/O event.
+//
+// -1 (default) means blocking indefinitely until an event arrives.
+func EvPollTimeout(msec int) Option {
+	return func(o *Options) {
+		if msec > 0 || msec == -1 {
+			o.evPollTimeout = msec
+		}
+	}
+}
+
+// EvPollTimeoutHook is called within the evpoll coroutine each time epoll_wait times out.
+// It only works with EvPollTimeout.
+//
+// The parameter 'millisecond' is the current unix timestamp.
+func EvPollTimeoutHook(f func(millisecond int64)) Option {
+	return func(o *Options) {
+		o.evPollTimeoutHook = f
+	}
+}
+
+// EvLogger sets the Logger of the internal conditions, the default discards them
+func EvLogger(l Logger) Option {
+	return func(o *Options) {
+		if l != nil {
+			o.logger = l
+		}
+	}
+}
+
+// EvTracer emits the lifecycle events of the connections to t, see Tracer. nil (default) means
+// no tracing.
+func EvTracer(t Tracer) Option {
+	return func(o *Options) {
+		o.tracer = t
+	}
+}
+
+// EvPanicRecovery recovers the panics of EvHandler callbacks (OnOpen in acceptor, OnRead, OnWrite,
+// OnTimeout, OnClose...), the panic is logged by EvLogger and the handler is closed, the evpoll keeps
+// serving the other fds. Enabled by default, disable it to get the original stack while debugging.
+func EvPanicRecovery(v bool) Option {
+	return func(o *Options) {
+		o.panicRecovery = v
+	}
+}
+
 // TimerHeapInitSize is the initial array size of the heap structure used to implement timers
 func TimerHeapInitSize(n int) Option {
 	return func(o *Options) {
@@ -162,3 +492,15 @@ func TimerHeapInitSize(n int) Option {
 		}
 	}
 }
+
+// TimerWheel uses a hierarchical timing wheel instead of the 4-heap timer (driven by timerfd).
+// The wheel is driven by the epoll_wait timeout, insert and cancel are O(1), which fits a large
+// number of timers that are mostly canceled before expiring (e.g. idle timeouts).
+//
+// NOTE: The wheel does not wake up a blocking evpoll, so timers MUST be scheduled within the evpoll
+// coroutine (or before Reactor.Run).
+func TimerWheel(v bool) Option {
+	return func(o *Options) {
+		o.timerWheel = v
+	}
+}
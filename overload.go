@@ -0,0 +1,66 @@
+package goev
+
+import "time"
+
+// OverloadAction is the decision of an OverloadPolicy for an event
+type OverloadAction int
+
+const (
+	// OverloadProcess dispatches the event as usual
+	OverloadProcess OverloadAction = iota
+
+	// OverloadDefer skips the event in this batch, a level-triggered fd is reported again by the
+	// next epoll_wait. It is ignored (processed) for EPOLLET/EPOLLONESHOT fds, whose event would
+	// be lost.
+	OverloadDefer
+
+	// OverloadDrop closes the fd, it is removed from the reactor and OnClose is called
+	OverloadDrop
+)
+
+// OverloadInfo describes the batch of events an evpoll is behind with
+type OverloadInfo struct {
+	Batch   int   // events returned by the epoll_wait
+	Pending int   // events of the batch not dispatched yet, including the current one
+	Elapsed int64 // millisecond, time spent on the batch so far
+}
+
+// OverloadPolicy decides which events an evpoll sheds when it falls behind, see EvPollOverload.
+// E.g. defer the acceptor so that the connections being served are served first, or drop the
+// connections which have been idle for long.
+type OverloadPolicy interface {
+	// OnOverload is called within the evpoll coroutine instead of dispatching the event of fd to
+	// eh while the batch is overloaded, the internal handlers of the evpoll are not passed.
+	OnOverload(fd int, eh EvHandler, info OverloadInfo) OverloadAction
+}
+
+// shed consults the OverloadPolicy before the i-th event of a batch of nfds events, which started
+// at begin, is dispatched. Returns true if the event has been shed.
+func (ep *evPoll) shed(ed *evData, nfds, i int, begin time.Time) bool {
+	info := OverloadInfo{Batch: nfds, Pending: nfds - i}
+	if ep.overloadBudget > 0 {
+		info.Elapsed = time.Since(begin).Milliseconds()
+	}
+	if (ep.overloadPending < 1 || info.Pending <= ep.overloadPending) &&
+		(ep.overloadBudget < 1 || info.Elapsed <= ep.overloadBudget) {
+		return false
+	}
+	if ep.internal(ed.eh) {
+		return false
+	}
+	switch ep.overload.OnOverload(ed.fd, ed.eh, info) {
+	case OverloadDefer:
+		if ed.events&(EPOLLET|EPOLLONESHOT) != 0 {
+			return false
+		}
+		ep.stats.overloadDefers.Add(1)
+		return true
+	case OverloadDrop:
+		if ed.fd > 0 { // not closed by the policy
+			ep.closeEvData(ed)
+		}
+		ep.stats.overloadDrops.Add(1)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordPolicy drops the first fd it is asked for, defers the second one and processes the others
+type recordPolicy struct {
+	calls   []OverloadInfo
+	dropped int
+	delayed int
+}
+
+func (p *recordPolicy) OnOverload(fd int, eh EvHandler, info OverloadInfo) OverloadAction {
+	p.calls = append(p.calls, info)
+	switch len(p.calls) {
+	case 1:
+		p.dropped = fd
+		return OverloadDrop
+	case 2:
+		p.delayed = fd
+		return OverloadDefer
+	}
+	return OverloadProcess
+}
+
+func TestEvPollOverload(t *testing.T) {
+	const n, maxPending = 8, 4
+	policy := &recordPolicy{}
+	r := newTestReactor(t, EvPollNum(1), EvPollOverload(policy, maxPending, 0))
+
+	readers := make(map[int]*pipeReader, n)
+	wfds := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		rfd, wfd := newPipe(t)
+		t.Cleanup(func() { syscall.Close(wfd) })
+		h := newPipeReader()
+		if err := r.AddEvHandler(h, rfd, EvIn); err != nil {
+			t.Fatal(err)
+		}
+		readers[rfd] = h
+		wfds = append(wfds, wfd)
+	}
+	// written within the evpoll, so that the next epoll_wait returns all of them in one batch
+	done := make(chan struct{})
+	r.Post(wfds[0], func() {
+		for _, wfd := range wfds {
+			syscall.Write(wfd, []byte{'x'})
+		}
+		close(done)
+	})
+	<-done
+
+	for fd, h := range readers {
+		select {
+		case <-h.readC:
+			if fd == policy.dropped {
+				t.Fatalf("dropped fd %d read", fd)
+			}
+		case <-h.closeC:
+			if fd != policy.dropped {
+				t.Fatalf("fd %d closed, dropped %d", fd, policy.dropped)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("fd %d neither read nor closed", fd)
+		}
+	}
+
+	// consulted for the events beyond maxPending only, the deferred one is not consulted again
+	// as its next batch is not overloaded
+	if len(policy.calls) != n-maxPending {
+		t.Fatalf("policy consulted %d times, want %d", len(policy.calls), n-maxPending)
+	}
+	for i, info := range policy.calls {
+		if info.Batch != n || info.Pending != n-i {
+			t.Fatalf("call %d got %+v", i, info)
+		}
+	}
+	s := r.Stats()[0]
+	if s.OverloadDrops != 1 || s.OverloadDefers != 1 {
+		t.Fatalf("got %d drops, %d defers", s.OverloadDrops, s.OverloadDefers)
+	}
+}
@@ -0,0 +1,115 @@
+package goev
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Pool is a typed free list built on sync.Pool, for the objects a server allocates per connection
+// (handlers, parsers, session state) so that connection churn doesn't turn into GC work.
+// The package pools the buffers of ReadBuffer and Writer with it.
+//
+// The reset contract: Put calls reset before the object goes back to the pool, reset MUST clear
+// everything left by the previous connection (IOHandle.Init for handlers, buffered data, references
+// to other objects), so that Get returns an object equivalent to a new one. The object MUST NOT be
+// used after Put, e.g. a handler is put at the end of its OnClose.
+//
+// For example:
+//
+//	var connPool = goev.NewPool(func() *Conn {
+//	    return &Conn{rb: goev.NewReadBuffer(4096, 0)}
+//	}, func(c *Conn) {
+//	    c.rb.Release()
+//	    c.session = nil
+//	    c.Init()
+//	})
+//
+//	goev.NewAcceptor(r, func() goev.EvHandler { return connPool.Get() }, ":8080")
+//
+//	func (c *Conn) OnClose() {
+//	    syscall.Close(c.Fd())
+//	    connPool.Put(c)
+//	}
+//
+// T should be a pointer type, converting other types to any allocates on Put.
+// Pool is safe for concurrent use, the pooled objects may be dropped at any GC.
+type Pool[T any] struct {
+	p     sync.Pool
+	reset func(T)
+}
+
+// NewPool return an instance, newFn creates an object when the pool is empty, reset may be nil
+// if the objects hold nothing to clear
+func NewPool[T any](newFn func() T, reset func(T)) *Pool[T] {
+	p := &Pool[T]{reset: reset}
+	p.p.New = func() any { return newFn() }
+	return p
+}
+
+// Get returns a pooled object or a new one
+func (p *Pool[T]) Get() T {
+	return p.p.Get().(T)
+}
+
+// Put resets v and returns it to the pool
+func (p *Pool[T]) Put(v T) {
+	if p.reset != nil {
+		p.reset(v)
+	}
+	p.p.Put(v)
+}
+
+// buffers are pooled by size class, 1KB << i
+const (
+	bytesPoolMinBits = 10
+	bytesPoolClasses = 8 // up to 128KB
+)
+
+var bytesPools = func() (pools [bytesPoolClasses]*Pool[*[]byte]) {
+	for i := range pools {
+		size := 1 << (bytesPoolMinBits + i)
+		pools[i] = NewPool(func() *[]byte {
+			b := make([]byte, size)
+			return &b
+		}, func(bp *[]byte) {
+			b := *bp
+			for i := range b { // no data of a connection leaks into the next one
+				b[i] = 0
+			}
+		})
+	}
+	return
+}()
+
+// bytesClass returns the size class of size, -1 if beyond the largest
+func bytesClass(size int) int {
+	if size <= 1<<bytesPoolMinBits {
+		return 0
+	}
+	c := bits.Len(uint(size-1)) - bytesPoolMinBits
+	if c >= bytesPoolClasses {
+		return -1
+	}
+	return c
+}
+
+// getBytes returns a zeroed buffer of at least size bytes, its length is the class size
+func getBytes(size int) *[]byte {
+	c := bytesClass(size)
+	if c < 0 {
+		b := make([]byte, size)
+		return &b
+	}
+	return bytesPools[c].Get()
+}
+
+// putBytes zeroes b and returns it to the pool through bp, b is dropped if its capacity is not a
+// class size (e.g. grown by append). Neither may be used after.
+func putBytes(bp *[]byte, b []byte) {
+	c := bytesClass(cap(b))
+	if bp == nil || c < 0 || cap(b) != 1<<(bytesPoolMinBits+c) {
+		return
+	}
+	*bp = b[:cap(b)]
+	bytesPools[c].Put(bp)
+}
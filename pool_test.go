@@ -0,0 +1,145 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	type state struct {
+		id   int
+		data []byte
+	}
+	resets := 0
+	p := NewPool(func() *state { return &state{} }, func(s *state) {
+		resets++
+		s.id, s.data = 0, nil
+	})
+	s := p.Get()
+	s.id, s.data = 1, []byte("secret")
+	p.Put(s)
+	if resets != 1 || s.id != 0 || s.data != nil {
+		t.Fatal("Put did not reset the object")
+	}
+	if s = p.Get(); s.id != 0 || s.data != nil {
+		t.Fatal("Get returned a dirty object")
+	}
+
+	// pooled buffers are zeroed
+	for _, size := range []int{1, 1024, 1025, 4096, 128 * 1024, 128*1024 + 1} {
+		bp := getBytes(size)
+		if len(*bp) < size {
+			t.Fatalf("getBytes(%d) returned %d bytes", size, len(*bp))
+		}
+		b := *bp
+		for i := range b {
+			b[i] = 'x'
+		}
+		putBytes(bp, b)
+		for i := 0; i < 4; i++ {
+			bp = getBytes(size)
+			for _, c := range *bp {
+				if c != 0 {
+					t.Fatalf("buffer of size %d not zeroed", size)
+				}
+			}
+		}
+	}
+
+	// released ReadBuffer takes a buffer again
+	a, b := newSocketPair(t)
+	rb := NewReadBuffer(16, 0)
+	syscall.Write(b, []byte("hello"))
+	rb.ReadFromFd(a)
+	rb.Release()
+	if rb.Len() != 0 {
+		t.Fatal("data kept after Release")
+	}
+	syscall.Write(b, []byte("world"))
+	if _, err := rb.ReadFromFd(a); err != nil || string(rb.Bytes()) != "world" {
+		t.Fatalf("got %q, %v after Release", rb.Bytes(), err)
+	}
+}
+
+// churnConn echoes a message then the client closes, the state is pooled or allocated per connection
+type churnConn struct {
+	IOHandle
+
+	rb   *ReadBuffer
+	w    *Writer
+	pool *Pool[*churnConn]
+}
+
+func (c *churnConn) OnOpen(fd int) bool {
+	return c.GetReactor().AddEvHandler(c, fd, EvIn) == nil
+}
+
+func (c *churnConn) OnRead() bool {
+	_, err := c.rb.ReadFromFd(c.Fd())
+	if c.rb.Len() > 0 {
+		c.w.Write(c.rb.Bytes())
+		c.rb.Discard(c.rb.Len())
+	}
+	return err == nil
+}
+
+func (c *churnConn) OnClose() {
+	syscall.Close(c.Fd())
+	if c.pool != nil {
+		c.pool.Put(c)
+	}
+}
+
+func newChurnConn(pool *Pool[*churnConn]) *churnConn {
+	c := &churnConn{rb: NewReadBuffer(4096, 0), pool: pool}
+	c.w = NewWriter(c)
+	return c
+}
+
+func BenchmarkConnChurn(b *testing.B) {
+	run := func(b *testing.B, newHandler func() EvHandler) {
+		r, err := NewReactor(EvPollNum(1))
+		if err != nil {
+			b.Fatal(err)
+		}
+		go r.Run()
+		defer r.Stop()
+		a, err := NewAcceptor(r, func() EvHandler {
+			h := newHandler()
+			h.setReactor(r)
+			return h
+		}, "127.0.0.1:0")
+		if err != nil {
+			b.Fatal(err)
+		}
+		addr := a.Addr().String()
+		sa, _ := parseInet4Addr(addr)
+		buf := make([]byte, 5)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err = syscall.Connect(fd, sa); err != nil {
+				b.Fatal(err)
+			}
+			syscall.Write(fd, []byte("hello"))
+			syscall.Read(fd, buf)
+			syscall.Close(fd)
+		}
+	}
+	b.Run("alloc", func(b *testing.B) {
+		run(b, func() EvHandler { return newChurnConn(nil) })
+	})
+	b.Run("pool", func(b *testing.B) {
+		var pool *Pool[*churnConn]
+		pool = NewPool(func() *churnConn { return newChurnConn(pool) }, func(c *churnConn) {
+			c.w.Reset()
+			c.rb.Release()
+			c.Init()
+		})
+		run(b, func() EvHandler { return pool.Get() })
+	})
+}
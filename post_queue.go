@@ -0,0 +1,125 @@
+package goev
+
+import (
+	"errors"
+	"sync"
+)
+
+// PostOverflowPolicy tells what Reactor.Post does once the post queue of the evpoll is full,
+// see EvPollPostQueue
+type PostOverflowPolicy int
+
+const (
+	PostBlock      PostOverflowPolicy = iota // wait for room, MUST NOT Post to its own evpoll then
+	PostDropOldest                           // drop the oldest closure waiting, it never runs
+	PostReject                               // return ErrPostQueueFull to the submitter
+)
+
+func (p PostOverflowPolicy) String() string {
+	switch p {
+	case PostBlock:
+		return "block"
+	case PostDropOldest:
+		return "drop-oldest"
+	case PostReject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// ErrPostQueueFull is returned by Reactor.Post when the post queue of the evpoll is full and the
+// policy is PostReject
+var ErrPostQueueFull = errors.New("goev: post queue full")
+
+// postLimit bounds the closures of Reactor.Post waiting in the queue of asyncWrite, the other items
+// (async writes, internal closures) are not counted nor dropped
+type postLimit struct {
+	capacity int // 0 means unbounded
+	policy   PostOverflowPolicy
+	queued   int        // posted closures in writeq, guarded by asyncWrite.mtx
+	roomC    *sync.Cond // PostBlock, signaled when writeq is taken by evpoll
+}
+
+func (aw *asyncWrite) setPostLimit(capacity int, policy PostOverflowPolicy) {
+	aw.limit = postLimit{capacity: capacity, policy: policy}
+	if policy == PostBlock {
+		aw.limit.roomC = sync.NewCond(&aw.mtx)
+	}
+}
+
+// post queues the closure of Reactor.Post within the limit of EvPollPostQueue. At most capacity
+// closures wait to be taken by evpoll, besides the batch being run. It returns ErrReactorClosed
+// once evpoll has been stopped, the closure would never run.
+func (aw *asyncWrite) post(awi asyncWriteItem) error {
+	awi.posted = true
+	aw.mtx.Lock()
+	if aw.evPoll.stopped.Load() {
+		aw.mtx.Unlock()
+		return ErrReactorClosed
+	}
+	if aw.limit.capacity > 0 {
+		if aw.limit.queued >= aw.limit.capacity {
+			aw.evPoll.stats.postOverflows.Add(1)
+			switch aw.limit.policy {
+			case PostReject:
+				aw.mtx.Unlock()
+				return ErrPostQueueFull
+			case PostDropOldest:
+				aw.dropOldestPost()
+			default:
+				for aw.limit.queued >= aw.limit.capacity {
+					aw.limit.roomC.Wait()
+					if aw.evPoll.stopped.Load() { // woken by stoppedPosts
+						aw.mtx.Unlock()
+						return ErrReactorClosed
+					}
+				}
+			}
+		}
+		aw.limit.queued++
+	}
+	aw.writeq.Push(awi)
+	aw.mtx.Unlock()
+	aw.notify()
+	return nil
+}
+
+// dropOldestPost removes the oldest posted closure of writeq, keeping the order of the other items.
+// MUST be called with mtx locked.
+func (aw *asyncWrite) dropOldestPost() {
+	if q := aw.writeq; q.len > 0 && q.buffer[q.head].posted { // the usual case
+		q.Pop()
+		aw.limit.queued--
+		return
+	}
+	dropped := false
+	for i, n := 0, aw.writeq.Len(); i < n; i++ {
+		item, _ := aw.writeq.Pop()
+		if !dropped && item.posted {
+			dropped = true
+			aw.limit.queued--
+			continue
+		}
+		aw.writeq.Push(item)
+	}
+}
+
+// stoppedPosts releases the submitters blocked by PostBlock once evpoll has been stopped, it will
+// not take the queue any more
+func (aw *asyncWrite) stoppedPosts() {
+	if aw.limit.roomC != nil {
+		aw.mtx.Lock()
+		aw.limit.roomC.Broadcast()
+		aw.mtx.Unlock()
+	}
+}
+
+// takenPosts is called with mtx locked once writeq has been swapped to be run
+func (aw *asyncWrite) takenPosts() {
+	if aw.limit.queued > 0 {
+		aw.limit.queued = 0
+		if aw.limit.roomC != nil {
+			aw.limit.roomC.Broadcast()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package goev
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockEvPoll posts a closure which blocks evpoll until the returned func is called
+func blockEvPoll(t *testing.T, r *Reactor) func() {
+	startedC, gateC := make(chan struct{}), make(chan struct{})
+	if err := r.Post(1, func() {
+		close(startedC)
+		<-gateC
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-startedC
+	return func() { close(gateC) }
+}
+
+// waitRun waits until n closures have run
+func waitRun(t *testing.T, ran *atomic.Int64, n int64) {
+	for begin := time.Now(); ran.Load() < n; time.Sleep(time.Millisecond) {
+		if time.Since(begin) > 2*time.Second {
+			t.Fatalf("%d of %d closures run", ran.Load(), n)
+		}
+	}
+}
+
+func TestPostQueueReject(t *testing.T) {
+	const capacity, producers, posts = 8, 16, 10
+	r := newTestReactor(t, EvPollNum(1), EvPollPostQueue(capacity, PostReject))
+	release := blockEvPoll(t, r)
+
+	var ran, accepted, rejected atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				err := r.Post(1, func() { ran.Add(1) })
+				if err == nil {
+					accepted.Add(1)
+				} else if errors.Is(err, ErrPostQueueFull) {
+					rejected.Add(1)
+				} else {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted.Load() != capacity || rejected.Load() != producers*posts-capacity {
+		t.Fatalf("%d posts accepted, %d rejected", accepted.Load(), rejected.Load())
+	}
+	if n := r.Stats()[0].PostOverflows; n != uint64(rejected.Load()) {
+		t.Fatalf("%d overflows counted", n)
+	}
+	release()
+	waitRun(t, &ran, capacity)
+
+	// room again once evpoll has taken the queue
+	if err := r.Post(1, func() { ran.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, &ran, capacity+1)
+}
+
+func TestPostQueueDropOldest(t *testing.T) {
+	const capacity, producers, posts = 8, 16, 10
+	r := newTestReactor(t, EvPollNum(1), EvPollPostQueue(capacity, PostDropOldest))
+
+	// the newest closures are kept, in order
+	release := blockEvPoll(t, r)
+	orderC := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		i := i
+		if err := r.Post(1, func() { orderC <- i }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release()
+	for want := 20 - capacity; want < 20; want++ {
+		select {
+		case i := <-orderC:
+			if i != want {
+				t.Fatalf("closure %d run, want %d", i, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("closure %d not run", want)
+		}
+	}
+
+	// concurrent producers never fail, capacity closures survive
+	release = blockEvPoll(t, r)
+	var ran atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				if err := r.Post(1, func() { ran.Add(1) }); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	release()
+	waitRun(t, &ran, capacity)
+	time.Sleep(20 * time.Millisecond)
+	if ran.Load() != capacity {
+		t.Fatalf("%d closures run, want %d", ran.Load(), capacity)
+	}
+}
+
+func TestPostQueueBlock(t *testing.T) {
+	const capacity, producers, posts = 4, 8, 5
+	r := newTestReactor(t, EvPollNum(1), EvPollPostQueue(capacity, PostBlock))
+	release := blockEvPoll(t, r)
+
+	var ran, returned atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				if err := r.Post(1, func() { ran.Add(1) }); err != nil {
+					t.Error(err)
+				}
+				returned.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := returned.Load(); n != capacity {
+		t.Fatalf("%d posts returned while evpoll is blocked, want %d", n, capacity)
+	}
+	release()
+	wg.Wait()
+	waitRun(t, &ran, producers*posts)
+}
+
+func TestPostQueueBlockStop(t *testing.T) {
+	const capacity = 2
+	r := newTestReactor(t, EvPollNum(1), EvPollPostQueue(capacity, PostBlock))
+	release := blockEvPoll(t, r)
+	defer release()
+	for i := 0; i < capacity; i++ {
+		if err := r.Post(1, func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errC := make(chan error, 1)
+	go func() { errC <- r.Post(1, func() {}) }()
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+	select {
+	case err := <-errC:
+		if err != ErrReactorClosed {
+			t.Fatalf("blocked Post returned %v after Stop", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Post still blocked after Stop")
+	}
+	if err := r.Post(1, func() {}); err != ErrReactorClosed {
+		t.Fatalf("Post returned %v after Stop", err)
+	}
+}
@@ -0,0 +1,313 @@
+package goev
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// proxy handshake states
+const (
+	socks5Method  = iota // waiting for the method selection
+	socks5Auth           // waiting for the username/password status (RFC 1929)
+	socks5Connect        // waiting for the reply of CONNECT
+	httpConnect          // waiting for the response header of CONNECT
+)
+
+// proxyConnect connects to the proxy of c, then asks it to connect to addr
+func (c *Connector) proxyConnect(addr string, eh EvHandler, timeout int64) error {
+	host, portS, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return errors.New("Connector:Connect param:addr invalid")
+	}
+	port, _ := strconv.ParseInt(portS, 10, 64)
+	if port < 1 || port > 65535 {
+		return errors.New("port must in (0, 65536)")
+	}
+	ph := &proxyHandshake{
+		eh:       eh,
+		host:     host,
+		port:     int(port),
+		deadline: time.Now().UnixMilli() + timeout,
+		in:       make([]byte, 0, 1024),
+	}
+	if c.proxy.Scheme == "socks5" {
+		if len(host) > 255 {
+			return errors.New("Connector:Connect socks5 host too long")
+		}
+		ph.state = socks5Method
+		ph.out = []byte{5, 1, 0} // no authentication
+		if c.proxy.User != nil {
+			ph.out = []byte{5, 2, 0, 2} // or username/password
+		}
+	} else {
+		ph.state = httpConnect
+		hostPort := net.JoinHostPort(host, portS)
+		req := "CONNECT " + hostPort + " HTTP/1.1\r\nHost: " + hostPort + "\r\n"
+		if u := c.proxy.User; u != nil {
+			pass, _ := u.Password()
+			req += "Proxy-Authorization: Basic " +
+				base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)) + "\r\n"
+		}
+		ph.out = []byte(req + "\r\n")
+	}
+	if c.proxy.User != nil {
+		ph.user = c.proxy.User.Username()
+		ph.pass, _ = c.proxy.User.Password()
+	}
+	return c.tcpConnect(c.proxy.Host, ph, timeout)
+}
+
+// proxyHandshake performs the handshake with the proxy across OnRead/OnWrite once the connection
+// to it is open, then hands the fd over to eh. The reply is read exactly, so the data the target
+// sends first stays in the socket for eh.
+type proxyHandshake struct {
+	IOHandle
+
+	eh         EvHandler
+	host       string
+	port       int
+	user, pass string
+	deadline   int64 // millisecond, the timeout of Connect covers the handshake as well
+
+	state    int
+	out      []byte // request not written yet
+	in       []byte // reply read so far
+	err      error  // reported by OnClose
+	notified bool   // eh.OnOpen or eh.OnConnectFail has been called
+}
+
+// OnOpen the connection to the proxy is open
+func (ph *proxyHandshake) OnOpen(fd int) bool {
+	if err := ph.GetReactor().AddEvHandler(ph, fd, EvIn|EvOut); err != nil {
+		return false
+	}
+	timeout := ph.deadline - ph.getEvPoll().nowMilli()
+	if timeout < 1 {
+		timeout = 1
+	}
+	ph.ScheduleTimer(ph, timeout, 0) // canceled once the handshake is done
+	return true
+}
+
+// OnConnectFail the connection to the proxy failed
+func (ph *proxyHandshake) OnConnectFail(err error) {
+	ph.notified = true
+	ph.eh.OnConnectFail(err)
+}
+
+// OnWrite writes the pending request, EPOLLOUT is removed once it's done
+func (ph *proxyHandshake) OnWrite() bool {
+	for len(ph.out) > 0 {
+		n, err := syscall.Write(ph.Fd(), ph.out)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return true
+		}
+		if err != nil {
+			ph.err = connectError(err)
+			return false
+		}
+		ph.out = ph.out[n:]
+	}
+	return ph.getEvPoll().subtract(ph.Fd(), syscall.EPOLLOUT) == nil
+}
+
+// OnRead reads the reply of the current state
+func (ph *proxyHandshake) OnRead() bool {
+	switch ph.state {
+	case httpConnect:
+		return ph.readHTTP()
+	case socks5Connect:
+		return ph.readSocks5Reply()
+	}
+	// VER METHOD or VER STATUS
+	if ok, err := ph.fill(2); !ok {
+		ph.err = err
+		return err == nil
+	}
+	if ph.state == socks5Auth {
+		return ph.in[1] == 0 && ph.socks5Request()
+	}
+	switch {
+	case ph.in[0] != 5:
+		return false
+	case ph.in[1] == 0:
+		return ph.socks5Request()
+	case ph.in[1] == 2 && ph.user != "":
+		ph.state = socks5Auth
+		req := append([]byte{1, byte(len(ph.user))}, ph.user...)
+		req = append(append(req, byte(len(ph.pass))), ph.pass...)
+		return ph.request(req)
+	}
+	return false
+}
+
+// readSocks5Reply reads VER REP RSV ATYP BND.ADDR BND.PORT, the length depends on ATYP and the
+// first byte of BND.ADDR
+func (ph *proxyHandshake) readSocks5Reply() bool {
+	ok, err := ph.fill(5)
+	if ok {
+		switch ph.in[3] {
+		case 1:
+			ok, err = ph.fill(4 + 4 + 2)
+		case 4:
+			ok, err = ph.fill(4 + 16 + 2)
+		case 3:
+			ok, err = ph.fill(4 + 1 + int(ph.in[4]) + 2)
+		default:
+			return false
+		}
+	}
+	if !ok {
+		ph.err = err
+		return err == nil
+	}
+	if ph.in[0] != 5 {
+		return false
+	}
+	switch ph.in[1] {
+	case 0:
+		return ph.done()
+	case 3, 4: // network/host unreachable
+		ph.err = ErrConnectUnreachable
+	case 5:
+		ph.err = ErrConnectRefused
+	case 6:
+		ph.err = ErrConnectTimeout
+	}
+	return false
+}
+
+// socks5Request sends CONNECT
+func (ph *proxyHandshake) socks5Request() bool {
+	req := []byte{5, 1, 0}
+	if ip, err := netip.ParseAddr(ph.host); err == nil && ip.Is4() {
+		a := ip.As4()
+		req = append(append(req, 1), a[:]...)
+	} else if err == nil {
+		a := ip.As16()
+		req = append(append(req, 4), a[:]...)
+	} else {
+		req = append(append(req, 3, byte(len(ph.host))), ph.host...)
+	}
+	ph.state = socks5Connect
+	return ph.request(append(req, byte(ph.port>>8), byte(ph.port)))
+}
+
+// request sends req of the next state
+func (ph *proxyHandshake) request(req []byte) bool {
+	ph.in = ph.in[:0]
+	ph.out = req
+	return ph.getEvPoll().append(ph.Fd(), syscall.EPOLLOUT) == nil
+}
+
+// fill reads until len(ph.in) is n, it returns false if more is to come
+func (ph *proxyHandshake) fill(n int) (bool, error) {
+	for len(ph.in) < n {
+		m, err := syscall.Read(ph.Fd(), ph.in[len(ph.in):n])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return false, nil
+		}
+		if err != nil || m == 0 {
+			return false, ErrConnectProxy
+		}
+		ph.in = ph.in[:len(ph.in)+m]
+	}
+	return true, nil
+}
+
+// readHTTP reads the response header of CONNECT, peeking first to not consume the bytes after it
+func (ph *proxyHandshake) readHTTP() bool {
+	for {
+		buf := ph.in[len(ph.in):cap(ph.in)]
+		if len(buf) == 0 {
+			return false // header too large
+		}
+		n, _, err := syscall.Recvfrom(ph.Fd(), buf, syscall.MSG_PEEK)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return true
+		}
+		if err != nil || n == 0 {
+			return false
+		}
+		from := len(ph.in) - 3
+		if from < 0 {
+			from = 0
+		}
+		if i := bytes.Index(ph.in[from:len(ph.in)+n], []byte("\r\n\r\n")); i >= 0 {
+			n = from + i + 4 - len(ph.in)
+		}
+		if ok, err := ph.fill(len(ph.in) + n); !ok || err != nil {
+			return false // peeked bytes are readable
+		}
+		if !bytes.HasSuffix(ph.in, []byte("\r\n\r\n")) {
+			continue
+		}
+		// HTTP/1.1 200 Connection established
+		if !bytes.HasPrefix(ph.in, []byte("HTTP/1.")) || len(ph.in) < 12 || ph.in[8] != ' ' {
+			return false
+		}
+		if code, _ := strconv.Atoi(string(ph.in[9:12])); code/100 != 2 {
+			return false
+		}
+		return ph.done()
+	}
+}
+
+// done hands the tunnel over to eh
+func (ph *proxyHandshake) done() bool {
+	ph.CancelTimer(ph)
+	ph.notified = true
+
+	r := ph.GetReactor()
+	r.RemoveEvHandler(ph, ph.Fd())
+	fd := ph.Fd()
+	ph.setFd(-1)
+
+	ph.eh.setReactor(r)
+	openConnected(ph.eh, fd)
+	return true
+}
+
+// traceDeferred the connection is traced as the one of eh once the handshake is done
+func (ph *proxyHandshake) traceDeferred() {}
+
+// OnTimeout the handshake did not complete in time
+func (ph *proxyHandshake) OnTimeout(now int64) bool {
+	if ph.Fd() != -1 {
+		ph.GetReactor().RemoveEvHandler(ph, ph.Fd())
+	}
+	ph.err = ErrConnectTimeout
+	ph.OnClose()
+	return false
+}
+
+// OnClose the handshake failed, also reached by OnOpen returning false
+func (ph *proxyHandshake) OnClose() {
+	if ph.notified == false {
+		ph.notified = true
+		ph.CancelTimer(ph)
+		if ph.err == nil {
+			ph.err = ErrConnectProxy
+		}
+		ph.eh.OnConnectFail(ph.err)
+	}
+	if ph.Fd() != -1 {
+		syscall.Close(ph.Fd())
+		ph.setFd(-1)
+	}
+}
@@ -0,0 +1,218 @@
+package goev
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// greetServer sends "welcome" first, then echoes
+func greetServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("welcome"))
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+// pipe copies between the client and the target until one of them closes
+func pipe(client, target net.Conn) {
+	go func() {
+		io.Copy(target, client)
+		target.Close()
+	}()
+	io.Copy(client, target)
+	client.Close()
+}
+
+// socks5Server is a minimal SOCKS5 server (no authentication, CONNECT only), rep != 0 is
+// replied instead of connecting
+func socks5Server(t *testing.T, rep byte) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+					return
+				}
+				if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+					return
+				}
+				conn.Write([]byte{5, 0})
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(conn, buf[:n])
+					host = string(buf[:n])
+				default:
+					return
+				}
+				io.ReadFull(conn, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				if rep != 0 {
+					conn.Write([]byte{5, rep, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// httpProxyServer is a minimal HTTP CONNECT proxy
+func httpProxyServer(t *testing.T) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				line, err := br.ReadString('\n')
+				if err != nil || !strings.HasPrefix(line, "CONNECT ") {
+					return
+				}
+				for {
+					if l, err := br.ReadString('\n'); err != nil || l == "\r\n" {
+						break
+					}
+				}
+				target, err := net.Dial("tcp", strings.Fields(line)[1])
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// proxyEcho connects to addr through proxyURL and checks the greeting and the echo
+func proxyEcho(t *testing.T, r *Reactor, proxyURL, addr string) {
+	c, err := NewConnector(r, ConnectProxy(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newConnectResult()
+	if err = c.Connect(addr, h, 2000); err != nil {
+		t.Fatal(err)
+	}
+	var fd int
+	select {
+	case fd = <-h.openC:
+	case err := <-h.failC:
+		t.Fatalf("connect through %s: %v", proxyURL, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("connect result not notified")
+	}
+	defer syscall.Close(fd)
+	syscall.SetNonblock(fd, false)
+	syscall.Write(fd, []byte("hello"))
+	buf := make([]byte, 12)
+	for n := 0; n < len(buf); {
+		m, err := syscall.Read(fd, buf[n:])
+		if err != nil || m == 0 {
+			t.Fatalf("read through %s: %v", proxyURL, err)
+		}
+		n += m
+	}
+	if string(buf) != "welcomehello" {
+		t.Fatalf("got %q through %s", buf, proxyURL)
+	}
+}
+
+func TestConnectorProxy(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	target := greetServer(t)
+	port := strconv.Itoa(target.Addr().(*net.TCPAddr).Port)
+
+	socks5 := "socks5://" + socks5Server(t, 0)
+	proxyEcho(t, r, socks5, target.Addr().String())
+	proxyEcho(t, r, socks5, "localhost:"+port) // resolved by the proxy
+	proxyEcho(t, r, "http://"+httpProxyServer(t), "localhost:"+port)
+
+	// the proxy replies "connection refused"
+	c, err := NewConnector(r, ConnectProxy("socks5://"+socks5Server(t, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newConnectResult()
+	if err = c.Connect(target.Addr().String(), h, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != ErrConnectRefused {
+		t.Fatalf("got %v, want ErrConnectRefused", err)
+	}
+
+	// not a proxy
+	c, err = NewConnector(r, ConnectProxy("socks5://"+target.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = newConnectResult()
+	if err = c.Connect(target.Addr().String(), h, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.wait(t); err != ErrConnectProxy {
+		t.Fatalf("got %v, want ErrConnectProxy", err)
+	}
+
+	if _, err = NewConnector(r, ConnectProxy("ftp://127.0.0.1:21")); err == nil {
+		t.Fatal("unsupported proxy scheme accepted")
+	}
+}
@@ -3,22 +3,50 @@ package goev
 // Autor cuisw. 2023.07
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/netip"
+	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
+// ErrReactorClosed is returned by Run when the reactor has been stopped by Stop or Shutdown, and by
+// Post afterwards
+var ErrReactorClosed = errors.New("goev: reactor closed")
+
 // Reactor provides an I/O event-driven event handling model, where multiple epoll processes
 // can be specified internally. The file descriptors (fd) between multiple Reactors can be
 // bound to each other, enabling concurrent processing in multiple threads.
+//
+// Each evpoll waits on its own epoll fd and owns the fds registered with it (fd % evPollNum by
+// default), so the evpolls don't share any lock in the event loop, see EvPollMode.
 type Reactor struct {
 	noCopy
 
 	evPollLockOSThread bool
+	evPollCPUs         []int // the CPU of evpoll i is evPollCPUs[i % len], nil if not bound
 	evPollNum          int
 	evPolls            []evPoll
+	pollMode           PollMode
+
+	restartBudget atomic.Int64 // restarts left, see EvPollRestart
+	restartDelay  int64
+
+	conns     atomic.Int64 // open connections accepted by the acceptors
+	maxConns  int64
+	rejectMsg []byte
+
+	gracefulTimeout int64 // millisecond, see CloseGracefully
+
+	sigMtx sync.Mutex
+	sigC   chan os.Signal // see HandleSignals
 }
 
 // NewReactor return an instance
@@ -27,18 +55,59 @@ func NewReactor(opts ...Option) (*Reactor, error) {
 	if evOptions.evPollNum < 1 {
 		panic("options: EvPollThreadNum MUST > 0")
 	}
+	for _, cpu := range evOptions.evPollCPUs {
+		if cpu < 0 || cpu >= runtime.NumCPU() {
+			return nil, fmt.Errorf("options: EvPollCPUAffinity cpu %d not in [0, %d)", cpu, runtime.NumCPU())
+		}
+	}
 	r := &Reactor{
 		evPollLockOSThread: evOptions.evPollLockOSThread,
+		evPollCPUs:         evOptions.evPollCPUs,
 		evPollNum:          evOptions.evPollNum,
 		evPolls:            make([]evPoll, evOptions.evPollNum),
+		pollMode:           evOptions.pollMode,
+		maxConns:           int64(evOptions.maxConns),
+		rejectMsg:          evOptions.rejectMsg,
+		gracefulTimeout:    evOptions.gracefulTimeout,
+		restartDelay:       evOptions.evPollRestartDelay,
 	}
+	r.restartBudget.Store(int64(evOptions.evPollRestarts))
 	for i := 0; i < r.evPollNum; i++ {
-		timer := newTimer4Heap(evOptions.timerHeapInitSize)
-		if err := r.evPolls[i].open(evOptions.evFdMaxSize, timer,
-			evOptions.evPollReadBuffSize, evOptions.evPollWriteBuffSize); err != nil {
+		var t timer
+		var th *timer4Heap
+		if evOptions.timerWheel == true {
+			t = newTimerWheel()
+		} else {
+			th = newTimer4Heap(evOptions.timerHeapInitSize)
+			t = th
+		}
+		r.evPolls[i].logger = evOptions.logger
+		r.evPolls[i].tracer = evOptions.tracer
+		r.evPolls[i].panicRecovery = evOptions.panicRecovery
+		r.evPolls[i].gracefulTimeout = evOptions.gracefulTimeout
+		r.evPolls[i].drainTimeout = evOptions.drainTimeout
+		if evOptions.overloadPolicy != nil && (evOptions.overloadPending > 0 || evOptions.overloadBudget > 0) {
+			r.evPolls[i].overload = evOptions.overloadPolicy
+			r.evPolls[i].overloadPending = evOptions.overloadPending
+			r.evPolls[i].overloadBudget = evOptions.overloadBudget
+		}
+		r.evPolls[i].readyNum = evOptions.evReadyNum
+		if n := len(evOptions.evPollBusyPoll); n > 0 {
+			r.evPolls[i].busyPollSpins = evOptions.evPollBusyPoll[i%n]
+		}
+		if err := r.evPolls[i].open(evOptions.evFdMaxSize, evOptions.evFdGrowLimit, t,
+			evOptions.evPollReadBuffSize, evOptions.evPollWriteBuffSize,
+			evOptions.evPollTimeout, evOptions.evPollTimeoutHook); err != nil {
+			if th != nil && r.evPolls[i].timer == nil { // epoll_create1 failed
+				syscall.Close(th.timerfd())
+			}
+			r.release()
 			return nil, err
 		}
-		r.evPolls[i].add(timer.timerfd(), EvIn, timer)
+		if th != nil {
+			r.evPolls[i].add(th.timerfd(), EvIn, th)
+		}
+		r.evPolls[i].asyncWrite.setPostLimit(evOptions.postQueueCap, evOptions.postOverflow)
 
 	}
 	return r, nil
@@ -47,16 +116,79 @@ func NewReactor(opts ...Option) (*Reactor, error) {
 // AddEvHandler can register a file descriptor (fd) and its corresponding handler object into the Reactor.
 // If multiple evPool instances are specified internally, the fd will be rotated to the designated
 // evPool instance based on fd % idx.
+// The events are passed to epoll_ctl as is, so flags like EvPri or EPOLLWAKEUP can be added.
 func (r *Reactor) AddEvHandler(eh EvHandler, fd int, events uint32) error {
 	if fd < 1 || eh == nil { // NOTE fd must > 0
 		return errors.New("AddEvHandler: invalid params")
 	}
-	i := 0
-	if r.evPollNum > 1 {
-		// fd is a self-incrementing and cyclic integer, can be allocated through round-robin distribution.
-		i = fd % r.evPollNum
+	return r.evPolls[r.evPollIndex(fd)].add(fd, events, eh)
+}
+
+// release closes the fds of the evpolls of a reactor which has not run, on the error paths of
+// NewReactor and NewReactorGroup
+func (r *Reactor) release() {
+	for i := range r.evPolls {
+		r.evPolls[i].release()
 	}
-	return r.evPolls[i].add(fd, events, eh)
+}
+
+// evPollIndex returns the evpoll which fd is routed to, see EvPollMode
+func (r *Reactor) evPollIndex(fd int) int {
+	if r.evPollNum == 1 {
+		return 0
+	}
+	// fd is a self-incrementing and cyclic integer, can be allocated through round-robin distribution.
+	return r.route(fd % r.evPollNum)
+}
+
+// route returns the evpoll of index idx chosen for an fd, evpoll 0 in PollModeShared
+func (r *Reactor) route(idx int) int {
+	if r.pollMode == PollModeShared {
+		return 0
+	}
+	return idx
+}
+
+// addEvHandlerTo registers fd to the specified evpoll instead of fd % evPollNum
+func (r *Reactor) addEvHandlerTo(idx int, eh EvHandler, fd int, events uint32) error {
+	if idx < 0 {
+		return r.AddEvHandler(eh, fd, events)
+	}
+	if fd < 1 || eh == nil || idx >= r.evPollNum {
+		return errors.New("AddEvHandler: invalid params")
+	}
+	return r.evPolls[r.route(idx)].add(fd, events, eh)
+}
+
+// AddFd registers an fd the application already owns (e.g. a pipe, an inotify fd or a socket of
+// another library) with h, like AddEvHandler after validating fd and events. The reactor of h is
+// set, h.OnClose is called when the evpoll closes it (e.g. OnRead returns false), where the fd
+// should be closed as usual.
+func (r *Reactor) AddFd(fd int, events uint32, h EvHandler) error {
+	if h == nil {
+		return errors.New("AddFd: EvHandler is nil")
+	}
+	if fd < 1 {
+		return errors.New("AddFd: invalid fd")
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil {
+		return errors.New("AddFd: fcntl: " + err.Error())
+	}
+	if events == 0 { // the other flags (EPOLLPRI, EPOLLWAKEUP...) are passed through to epoll_ctl
+		return errors.New("AddFd: invalid events")
+	}
+	h.setReactor(r)
+	return r.AddEvHandler(h, fd, events)
+}
+
+// RemoveFd unregisters fd added by AddFd (or AddEvHandler), the fd is not closed and OnClose is
+// not called, the application keeps owning it.
+func (r *Reactor) RemoveFd(fd int) error {
+	eh := r.GetHandler(fd)
+	if eh == nil {
+		return errors.New("RemoveFd: fd not registered")
+	}
+	return r.RemoveEvHandler(eh, fd)
 }
 
 // RemoveEvHandler removes the handler object from the Reactor.
@@ -70,30 +202,415 @@ func (r *Reactor) RemoveEvHandler(eh EvHandler, fd int) error {
 	return errors.New("ev handler not add")
 }
 
+// GetHandler returns the EvHandler registered with fd, nil if none. A connection is registered by
+// AddEvHandler (usually in OnOpen) and unregistered before OnClose is called.
+// It can be called from any goroutine, use Post to operate the handler (e.g. to close it) within
+// its evpoll coroutine.
+func (r *Reactor) GetHandler(fd int) EvHandler {
+	if fd < 1 {
+		return nil
+	}
+	i := r.evPollIndex(fd)
+	if eh := r.evPolls[i].evHandlerMap.handler(fd); eh != nil {
+		return eh
+	}
+	for j := 0; j < r.evPollNum; j++ { // registered to another evpoll, see addEvHandlerTo
+		if j == i {
+			continue
+		}
+		if eh := r.evPolls[j].evHandlerMap.handler(fd); eh != nil {
+			return eh
+		}
+	}
+	return nil
+}
+
+// BytesRead returns the number of bytes read from the connection fd (see IOHandle.BytesRead), 0 if
+// fd is not registered. It can be called from any goroutine, e.g. for per-connection quotas.
+func (r *Reactor) BytesRead(fd int) uint64 {
+	if eh := r.GetHandler(fd); eh != nil {
+		return eh.BytesRead()
+	}
+	return 0
+}
+
+// BytesWritten returns the number of bytes written to the connection fd (see
+// IOHandle.BytesWritten), 0 if fd is not registered. It can be called from any goroutine.
+func (r *Reactor) BytesWritten(fd int) uint64 {
+	if eh := r.GetHandler(fd); eh != nil {
+		return eh.BytesWritten()
+	}
+	return 0
+}
+
+// PeerAddr returns the remote address of the connected socket fd (TCP over IPv4 or IPv6), e.g.
+// within OnOpen for logging or access control. An IPv4-mapped IPv6 address is unmapped.
+// It can be called from any goroutine.
+func (r *Reactor) PeerAddr(fd int) (netip.AddrPort, error) {
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		return netip.AddrPort{}, errors.New("getpeername: " + err.Error())
+	}
+	switch v := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(v.Addr), uint16(v.Port)), nil
+	case *syscall.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(v.Addr).Unmap(), uint16(v.Port)), nil
+	}
+	return netip.AddrPort{}, errors.New("PeerAddr: not an inet socket")
+}
+
+// Now returns the time in millisecond cached by the evpolls when epoll_wait returned (the latest
+// of them), the same value passed to OnTimeout. The timers and deadlines are based on it, so
+// clock_gettime is called once per epoll_wait instead of once per timer operation.
+//
+// The precision tradeoff: within a batch of events the time does not advance, it lags behind the
+// wall clock by the time spent handling the batch so far. The current time is returned while all
+// evpolls are blocking. It can be called from any goroutine.
+func (r *Reactor) Now() int64 {
+	var now int64
+	for i := 0; i < r.evPollNum; i++ {
+		if v := r.evPolls[i].now.Load(); v > now {
+			now = v
+		}
+	}
+	if now == 0 {
+		return time.Now().UnixMilli()
+	}
+	return now
+}
+
+// Connections returns the number of the open connections accepted by the acceptors of the reactor,
+// it can be called from any goroutine
+func (r *Reactor) Connections() int {
+	return int(r.conns.Load())
+}
+
+// reserveConn counts a new connection, returns false if MaxConnections has been reached
+func (r *Reactor) reserveConn() bool {
+	for {
+		n := r.conns.Load()
+		if r.maxConns > 0 && n >= r.maxConns {
+			return false
+		}
+		if r.conns.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// ReArm re-enables an fd registered with EPOLLONESHOT (e.g. EvInOneShot) after its event has been
+// handled. The events replace the registered ones, include EPOLLONESHOT to keep the oneshot mode.
+//
+// Between the dispatch and ReArm the fd is disarmed and no event will be reported for it.
+func (r *Reactor) ReArm(eh EvHandler, fd int, events uint32) error {
+	if eh == nil || fd < 1 {
+		return errors.New("invalid EvHandler or fd")
+	}
+	if ep := eh.getEvPoll(); ep != nil {
+		return ep.rearm(fd, events)
+	}
+	return errors.New("ev handler not add")
+}
+
+// CancelTimer cancels the pending timer of eh, the timerItem is released immediately.
+// Returns false if eh has no pending timer (e.g. a one-shot timer that already fired).
+//
+// Call it within the evpoll coroutine of eh.
+func (r *Reactor) CancelTimer(eh EvHandler) bool {
+	if eh == nil {
+		return false
+	}
+	if ep := eh.getEvPoll(); ep != nil {
+		return ep.cancelTimer(eh)
+	}
+	return false
+}
+
+// ResetTimer reschedules the timer of eh to expire after delay (millisecond), keeping its interval.
+// If eh has no pending timer, a one-shot timer is scheduled.
+//
+// Call it within the evpoll coroutine of eh.
+func (r *Reactor) ResetTimer(eh EvHandler, delay int64) error {
+	if eh == nil {
+		return errors.New("invalid EvHandler")
+	}
+	if ep := eh.getEvPoll(); ep != nil {
+		return ep.resetTimer(eh, delay)
+	}
+	return errors.New("ev handler has not been added to the reactor yet")
+}
+
+// Broadcast sends data to every registered handler accepted by filter (e.g. a type assertion of
+// your connection type, filter MUST reject the handlers of the framework such as Acceptor).
+// It can be called from any goroutine.
+//
+// data is written by each evpoll through the async write of the handlers, so a slow peer is
+// queued like AsyncWrite (the handler MUST call AsyncOrderedFlush in OnWrite) instead of blocking
+// the others, and a broken connection is closed by its evpoll on EPOLLERR/EPOLLHUP.
+// OnAsyncWriteBufDone is called for each handler, data MUST NOT be modified until then.
+func (r *Reactor) Broadcast(data []byte, filter func(eh EvHandler) bool) error {
+	if filter == nil {
+		return errors.New("Broadcast: filter is nil")
+	}
+	abf := AsyncWriteBuf{Len: len(data), Buf: data}
+	for i := 0; i < r.evPollNum; i++ {
+		r.evPolls[i].push(asyncWriteItem{abf: abf, filter: filter})
+	}
+	return nil
+}
+
+// Post runs fn within the evpoll coroutine which fd belongs to (the one it is registered to, else
+// fd % evPollNum as AddEvHandler does), it can be called from any goroutine, e.g. to write or close
+// a connection safely.
+//
+// The closures posted for the same fd run in FIFO order, and in order with the async writes.
+// The closures waiting can be bounded, see EvPollPostQueue. It returns ErrReactorClosed once the
+// evpoll has been stopped (Stop or Shutdown), including to a submitter blocked by PostBlock.
+func (r *Reactor) Post(fd int, fn func()) error {
+	if fd < 1 || fn == nil {
+		return errors.New("Post: invalid params")
+	}
+	ep := &r.evPolls[r.evPollIndex(fd)]
+	if eh := r.GetHandler(fd); eh != nil {
+		if hep := eh.getEvPoll(); hep != nil { // may be another one, see addEvHandlerTo
+			ep = hep
+		}
+	}
+	return ep.asyncWrite.post(asyncWriteItem{fd: fd, fn: fn})
+}
+
+// EnableRead pauses (false) or resumes (true) reading the connection fd, e.g. to push back on a
+// fast producer from another goroutine: EvIn is removed from (or added back to) the events of fd,
+// OnRead is not called while paused and the data of the peer waits in the socket buffer.
+// It can be called from any goroutine, the change is posted to the evpoll of fd and is done in
+// order with Post. It is ignored once the connection is being closed (CloseGracefully).
+func (r *Reactor) EnableRead(fd int, on bool) error {
+	return r.enableEvents(fd, syscall.EPOLLIN|syscall.EPOLLRDHUP, on)
+}
+
+// EnableWrite adds (true) or removes (false) EvOut of the connection fd like EnableRead, OnWrite
+// is called while fd is writable. Writer enables and disables EvOut by itself, don't mix them.
+func (r *Reactor) EnableWrite(fd int, on bool) error {
+	return r.enableEvents(fd, syscall.EPOLLOUT, on)
+}
+
+// WatchedEvents returns the events fd is registered with (e.g. EvIn|EvOut), 0 if fd is not
+// registered. It can be called from any goroutine, the changes posted by EnableRead/EnableWrite
+// are reflected once done.
+func (r *Reactor) WatchedEvents(fd int) uint32 {
+	eh := r.GetHandler(fd)
+	if eh == nil {
+		return 0
+	}
+	if ep := eh.getEvPoll(); ep != nil {
+		return ep.evHandlerMap.watched(fd)
+	}
+	return 0
+}
+
+func (r *Reactor) enableEvents(fd int, events uint32, on bool) error {
+	eh := r.GetHandler(fd)
+	if eh == nil {
+		return errors.New("EnableRead/EnableWrite: fd not registered")
+	}
+	ep := eh.getEvPoll()
+	ep.push(asyncWriteItem{fd: fd, fn: func() {
+		ed := ep.loadEvData(fd)
+		if ed == nil || ed.eh != eh || eh.gracefulClosing() { // closed or closing in the meantime
+			return
+		}
+		to := ed.events &^ events
+		if on {
+			to = ed.events | events
+		}
+		if to == ed.events {
+			return
+		}
+		if err := ep.modify(ed, to); err != nil {
+			ep.logger.Errorf("goev: modify the events of fd %d: %s", fd, err.Error())
+		}
+	}})
+	return nil
+}
+
 // Run starts the multi-event evpolling to run.
+//
+// Run blocks until all evpolls exit. It returns ErrReactorClosed after Stop (or Shutdown), otherwise
+// the error of the first failed evpoll (in index order), which wraps the syscall.Errno, e.g.
+// errors.Is(err, syscall.EBADF), callers may recreate the reactor in that case.
+// Same as RunContext(context.Background())
 func (r *Reactor) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is Run which is stopped (like Stop) when ctx is done, it returns ctx.Err() wrapped
+// in that case, use errors.Is(err, context.Canceled) to check it.
+func (r *Reactor) RunContext(ctx context.Context) error {
+	if ctx.Done() != nil {
+		exitC := make(chan struct{})
+		defer close(exitC)
+		go func() {
+			select {
+			case <-ctx.Done():
+				r.Stop()
+			case <-exitC:
+			}
+		}()
+	}
+
 	var wg sync.WaitGroup
-	var errS []string
-	var errSMtx sync.Mutex
+	errS := make([]error, r.evPollNum) // one slot per evpoll, no lock needed
 	for i := 0; i < r.evPollNum; i++ {
 		wg.Add(1)
 		go func(j int) {
+			defer wg.Done() // after errS[j] is set
 			if r.evPollLockOSThread == true {
 				// Refer to go doc runtime.LockOSThread
 				// LockOSThread will bind the current goroutine to the current OS thread T,
 				// preventing other goroutines from being scheduled onto this thread T
 				runtime.LockOSThread()
 			}
-			err := r.evPolls[j].run(&wg)
-			errSMtx.Lock()
-			errS = append(errS, fmt.Sprintf("epoll#%d err: %s", j, err.Error()))
-			errSMtx.Unlock()
+			if len(r.evPollCPUs) > 0 {
+				if err := setCPUAffinity(r.evPollCPUs[j%len(r.evPollCPUs)]); err != nil {
+					errS[j] = err
+					r.Stop() // the others
+					return
+				}
+			}
+			errS[j] = r.runEvPoll(j)
 		}(i)
 	}
 	wg.Wait()
+	r.stopSignals()
 
-	if len(errS) == 0 {
-		return nil
+	for i, err := range errS {
+		if err != nil {
+			return fmt.Errorf("epoll#%d err: %w", i, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("reactor run: %w", err)
+	}
+	return ErrReactorClosed
+}
+
+// runEvPoll runs evpoll i, restarts it after a failure while the restart budget lasts
+func (r *Reactor) runEvPoll(i int) error {
+	ep := &r.evPolls[i]
+	delay := r.restartDelay
+	for {
+		err := ep.run(nil)
+		if err == nil || ep.stopped.Load() || r.restartBudget.Add(-1) < 0 {
+			return err
+		}
+		ep.logger.Errorf("goev: evpoll#%d exited: %s, restart in %dms", i, err.Error(), delay)
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+		delay *= 2
+		ep.stats.restarts.Add(1)
+	}
+}
+
+// Shutdown tears the reactor down in order, it can be called from any goroutine while Run is
+// running:
+//  1. closes the acceptors, no new connection is accepted
+//  2. waits for the pending async writes of the connections to be sent
+//  3. closes all handlers (OnClose is called), the connections still pending when ctx is done
+//     are closed as well
+//  4. stops the evpolls, Run returns
+//
+// It returns ctx.Err() if ctx is done before the async writes are drained, or before the handlers
+// are closed: it does not wait for an evpoll busy in a handler then, which stops once it has closed
+// its handlers.
+func (r *Reactor) Shutdown(ctx context.Context) error {
+	err := r.execInEvPolls(ctx, func(ep *evPoll) {
+		ep.closeAll(func(eh EvHandler) bool {
+			_, ok := eh.(*Acceptor)
+			return ok
+		})
+	})
+	for err == nil {
+		pending := 0
+		err = r.execInEvPolls(ctx, func(ep *evPoll) {
+			pending += ep.pendingWrites() // evpolls run one by one, see execInEvPolls
+		})
+		if err != nil || pending == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// each evpoll stops itself once its handlers are closed, so that returning on ctx does not
+	// leave them open
+	closeAll := func(ep *evPoll) {
+		ep.closeAll(func(EvHandler) bool { return true })
+		ep.stop()
+	}
+	doneCs := make([]chan struct{}, r.evPollNum)
+	for i := 0; i < r.evPollNum; i++ {
+		ep := &r.evPolls[i]
+		if !ep.running.Load() { // nobody else touches the handlers
+			closeAll(ep)
+			continue
+		}
+		doneC := make(chan struct{})
+		ep.push(asyncWriteItem{fn: func() {
+			closeAll(ep)
+			close(doneC)
+		}})
+		doneCs[i] = doneC
+	}
+	for _, doneC := range doneCs {
+		if doneC == nil {
+			continue
+		}
+		select {
+		case <-doneC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// execInEvPolls runs fn within every evpoll one after another, returns ctx.Err() if ctx is done
+// first
+func (r *Reactor) execInEvPolls(ctx context.Context, fn func(ep *evPoll)) error {
+	for i := 0; i < r.evPollNum; i++ {
+		ep := &r.evPolls[i]
+		doneC := make(chan struct{})
+		ep.push(asyncWriteItem{fn: func() {
+			fn(ep)
+			close(doneC)
+		}})
+		select {
+		case <-doneC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// setCPUAffinity binds the current thread (locked by runtime.LockOSThread) to cpu
+func setCPUAffinity(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return errors.New("sched_setaffinity: " + err.Error())
+	}
+	return nil
+}
+
+// Stop wakes up all evpolls and makes Run return, it can be called from any goroutine.
+//
+// Registered fds are not closed.
+func (r *Reactor) Stop() {
+	for i := 0; i < r.evPollNum; i++ {
+		r.evPolls[i].stop()
 	}
-	return errors.New(strings.Join(errS, "; "))
 }
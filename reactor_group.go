@@ -0,0 +1,127 @@
+package goev
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// BalanceStrategy chooses the reactor of an accepted connection in ReactorGroup
+type BalanceStrategy int
+
+const (
+	// RoundRobin assigns the connections to the reactors in turn
+	RoundRobin BalanceStrategy = iota
+
+	// LeastConnections assigns a connection to the reactor with the fewest registered fds
+	LeastConnections
+
+	// FdHash assigns a connection to the reactor fd % N
+	FdHash
+)
+
+// ReactorGroup owns N reactors and spreads the accepted connections over them, when the evpolls
+// of a single reactor become a bottleneck. A connection stays on the reactor it is assigned to.
+type ReactorGroup struct {
+	noCopy
+
+	reactors []*Reactor
+	strategy BalanceStrategy
+	next     atomic.Uint64 // RoundRobin
+}
+
+// NewReactorGroup creates n reactors with opts
+func NewReactorGroup(n int, strategy BalanceStrategy, opts ...Option) (*ReactorGroup, error) {
+	if n < 1 {
+		return nil, errors.New("ReactorGroup: n < 1")
+	}
+	if strategy < RoundRobin || strategy > FdHash {
+		return nil, errors.New("ReactorGroup: invalid strategy")
+	}
+	g := &ReactorGroup{strategy: strategy, reactors: make([]*Reactor, n)}
+	for i := 0; i < n; i++ {
+		r, err := NewReactor(opts...)
+		if err != nil {
+			for _, r := range g.reactors[:i] {
+				r.release()
+			}
+			return nil, err
+		}
+		g.reactors[i] = r
+	}
+	return g, nil
+}
+
+// Reactors returns the reactors of the group
+func (g *ReactorGroup) Reactors() []*Reactor {
+	return g.reactors
+}
+
+// Next returns the reactor which fd should be registered with, it can be called from any goroutine
+func (g *ReactorGroup) Next(fd int) *Reactor {
+	n := len(g.reactors)
+	switch g.strategy {
+	case LeastConnections:
+		r, least := g.reactors[0], g.reactors[0].registered()
+		for _, rr := range g.reactors[1:] {
+			if c := rr.registered(); c < least {
+				r, least = rr, c
+			}
+		}
+		return r
+	case FdHash:
+		return g.reactors[fd%n]
+	}
+	return g.reactors[(g.next.Add(1)-1)%uint64(n)]
+}
+
+// NewAcceptor opens a listener on the first reactor, the reactor of each accepted connection is
+// chosen by Next and set to its EvHandler before OnOpen, so OnOpen MUST register the fd by
+// GetReactor().AddEvHandler
+func (g *ReactorGroup) NewAcceptor(newEvHanlderFunc func() EvHandler, addr string,
+	opts ...Option) (*Acceptor, error) {
+	a, err := NewAcceptor(g.reactors[0], newEvHanlderFunc, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	a.group = g
+	return a, nil
+}
+
+// Run runs all reactors, it blocks until all of them exit and returns the error of the first
+// failed one (in index order), ErrReactorClosed if all of them have been stopped
+func (g *ReactorGroup) Run() error {
+	var wg sync.WaitGroup
+	errS := make([]error, len(g.reactors))
+	for i, r := range g.reactors {
+		wg.Add(1)
+		go func(j int, r *Reactor) {
+			defer wg.Done()
+			errS[j] = r.Run()
+		}(i, r)
+	}
+	wg.Wait()
+	for i, err := range errS {
+		if !errors.Is(err, ErrReactorClosed) {
+			return fmt.Errorf("reactor#%d err: %w", i, err)
+		}
+	}
+	return ErrReactorClosed
+}
+
+// Stop stops all reactors, it can be called from any goroutine
+func (g *ReactorGroup) Stop() {
+	for _, r := range g.reactors {
+		r.Stop()
+	}
+}
+
+// registered returns the number of the fds registered with all evpolls
+func (r *Reactor) registered() int {
+	n := 0
+	for i := 0; i < r.evPollNum; i++ {
+		n += r.evPolls[i].evHandlerMap.count()
+	}
+	return n
+}
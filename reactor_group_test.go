@@ -0,0 +1,126 @@
+package goev
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReactorGroup(t *testing.T) {
+	const reactors, conns = 3, 30
+	for _, strategy := range []BalanceStrategy{RoundRobin, LeastConnections, FdHash} {
+		g, err := NewReactorGroup(reactors, strategy, EvPollNum(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go g.Run()
+
+		openC := make(chan groupOpen, conns)
+		a, err := g.NewAcceptor(func() EvHandler {
+			c := &echoConn{}
+			return &groupConn{echoConn: c, openC: openC}
+		}, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := a.Addr().String()
+		var clients []net.Conn
+		for i := 0; i < conns; i++ {
+			conn, err := net.Dial("tcp4", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients = append(clients, conn)
+			echo(t, conn, "x") // opened before the next one, for LeastConnections
+		}
+		dist := make(map[*Reactor]int)
+		for i := 0; i < conns; i++ {
+			select {
+			case o := <-openC:
+				if strategy == FdHash && o.r != g.Reactors()[o.fd%reactors] {
+					t.Fatalf("fd %d not assigned to reactor#%d", o.fd, o.fd%reactors)
+				}
+				dist[o.r]++
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection not opened")
+			}
+		}
+		for i, r := range g.Reactors() {
+			if strategy == FdHash { // depends on the fds, the clients in this process take some
+				continue
+			}
+			if n := dist[r]; n < conns/reactors-3 || n > conns/reactors+3 {
+				t.Fatalf("strategy %d: reactor#%d has %d of %d connections", strategy, i, n, conns)
+			}
+		}
+		for _, conn := range clients {
+			conn.Close()
+		}
+		a.reactor.RemoveEvHandler(a, a.fd)
+		a.OnClose()
+		g.Stop()
+	}
+}
+
+type groupOpen struct {
+	fd int
+	r  *Reactor
+}
+
+// groupConn is an echoConn reporting the reactor it is registered with
+type groupConn struct {
+	*echoConn
+
+	openC chan groupOpen
+}
+
+func (c *groupConn) OnOpen(fd int) bool {
+	if !c.echoConn.OnOpen(fd) {
+		return false
+	}
+	c.openC <- groupOpen{fd, c.GetReactor()}
+	return true
+}
+
+// openFds returns the number of open fds and the highest one
+func openFds(t *testing.T) (n, highest int) {
+	des, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, de := range des {
+		if fd, _ := strconv.Atoi(de.Name()); fd > highest {
+			highest = fd
+		}
+	}
+	return len(des) - 1, highest // without the fd of ReadDir
+}
+
+func TestReactorGroupRelease(t *testing.T) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	before, highest := openFds(t)
+	// room for a reactor of 1 evpoll (4 fds), not for 2
+	limit := rlim
+	limit.Cur = uint64(before + 6)
+	if limit.Cur <= uint64(highest) {
+		t.Skip("too many fds free below the highest one")
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip(err)
+	}
+	_, err := NewReactorGroup(2, RoundRobin, EvPollNum(1))
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	if err == nil {
+		t.Fatal("NewReactorGroup succeeded beyond RLIMIT_NOFILE")
+	}
+	if after, _ := openFds(t); after != before {
+		t.Fatalf("%d fds open after the failure, %d before", after, before)
+	}
+}
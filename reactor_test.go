@@ -0,0 +1,853 @@
+package goev
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReactorStop(t *testing.T) {
+	r, err := NewReactor(EvPollNum(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- r.Run()
+	}()
+	time.Sleep(50 * time.Millisecond) // let evpolls block in epoll_wait
+	r.Stop()
+
+	select {
+	case err = <-errC:
+		if err != ErrReactorClosed {
+			t.Fatalf("Run returned %v, want ErrReactorClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+}
+
+func TestReactorRunEINTR(t *testing.T) {
+	l := &captureLogger{}
+	r, err := NewReactor(EvPollNum(1), EvPollLockOSThread(true), EvLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- r.Run()
+	}()
+	tidC := make(chan int, 1)
+	r.Post(1, func() { tidC <- syscall.Gettid() }) // the thread of the evpoll
+	tid := <-tidC
+
+	// interrupts the blocking epoll_wait, the Go runtime ignores SIGURG
+	for i := 0; i < 20 && l.find("D goev: epoll_wait interrupted") == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		unix.Tgkill(syscall.Getpid(), tid, syscall.SIGURG)
+	}
+	if l.find("D goev: epoll_wait interrupted") == "" {
+		t.Fatal("epoll_wait not interrupted")
+	}
+	select {
+	case err = <-errC:
+		t.Fatalf("Run returned %v on EINTR", err)
+	default:
+	}
+	doneC := make(chan struct{})
+	r.Post(1, func() { close(doneC) })
+	select {
+	case <-doneC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the evpoll stopped serving after EINTR")
+	}
+	r.Stop()
+	if err = <-errC; err != ErrReactorClosed {
+		t.Fatalf("Run returned %v, want ErrReactorClosed", err)
+	}
+}
+
+func TestReactorNow(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	type sample struct{ now, wall int64 }
+	sampleC := make(chan sample, 1)
+	var prev sample
+	for i := 0; i < 5; i++ {
+		r.Post(1, func() {
+			s := sample{r.Now(), time.Now().UnixMilli()}
+			time.Sleep(20 * time.Millisecond) // the batch doesn't advance the cached time
+			if now := r.Now(); now != s.now {
+				t.Errorf("Now advanced from %d to %d within a batch", s.now, now)
+			}
+			sampleC <- s
+		})
+		s := <-sampleC
+		if d := s.wall - s.now; d < 0 || d > 10 {
+			t.Fatalf("Now %d is %dms behind the wall clock", s.now, d)
+		}
+		if i > 0 {
+			if d := (s.now - prev.now) - (s.wall - prev.wall); d < -10 || d > 10 {
+				t.Fatalf("Now advanced %dms, the wall clock %dms", s.now-prev.now, s.wall-prev.wall)
+			}
+		}
+		prev = s
+		time.Sleep(30 * time.Millisecond)
+	}
+	// all evpolls are blocking
+	if d := time.Now().UnixMilli() - r.Now(); d < 0 || d > 1 {
+		t.Fatalf("Now is %dms behind the wall clock while blocking", d)
+	}
+}
+
+func TestReactorRunContext(t *testing.T) {
+	r, err := NewReactor(EvPollNum(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		errC <- r.RunContext(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond) // let evpolls block in epoll_wait
+	cancel()
+
+	select {
+	case err = <-errC:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestReactorRunError(t *testing.T) {
+	r, err := NewReactor(EvPollNum(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(r.evPolls[2].efd) // epoll_wait fails with EBADF
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- r.Run()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r.Stop()
+
+	select {
+	case err = <-errC:
+		if err == nil || !strings.HasPrefix(err.Error(), "epoll#2 ") {
+			t.Fatalf("Run returned %v, want epoll#2 error", err)
+		}
+		if !errors.Is(err, syscall.EBADF) || errors.Is(err, ErrReactorClosed) {
+			t.Fatalf("Run returned %v, want a fatal EBADF", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+}
+
+func TestReactorEvPollRestart(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2), EvPollRestart(1, 100))
+	ep := &r.evPolls[1]
+	waitRunning := func(v bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for ep.running.Load() != v && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return ep.running.Load() == v
+	}
+	if !waitRunning(true) {
+		t.Fatal("evpoll#1 not running")
+	}
+	saved, err := unix.FcntlInt(uintptr(ep.efd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(saved)
+	devNull, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(devNull)
+
+	// epoll_wait fails with EINVAL once the blocked one returns, until the epoll fd is restored
+	unix.Dup3(devNull, ep.efd, unix.O_CLOEXEC)
+	r.Post(3, func() {}) // wakes up evpoll#1
+	if !waitRunning(false) {
+		t.Fatal("evpoll#1 did not exit")
+	}
+	unix.Dup3(saved, ep.efd, unix.O_CLOEXEC)
+
+	if !waitRunning(true) {
+		t.Fatal("evpoll#1 not restarted")
+	}
+	doneC := make(chan int, 1)
+	r.Post(3, func() { doneC <- 1 })
+	select {
+	case <-doneC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("evpoll#1 not dispatching after restart")
+	}
+	if n := r.Stats()[1].Restarts; n != 1 {
+		t.Fatalf("Restarts %d, want 1", n)
+	}
+}
+
+type broadcastConn struct {
+	echoConn
+}
+
+func (c *broadcastConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	c.openC <- fd
+	return true
+}
+func (c *broadcastConn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+
+func TestReactorBroadcast(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	openC := make(chan int, 8)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &broadcastConn{echoConn{openC: openC}}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	conns := make([]net.Conn, 5)
+	for i := range conns {
+		if conns[i], err = net.Dial("tcp4", addr); err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+		select {
+		case <-openC:
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnOpen not fired")
+		}
+	}
+	msg := "broadcast"
+	err = r.Broadcast([]byte(msg), func(eh EvHandler) bool {
+		_, ok := eh.(*broadcastConn)
+		return ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, conn := range conns {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		bf := make([]byte, len(msg))
+		if _, err = io.ReadFull(conn, bf); err != nil {
+			t.Fatalf("conn#%d: %v", i, err)
+		}
+		if string(bf) != msg {
+			t.Fatalf("conn#%d got %q", i, bf)
+		}
+	}
+	if r.Broadcast([]byte(msg), nil) == nil {
+		t.Fatal("nil filter accepted")
+	}
+}
+
+type tidConn struct {
+	echoConn
+
+	tidC chan int
+}
+
+func (c *tidConn) OnRead() bool {
+	c.tidC <- unix.Gettid()
+	return c.echoConn.OnRead()
+}
+
+func TestReactorPost(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2), EvPollLockOSThread(true))
+	fd, peer := newSocketPair(t)
+	c := &tidConn{tidC: make(chan int, 1)}
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(peer, []byte{'x'})
+	pollerTid := <-c.tidC
+	readFull(t, peer, 1) // echo
+
+	tidC := make(chan int, 1)
+	err := r.Post(fd, func() {
+		tidC <- unix.Gettid()
+		c.Write([]byte("posted"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tid := <-tidC; tid != pollerTid {
+		t.Fatalf("fn ran on thread %d, the evpoll is on %d", tid, pollerTid)
+	}
+	if bf := readFull(t, peer, 6); string(bf) != "posted" {
+		t.Fatalf("got %q", bf)
+	}
+
+	var seq []int
+	doneC := make(chan struct{})
+	for i := 0; i < 1000; i++ {
+		i := i
+		r.Post(fd, func() {
+			seq = append(seq, i)
+			if i == 999 {
+				close(doneC)
+			}
+		})
+	}
+	select {
+	case <-doneC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("posted closures not run")
+	}
+	for i, v := range seq {
+		if v != i {
+			t.Fatalf("closure#%d ran at %d", v, i)
+		}
+	}
+}
+
+func TestReactorPostEvPoll(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2), EvPollLockOSThread(true))
+	fd, peer := newSocketPair(t)
+	c := &tidConn{tidC: make(chan int, 1)}
+	idx := 1 - fd%2 // not the one of fd % evPollNum
+	if err := r.addEvHandlerTo(idx, c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(peer, []byte{'x'})
+	pollerTid := <-c.tidC
+	readFull(t, peer, 1)
+
+	tidC := make(chan int, 1)
+	if err := r.Post(fd, func() { tidC <- unix.Gettid() }); err != nil {
+		t.Fatal(err)
+	}
+	if tid := <-tidC; tid != pollerTid {
+		t.Fatalf("fn ran on thread %d, the evpoll of fd is on %d", tid, pollerTid)
+	}
+}
+
+type shutdownConn struct {
+	IOHandle
+
+	openC  chan *shutdownConn
+	closeC chan int
+}
+
+func (c *shutdownConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	c.openC <- c
+	return true
+}
+func (c *shutdownConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n > 0
+}
+func (c *shutdownConn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+func (c *shutdownConn) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+		c.closeC <- 1
+	}
+}
+
+func TestReactorShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		bufSize int // async written to the first connection, whose peer doesn't read
+		timeout time.Duration
+		wantErr error
+	}{
+		{"drained", 8 * 1024 * 1024, 2 * time.Second, nil},
+		{"deadline", 64 * 1024 * 1024, 100 * time.Millisecond, context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewReactor(EvPollNum(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			runC := make(chan error, 1)
+			go func() { runC <- r.Run() }()
+
+			openC, closeC := make(chan *shutdownConn, 4), make(chan int, 4)
+			a, err := NewAcceptor(r, func() EvHandler {
+				c := &shutdownConn{openC: openC, closeC: closeC}
+				c.setReactor(r)
+				return c
+			}, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := a.Addr().String()
+			const n = 3
+			for i := 0; i < n; i++ {
+				conn, err := net.Dial("tcp4", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				c := <-openC
+				if i == 0 {
+					c.AsyncWrite(c, AsyncWriteBuf{Len: tc.bufSize, Buf: make([]byte, tc.bufSize)})
+					if tc.wantErr == nil {
+						go io.Copy(io.Discard, conn)
+					}
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			if err = r.Shutdown(ctx); err != tc.wantErr {
+				t.Fatalf("Shutdown: %v, want %v", err, tc.wantErr)
+			}
+			for i := 0; i < n; i++ {
+				select {
+				case <-closeC:
+				case <-time.After(time.Second):
+					t.Fatalf("%d/%d OnClose fired", i, n)
+				}
+			}
+			select {
+			case err = <-runC:
+				if err != ErrReactorClosed {
+					t.Fatalf("Run returned %v, want ErrReactorClosed", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Run did not return")
+			}
+			if conn, err := net.Dial("tcp4", addr); err == nil {
+				conn.Close()
+				t.Fatal("still accepting after Shutdown")
+			}
+		})
+	}
+}
+
+func TestReactorShutdownBusyEvPoll(t *testing.T) {
+	r, err := NewReactor(EvPollNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runC := make(chan error, 1)
+	go func() { runC <- r.Run() }()
+	blockC := make(chan struct{})
+	r.evPolls[0].push(asyncWriteItem{fn: func() { <-blockC }}) // busy in a handler
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() { errC <- r.Shutdown(ctx) }()
+	select {
+	case err = <-errC:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Shutdown: %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return when ctx is done")
+	}
+	close(blockC)
+	select {
+	case err = <-runC:
+		if err != ErrReactorClosed {
+			t.Fatalf("Run returned %v, want ErrReactorClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestReactorGetHandler(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	openC := make(chan *shutdownConn, 1)
+	closeC := make(chan int, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &shutdownConn{openC: openC, closeC: closeC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-openC
+	fd := c.Fd()
+	if eh := r.GetHandler(fd); eh != EvHandler(c) {
+		t.Fatalf("GetHandler(%d) = %v, want the accepted conn", fd, eh)
+	}
+	if r.GetHandler(0) != nil || r.GetHandler(1<<20) != nil {
+		t.Fatal("handler of an unregistered fd")
+	}
+
+	// force closing by fd
+	r.Post(fd, func() {
+		if eh := r.GetHandler(fd); eh != nil {
+			r.RemoveEvHandler(eh, fd)
+			eh.OnClose()
+		}
+	})
+	select {
+	case <-closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn not closed")
+	}
+	if eh := r.GetHandler(fd); eh != nil {
+		t.Fatalf("GetHandler(%d) = %v after closed", fd, eh)
+	}
+}
+
+func TestReactorCPUAffinity(t *testing.T) {
+	if _, err := NewReactor(EvPollCPUAffinity([]int{runtime.NumCPU()})); err == nil {
+		t.Fatal("cpu out of range accepted")
+	}
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Skipf("sched_getaffinity: %v", err)
+	}
+	var cpus []int // allowed to the test process
+	for i := 0; i < runtime.NumCPU() && len(cpus) < 2; i++ {
+		if set.IsSet(i) {
+			cpus = append(cpus, i)
+		}
+	}
+	if len(cpus) == 0 {
+		t.Skip("no cpu allowed")
+	}
+	r := newTestReactor(t, EvPollNum(2), EvPollCPUAffinity(cpus))
+	for i := 0; i < 2; i++ {
+		setC := make(chan unix.CPUSet, 1)
+		r.Post(2+i, func() { // evpoll#i
+			var s unix.CPUSet
+			unix.SchedGetaffinity(0, &s)
+			setC <- s
+		})
+		s := <-setC
+		if want := cpus[i%len(cpus)]; s.Count() != 1 || !s.IsSet(want) {
+			t.Fatalf("evpoll#%d bound to %d cpus, want cpu %d", i, s.Count(), want)
+		}
+	}
+}
+
+// BenchmarkReactorScaling echoes a byte over 64 connections concurrently with 1 to 8 evpolls,
+// each evpoll waits on its own epoll fd for the connections fd % evPollNum, no lock is shared.
+func BenchmarkReactorScaling(b *testing.B) {
+	const conns = 64
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run("evpolls-"+strconv.Itoa(n), func(b *testing.B) {
+			r, err := NewReactor(EvPollNum(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			go r.Run()
+			defer r.Stop()
+
+			peers := make([]int, conns)
+			for i := range peers {
+				fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer syscall.Close(fds[1])
+				syscall.SetNonblock(fds[0], true)
+				c := &echoConn{}
+				if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+					b.Fatal(err)
+				}
+				defer c.OnClose()
+				peers[i] = fds[1]
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for _, peer := range peers {
+				wg.Add(1)
+				go func(peer int) {
+					defer wg.Done()
+					bf := []byte{'x'}
+					for i := 0; i < b.N/conns+1; i++ {
+						syscall.Write(peer, bf)
+						syscall.Read(peer, bf)
+					}
+				}(peer)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestReactorPeerAddr(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	openC := make(chan int, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	local, _ := net.ResolveTCPAddr("tcp4", freeAddr(t)) // a known local port
+	d := net.Dialer{LocalAddr: local}
+	conn, err := d.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ap, err := r.PeerAddr(<-openC); err != nil || ap.String() != local.String() {
+		t.Fatalf("PeerAddr %v %v, want %v", ap, err, local)
+	}
+
+	// IPv6, accepted by net.Listener
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 unavailable: %v", err)
+	}
+	defer l.Close()
+	conn6, err := net.Dial("tcp6", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn6.Close()
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	rc, _ := sc.(*net.TCPConn).SyscallConn()
+	rc.Control(func(fd uintptr) {
+		if ap, err := r.PeerAddr(int(fd)); err != nil || ap.String() != conn6.LocalAddr().String() {
+			t.Fatalf("PeerAddr %v %v, want %v", ap, err, conn6.LocalAddr())
+		}
+	})
+	fds, _ := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	if _, err = r.PeerAddr(fds[0]); err == nil {
+		t.Fatal("PeerAddr of a unix socket")
+	}
+}
+
+// toggleConn reports OnRead and OnWrite
+type toggleConn struct {
+	IOHandle
+
+	readC  chan string
+	writeC chan struct{}
+}
+
+func (c *toggleConn) OnRead() bool {
+	bf, n, _ := c.Read()
+	if n > 0 {
+		c.readC <- string(bf)
+		return true
+	}
+	return false
+}
+func (c *toggleConn) OnWrite() bool {
+	select {
+	case c.writeC <- struct{}{}:
+	default:
+	}
+	return true
+}
+func (c *toggleConn) OnClose() {}
+
+func TestReactorEnableReadWrite(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	fd, peer := newSocketPair(t)
+	c := &toggleConn{readC: make(chan string, 4), writeC: make(chan struct{}, 1)}
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	// barrier waits for the changes posted before
+	barrier := func() {
+		done := make(chan struct{})
+		r.Post(fd, func() { close(done) })
+		<-done
+	}
+
+	if err := r.EnableRead(fd, false); err != nil {
+		t.Fatal(err)
+	}
+	barrier()
+	if ev := r.WatchedEvents(fd); ev&syscall.EPOLLIN != 0 {
+		t.Fatalf("events %#x after pausing reads", ev)
+	}
+	syscall.Write(peer, []byte("paused"))
+	select {
+	case s := <-c.readC:
+		t.Fatalf("OnRead %q while paused", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+	r.EnableRead(fd, true)
+	select {
+	case s := <-c.readC:
+		if s != "paused" {
+			t.Fatalf("read %q after resuming", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnRead not called after resuming")
+	}
+	if ev := r.WatchedEvents(fd); ev != EvIn {
+		t.Fatalf("events %#x after resuming, want EvIn", ev)
+	}
+
+	r.EnableWrite(fd, true)
+	select {
+	case <-c.writeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnWrite not called")
+	}
+	r.EnableWrite(fd, false)
+	barrier()
+	if ev := r.WatchedEvents(fd); ev != EvIn {
+		t.Fatalf("events %#x after disabling writes, want EvIn", ev)
+	}
+	select { // sent before disabling
+	case <-c.writeC:
+	default:
+	}
+	select {
+	case <-c.writeC:
+		t.Fatal("OnWrite called after disabling")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := r.EnableRead(12345, false); err == nil {
+		t.Fatal("unregistered fd accepted")
+	}
+	if ev := r.WatchedEvents(12345); ev != 0 {
+		t.Fatalf("events %#x of an unregistered fd", ev)
+	}
+}
+
+func TestReactorPollMode(t *testing.T) {
+	for _, mode := range []PollMode{PollModeSharded, PollModeShared} {
+		t.Run(mode.String(), func(t *testing.T) {
+			r := newTestReactor(t, EvPollNum(4), EvPollMode(mode))
+			openC := make(chan int, 8)
+			a, err := NewAcceptor(r, func() EvHandler {
+				c := &echoConn{openC: openC}
+				c.setReactor(r)
+				return c
+			}, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := a.Addr().String()
+			defer a.OnClose()
+
+			used := make(map[*evPoll]bool)
+			for i := 0; i < 8; i++ {
+				conn, err := net.Dial("tcp4", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				fd := <-openC
+				echo(t, conn, "ping")
+				eh := r.GetHandler(fd)
+				if eh == nil {
+					t.Fatalf("fd %d not registered", fd)
+				}
+				ep := eh.getEvPoll()
+				used[ep] = true
+				// posted to the evpoll of the connection
+				runIn(t, r, fd, func() {
+					if ed := ep.loadEvData(fd); ed == nil || ed.eh != eh {
+						t.Errorf("fd %d posted to another evpoll", fd)
+					}
+				})
+			}
+			if mode == PollModeShared && (len(used) != 1 || !used[&r.evPolls[0]]) {
+				t.Fatalf("connections spread over %d evpolls in shared mode", len(used))
+			}
+			if mode == PollModeSharded && len(used) < 2 {
+				t.Fatalf("connections on %d evpoll in sharded mode", len(used))
+			}
+		})
+	}
+}
+
+// BenchmarkPollMode echoes a byte over 64 connections concurrently with 4 evpolls, sharing the
+// epoll instance of evpoll 0 or spread over the 4 epoll instances
+func BenchmarkPollMode(b *testing.B) {
+	const conns = 64
+	for _, mode := range []PollMode{PollModeSharded, PollModeShared} {
+		b.Run(mode.String(), func(b *testing.B) {
+			r, err := NewReactor(EvPollNum(4), EvPollMode(mode))
+			if err != nil {
+				b.Fatal(err)
+			}
+			go r.Run()
+			defer r.Stop()
+
+			peers := make([]int, conns)
+			for i := range peers {
+				fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer syscall.Close(fds[1])
+				syscall.SetNonblock(fds[0], true)
+				c := &echoConn{}
+				if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+					b.Fatal(err)
+				}
+				defer c.OnClose()
+				peers[i] = fds[1]
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for _, peer := range peers {
+				wg.Add(1)
+				go func(peer int) {
+					defer wg.Done()
+					bf := []byte{'x'}
+					for i := 0; i < b.N/conns+1; i++ {
+						syscall.Write(peer, bf)
+						syscall.Read(peer, bf)
+					}
+				}(peer)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package goev
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// ErrReadBufferFull is returned by ReadFromFd when the buffered data reaches the max size
+var ErrReadBufferFull = errors.New("goev: read buffer is full")
+
+// ReadBuffer is an inbound buffer attached to a connection, it accumulates the data read from the
+// fd until a complete message arrives. Parse Bytes() incrementally and Discard what has been consumed,
+// the incomplete remainder is kept for the next OnRead.
+//
+// ReadBuffer is not thread-safe, use it only within the evpoll coroutine of the connection.
+//
+// For example:
+//
+//	func (x *XX) OnRead() bool {
+//	    _, err := x.rb.ReadFromHandler(x)
+//	    for {
+//	        bf := x.rb.Bytes()
+//	        i := bytes.IndexByte(bf, '\n')
+//	        if i < 0 {
+//	            break
+//	        }
+//	        x.onLine(bf[:i])
+//	        x.rb.Discard(i + 1)
+//	    }
+//	    return err == nil
+//	}
+type ReadBuffer struct {
+	buf     []byte // buffered data is buf[r:w]
+	bp      *[]byte
+	r       int
+	w       int
+	size    int
+	maxSize int
+}
+
+// NewReadBuffer return an instance, the buffer starts with size and grows up to maxSize
+// (0 means no limit). The buffer is taken from a pool, see Release.
+func NewReadBuffer(size, maxSize int) *ReadBuffer {
+	if size < 1 {
+		size = 4096
+	}
+	if maxSize > 0 && size > maxSize {
+		size = maxSize
+	}
+	b := &ReadBuffer{size: size, maxSize: maxSize}
+	b.bp = getBytes(size)
+	b.buf = (*b.bp)[:size]
+	return b
+}
+
+// ReadFromFd reads the non-blocking fd until EAGAIN (ignoring EINTR), so it is suitable for
+// EvInET, returns the number of bytes read.
+//
+// The error is io.EOF if the peer has closed (the data read before is buffered),
+// ErrReadBufferFull if the max size is reached, nil on EAGAIN.
+func (b *ReadBuffer) ReadFromFd(fd int) (int, error) {
+	total := 0
+	for {
+		if b.w == len(b.buf) && b.grow() == false {
+			return total, ErrReadBufferFull
+		}
+		n, err := syscall.Read(fd, b.buf[b.w:])
+		if n > 0 {
+			b.w += n
+			total += n
+			continue
+		}
+		if err == nil {
+			return total, io.EOF
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return total, nil
+		}
+		return total, err
+	}
+}
+
+// ReadFromHandler is ReadFromFd of the connection of eh, the bytes read are counted in
+// eh.BytesRead and the stats of its evpoll
+func (b *ReadBuffer) ReadFromHandler(eh EvHandler) (int, error) {
+	n, err := b.ReadFromFd(eh.Fd())
+	eh.countRead(n)
+	return n, err
+}
+
+// grow makes room at the tail, moves the buffered data to the head first
+func (b *ReadBuffer) grow() bool {
+	if b.r > 0 {
+		b.w = copy(b.buf, b.buf[b.r:b.w])
+		b.r = 0
+		return true
+	}
+	size := len(b.buf) * 2
+	if size == 0 { // released
+		size = b.size
+	}
+	if b.maxSize > 0 && size > b.maxSize {
+		size = b.maxSize
+	}
+	if size <= len(b.buf) {
+		return false
+	}
+	bp := getBytes(size)
+	buf := (*bp)[:size]
+	copy(buf, b.buf[:b.w])
+	putBytes(b.bp, b.buf)
+	b.bp, b.buf = bp, buf
+	return true
+}
+
+// Bytes returns the buffered data, valid until the next ReadFromFd or Discard
+func (b *ReadBuffer) Bytes() []byte {
+	return b.buf[b.r:b.w]
+}
+
+// Len returns the length of the buffered data
+func (b *ReadBuffer) Len() int {
+	return b.w - b.r
+}
+
+// Peek returns the next n bytes without consuming them, nil if fewer than n bytes are buffered
+func (b *ReadBuffer) Peek(n int) []byte {
+	if n < 0 || n > b.Len() {
+		return nil
+	}
+	return b.buf[b.r : b.r+n]
+}
+
+// Discard consumes the next n bytes (at most Len())
+func (b *ReadBuffer) Discard(n int) {
+	if n >= b.Len() {
+		b.r, b.w = 0, 0
+		return
+	}
+	if n > 0 {
+		b.r += n
+	}
+}
+
+// Reset discards all buffered data
+func (b *ReadBuffer) Reset() {
+	b.r, b.w = 0, 0
+}
+
+// Release discards all buffered data and returns the buffer to the pool zeroed, e.g. in OnClose.
+// The ReadBuffer stays usable, the next ReadFromFd takes a buffer again.
+func (b *ReadBuffer) Release() {
+	putBytes(b.bp, b.buf)
+	b.bp, b.buf = nil, nil
+	b.r, b.w = 0, 0
+}
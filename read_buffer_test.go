@@ -0,0 +1,86 @@
+package goev
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// lineFramer returns the complete lines of rb and consumes them
+func lineFramer(rb *ReadBuffer) (lines []string) {
+	for {
+		bf := rb.Bytes()
+		i := bytes.IndexByte(bf, '\n')
+		if i < 0 {
+			return
+		}
+		lines = append(lines, string(bf[:i]))
+		rb.Discard(i + 1)
+	}
+}
+
+func TestReadBuffer(t *testing.T) {
+	fd, peer := newSocketPair(t)
+	rb := NewReadBuffer(8, 0)
+
+	if n, err := rb.ReadFromFd(fd); n != 0 || err != nil {
+		t.Fatalf("read empty socket: %d %v", n, err)
+	}
+
+	// one message across two reads
+	syscall.Write(peer, []byte("hello, "))
+	if n, err := rb.ReadFromFd(fd); n != 7 || err != nil {
+		t.Fatalf("read: %d %v", n, err)
+	}
+	if lines := lineFramer(rb); len(lines) != 0 {
+		t.Fatalf("incomplete message framed: %q", lines)
+	}
+	if string(rb.Peek(5)) != "hello" || rb.Peek(8) != nil {
+		t.Fatal("Peek")
+	}
+	syscall.Write(peer, []byte("world\nnext"))
+	if _, err := rb.ReadFromFd(fd); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lineFramer(rb); len(lines) != 1 || lines[0] != "hello, world" {
+		t.Fatalf("framed %q", lines)
+	}
+	if string(rb.Bytes()) != "next" {
+		t.Fatalf("remainder %q", rb.Bytes())
+	}
+
+	// more than the buffer size is read in one call (ET), then EOF
+	long := strings.Repeat("x", 100000)
+	go func() {
+		syscall.Write(peer, []byte(long+"\n"))
+		syscall.Shutdown(peer, syscall.SHUT_WR)
+	}()
+	var err error
+	for err == nil {
+		_, err = rb.ReadFromFd(fd)
+	}
+	if err != io.EOF {
+		t.Fatalf("read %v, want EOF", err)
+	}
+	if lines := lineFramer(rb); len(lines) != 1 || lines[0] != "next"+long || rb.Len() != 0 {
+		t.Fatalf("framed %d lines, %d bytes left", len(lines), rb.Len())
+	}
+}
+
+func TestReadBufferFull(t *testing.T) {
+	fd, peer := newSocketPair(t)
+	rb := NewReadBuffer(4, 16)
+	syscall.Write(peer, []byte("0123456789abcdefXYZ"))
+	if n, err := rb.ReadFromFd(fd); n != 16 || err != ErrReadBufferFull {
+		t.Fatalf("read %d %v, want 16 ErrReadBufferFull", n, err)
+	}
+	rb.Discard(10)
+	if n, err := rb.ReadFromFd(fd); n != 3 || err != nil { // compacted
+		t.Fatalf("read %d %v", n, err)
+	}
+	if string(rb.Bytes()) != "abcdefXYZ" {
+		t.Fatalf("buffered %q", rb.Bytes())
+	}
+}
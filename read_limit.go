@@ -0,0 +1,121 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// readLimiter is a token bucket of the bytes read by IOHandle.Read
+type readLimiter struct {
+	eh     EvHandler
+	rate   int64 // bytes per second
+	burst  int64 // capacity of the bucket
+	tokens int64
+	frac   int64 // the rest of the refills below 1 byte, in bytes*millisecond/second
+	last   int64 // millisecond, the last refill
+
+	paused  uint32 // the events disabled while the bucket is empty, 0 if not paused
+	timerID TimerID
+}
+
+// SetReadRateLimit caps the bytes per second read from the fd by IOHandle.Read with a token bucket
+// of burst bytes (burst <= 0 means rate). Read never reads more than the tokens left, when the bucket
+// is exhausted EvIn is disabled and re-enabled by a timer once it has been refilled, so the peer is
+// throttled by the socket buffer (backpressure) and no data is dropped. rate 0 disables it.
+//
+// It MUST be called after the IOHandle is registered with the reactor, within the evpoll coroutine.
+// The data read by other means (e.g. ReadBuffer.ReadFromFd) is not limited.
+func (h *IOHandle) SetReadRateLimit(eh EvHandler, rate, burst int64) error {
+	if rate < 0 {
+		return errors.New("read rate < 0")
+	}
+	if h._ep == nil {
+		return errors.New("ev handler has not been added to the reactor yet")
+	}
+	if rl := h._readLimit; rl != nil {
+		h.CancelTimerID(rl.timerID)
+		if rl.paused != 0 {
+			h._ep.append(h._fd, rl.paused)
+		}
+		h._readLimit = nil
+	}
+	if rate == 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	h._readLimit = &readLimiter{eh: eh, rate: rate, burst: burst, tokens: burst,
+		last: time.Now().UnixMilli()}
+	return nil
+}
+
+// limitedRead reads at most the tokens left, EAGAIN if the bucket is empty
+func (h *IOHandle) limitedRead() (bf []byte, n int, err error) {
+	rl := h._readLimit
+	if rl.paused != 0 {
+		return nil, -1, syscall.EAGAIN
+	}
+	rl.refill(time.Now().UnixMilli())
+	bf = h._ep.evPollReadBuff
+	if int64(len(bf)) > rl.tokens {
+		bf = bf[:rl.tokens]
+	}
+	n, err = syscall.Read(h._fd, bf)
+	if n > 0 {
+		bf = bf[:n]
+		rl.tokens -= int64(n)
+	} else {
+		bf = nil
+	}
+	if rl.tokens < 1 {
+		h.pauseRead()
+	}
+	return
+}
+
+// pauseRead disables EvIn (and EPOLLRDHUP, which is level-triggered) until the bucket is refilled
+func (h *IOHandle) pauseRead() {
+	rl := h._readLimit
+	ed := h._ep.loadEvData(h._fd)
+	if ed == nil || ed.eh != rl.eh {
+		return
+	}
+	events := ed.events & (syscall.EPOLLIN | syscall.EPOLLRDHUP)
+	if events == 0 || h._ep.subtract(h._fd, events) != nil {
+		return
+	}
+	rl.paused = events
+	delay := ((rl.burst-rl.tokens)*1000 + rl.rate - 1) / rl.rate
+	if delay < 1 {
+		delay = 1
+	}
+	rl.timerID, _ = h.ScheduleTimerFunc(rl.eh, delay, 0, h.resumeRead, rl)
+}
+
+func (h *IOHandle) resumeRead(millisecond int64, arg any) bool {
+	rl := arg.(*readLimiter)
+	if h._fd < 1 || h._readLimit != rl { // closed or replaced
+		return false
+	}
+	events := rl.paused
+	rl.paused = 0
+	rl.refill(millisecond)
+	if ed := h._ep.loadEvData(h._fd); ed != nil && ed.eh == rl.eh {
+		h._ep.append(h._fd, events)
+	}
+	return false
+}
+
+func (rl *readLimiter) refill(now int64) {
+	if d := now - rl.last; d > 0 {
+		n := d*rl.rate + rl.frac // e.g. 1ms at 500 bytes/s is half a byte, kept for the next one
+		rl.tokens += n / 1000
+		rl.frac = n % 1000
+		if rl.tokens >= rl.burst {
+			rl.tokens, rl.frac = rl.burst, 0
+		}
+		rl.last = now
+	}
+}
@@ -0,0 +1,85 @@
+package goev
+
+import (
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// countConn reads until EAGAIN and counts the bytes
+type countConn struct {
+	IOHandle
+
+	n atomic.Int64
+}
+
+func (c *countConn) OnRead() bool {
+	for {
+		_, n, err := c.Read()
+		if err == syscall.EAGAIN {
+			return true
+		}
+		if n <= 0 {
+			return false
+		}
+		c.n.Add(int64(n))
+	}
+}
+func (c *countConn) OnClose() {}
+
+func TestReadRateLimit(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := newSocketPair(t)
+	const rate, burst = 64 * 1024, 16 * 1024
+
+	c := &countConn{}
+	errC := make(chan error, 1)
+	r.Post(fd, func() { // within the evpoll coroutine
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			errC <- err
+			return
+		}
+		errC <- c.SetReadRateLimit(c, rate, burst)
+	})
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { // a burst much faster than the limit
+		bf := make([]byte, 64*1024)
+		for {
+			if _, err := syscall.Write(peer, bf); err != nil {
+				return
+			}
+		}
+	}()
+	begin := time.Now()
+	time.Sleep(500 * time.Millisecond)
+	got := c.n.Load()
+	max := burst + int64(time.Since(begin).Seconds()*rate) + 8192
+	if got > max || got < rate/4 {
+		t.Fatalf("read %d bytes in 500ms, want %d~%d at %d bytes/s", got, rate/4, max, rate)
+	}
+
+	// disabled, read as fast as the peer writes
+	r.Post(fd, func() { errC <- c.SetReadRateLimit(c, 0, 0) })
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	got = c.n.Load()
+	time.Sleep(100 * time.Millisecond)
+	if d := c.n.Load() - got; d < rate {
+		t.Fatalf("read %d bytes in 100ms without limit", d)
+	}
+}
+
+func TestReadLimiterRefill(t *testing.T) {
+	rl := &readLimiter{rate: 500, burst: 1000}
+	for now := int64(1); now <= 1000; now++ { // 1ms steps, half a byte each
+		rl.refill(now)
+	}
+	if rl.tokens != 500 {
+		t.Fatalf("%d tokens refilled in 1s at 500 bytes/s", rl.tokens)
+	}
+}
@@ -0,0 +1,62 @@
+package goev
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isDeadFd tells whether err of epoll_ctl or of an I/O on a registered fd means that the fd has
+// been closed out of band, i.e. not through the reactor (by the handler or external code).
+// The kernel drops the epoll registration of a file once its last fd is closed, so the fd gets no
+// event any more and its number may be reused by a new file.
+func isDeadFd(err error) bool {
+	return err == syscall.EBADF || err == syscall.ENOENT
+}
+
+// reapDeadFd cleans up the registration of fd closed out of band: the registry entry is deleted
+// at once (so that the fd number can be registered again) and OnClose of the handler is called
+// within the evpoll coroutine, once. Fd() returns -1 within that OnClose, so that the handler
+// does not close the fd number again, it may belong to a new file by then.
+//
+// It can be called from any goroutine.
+func (ep *evPoll) reapDeadFd(fd int) {
+	eh := ep.evHandlerMap.unregister(fd)
+	if eh == nil { // reaped or closed already
+		return
+	}
+	eh.releaseConn()
+	ep.stats.deadFdReaps.Add(1)
+	ep.push(asyncWriteItem{fd: fd, fn: func() {
+		if !eh.setClosed() {
+			return
+		}
+		ep.cancelTimer(eh)
+		if ep.tracer != nil {
+			ep.closeReason(eh, ClosedByError)
+			ep.traceClosed(eh, fd)
+		}
+		eh.setFd(-1)
+		eh.OnClose()
+	}})
+}
+
+// staleFd tells whether the registration of fd is stale: the fd has been closed out of band and
+// its number reused by a file not registered with epoll yet. It is probed by epoll_ctl(MOD) with
+// the events of the registration, which fails with ENOENT for a new file and changes nothing for
+// the file registered. A oneshot registration is not probed, the probe would rearm it.
+func (ep *evPoll) staleFd(fd int) bool {
+	ed := ep.evHandlerMap.load(fd)
+	if ed == nil || ed.events&syscall.EPOLLONESHOT != 0 {
+		return false
+	}
+	ev := syscall.EpollEvent{Events: ed.events}
+	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
+	return syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, fd, &ev) == syscall.ENOENT
+}
+
+// reapDead reaps the registration of h after its I/O failed with err, see reapDeadFd
+func (h *IOHandle) reapDead(err error) {
+	if err == syscall.EBADF && h._ep != nil && h._fd > 0 {
+		h._ep.reapDeadFd(h._fd)
+	}
+}
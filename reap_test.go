@@ -0,0 +1,106 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// deadConn records the Fd() seen by OnClose
+type deadConn struct {
+	IOHandle
+
+	readC   chan struct{}
+	closedC chan int
+}
+
+func newDeadConn(t *testing.T, r *Reactor) (*deadConn, int, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fds[1]) })
+	c := &deadConn{readC: make(chan struct{}, 1), closedC: make(chan int, 2)}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	return c, fds[0], fds[1]
+}
+
+func (c *deadConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n > 0 {
+		c.readC <- struct{}{}
+	}
+	return n > 0
+}
+
+func (c *deadConn) OnClose() {
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+	}
+	c.closedC <- c.Fd()
+}
+
+// waitReaped checks that OnClose of c is called once, with Fd() -1
+func waitReaped(t *testing.T, r *Reactor, c *deadConn, fd int) {
+	select {
+	case got := <-c.closedC:
+		if got != -1 {
+			t.Fatalf("Fd() %d in OnClose of a reaped fd", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("fd %d not reaped", fd)
+	}
+	select {
+	case <-c.closedC:
+		t.Fatalf("OnClose of fd %d called twice", fd)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if eh := r.GetHandler(fd); eh == c {
+		t.Fatalf("fd %d still registered", fd)
+	}
+}
+
+func TestReapDeadFd(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1), EvLogger(&captureLogger{}))
+
+	// written after closed behind the reactor's back
+	c, fd, _ := newDeadConn(t, r)
+	syscall.Close(fd)
+	runIn(t, r, fd, func() {
+		if _, err := c.Write([]byte("x")); err != syscall.EBADF {
+			t.Errorf("write got %v", err)
+		}
+	})
+	waitReaped(t, r, c, fd)
+
+	// modified after closed
+	c, fd, _ = newDeadConn(t, r)
+	syscall.Close(fd)
+	if err := r.EnableWrite(fd, true); err != nil {
+		t.Fatal(err)
+	}
+	waitReaped(t, r, c, fd)
+
+	// the number of the fd closed is reused by a new connection, which can be registered
+	c, fd, _ = newDeadConn(t, r)
+	syscall.Close(fd)
+	next, nextFd, peer := newDeadConn(t, r)
+	if nextFd != fd {
+		t.Skipf("fd %d not reused (%d)", fd, nextFd)
+	}
+	waitReaped(t, r, c, fd)
+	syscall.Write(peer, []byte("x"))
+	select {
+	case <-next.readC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the new connection not read")
+	}
+	if r.GetHandler(fd) != next {
+		t.Fatal("the new connection not registered")
+	}
+	if n := r.Stats()[0].DeadFdReaps; n != 3 {
+		t.Fatalf("%d dead fds reaped, want 3", n)
+	}
+}
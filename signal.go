@@ -0,0 +1,80 @@
+package goev
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// SignalHandler receives the signals registered by Reactor.HandleSignals
+type SignalHandler interface {
+	// OnSignal is called within the first evpoll coroutine, like the other callbacks of the
+	// reactor, so it can access the handlers of that evpoll without locking
+	OnSignal(sig syscall.Signal)
+}
+
+// HandleSignals delivers sigs to h.OnSignal within the evpoll, h nil means Shutdown on any of them
+// (bounded by GracefulCloseTimeout). It replaces the previous registration, the default handling
+// of sigs is restored when Run returns.
+//
+// The Go runtime owns the signal handlers and does not keep a signal blocked in all threads, so
+// signalfd can't be used, sigs are received by os/signal and queued to the evpoll like Post.
+func (r *Reactor) HandleSignals(h SignalHandler, sigs ...syscall.Signal) error {
+	if len(sigs) == 0 {
+		return errors.New("HandleSignals: no signal")
+	}
+	if h == nil {
+		h = shutdownOnSignal{r}
+	}
+	r.stopSignals()
+
+	c := make(chan os.Signal, 8)
+	osSigs := make([]os.Signal, len(sigs))
+	for i := range sigs {
+		osSigs[i] = sigs[i]
+	}
+	signal.Notify(c, osSigs...)
+	go func() {
+		ep := &r.evPolls[0]
+		for sig := range c {
+			s := sig.(syscall.Signal)
+			ep.push(asyncWriteItem{fn: func() { h.OnSignal(s) }})
+		}
+	}()
+	r.sigMtx.Lock()
+	r.sigC = c
+	r.sigMtx.Unlock()
+	return nil
+}
+
+// stopSignals restores the default handling of the signals registered by HandleSignals
+func (r *Reactor) stopSignals() {
+	r.sigMtx.Lock()
+	defer r.sigMtx.Unlock()
+	if r.sigC != nil {
+		signal.Stop(r.sigC)
+		close(r.sigC) // no more sends after signal.Stop
+		r.sigC = nil
+	}
+}
+
+// shutdownOnSignal is the default SignalHandler
+type shutdownOnSignal struct {
+	r *Reactor
+}
+
+func (s shutdownOnSignal) OnSignal(sig syscall.Signal) {
+	// Shutdown waits for the evpolls, it can't be called within one of them
+	go func() {
+		ctx := context.Background()
+		if s.r.gracefulTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(s.r.gracefulTimeout)*time.Millisecond)
+			defer cancel()
+		}
+		s.r.Shutdown(ctx)
+	}()
+}
@@ -0,0 +1,59 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+type sigRecorder struct {
+	r    *Reactor
+	sigC chan syscall.Signal
+}
+
+func (s *sigRecorder) OnSignal(sig syscall.Signal) {
+	if !s.r.evPolls[0].running.Load() {
+		panic("OnSignal not within the evpoll")
+	}
+	s.sigC <- sig
+}
+
+func TestReactorHandleSignals(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	h := &sigRecorder{r: r, sigC: make(chan syscall.Signal, 1)}
+	if err := r.HandleSignals(h, syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	select {
+	case sig := <-h.sigC:
+		if sig != syscall.SIGUSR1 {
+			t.Fatalf("got %v, want SIGUSR1", sig)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnSignal not called")
+	}
+
+	// the default handler shuts the reactor down
+	r2, err := NewReactor(EvPollNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.HandleSignals(nil, syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	exitC := make(chan error, 1)
+	go func() { exitC <- r2.Run() }()
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	select {
+	case err := <-exitC:
+		if err != ErrReactorClosed {
+			t.Fatalf("Run returned %v, want ErrReactorClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not shut down on the signal")
+	}
+	if r2.sigC != nil {
+		t.Fatal("signal handling not restored after Run returned")
+	}
+}
@@ -0,0 +1,233 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// The maximum number of bytes moved by a single splice
+const spliceChunk = 64 * 1024
+
+// SpliceProxy relays the data between two connected fds in both directions by splice(2), the data
+// moves kernel-to-kernel through a pipe per direction without being copied to userspace.
+//
+// When a sink can't take more, reading from the source is paused (EvIn disabled) until the pipe has
+// been drained in OnWrite, so the memory of a proxy is bounded by the pipe capacity. The EOF of a
+// source is forwarded by shutdown(SHUT_WR) after the pending data, the proxy is closed (both fds,
+// the pipes) when both directions have finished or on error.
+//
+// Both fds MUST be non-blocking, they are registered on the same evpoll.
+type SpliceProxy struct {
+	a, b    *spliceEnd
+	closed  bool
+	onClose func()
+}
+
+// spliceEnd is the EvHandler of one fd, it reads into pipe and writes the pipe of peer
+type spliceEnd struct {
+	IOHandle
+
+	proxy *SpliceProxy
+	peer  *spliceEnd
+
+	pipe       [2]int // the data read from this fd, to be written to peer
+	buffered   int    // bytes in pipe
+	eof        bool   // this fd has reached EOF
+	done       bool   // the direction this -> peer has finished
+	hup        bool   // removed from the evpoll on EPOLLHUP, read without waiting
+	outEnabled bool
+}
+
+// NewSpliceProxy registers fdA and fdB and starts relaying, onClose (may be nil) is called within
+// the evpoll coroutine after the proxy has been closed.
+func NewSpliceProxy(r *Reactor, fdA, fdB int, onClose func()) (*SpliceProxy, error) {
+	p := &SpliceProxy{onClose: onClose}
+	p.a = &spliceEnd{proxy: p, pipe: [2]int{-1, -1}}
+	p.b = &spliceEnd{proxy: p, pipe: [2]int{-1, -1}, peer: p.a}
+	p.a.peer = p.b
+	for _, e := range []*spliceEnd{p.a, p.b} {
+		if err := syscall.Pipe2(e.pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+			p.closePipes()
+			return nil, errors.New("SpliceProxy pipe2: " + err.Error())
+		}
+	}
+	idx := r.evPollIndex(fdA) // the same evpoll, the two ends manipulate each other
+	if err := r.addEvHandlerTo(idx, p.a, fdA, EvIn); err != nil {
+		p.closePipes()
+		return nil, errors.New("SpliceProxy: " + err.Error())
+	}
+	if err := r.addEvHandlerTo(idx, p.b, fdB, EvIn); err != nil {
+		r.RemoveEvHandler(p.a, fdA)
+		p.closePipes()
+		return nil, errors.New("SpliceProxy: " + err.Error())
+	}
+	return p, nil
+}
+
+// OnRead moves the data of this fd to peer until EAGAIN or peer can't take more
+func (e *spliceEnd) OnRead() bool {
+	for {
+		if e.buffered > 0 {
+			if err := e.drain(); err != nil {
+				return false
+			}
+			if e.buffered > 0 { // backpressure, resumed by peer.OnWrite
+				return e.pause() == nil
+			}
+		}
+		n, err := splice(e.Fd(), e.pipe[1], spliceChunk)
+		if err == syscall.EAGAIN {
+			return true
+		} else if err != nil {
+			return false
+		}
+		if n == 0 {
+			return e.onEOF()
+		}
+		e.buffered += n
+	}
+}
+
+// OnWrite drains the pipe of peer, and resumes reading peer
+func (e *spliceEnd) OnWrite() bool {
+	p := e.peer
+	if err := p.drain(); err != nil {
+		return false
+	}
+	if p.buffered > 0 {
+		return true
+	}
+	if e.outEnabled {
+		e.outEnabled = false
+		if e.getEvPoll().subtract(e.Fd(), syscall.EPOLLOUT) != nil {
+			return false
+		}
+	}
+	if p.eof {
+		return p.finish()
+	}
+	if p.hup {
+		return p.OnRead()
+	}
+	return e.getEvPoll().append(p.Fd(), EvIn) == nil // level-triggered, OnRead if readable
+}
+
+// OnClose closes the whole proxy
+func (e *spliceEnd) OnClose() {
+	e.proxy.close()
+}
+
+// onHangUp both directions of this fd are shut down. If the writing side has been shut down by the
+// proxy (EOF forwarded), the data received before the peer shut down its writing side is still
+// readable, so the fd is removed from the evpoll and read until EOF.
+func (e *spliceEnd) onHangUp() bool {
+	if !e.peer.done { // the peer can't receive any more
+		return false
+	}
+	e.hup = true
+	if e.getEvPoll().remove(e.Fd()) != nil {
+		return false
+	}
+	if !e.eof && e.OnRead() == false {
+		e.proxy.close()
+	}
+	return true
+}
+
+// drain writes the pipe to peer, EAGAIN is not an error
+func (e *spliceEnd) drain() error {
+	for e.buffered > 0 {
+		n, err := splice(e.pipe[0], e.peer.Fd(), e.buffered)
+		if err == syscall.EAGAIN {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		e.buffered -= n
+	}
+	return nil
+}
+
+// pause stops reading this fd until the pipe is drained to peer
+func (e *spliceEnd) pause() error {
+	if !e.hup {
+		if err := e.getEvPoll().subtract(e.Fd(), syscall.EPOLLIN); err != nil {
+			return err
+		}
+	}
+	if e.peer.outEnabled {
+		return nil
+	}
+	e.peer.outEnabled = true
+	// without EPOLLRDHUP, which would be reported repeatedly after the EOF of peer
+	return e.getEvPoll().append(e.peer.Fd(), syscall.EPOLLOUT)
+}
+
+// onEOF stops reading, the direction finishes once the pipe is drained
+func (e *spliceEnd) onEOF() bool {
+	e.eof = true
+	if !e.hup && e.getEvPoll().subtract(e.Fd(), syscall.EPOLLIN|syscall.EPOLLRDHUP) != nil {
+		return false
+	}
+	if e.buffered > 0 {
+		return e.pause() == nil
+	}
+	return e.finish()
+}
+
+// finish forwards EOF to peer, returns false if both directions have finished
+func (e *spliceEnd) finish() bool {
+	e.done = true
+	syscall.Shutdown(e.peer.Fd(), syscall.SHUT_WR)
+	if e.peer.done {
+		// evpoll calls e.OnClose (the proxy) if e is the handler being dispatched, otherwise
+		// e is peer of the dispatched one and the return value is for peer, both close the proxy
+		return false
+	}
+	return true
+}
+
+// close removes both fds from the reactor and closes them and the pipes
+func (p *SpliceProxy) close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, e := range []*spliceEnd{p.a, p.b} {
+		if fd := e.Fd(); fd > 0 {
+			if ed := e.getEvPoll().loadEvData(fd); ed != nil && ed.eh == EvHandler(e) {
+				e.getEvPoll().remove(fd)
+			}
+			syscall.Close(fd)
+			e.setFd(-1)
+		}
+	}
+	p.closePipes()
+	if p.onClose != nil {
+		p.onClose()
+	}
+}
+
+func (p *SpliceProxy) closePipes() {
+	for _, e := range []*spliceEnd{p.a, p.b} {
+		for i, fd := range e.pipe {
+			if fd >= 0 {
+				syscall.Close(fd)
+				e.pipe[i] = -1
+			}
+		}
+	}
+}
+
+// splice moves up to n bytes from in to out (one of them is a pipe), ignoring EINTR
+func splice(in, out, n int) (int, error) {
+	for {
+		m, err := unix.Splice(in, nil, out, nil, n, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+		if err == syscall.EINTR {
+			continue
+		}
+		return int(m), err
+	}
+}
@@ -0,0 +1,137 @@
+package goev
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// spliceSocketPair returns a non-blocking fd for the proxy and a blocking peer, the peer is closed
+// in Cleanup
+func spliceSocketPair(t *testing.T) (int, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fds[1]) })
+	syscall.SetNonblock(fds[0], true)
+	return fds[0], fds[1]
+}
+
+// readAll reads fd until EOF
+func readAll(fd int) ([]byte, error) {
+	var got []byte
+	bf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(fd, bf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return got, err
+		}
+		if n == 0 {
+			return got, nil
+		}
+		got = append(got, bf[:n]...)
+	}
+}
+
+func TestSpliceProxy(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	a, clientA := spliceSocketPair(t)
+	b, clientB := spliceSocketPair(t)
+	closeC := make(chan struct{})
+	if _, err := NewSpliceProxy(r, a, b, func() { close(closeC) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// both directions at the same time, larger than the socket buffers and the pipes
+	dataA := make([]byte, 8*1024*1024+7)
+	dataB := make([]byte, 3*1024*1024+5)
+	rand.Read(dataA)
+	rand.Read(dataB)
+	gotC := make(chan []byte, 2)
+	for _, c := range []struct {
+		from, to int
+		data     []byte
+	}{{clientA, clientB, dataA}, {clientB, clientA, dataB}} {
+		go func(from int, data []byte) {
+			for len(data) > 0 {
+				n, err := syscall.Write(from, data)
+				if err != nil {
+					return
+				}
+				data = data[n:]
+			}
+			syscall.Shutdown(from, syscall.SHUT_WR) // EOF is forwarded
+		}(c.from, c.data)
+		go func(to int, slow bool) {
+			if slow { // backpressure, the proxy pauses reading
+				time.Sleep(100 * time.Millisecond)
+			}
+			got, _ := readAll(to)
+			gotC <- got
+		}(c.to, c.to == clientB)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-gotC:
+			if !bytes.Equal(got, dataA) && !bytes.Equal(got, dataB) {
+				t.Fatalf("received %d bytes, not identical", len(got))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("proxy did not forward the data and EOF")
+		}
+	}
+	select {
+	case <-closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy not closed after both directions finished")
+	}
+	var st syscall.Stat_t
+	if syscall.Fstat(a, &st) != syscall.EBADF || syscall.Fstat(b, &st) != syscall.EBADF {
+		t.Fatal("fds not closed")
+	}
+}
+
+func TestSpliceProxyPeerClose(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, clientA := spliceSocketPair(t)
+	b, clientB := spliceSocketPair(t)
+	closeC := make(chan struct{})
+	if _, err := NewSpliceProxy(r, a, b, func() { close(closeC) }); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(clientA, []byte("hello"))
+	bf := make([]byte, 5)
+	if _, err := io.ReadFull(fdReader(clientB), bf); err != nil || string(bf) != "hello" {
+		t.Fatalf("read %q %v", bf, err)
+	}
+	syscall.Shutdown(clientB, syscall.SHUT_RDWR) // reset by B
+	select {
+	case <-closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy not closed")
+	}
+	if got, err := readAll(clientA); err != nil || len(got) != 0 {
+		t.Fatalf("read after proxy closed: %q %v, want EOF", got, err)
+	}
+}
+
+// fdReader is an io.Reader of a blocking fd
+type fdReader int
+
+func (fd fdReader) Read(p []byte) (int, error) {
+	n, err := syscall.Read(int(fd), p)
+	if n == 0 && err == nil {
+		return 0, io.EOF
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
@@ -0,0 +1,169 @@
+package goev
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownState is returned (wrapped) by StateMachine when it enters a state without handler
+var ErrUnknownState = errors.New("goev: unknown state")
+
+// StateFunc handles the data of rb in the current state of a StateMachine: it consumes (Discard)
+// what it has handled and returns the next state, the current one to wait for more data. An error
+// stops StateMachine.Feed, the connection should be closed then.
+type StateFunc[S comparable] func(rb *ReadBuffer) (S, error)
+
+type stateDef[S comparable] struct {
+	fn      StateFunc[S]
+	timeout int64 // millisecond, 0 no limit
+}
+
+// StateMachine drives the protocol states of a connection (e.g. awaiting-header, awaiting-body,
+// idle) from OnRead: Feed calls the StateFunc of the current state until it needs more data. A state
+// may have a timeout, the time the connection can stay in it, checked by a timer of the evpoll.
+//
+// It is not thread-safe, use it only within the evpoll coroutine of the connection. For example:
+//
+//	c.sm = goev.NewStateMachine(c, stateHeader)
+//	c.sm.Handle(stateHeader, c.onHeader, 5000)
+//	c.sm.Handle(stateBody, c.onBody, 30000)
+//
+//	func (c *Conn) OnOpen(fd int) bool {
+//	    ... // register c with the reactor
+//	    return c.sm.Start() == nil
+//	}
+//	func (c *Conn) OnRead() bool {
+//	    _, err := c.rb.ReadFromHandler(c)
+//	    if c.sm.Feed(c.rb) != nil {
+//	        return false
+//	    }
+//	    return err == nil
+//	}
+//	func (c *Conn) OnClose() {
+//	    c.sm.Stop()
+//	    ...
+//	}
+type StateMachine[S comparable] struct {
+	eh        EvHandler
+	state     S
+	states    map[S]stateDef[S]
+	onTimeout func(state S) bool
+	timerID   TimerID
+}
+
+// NewStateMachine return an instance for the connection eh, starting in the state initial. eh MUST
+// be the handler registered with the reactor, the timeouts close only that one.
+func NewStateMachine[S comparable](eh EvHandler, initial S) *StateMachine[S] {
+	return &StateMachine[S]{
+		eh:     eh,
+		state:  initial,
+		states: make(map[S]stateDef[S]),
+	}
+}
+
+// Handle sets the StateFunc of state, the connection is closed if it stays in state longer than
+// timeout (millisecond, 0 no limit), see SetTimeoutHandler.
+func (sm *StateMachine[S]) Handle(state S, fn StateFunc[S], timeout int64) error {
+	if fn == nil || timeout < 0 {
+		return errors.New("StateMachine: invalid params")
+	}
+	sm.states[state] = stateDef[S]{fn: fn, timeout: timeout}
+	return nil
+}
+
+// SetTimeoutHandler sets fn called when the timeout of state expires, within the evpoll coroutine.
+// The connection is closed if fn returns false (or without fn), otherwise it may have transitioned
+// to another state, or the timeout of state restarts.
+func (sm *StateMachine[S]) SetTimeoutHandler(fn func(state S) bool) {
+	sm.onTimeout = fn
+}
+
+// State returns the current state
+func (sm *StateMachine[S]) State() S {
+	return sm.state
+}
+
+// Start enters the initial state, i.e. starts its timeout. It MUST be called after eh is registered
+// with the reactor (e.g. in OnOpen).
+func (sm *StateMachine[S]) Start() error {
+	return sm.Transition(sm.state)
+}
+
+// Transition enters the state to (again if it is the current one), restarting the timeout
+func (sm *StateMachine[S]) Transition(to S) error {
+	def, ok := sm.states[to]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownState, to)
+	}
+	sm.Stop()
+	sm.state = to
+	if def.timeout == 0 {
+		return nil
+	}
+	ep := sm.eh.getEvPoll()
+	if ep == nil {
+		return errors.New("goev: StateMachine ev handler has not been added to the reactor yet")
+	}
+	id, err := ep.scheduleTimerFunc(sm.eh, def.timeout, 0, sm.checkTimeout, nil)
+	sm.timerID = id
+	return err
+}
+
+// Feed calls the StateFunc of the current state with rb, again as long as it transitions or
+// consumes data, i.e. until it waits for more data in the same state or returns an error.
+func (sm *StateMachine[S]) Feed(rb *ReadBuffer) error {
+	for {
+		def, ok := sm.states[sm.state]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrUnknownState, sm.state)
+		}
+		before, state := rb.Len(), sm.state
+		next, err := def.fn(rb)
+		if err != nil {
+			return err
+		}
+		if sm.state != state { // transitioned by fn
+			continue
+		}
+		if next != state {
+			if err = sm.Transition(next); err != nil {
+				return err
+			}
+		} else if rb.Len() == before || rb.Len() == 0 {
+			return nil
+		}
+	}
+}
+
+// Stop cancels the timeout of the current state, e.g. in OnClose
+func (sm *StateMachine[S]) Stop() {
+	if sm.timerID.ti != nil {
+		sm.eh.getEvPoll().cancelTimerID(sm.timerID)
+		sm.timerID = TimerID{}
+	}
+}
+
+func (sm *StateMachine[S]) checkTimeout(millisecond int64, _ any) bool {
+	sm.timerID = TimerID{}
+	fd := sm.eh.Fd()
+	if fd < 1 {
+		return false
+	}
+	ep := sm.eh.getEvPoll()
+	// removed without Stop, the fd number may be registered by another handler since
+	ed := ep.loadEvData(fd)
+	if ed == nil || ed.fd != fd || ed.eh != sm.eh {
+		return false
+	}
+	state := sm.state
+	if sm.onTimeout != nil && sm.onTimeout(state) {
+		if sm.state == state && sm.timerID.ti == nil {
+			sm.Transition(state)
+		}
+		return false
+	}
+	if ed = ep.loadEvData(fd); ed != nil && ed.fd == fd && ed.eh == sm.eh { // not closed by onTimeout
+		ep.closeEvData(ed)
+	}
+	return false
+}
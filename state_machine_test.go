@@ -0,0 +1,235 @@
+package goev
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type reqState int
+
+const (
+	reqIdle   reqState = iota // waiting for a request
+	reqHeader                 // waiting for the "<body size>\n" line
+	reqBody                   // waiting for the body
+)
+
+// reqConn parses the requests "<body size>\n<body>" with a StateMachine, replies "ok:<body>"
+type reqConn struct {
+	IOHandle
+
+	rb       *ReadBuffer
+	sm       *StateMachine[reqState]
+	bodyLen  int
+	enterC   chan reqState // the states entered
+	timeoutC chan reqState
+}
+
+func newReqConn(r *Reactor) *reqConn {
+	c := &reqConn{
+		rb:       NewReadBuffer(64, 1024),
+		enterC:   make(chan reqState, 64),
+		timeoutC: make(chan reqState, 4),
+	}
+	c.setReactor(r)
+	c.sm = NewStateMachine[reqState](c, reqIdle)
+	c.sm.Handle(reqIdle, c.onIdle, 0)
+	c.sm.Handle(reqHeader, c.onHeader, 100)
+	c.sm.Handle(reqBody, c.onBody, 100)
+	c.sm.SetTimeoutHandler(c.onTimeout)
+	return c
+}
+
+func (c *reqConn) OnOpen(fd int) bool {
+	if err := c.GetReactor().AddEvHandler(c, fd, EvIn); err != nil {
+		return false
+	}
+	return c.sm.Start() == nil
+}
+func (c *reqConn) OnRead() bool {
+	_, err := c.rb.ReadFromHandler(c)
+	if c.sm.Feed(c.rb) != nil {
+		return false
+	}
+	return err == nil
+}
+func (c *reqConn) OnClose() {
+	c.sm.Stop()
+	c.rb.Release()
+	if c.Fd() != -1 {
+		syscall.Close(c.Fd())
+		c.Destroy(c)
+	}
+}
+
+func (c *reqConn) enter(s reqState) (reqState, error) {
+	c.enterC <- s
+	return s, nil
+}
+
+func (c *reqConn) onIdle(rb *ReadBuffer) (reqState, error) {
+	if rb.Len() == 0 {
+		return reqIdle, nil
+	}
+	return c.enter(reqHeader)
+}
+
+func (c *reqConn) onHeader(rb *ReadBuffer) (reqState, error) {
+	i := bytes.IndexByte(rb.Bytes(), '\n')
+	if i < 0 {
+		return reqHeader, nil
+	}
+	n, err := strconv.Atoi(string(rb.Bytes()[:i]))
+	if err != nil {
+		return reqHeader, errors.New("bad header")
+	}
+	rb.Discard(i + 1)
+	c.bodyLen = n
+	return c.enter(reqBody)
+}
+
+func (c *reqConn) onBody(rb *ReadBuffer) (reqState, error) {
+	body := rb.Peek(c.bodyLen)
+	if body == nil {
+		return reqBody, nil
+	}
+	c.Write(append([]byte("ok:"), body...))
+	rb.Discard(c.bodyLen)
+	return c.enter(reqIdle)
+}
+
+// onTimeout drops an incomplete header and waits for the next request, closes on a body timeout
+func (c *reqConn) onTimeout(s reqState) bool {
+	c.timeoutC <- s
+	if s != reqHeader {
+		return false
+	}
+	c.rb.Reset()
+	c.sm.Transition(reqIdle)
+	c.enterC <- reqIdle
+	return true
+}
+
+func TestStateMachine(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	conns := make(chan *reqConn, 1)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := newReqConn(r)
+		conns <- c
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-conns
+
+	expect := func(states ...reqState) {
+		t.Helper()
+		for _, want := range states {
+			select {
+			case s := <-c.enterC:
+				if s != want {
+					t.Fatalf("entered %d, want %d", s, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("state %d not entered", want)
+			}
+		}
+	}
+	reply := func(want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		bf := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, bf); err != nil || string(bf) != want {
+			t.Fatalf("replied %q, %v, want %q", bf, err, want)
+		}
+	}
+	timeout := func(want reqState) {
+		t.Helper()
+		select {
+		case s := <-c.timeoutC:
+			if s != want {
+				t.Fatalf("timeout in %d, want %d", s, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no timeout in %d", want)
+		}
+	}
+
+	// a request in pieces, each state waits for more data
+	conn.Write([]byte("1"))
+	expect(reqHeader)
+	conn.Write([]byte("1\nhello"))
+	expect(reqBody)
+	conn.Write([]byte(" world"))
+	expect(reqIdle)
+	reply("ok:hello world")
+
+	// 2 requests at once
+	conn.Write([]byte("2\nab3\ncde"))
+	expect(reqHeader, reqBody, reqIdle, reqHeader, reqBody, reqIdle)
+	reply("ok:abok:cde")
+
+	// the idle state has no timeout, the header timeout is handled
+	time.Sleep(150 * time.Millisecond)
+	conn.Write([]byte("12"))
+	expect(reqHeader)
+	timeout(reqHeader)
+	expect(reqIdle)
+	conn.Write([]byte("1\nx"))
+	expect(reqHeader, reqBody, reqIdle)
+	reply("ok:x")
+
+	// the body timeout closes the connection
+	conn.Write([]byte("5\nab"))
+	expect(reqHeader, reqBody)
+	timeout(reqBody)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := conn.Read(make([]byte, 8)); err != io.EOF {
+		t.Fatalf("read %d, %v after the body timeout, want EOF", n, err)
+	}
+	select {
+	case s := <-c.timeoutC:
+		t.Fatalf("timeout in %d after close", s)
+	default:
+	}
+}
+
+func TestStateMachineTimeoutRemoved(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a := newReqConn(r)
+	b, fd, _ := newDeadConn(t, r) // registered with the fd, a takes it over
+	runIn(t, r, fd, func() {
+		r.RemoveEvHandler(b, fd)
+		if err := r.AddEvHandler(a, fd, EvIn); err != nil {
+			t.Error(err)
+		}
+		a.sm.Transition(reqHeader)
+		r.RemoveEvHandler(a, fd) // without Stop
+		if err := r.AddEvHandler(b, fd, EvIn); err != nil {
+			t.Error(err)
+		}
+	})
+	select {
+	case s := <-a.timeoutC:
+		t.Fatalf("timeout in %d after a was removed", s)
+	case fd := <-b.closedC:
+		t.Fatalf("fd %d of another handler closed by the timeout", fd)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if eh := r.GetHandler(fd); eh != b {
+		t.Fatalf("fd %d registered with %v", fd, eh)
+	}
+}
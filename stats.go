@@ -0,0 +1,81 @@
+package goev
+
+import (
+	"sync/atomic"
+)
+
+// EvPollStats is a snapshot of the runtime statistics of an evpoll
+type EvPollStats struct {
+	EpollWaitCalls  uint64 // number of epoll_wait calls
+	EventsReturned  uint64 // total events returned by epoll_wait
+	EventsProcessed uint64 // events dispatched to EvHandler (excluding the stale ones)
+	HupErrCloses    uint64 // fds closed due to EPOLLHUP/EPOLLERR
+	FdLimitDrops    uint64 // connections dropped by acceptors due to EMFILE/ENFILE
+	Panics          uint64 // panics of EvHandler callbacks recovered (EvPanicRecovery)
+	EventsBufSize   uint64 // current size of the epoll_wait events buffer (EvReadyNum)
+	ConnRejects     uint64 // connections rejected by acceptors due to MaxConnections
+	Restarts        uint64 // restarts after epoll_wait failed, see EvPollRestart
+	Accepts         uint64 // connections accepted by acceptors
+	AcceptErrors    uint64 // accept failures other than EAGAIN/EINTR/ECONNABORTED
+	BytesRead       uint64 // bytes read by IOHandle.Read and ReadBuffer.ReadFromHandler
+	BytesWritten    uint64 // bytes written by IOHandle.Write, AsyncWrite and Writer
+	TimerFires      uint64 // timer callbacks called (OnTimeout or ScheduleTimerFunc)
+	OverloadDefers  uint64 // events deferred by the OverloadPolicy
+	OverloadDrops   uint64 // fds dropped by the OverloadPolicy
+	DeadFdReaps     uint64 // registrations of fds closed out of band cleaned up
+	PostOverflows   uint64 // Reactor.Post calls finding the post queue full, see EvPollPostQueue
+}
+
+// Updated only by the evpoll coroutine, read from any goroutine without blocking evpoll
+type evPollStats struct {
+	epollWaitCalls  atomic.Uint64
+	eventsReturned  atomic.Uint64
+	eventsProcessed atomic.Uint64
+	hupErrCloses    atomic.Uint64
+	fdLimitDrops    atomic.Uint64
+	panics          atomic.Uint64
+	eventsBufSize   atomic.Uint64
+	connRejects     atomic.Uint64
+	restarts        atomic.Uint64
+	accepts         atomic.Uint64
+	acceptErrors    atomic.Uint64
+	bytesRead       atomic.Uint64
+	bytesWritten    atomic.Uint64
+	timerFires      atomic.Uint64
+	overloadDefers  atomic.Uint64
+	overloadDrops   atomic.Uint64
+	deadFdReaps     atomic.Uint64
+	postOverflows   atomic.Uint64
+}
+
+func (s *evPollStats) snapshot() EvPollStats {
+	return EvPollStats{
+		EpollWaitCalls:  s.epollWaitCalls.Load(),
+		EventsReturned:  s.eventsReturned.Load(),
+		EventsProcessed: s.eventsProcessed.Load(),
+		HupErrCloses:    s.hupErrCloses.Load(),
+		FdLimitDrops:    s.fdLimitDrops.Load(),
+		Panics:          s.panics.Load(),
+		EventsBufSize:   s.eventsBufSize.Load(),
+		ConnRejects:     s.connRejects.Load(),
+		Restarts:        s.restarts.Load(),
+		Accepts:         s.accepts.Load(),
+		AcceptErrors:    s.acceptErrors.Load(),
+		BytesRead:       s.bytesRead.Load(),
+		BytesWritten:    s.bytesWritten.Load(),
+		TimerFires:      s.timerFires.Load(),
+		OverloadDefers:  s.overloadDefers.Load(),
+		OverloadDrops:   s.overloadDrops.Load(),
+		DeadFdReaps:     s.deadFdReaps.Load(),
+		PostOverflows:   s.postOverflows.Load(),
+	}
+}
+
+// Stats returns the statistics snapshot of each evpoll, indexed by evpoll number
+func (r *Reactor) Stats() []EvPollStats {
+	s := make([]EvPollStats, r.evPollNum)
+	for i := 0; i < r.evPollNum; i++ {
+		s[i] = r.evPolls[i].stats.snapshot()
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package goev
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReactorStats(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	rfd, wfd := newPipe(t)
+	h := newPipeReader()
+	if err := r.AddEvHandler(h, rfd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	for i := 0; i < n; i++ {
+		syscall.Write(wfd, []byte{'x'})
+		select {
+		case <-h.readC:
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnRead not fired")
+		}
+	}
+	syscall.Close(wfd) // EPOLLHUP on the read end
+	select {
+	case <-h.closeC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not fired")
+	}
+
+	s := r.Stats()[0]
+	if s.EventsProcessed != n+1 {
+		t.Fatalf("EventsProcessed %d, want %d", s.EventsProcessed, n+1)
+	}
+	if s.EventsReturned < s.EventsProcessed {
+		t.Fatalf("EventsReturned %d < EventsProcessed %d", s.EventsReturned, s.EventsProcessed)
+	}
+	if s.EpollWaitCalls < n+1 {
+		t.Fatalf("EpollWaitCalls %d, want >= %d", s.EpollWaitCalls, n+1)
+	}
+	if s.HupErrCloses != 1 {
+		t.Fatalf("HupErrCloses %d, want 1", s.HupErrCloses)
+	}
+}
+
+// countingConn reads into its ReadBuffer until want bytes, then replies by Writer and IOHandle.Write
+type countingConn struct {
+	IOHandle
+
+	rb    *ReadBuffer
+	w     *Writer
+	want  int
+	reply []byte
+}
+
+func (c *countingConn) OnRead() bool {
+	_, err := c.rb.ReadFromHandler(c)
+	if c.rb.Len() >= c.want && c.want > 0 {
+		c.want = 0
+		c.Write([]byte("head")) // the socket is empty, written at once
+		c.w.Write(c.reply)
+	}
+	return err == nil
+}
+func (c *countingConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *countingConn) OnClose()      {}
+
+func TestReactorBytesCount(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(2))
+	fd, peer := newSocketPair(t)
+	const in, out = 100 * 1024, 300 * 1024
+	c := &countingConn{rb: NewReadBuffer(4096, 0), want: in, reply: make([]byte, out)}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	go syscall.Write(peer, make([]byte, in)) // blocking until read
+	readFull(t, peer, 4+out)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.BytesWritten(fd) != 4+out && time.Now().Before(deadline) { // counted after the write returns
+		time.Sleep(time.Millisecond)
+	}
+	if n := r.BytesRead(fd); n != in {
+		t.Fatalf("BytesRead %d, want %d", n, in)
+	}
+	if n := r.BytesWritten(fd); n != 4+out {
+		t.Fatalf("BytesWritten %d, want %d", n, 4+out)
+	}
+	if r.BytesRead(peer) != 0 {
+		t.Fatal("BytesRead of an unregistered fd")
+	}
+	r.RemoveFd(fd)
+	c.Init() // reset when reused
+	if c.BytesRead() != 0 || c.BytesWritten() != 0 {
+		t.Fatal("counters not reset by Init")
+	}
+}
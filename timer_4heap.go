@@ -3,7 +3,6 @@ package goev
 import (
 	"errors"
 	"syscall"
-	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -14,11 +13,63 @@ type timerItem struct {
 	expiredAt int64
 	interval  int64
 	eh        EvHandler
+
+	// not nil if scheduled by ScheduleTimerFunc, otherwise eh.OnTimeout is called
+	fn  func(millisecond int64, arg any) bool
+	arg any
+
+	index int // position in fheap or in the wheel slot, -1 if not in
+	level int // wheel level
+	slot  int // wheel slot
+}
+
+// fire calls the timer callback, returns true if ti should be rescheduled (ti.expiredAt is updated)
+func (ti *timerItem) fire(now int64) bool {
+	eh := ti.eh
+	if ep := eh.getEvPoll(); ep != nil && ep.panicRecovery {
+		defer func() {
+			if v := recover(); v != nil {
+				ti.done(eh)
+				ep.onPanic(eh, eh.Fd(), v)
+			}
+		}()
+	}
+	if ep := eh.getEvPoll(); ep != nil {
+		ep.stats.timerFires.Add(1)
+	}
+	var ok bool
+	if ti.fn != nil {
+		ok = ti.fn(now, ti.arg)
+	} else {
+		ok = eh.OnTimeout(now)
+	}
+	if ti.eh == nil { // canceled in callback
+		return false
+	}
+	if ok == true && ti.interval > 0 {
+		ti.expiredAt = now + ti.interval
+		return true
+	}
+	ti.done(eh)
+	return false
 }
 
+// done marks ti as fired, cancel is a no-op from now on
+func (ti *timerItem) done(eh EvHandler) {
+	ti.eh = nil
+	if ti.fn == nil {
+		eh.setTimerItem(nil) // release timerItem
+	}
+}
+
+// timer4Heap is the default timer driver, a 4-ary min-heap backed by a timerfd registered with the
+// evpoll. The timerfd is armed (one-shot, relative) to the earliest expiration, so OnTimeout is
+// fired by its readiness event instead of the epoll_wait timeout; interval timers are pushed back
+// into the heap after firing.
 type timer4Heap struct {
 	IOHandle
 
+	now            func() int64
 	tfd            int
 	timerfdSettime int64
 	fheap          []*timerItem
@@ -37,12 +88,16 @@ func newTimer4Heap(initCap int) *timer4Heap {
 		panic("TimerfdCreate: " + err.Error())
 	}
 	th := &timer4Heap{
+		now:   wallClock,
 		tfd:   tfd,
 		fheap: make([]*timerItem, 0, initCap),
 	}
 	return th
 }
 
+func (th *timer4Heap) setClock(now func() int64) {
+	th.now = now
+}
 func (th *timer4Heap) timerfd() int {
 	return th.tfd
 }
@@ -60,7 +115,7 @@ func (th *timer4Heap) OnRead() bool {
 	var readTimerfdV int64 = 0 // Compared to var bf [8] byte, the performance is the same
 	var readTimerfdBuf = (*(*[8]byte)(unsafe.Pointer(&readTimerfdV)))[:]
 	syscall.Read(th.tfd, readTimerfdBuf)
-	delay := th.handleExpired(time.Now().UnixMilli())
+	delay := th.handleExpired(th.now())
 	if delay > 0 {
 		th.adjustTimerfd(delay)
 	}
@@ -75,23 +130,23 @@ func (th *timer4Heap) schedule(eh EvHandler, delay, interval int64) error {
 		return errors.New("eh had scheduled")
 	}
 
-	now := time.Now().UnixMilli()
 	ti := &timerItem{
-		expiredAt: now + delay,
+		expiredAt: th.now() + delay,
 		interval:  interval,
 		eh:        eh,
 	}
-	th.fheap = append(th.fheap, ti)
-	th.shiftUp(len(th.fheap) - 1)
+	th.scheduleItem(ti)
 	eh.setTimerItem(ti)
+	return nil
+}
+func (th *timer4Heap) scheduleItem(ti *timerItem) {
+	th.push(ti)
 
 	min := th.fheap[0]
 	if min.expiredAt != th.timerfdSettime {
-		th.adjustTimerfd(min.expiredAt - now)
+		th.adjustTimerfd(min.expiredAt - th.now())
 		th.timerfdSettime = min.expiredAt
 	}
-
-	return nil
 }
 func (th *timer4Heap) scheduleTest(eh EvHandler, delay, interval int64) error {
 	ti := &timerItem{
@@ -99,20 +154,29 @@ func (th *timer4Heap) scheduleTest(eh EvHandler, delay, interval int64) error {
 		interval:  interval,
 		eh:        eh,
 	}
-	th.fheap = append(th.fheap, ti)
-	th.shiftUp(len(th.fheap) - 1)
+	th.push(ti)
 	eh.setTimerItem(ti)
 	return nil
 }
-func (th *timer4Heap) cancel(eh EvHandler) {
+func (th *timer4Heap) cancel(eh EvHandler) bool {
 	ti := eh.getTimerItem()
 	if ti == nil {
-		return
+		return false
+	}
+	th.cancelItem(ti)
+	eh.setTimerItem(nil)
+	return true
+}
+func (th *timer4Heap) cancelItem(ti *timerItem) bool {
+	if ti.eh == nil {
+		return false
+	}
+	if ti.index >= 0 { // not in fheap when called in OnTimeout
+		th.remove(ti)
 	}
 	ti.eh = nil
-	ti.expiredAt = 1 // 防止定时器时间太久导致ti回收被延迟太久(这是不确定的, 因为没有改变ti 在heap的位置)
 	// No need to adjust timerfd
-	eh.setTimerItem(nil)
+	return true
 }
 func (th *timer4Heap) handleExpired(now int64) int64 {
 	if len(th.fheap) == 0 {
@@ -129,12 +193,8 @@ func (th *timer4Heap) handleExpired(now int64) int64 {
 		if item.eh == nil { // canceled
 			continue
 		}
-		if item.eh.OnTimeout(now) == true && item.interval > 0 {
-			item.expiredAt = now + item.interval
-			th.fheap = append(th.fheap, item)
-			th.shiftUp(len(th.fheap) - 1)
-		} else {
-			item.eh.setTimerItem(nil) // release timerItem
+		if item.fire(now) {
+			th.push(item)
 		}
 	}
 	return delta
@@ -154,20 +214,43 @@ func (th *timer4Heap) popOne(now, errorVal int64) (*timerItem, int64) {
 	if delta > errorVal {
 		return nil, delta
 	}
+	th.remove(min)
+	return min, 0
+}
+
+func (th *timer4Heap) push(ti *timerItem) {
+	ti.index = len(th.fheap)
+	th.fheap = append(th.fheap, ti)
+	th.shiftUp(ti.index)
+}
+
+// remove ti from any position of fheap
+func (th *timer4Heap) remove(ti *timerItem) {
+	i := ti.index
 	last := len(th.fheap) - 1
-	th.fheap[0] = th.fheap[last]
+	if i != last {
+		th.swap(i, last)
+	}
+	th.fheap[last] = nil
 	th.fheap = th.fheap[:last]
+	ti.index = -1
+	if i < last {
+		th.shiftDown(i)
+		th.shiftUp(i)
+	}
+}
 
-	th.shiftDown(0)
-
-	return min, 0
+func (th *timer4Heap) swap(i, j int) {
+	th.fheap[i], th.fheap[j] = th.fheap[j], th.fheap[i]
+	th.fheap[i].index = i
+	th.fheap[j].index = j
 }
 
 func (th *timer4Heap) shiftUp(index int) {
 	parent := (index - 1) / 4
 
 	for index > 0 && th.fheap[index].expiredAt < th.fheap[parent].expiredAt {
-		th.fheap[index], th.fheap[parent] = th.fheap[parent], th.fheap[index]
+		th.swap(index, parent)
 		index = parent
 		parent = (index - 1) / 4
 	}
@@ -189,7 +272,7 @@ func (th *timer4Heap) shiftDown(index int) {
 			}
 
 			if smallest != index {
-				th.fheap[index], th.fheap[smallest] = th.fheap[smallest], th.fheap[index]
+				th.swap(index, smallest)
 				index = smallest
 			} else {
 				break
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"golang.org/x/sys/unix"
 	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -43,9 +44,17 @@ func TestTimer4Heap_Algo(t *testing.T) {
 		obj = &fheapTimer{}
 		t4h.scheduleTest(obj, delay, 0)
 	}
-	t4h.cancel(obj)
-	for i := 0; i < 200; i++ {
+	t4h.cancel(obj) // removed from the heap immediately
+	if t4h.size() != 199 {
+		t.Fatalf("size %d after cancel, want 199", t4h.size())
+	}
+	var last int64
+	for i := 0; i < 199; i++ {
 		ti, _ := t4h.popOne(0, 10000000)
+		if ti.expiredAt < last {
+			t.Fatalf("popped %d after %d", ti.expiredAt, last)
+		}
+		last = ti.expiredAt
 		fmt.Println(ti.expiredAt)
 	}
 	fmt.Println("len", t4h.size())
@@ -71,3 +80,167 @@ func TestTimer4Heap(t *testing.T) {
 	}()
 	time.Sleep(time.Second * 10)
 }
+
+type countTimer struct {
+	IOHandle
+
+	fired atomic.Int32
+}
+
+func (t *countTimer) OnTimeout(now int64) bool {
+	t.fired.Add(1)
+	return false
+}
+
+func TestReactorCancelResetTimer(t *testing.T) {
+	for _, wheel := range []bool{false, true} {
+		r, _ := NewReactor(EvPollNum(1), TimerWheel(wheel))
+		add := func(h EvHandler) {
+			fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+			t.Cleanup(func() { unix.Close(fd) })
+			r.AddEvHandler(h, fd, EvIn)
+		}
+		reset, canceled, fired := &countTimer{}, &countTimer{}, &countTimer{}
+		add(reset)
+		add(canceled)
+		add(fired)
+
+		reset.ScheduleTimer(reset, 30, 0)
+		if err := r.ResetTimer(reset, 120); err != nil {
+			t.Fatal(err)
+		}
+		canceled.ScheduleTimer(canceled, 30, 0)
+		if !r.CancelTimer(canceled) || r.CancelTimer(canceled) {
+			t.Fatal("CancelTimer should succeed only once")
+		}
+		fired.ScheduleTimer(fired, 10, 0)
+
+		go r.Run()
+		time.Sleep(80 * time.Millisecond)
+		if n := reset.fired.Load(); n != 0 {
+			t.Fatalf("wheel=%v reset timer fired before the new deadline", wheel)
+		}
+		if r.CancelTimer(fired) {
+			t.Fatalf("wheel=%v cancel a fired one-shot timer should be a no-op", wheel)
+		}
+		time.Sleep(120 * time.Millisecond)
+		r.Stop()
+
+		if n := reset.fired.Load(); n != 1 {
+			t.Fatalf("wheel=%v reset timer fired %d times, want 1", wheel, n)
+		}
+		if n := canceled.fired.Load(); n != 0 {
+			t.Fatalf("wheel=%v canceled timer fired %d times", wheel, n)
+		}
+		if n := fired.fired.Load(); n != 1 {
+			t.Fatalf("wheel=%v one-shot timer fired %d times", wheel, n)
+		}
+	}
+}
+
+func TestScheduleTimerFunc(t *testing.T) {
+	for _, wheel := range []bool{false, true} {
+		r, _ := NewReactor(EvPollNum(1), TimerWheel(wheel))
+		fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		defer unix.Close(fd)
+		h := &countTimer{}
+		r.AddEvHandler(h, fd, EvIn)
+
+		var fired [3]atomic.Int32
+		begin := time.Now()
+		fn := func(now int64, arg any) bool {
+			fired[arg.(int)].Add(1)
+			return true
+		}
+		if _, err := h.ScheduleTimerFunc(h, 30, 0, fn, 0); err != nil {
+			t.Fatal(err)
+		}
+		h.ScheduleTimerFunc(h, 20, 20, fn, 1)
+		id, _ := h.ScheduleTimerFunc(h, 60, 0, fn, 2)
+		h.ScheduleTimer(h, 10, 0) // the OnTimeout timer is independent
+		if !h.CancelTimerID(id) || h.CancelTimerID(id) {
+			t.Fatal("CancelTimerID should succeed only once")
+		}
+
+		go r.Run()
+		waitFor(t, "the repeating timer fired 4 times", func() bool { return fired[1].Load() >= 4 })
+		r.Stop()
+		if elapsed := time.Since(begin); elapsed < 75*time.Millisecond { // millisecond clock
+			t.Fatalf("wheel=%v repeating timer (20ms) fired 4 times in %v", wheel, elapsed)
+		}
+
+		// the others have expired before the 4th tick of the repeating timer
+		if n := fired[0].Load(); n != 1 {
+			t.Fatalf("wheel=%v one-shot timer fired %d times", wheel, n)
+		}
+		if n := fired[2].Load(); n != 0 {
+			t.Fatalf("wheel=%v canceled timer fired %d times", wheel, n)
+		}
+		if n := h.fired.Load(); n != 1 {
+			t.Fatalf("wheel=%v OnTimeout fired %d times", wheel, n)
+		}
+	}
+}
+
+func TestTimer4HeapAccuracy(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1)) // timerfd driven
+	fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	t.Cleanup(func() { unix.Close(fd) })
+	h := &countTimer{}
+
+	const delay, interval, fires = 50, 20, 5
+	onceC, tickC := make(chan time.Time, 1), make(chan time.Time, fires)
+	var begin time.Time
+	errC := make(chan error, 1)
+	r.Post(fd, func() {
+		if err := r.AddEvHandler(h, fd, EvIn); err != nil {
+			errC <- err
+			return
+		}
+		begin = time.Now()
+		if _, err := h.ScheduleTimerFunc(h, delay, 0, func(int64, any) bool {
+			onceC <- time.Now()
+			return false
+		}, nil); err != nil {
+			errC <- err
+			return
+		}
+		n := 0
+		_, err := h.ScheduleTimerFunc(h, interval, interval, func(int64, any) bool {
+			n++
+			tickC <- time.Now()
+			return n < fires
+		}, nil)
+		errC <- err
+	})
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+
+	// the expiration is in millisecond, never early. How late depends on the scheduling latency,
+	// i.e. on the load of the machine, so it is not bounded here.
+	check := func(name string, at time.Time, want time.Duration) {
+		if d := at.Sub(begin); d < want-time.Millisecond {
+			t.Fatalf("%s fired after %v, want %v", name, d, want)
+		}
+	}
+	for i := 1; i <= fires; i++ {
+		select {
+		case at := <-tickC:
+			check(fmt.Sprintf("interval timer#%d", i), at, time.Duration(i*interval)*time.Millisecond)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("interval timer fired %d times, want %d", i-1, fires)
+		}
+	}
+	select {
+	case at := <-onceC:
+		check("one-shot timer", at, delay*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-shot timer not fired")
+	}
+	select {
+	case <-tickC:
+		t.Fatal("interval timer fired after being stopped")
+	case <-time.After(3 * interval * time.Millisecond):
+	}
+}
@@ -0,0 +1,196 @@
+package goev
+
+import (
+	"errors"
+	"time"
+)
+
+// timer is the timer facility of an evpoll, all methods are called within the evpoll coroutine
+type timer interface {
+	schedule(eh EvHandler, delay, interval int64) error
+
+	// cancel returns false if eh has no pending timer
+	cancel(eh EvHandler) bool
+
+	// scheduleItem adds ti which expires at ti.expiredAt
+	scheduleItem(ti *timerItem)
+
+	// cancelItem returns false if ti is not pending
+	cancelItem(ti *timerItem) bool
+
+	// handleExpired calls OnTimeout of the expired timers, returns the delay (millisecond) of the
+	// next check, <= 0 means no pending timer
+	handleExpired(now int64) int64
+
+	// setClock sets the time source (millisecond) of the expirations, see evPoll.nowMilli
+	setClock(now func() int64)
+}
+
+// wallClock is the default time source of the timers
+func wallClock() int64 {
+	return time.Now().UnixMilli()
+}
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits // slots per level
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4 // 1 tick * 64^4 ≈ 4.6 hours, longer delays are cascaded again
+)
+
+// timerWheel is a hierarchical timing wheel (tick = 1 millisecond) like the old linux kernel timer.
+// Unlike timer4Heap it does not own a timerfd, evpoll uses the delay returned by handleExpired as
+// the epoll_wait timeout.
+//
+// Insert and cancel are O(1), suitable for a large number of timers that are mostly canceled
+// before expiring (e.g. idle timeouts).
+type timerWheel struct {
+	now     func() int64
+	current int64 // the next tick to be processed, in millisecond
+	count   int   // number of timerItem in the wheel
+	slots   [wheelLevels][wheelSlots][]*timerItem
+}
+
+func newTimerWheel() *timerWheel {
+	return &timerWheel{now: wallClock, current: wallClock()}
+}
+
+func (tw *timerWheel) setClock(now func() int64) {
+	tw.now = now
+}
+
+func (tw *timerWheel) schedule(eh EvHandler, delay, interval int64) error {
+	if delay < 0 || interval < 0 {
+		return errors.New("params are invalid")
+	}
+	if eh.getTimerItem() != nil {
+		return errors.New("eh had scheduled")
+	}
+	ti := &timerItem{
+		expiredAt: tw.now() + delay,
+		interval:  interval,
+		eh:        eh,
+	}
+	tw.scheduleItem(ti)
+	eh.setTimerItem(ti)
+	return nil
+}
+func (tw *timerWheel) scheduleItem(ti *timerItem) {
+	if tw.count == 0 { // the wheel may not have been advanced while evpoll was blocking
+		tw.current = tw.now()
+	}
+	tw.add(ti)
+}
+func (tw *timerWheel) cancel(eh EvHandler) bool {
+	ti := eh.getTimerItem()
+	if ti == nil {
+		return false
+	}
+	tw.cancelItem(ti)
+	eh.setTimerItem(nil)
+	return true
+}
+func (tw *timerWheel) cancelItem(ti *timerItem) bool {
+	if ti.eh == nil {
+		return false
+	}
+	if ti.index >= 0 { // not in the wheel when called in OnTimeout
+		tw.remove(ti)
+	}
+	ti.eh = nil
+	return true
+}
+
+// remove ti from its slot
+func (tw *timerWheel) remove(ti *timerItem) {
+	items := tw.slots[ti.level][ti.slot]
+	last := len(items) - 1
+	if ti.index != last {
+		items[ti.index] = items[last]
+		items[ti.index].index = ti.index
+	}
+	items[last] = nil
+	tw.slots[ti.level][ti.slot] = items[:last]
+	ti.index = -1
+	tw.count--
+}
+
+// take out all timers of a slot
+func (tw *timerWheel) take(level int, idx int64) []*timerItem {
+	items := tw.slots[level][idx]
+	tw.slots[level][idx] = nil
+	tw.count -= len(items)
+	for _, ti := range items {
+		ti.index = -1
+	}
+	return items
+}
+func (tw *timerWheel) add(ti *timerItem) {
+	expiredAt := ti.expiredAt
+	if expiredAt < tw.current {
+		expiredAt = tw.current
+	}
+	delta := expiredAt - tw.current
+	level := 0
+	for level < wheelLevels-1 && delta >= int64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+	if delta >= int64(1)<<(wheelBits*wheelLevels) { // out of range, cascaded again later
+		expiredAt = tw.current + int64(1)<<(wheelBits*wheelLevels) - 1
+	}
+	idx := (expiredAt >> (wheelBits * level)) & wheelMask
+	ti.level, ti.slot, ti.index = level, int(idx), len(tw.slots[level][idx])
+	tw.slots[level][idx] = append(tw.slots[level][idx], ti)
+	tw.count++
+}
+
+// cascade moves the timers of slots[level][idx] to the lower levels, returns idx
+func (tw *timerWheel) cascade(level int) int64 {
+	idx := (tw.current >> (wheelBits * level)) & wheelMask
+	for _, ti := range tw.take(level, idx) {
+		tw.add(ti)
+	}
+	return idx
+}
+func (tw *timerWheel) handleExpired(now int64) int64 {
+	if tw.count == 0 {
+		tw.current = now + 1
+		return 0
+	}
+	for tw.current <= now {
+		idx := tw.current & wheelMask
+		if idx == 0 {
+			for level := 1; level < wheelLevels && tw.cascade(level) == 0; level++ {
+			}
+		}
+		items := tw.take(0, idx)
+		tw.current++
+		for _, ti := range items {
+			if ti.eh == nil { // canceled in OnTimeout of the previous items
+				continue
+			}
+			if ti.fire(now) {
+				tw.add(ti)
+			}
+		}
+		if tw.count == 0 {
+			tw.current = now + 1
+			return 0
+		}
+	}
+	return tw.nextDelay(now)
+}
+
+// nextDelay returns the delay to the next non-empty slot of level 0, or to the next cascade
+func (tw *timerWheel) nextDelay(now int64) int64 {
+	for i := int64(0); i < wheelSlots; i++ {
+		t := tw.current + i
+		if i > 0 && t&wheelMask == 0 { // cascade point
+			return t - now
+		}
+		if len(tw.slots[0][t&wheelMask]) > 0 {
+			return t - now
+		}
+	}
+	return tw.current + wheelSlots - now
+}
@@ -0,0 +1,127 @@
+package goev
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type wheelTimer struct {
+	IOHandle
+
+	expiredAt int64
+	firedAt   int64
+	fired     atomic.Int32
+	repeat    bool
+}
+
+func (t *wheelTimer) OnTimeout(now int64) bool {
+	t.firedAt = now
+	t.fired.Add(1)
+	return t.repeat
+}
+
+func TestTimerWheel_Algo(t *testing.T) {
+	tw := newTimerWheel()
+	start := tw.current
+
+	var timers []*wheelTimer
+	for i := 0; i < 2000; i++ {
+		delay := rand.Int63() % 300000 // crosses level 0~2
+		wt := &wheelTimer{expiredAt: start + delay}
+		tw.schedule(wt, delay, 0)
+		timers = append(timers, wt)
+	}
+	far := &wheelTimer{} // beyond the range of the wheel (64^4 ms)
+	tw.schedule(far, 5*3600*1000, 0)
+	far.expiredAt = far.getTimerItem().expiredAt
+	timers = append(timers, far)
+	// schedule() reads the clock, so expiredAt of the others can be later than start+delay
+	for _, wt := range timers {
+		wt.expiredAt = wt.getTimerItem().expiredAt
+	}
+	canceled := timers[:100]
+	for _, wt := range canceled {
+		tw.cancel(wt)
+	}
+
+	const step = 7
+	now := start
+	for ; now < start+301000; now += step {
+		tw.handleExpired(now)
+	}
+	now = far.expiredAt + 1
+	tw.handleExpired(now)
+
+	for i, wt := range timers {
+		if i < len(canceled) {
+			if wt.fired.Load() != 0 {
+				t.Fatalf("canceled timer#%d fired", i)
+			}
+			continue
+		}
+		if wt.fired.Load() != 1 {
+			t.Fatalf("timer#%d fired %d times", i, wt.fired.Load())
+		}
+		if i == len(timers)-1 {
+			continue // far
+		}
+		if wt.firedAt < wt.expiredAt || wt.firedAt > wt.expiredAt+step {
+			t.Fatalf("timer#%d expired at %d, fired at %d", i, wt.expiredAt, wt.firedAt)
+		}
+	}
+	if tw.count != 0 {
+		t.Fatalf("%d timerItem left in the wheel", tw.count)
+	}
+}
+
+func TestTimerWheel(t *testing.T) {
+	r, err := NewReactor(EvPollNum(1), TimerWheel(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(wt *wheelTimer) {
+		fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		t.Cleanup(func() { unix.Close(fd) })
+		if err := r.AddEvHandler(wt, fd, EvIn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	once, repeat, canceled := &wheelTimer{}, &wheelTimer{repeat: true}, &wheelTimer{}
+	add(once)
+	add(repeat)
+	add(canceled)
+	begin := time.Now()
+	once.ScheduleTimer(once, 50, 0)
+	repeat.ScheduleTimer(repeat, 20, 20)
+	canceled.ScheduleTimer(canceled, 50, 0)
+	canceled.CancelTimer(canceled)
+
+	go r.Run()
+	defer r.Stop()
+	waitFor(t, "the repeating timer fired 8 times", func() bool { return repeat.fired.Load() >= 8 })
+	if elapsed := time.Since(begin); elapsed < 155*time.Millisecond { // millisecond clock
+		t.Fatalf("repeating timer (20ms) fired 8 times in %v", elapsed)
+	}
+	// the canceled timer expires with the one-shot timer
+	if n := once.fired.Load(); n != 1 {
+		t.Fatalf("one-shot timer fired %d times", n)
+	}
+	if n := canceled.fired.Load(); n != 0 {
+		t.Fatalf("canceled timer fired %d times", n)
+	}
+}
+
+// waitFor polls cond until it is true, fails after 5s, so that the timing assertions do not
+// depend on the load of the machine
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for begin := time.Now(); !cond(); time.Sleep(2 * time.Millisecond) {
+		if time.Since(begin) > 5*time.Second {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package goev
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TLSHandler serves TLS over the raw fd of a connection, it is registered with the reactor in
+// place of the inner EvHandler.
+//
+// crypto/tls runs over a pair of memory buffers (like the memory BIO of OpenSSL), so that the
+// ciphertext is read and written by the evpoll coroutine only. The handshake is driven across
+// multiple OnRead/OnWrite events, the inner OnOpen is called once it completes.
+//
+// crypto/tls can't resume a handshake interrupted by a read error, so the handshake runs in a
+// goroutine which only runs while the evpoll coroutine waits for it (the handler stays single
+// threaded). The goroutine exits when the handshake completes, the handler is closed, the
+// handshake times out (see SetHandshakeTimeout) or the reactor stops.
+//
+// The inner EvHandler:
+//   - MUST NOT register the fd in OnOpen, TLSHandler has done it, inner.Fd() is valid from OnOpen
+//   - reads the plaintext by TLSHandler.Read in OnRead, writes by TLSHandler.Write
+//   - closes the fd in OnClose as usual (OnClose is called only if OnOpen has been called)
+//
+// For example:
+//
+//	func() goev.EvHandler {
+//	    c := new(Conn)
+//	    c.tls = goev.NewTLSHandler(reactor, c, config)
+//	    return c.tls
+//	}
+type TLSHandler struct {
+	IOHandle
+
+	inner  EvHandler
+	conn   *tls.Conn
+	bio    *tlsBIO
+	w      *Writer
+	plain  []byte // decrypted data, returned by Read
+	opened bool   // inner.OnOpen has been called
+
+	// handshake coroutine
+	handshaking      bool
+	resumeC          chan struct{}
+	yieldC           chan error
+	stopC            <-chan struct{} // of the evpoll
+	handshakeTimeout int64           // millisecond
+	handshakeTimer   TimerID
+}
+
+// tlsHandshakeTimeout the default of TLSHandler.SetHandshakeTimeout, millisecond
+const tlsHandshakeTimeout = 10 * 1000
+
+// errTLSWantRead is returned by the handshake coroutine when it waits for more ciphertext
+var errTLSWantRead = errors.New("tls want read")
+
+// NewTLSHandler return a server side instance
+func NewTLSHandler(r *Reactor, inner EvHandler, config *tls.Config) *TLSHandler {
+	t := &TLSHandler{inner: inner, bio: &tlsBIO{}, handshakeTimeout: tlsHandshakeTimeout}
+	t.conn = tls.Server(t.bio, config)
+	t.setReactor(r)
+	return t
+}
+
+// NewTLSClientHandler return a client side instance, used with Connector.
+func NewTLSClientHandler(r *Reactor, inner EvHandler, config *tls.Config) *TLSHandler {
+	t := &TLSHandler{inner: inner, bio: &tlsBIO{}, handshakeTimeout: tlsHandshakeTimeout}
+	t.conn = tls.Client(t.bio, config)
+	t.setReactor(r)
+	return t
+}
+
+// SetHandshakeTimeout sets the time (millisecond) the handshake may take before the connection is
+// closed, 10s by default, 0 no limit. It MUST be called before OnOpen.
+func (t *TLSHandler) SetHandshakeTimeout(timeout int64) {
+	t.handshakeTimeout = timeout
+}
+
+// ConnectionState returns the state of the TLS connection, valid after the handshake
+func (t *TLSHandler) ConnectionState() tls.ConnectionState {
+	return t.conn.ConnectionState()
+}
+
+// Read returns the plaintext decrypted in this OnRead, call it in inner OnRead.
+// bf is valid until the next OnRead.
+func (t *TLSHandler) Read() (bf []byte, n int, err error) {
+	bf, t.plain = t.plain, t.plain[:0]
+	return bf, len(bf), nil
+}
+
+// Write encrypts bf and sends it, the ciphertext which can't be sent at the moment is kept and
+// sent in OnWrite.
+func (t *TLSHandler) Write(bf []byte) (n int, err error) {
+	if n, err = t.conn.Write(bf); err != nil {
+		return n, err
+	}
+	return n, t.flush()
+}
+
+// OnOpen register the fd and start the handshake
+func (t *TLSHandler) OnOpen(fd int) bool {
+	if err := t.GetReactor().AddEvHandler(t, fd, EvIn); err != nil {
+		return false
+	}
+	t.w = NewWriter(t)
+	if t.handshakeTimeout > 0 {
+		id, err := t.ScheduleTimerFunc(t, t.handshakeTimeout, 0, t.checkHandshake, nil)
+		if err != nil {
+			return false
+		}
+		t.handshakeTimer = id
+	}
+	t.handshaking = true
+	t.resumeC = make(chan struct{})
+	t.yieldC = make(chan error)
+	t.stopC = t.getEvPoll().stopC
+	go t.handshake()
+	return t.stepHandshake()
+}
+
+// checkHandshake closes the connection if the handshake has not completed in time
+func (t *TLSHandler) checkHandshake(int64, any) bool {
+	t.handshakeTimer = TimerID{}
+	if !t.handshaking {
+		return false
+	}
+	ep := t.getEvPoll()
+	if ed := ep.loadEvData(t.Fd()); ed != nil && ed.eh == t {
+		ep.closeEvData(ed)
+	}
+	return false
+}
+
+// OnRead reads the ciphertext, continues the handshake or decrypts
+func (t *TLSHandler) OnRead() bool {
+	eof := false
+	for {
+		bf, n, err := t.IOHandle.Read()
+		if n > 0 {
+			t.bio.in = append(t.bio.in, bf...)
+			if n == len(t.getEvPoll().evPollReadBuff) {
+				continue
+			}
+			break
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == nil || err != syscall.EAGAIN { // peer closed or error
+			eof = true
+		}
+		break
+	}
+	if t.handshaking {
+		if t.stepHandshake() == false {
+			return false
+		}
+	}
+	if t.opened && t.decrypt() == false {
+		eof = true
+	}
+	if len(t.plain) > 0 && t.inner.OnRead() == false {
+		return false
+	}
+	return !eof
+}
+
+// OnWrite sends the pending ciphertext
+func (t *TLSHandler) OnWrite() bool {
+	return t.w.Flush() == nil
+}
+
+// OnTimeout is not used by TLSHandler, the timers scheduled by inner are delivered to inner.
+func (t *TLSHandler) OnTimeout(millisecond int64) bool {
+	return false
+}
+
+// OnClose stops the handshake, and calls inner OnClose if it has been opened.
+func (t *TLSHandler) OnClose() {
+	t.CancelTimerID(t.handshakeTimer)
+	t.handshakeTimer = TimerID{}
+	if t.handshaking {
+		t.bio.closed = true
+		t.step()
+		t.handshaking = false
+	}
+	if t.w != nil {
+		t.w.Reset()
+	}
+	if t.opened {
+		t.opened = false
+		t.setFd(-1) // the fd is closed by inner
+		t.inner.OnClose()
+		return
+	}
+	if t.Fd() > 0 {
+		syscall.Close(t.Fd())
+		t.setFd(-1)
+	}
+}
+
+// handshake runs in a goroutine, but only while the evpoll coroutine is waiting in stepHandshake.
+// It gives up when the evpoll stops, nobody would resume it.
+func (t *TLSHandler) handshake() {
+	stopped := false
+	t.bio.yield = func() bool {
+		if !t.yield(errTLSWantRead) || !t.resume() {
+			stopped = true
+			return false
+		}
+		return !t.bio.closed
+	}
+	if !t.resume() {
+		return
+	}
+	err := t.conn.Handshake()
+	if !stopped {
+		t.bio.yield = nil
+		t.yield(err)
+	}
+}
+
+// step resumes the handshake goroutine and waits until it yields, it is called by the evpoll
+// coroutine. net.ErrClosed is returned if the evpoll has stopped in the meantime.
+func (t *TLSHandler) step() error {
+	select {
+	case t.resumeC <- struct{}{}:
+	case <-t.stopC:
+		return net.ErrClosed
+	}
+	select {
+	case err := <-t.yieldC:
+		return err
+	case <-t.stopC:
+		return net.ErrClosed
+	}
+}
+
+// resume waits for stepHandshake (or OnClose), returns false if the evpoll has stopped
+func (t *TLSHandler) resume() bool {
+	select {
+	case <-t.resumeC:
+		return true
+	case <-t.stopC:
+		return false
+	}
+}
+
+// yield hands err back to stepHandshake, returns false if the evpoll has stopped
+func (t *TLSHandler) yield(err error) bool {
+	select {
+	case t.yieldC <- err:
+		return true
+	case <-t.stopC:
+		return false
+	}
+}
+
+// stepHandshake runs the handshake until it needs more ciphertext or completes
+func (t *TLSHandler) stepHandshake() bool {
+	err := t.step()
+	if err != errTLSWantRead {
+		t.handshaking = false
+		t.CancelTimerID(t.handshakeTimer)
+		t.handshakeTimer = TimerID{}
+	}
+	if t.flush() != nil {
+		return false
+	}
+	if err == errTLSWantRead {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	t.inner.setParams(t.Fd(), t.getEvPoll())
+	t.inner.setReactor(t.GetReactor())
+	t.opened = true
+	if t.inner.OnOpen(t.Fd()) == false {
+		return false
+	}
+	return true
+}
+
+// decrypt moves the plaintext of bio.in to t.plain, returns false on close_notify or error
+func (t *TLSHandler) decrypt() bool {
+	bf := t.getEvPoll().evPollReadBuff
+	for {
+		n, err := t.conn.Read(bf)
+		if n > 0 {
+			t.plain = append(t.plain, bf[:n]...)
+		}
+		if err != nil {
+			if t.flush() != nil { // e.g. alert
+				return false
+			}
+			return err == errTLSWouldBlock
+		}
+	}
+}
+
+// flush sends the ciphertext written by crypto/tls
+func (t *TLSHandler) flush() error {
+	if len(t.bio.out) == 0 {
+		return nil
+	}
+	_, err := t.w.Write(t.bio.out)
+	t.bio.out = t.bio.out[:0]
+	return err
+}
+
+// tlsWouldBlockError is a temporary net.Error, crypto/tls can resume reading after it
+type tlsWouldBlockError struct{}
+
+func (tlsWouldBlockError) Error() string   { return "tls would block" }
+func (tlsWouldBlockError) Timeout() bool   { return false }
+func (tlsWouldBlockError) Temporary() bool { return true }
+
+var errTLSWouldBlock net.Error = tlsWouldBlockError{}
+
+// tlsBIO is the net.Conn of crypto/tls over memory buffers
+type tlsBIO struct {
+	in     []byte // ciphertext read from the fd
+	out    []byte // ciphertext to be written to the fd
+	closed bool
+
+	// yield is set during the handshake, it waits for more ciphertext
+	yield func() bool
+}
+
+func (b *tlsBIO) Read(p []byte) (int, error) {
+	for len(b.in) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		if b.yield == nil {
+			return 0, errTLSWouldBlock
+		}
+		if b.yield() == false {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, b.in)
+	b.in = b.in[:copy(b.in, b.in[n:])]
+	return n, nil
+}
+func (b *tlsBIO) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, net.ErrClosed
+	}
+	b.out = append(b.out, p...)
+	return len(p), nil
+}
+func (b *tlsBIO) Close() error                       { b.closed = true; return nil }
+func (b *tlsBIO) LocalAddr() net.Addr                { return nil }
+func (b *tlsBIO) RemoteAddr() net.Addr               { return nil }
+func (b *tlsBIO) SetDeadline(t time.Time) error      { return nil }
+func (b *tlsBIO) SetReadDeadline(t time.Time) error  { return nil }
+func (b *tlsBIO) SetWriteDeadline(t time.Time) error { return nil }
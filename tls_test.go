@@ -0,0 +1,191 @@
+package goev
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// selfSignedConfig returns a server config with a self-signed certificate for "goev" and a client
+// config trusting it
+func selfSignedConfig(t *testing.T) (srv, cli *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "goev"},
+		DNSNames:     []string{"goev"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	srv = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	cli = &tls.Config{RootCAs: pool, ServerName: "goev"}
+	return
+}
+
+// tlsSocketPair returns a non-blocking fd served by the reactor and the net.Conn of the peer
+func tlsSocketPair(t *testing.T) (int, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.SetNonblock(fds[0], true)
+	f := os.NewFile(uintptr(fds[1]), "socketpair")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fds[0], conn
+}
+
+// tlsEcho echoes the plaintext received
+type tlsEcho struct {
+	IOHandle
+
+	tls   *TLSHandler
+	msg   string // sent in OnOpen if not empty
+	readC chan string
+}
+
+func (e *tlsEcho) OnOpen(fd int) bool {
+	if e.msg != "" {
+		if _, err := e.tls.Write([]byte(e.msg)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+func (e *tlsEcho) OnRead() bool {
+	bf, n, _ := e.tls.Read()
+	if n == 0 {
+		return true
+	}
+	if e.readC != nil {
+		e.readC <- string(bf)
+		return true
+	}
+	_, err := e.tls.Write(bf)
+	return err == nil
+}
+func (e *tlsEcho) OnClose() {
+	if e.Fd() != -1 {
+		syscall.Close(e.Fd())
+		e.Destroy(e)
+	}
+}
+
+func TestTLSHandler(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	srvConfig, cliConfig := selfSignedConfig(t)
+
+	// server side TLSHandler, crypto/tls client
+	fd, peer := tlsSocketPair(t)
+	e := &tlsEcho{}
+	e.tls = NewTLSHandler(r, e, srvConfig)
+	openTLS(t, r, e.tls, fd)
+	conn := tls.Client(peer, cliConfig)
+	echo(t, conn, "hello tls")
+	if !conn.ConnectionState().HandshakeComplete {
+		t.Fatal("handshake not complete")
+	}
+
+	// client side TLSHandler, crypto/tls server
+	fd, peer = tlsSocketPair(t)
+	srv := tls.Server(peer, srvConfig)
+	go func() {
+		bf := make([]byte, 64)
+		srv.SetDeadline(time.Now().Add(2 * time.Second))
+		if n, err := srv.Read(bf); err == nil {
+			srv.Write(bf[:n])
+		}
+	}()
+	c := &tlsEcho{msg: "ping", readC: make(chan string, 1)}
+	c.tls = NewTLSClientHandler(r, c, cliConfig)
+	openTLS(t, r, c.tls, fd)
+	select {
+	case s := <-c.readC:
+		if s != "ping" {
+			t.Fatalf("got %q, want %q", s, "ping")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reply over tls")
+	}
+}
+
+// openTLS calls OnOpen of h within the evpoll, as an acceptor does
+func openTLS(t *testing.T, r *Reactor, h *TLSHandler, fd int) {
+	ok := false
+	runIn(t, r, fd, func() { ok = h.OnOpen(fd) })
+	if !ok {
+		t.Fatal("TLSHandler OnOpen failed")
+	}
+}
+
+// waitGoroutines waits until the number of goroutines drops to n
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	waitFor(t, "the handshake goroutine to exit", func() bool { return runtime.NumGoroutine() <= n })
+}
+
+func TestTLSHandshakeRelease(t *testing.T) {
+	srvConfig, _ := selfSignedConfig(t)
+
+	// the peer never sends its ClientHello
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := tlsSocketPair(t)
+	before := runtime.NumGoroutine()
+	e := &tlsEcho{}
+	e.tls = NewTLSHandler(r, e, srvConfig)
+	e.tls.SetHandshakeTimeout(50)
+	openTLS(t, r, e.tls, fd)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("read %v, want EOF after the handshake timeout", err)
+	}
+	waitGoroutines(t, before)
+
+	// the reactor stops during the handshake
+	fd, _ = tlsSocketPair(t)
+	t.Cleanup(func() { syscall.Close(fd) })
+	before = runtime.NumGoroutine()
+	r2, err := NewReactor(EvPollNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runC := make(chan error, 1)
+	go func() { runC <- r2.Run() }()
+	e = &tlsEcho{}
+	e.tls = NewTLSHandler(r2, e, srvConfig)
+	e.tls.SetHandshakeTimeout(0)
+	openTLS(t, r2, e.tls, fd)
+	r2.Stop()
+	<-runC
+	waitGoroutines(t, before)
+}
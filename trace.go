@@ -0,0 +1,129 @@
+package goev
+
+// TraceKind is the kind of a connection lifecycle event
+type TraceKind int
+
+const (
+	TraceAccepted  TraceKind = iota // accepted by an acceptor, before OnOpen
+	TraceOpened                     // OnOpen of an accepted or connected fd succeeded
+	TraceFirstByte                  // the connection is readable for the first time
+	TraceClosed                     // removed from the reactor, before OnClose
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceAccepted:
+		return "accepted"
+	case TraceOpened:
+		return "opened"
+	case TraceFirstByte:
+		return "first-byte"
+	case TraceClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// CloseReason tells why a connection is closed, see TraceClosed
+type CloseReason int
+
+const (
+	// ClosedByServer the handler or the application closed it: OnRead/OnWrite returned false,
+	// CloseGracefully, a timeout other than the idle one...
+	ClosedByServer    CloseReason = iota
+	ClosedByPeer                  // the peer closed or shut down (EPOLLHUP, EPOLLRDHUP)
+	ClosedByError                 // a socket error (EPOLLERR)
+	ClosedByIdle                  // the idle timeout, see SetIdleTimeout
+	ClosedByHeartbeat             // no data received within the heartbeat timeout, see SetHeartbeat
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case ClosedByServer:
+		return "server"
+	case ClosedByPeer:
+		return "peer"
+	case ClosedByError:
+		return "error"
+	case ClosedByIdle:
+		return "idle"
+	case ClosedByHeartbeat:
+		return "heartbeat"
+	}
+	return "unknown"
+}
+
+// TraceEvent is a lifecycle event of the connection fd
+type TraceEvent struct {
+	Kind   TraceKind
+	Fd     int
+	Time   int64       // millisecond, the time cached by the evpoll (see Reactor.Now)
+	Reason CloseReason // of TraceClosed
+}
+
+// Tracer receives the lifecycle events of the connections accepted or connected by the reactor,
+// e.g. for distributed tracing, see EvTracer. It is called within the evpoll coroutine of the
+// connection (of the acceptor for TraceAccepted), MUST NOT block.
+//
+// An opened connection is traced until it is closed by the reactor, a connection removed by
+// RemoveEvHandler and closed by the application has no TraceClosed.
+type Tracer interface {
+	OnTrace(ev TraceEvent)
+}
+
+type traceState struct {
+	on        bool // TraceOpened has been emitted, until TraceClosed
+	firstByte bool
+	reason    CloseReason
+}
+
+func (h *IOHandle) getTrace() *traceState {
+	return &h._trace
+}
+
+func (ep *evPoll) trace(kind TraceKind, fd int, reason CloseReason) {
+	ep.tracer.OnTrace(TraceEvent{Kind: kind, Fd: fd, Time: ep.nowMilli(), Reason: reason})
+}
+
+// traceDeferrer is implemented by the internal handlers which hand the connection over to another
+// handler once done (e.g. the proxy handshake), the connection is traced as the one of that handler
+type traceDeferrer interface {
+	traceDeferred()
+}
+
+// traceOpened starts tracing eh once its OnOpen(fd) succeeded, if it is registered
+func traceOpened(eh EvHandler, fd int) {
+	ep := eh.getEvPoll()
+	if ep == nil || ep.tracer == nil || ep.internal(eh) {
+		return
+	}
+	if _, ok := eh.(traceDeferrer); ok {
+		return
+	}
+	*eh.getTrace() = traceState{on: true}
+	ep.trace(TraceOpened, fd, ClosedByServer)
+}
+
+// traceFirstByte is called before OnRead
+func (ep *evPoll) traceFirstByte(ed *evData) {
+	if ts := ed.eh.getTrace(); ts.on && !ts.firstByte {
+		ts.firstByte = true
+		ep.trace(TraceFirstByte, ed.fd, ClosedByServer)
+	}
+}
+
+// closeReason records why eh is being closed, the first reason other than ClosedByServer is kept
+// (e.g. the peer's FIN before the pending writes are flushed)
+func (ep *evPoll) closeReason(eh EvHandler, reason CloseReason) {
+	if ts := eh.getTrace(); ep.tracer != nil && ts.reason == ClosedByServer {
+		ts.reason = reason
+	}
+}
+
+// traceClosed is called by closeEvData before OnClose
+func (ep *evPoll) traceClosed(eh EvHandler, fd int) {
+	if ts := eh.getTrace(); ts.on {
+		ts.on = false
+		ep.trace(TraceClosed, fd, ts.reason)
+	}
+}
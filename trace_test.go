@@ -0,0 +1,92 @@
+package goev
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordTracer records the events per fd
+type recordTracer struct {
+	mtx    sync.Mutex
+	events map[int][]TraceEvent
+}
+
+func (r *recordTracer) OnTrace(ev TraceEvent) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events[ev.Fd] = append(r.events[ev.Fd], ev)
+}
+
+// wait returns the events of fd once TraceClosed is recorded
+func (r *recordTracer) wait(t *testing.T, fd int) []TraceEvent {
+	for begin := time.Now(); time.Since(begin) < 2*time.Second; time.Sleep(5 * time.Millisecond) {
+		r.mtx.Lock()
+		evs := r.events[fd]
+		r.mtx.Unlock()
+		if n := len(evs); n > 0 && evs[n-1].Kind == TraceClosed {
+			r.mtx.Lock()
+			delete(r.events, fd) // may be reused
+			r.mtx.Unlock()
+			return evs
+		}
+	}
+	t.Fatalf("fd %d not closed", fd)
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordTracer{events: make(map[int][]TraceEvent)}
+	r := newTestReactor(t, EvPollNum(2), EvTracer(tracer))
+	openC := make(chan int, 2)
+	a, err := NewAcceptor(r, func() EvHandler {
+		c := &echoConn{openC: openC}
+		c.setReactor(r)
+		return c
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	check := func(fd int, reason CloseReason) {
+		evs := tracer.wait(t, fd)
+		want := []TraceKind{TraceAccepted, TraceOpened, TraceFirstByte, TraceClosed}
+		if len(evs) != len(want) {
+			t.Fatalf("fd %d events %v", fd, evs)
+		}
+		for i, ev := range evs {
+			if ev.Kind != want[i] || ev.Time == 0 || (i > 0 && ev.Time < evs[i-1].Time) {
+				t.Fatalf("fd %d event %d: %+v, want %s", fd, i, ev, want[i])
+			}
+		}
+		if evs[3].Reason != reason {
+			t.Fatalf("fd %d closed by %s, want %s", fd, evs[3].Reason, reason)
+		}
+	}
+
+	// request/response, then the client closes
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := <-openC
+	echo(t, conn, "ping")
+	conn.Close()
+	check(fd, ClosedByPeer)
+
+	// request/response, then the server closes
+	conn, err = net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fd = <-openC
+	echo(t, conn, "ping")
+	if err = r.CloseGracefully(fd); err != nil {
+		t.Fatal(err)
+	}
+	check(fd, ClosedByServer)
+}
@@ -0,0 +1,125 @@
+package goev
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DatagramHandler handles the datagrams received by UDPHandler
+type DatagramHandler interface {
+	// OnDatagram is called within the evpoll coroutine for each datagram.
+	// data refers to the evpoll shared read buffer, copy it if it is used after returning.
+	OnDatagram(fd int, data []byte, from syscall.Sockaddr)
+}
+
+// UDPHandler binds a UDP socket and registers it with the reactor, each received datagram is
+// delivered to DatagramHandler.OnDatagram with the peer address (recvfrom semantics instead of
+// the byte stream of OnRead).
+type UDPHandler struct {
+	IOHandle
+
+	h DatagramHandler
+}
+
+// NewUDPHandler return an instance
+//
+// The addr format 192.168.0.1:8080 or :8080
+func NewUDPHandler(r *Reactor, addr string, h DatagramHandler, opts ...Option) (*UDPHandler, error) {
+	evOptions := setOptions(opts...)
+	sa, err := parseInet4Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.New("Socket in NewUDPHandler: " + err.Error())
+	}
+	if evOptions.reuseAddr == true {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			syscall.Close(fd)
+			return nil, errors.New("Set SO_REUSEADDR in NewUDPHandler: " + err.Error())
+		}
+	}
+	if evOptions.sockRcvBufSize > 0 {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, evOptions.sockRcvBufSize)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, errors.New("Set SO_RCVBUF: " + err.Error())
+		}
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, errors.New("syscall bind: " + err.Error())
+	}
+	u := &UDPHandler{h: h}
+	u.setReactor(r)
+	if err = r.AddEvHandler(u, fd, EvIn); err != nil {
+		syscall.Close(fd)
+		return nil, errors.New("AddEvHandler in NewUDPHandler: " + err.Error())
+	}
+	return u, nil
+}
+
+// OnRead receives the pending datagrams
+func (u *UDPHandler) OnRead() bool {
+	bf := u.getEvPoll().evPollReadBuff
+	for i := 0; i < 64; i++ { // Don't process too many at once
+		n, from, err := syscall.Recvfrom(u.Fd(), bf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			break // EAGAIN or error, ignore
+		}
+		u.h.OnDatagram(u.Fd(), bf[:n], from)
+	}
+	return true
+}
+
+// SendTo sends a datagram to the specified address
+func (u *UDPHandler) SendTo(data []byte, to syscall.Sockaddr) error {
+	if u.Fd() < 1 {
+		return syscall.EBADF
+	}
+	for {
+		err := syscall.Sendto(u.Fd(), data, 0, to)
+		if err == syscall.EINTR {
+			continue
+		}
+		return err
+	}
+}
+
+// OnClose release the socket
+func (u *UDPHandler) OnClose() {
+	if u.Fd() != -1 {
+		syscall.Close(u.Fd())
+		u.setFd(-1)
+	}
+}
+
+// The addr format 192.168.0.1:8080 or :8080
+func parseInet4Addr(addr string) (*syscall.SockaddrInet4, error) {
+	ip := "0.0.0.0"
+	ipp := strings.Split(addr, ":")
+	if len(ipp) != 2 {
+		return nil, errors.New("address is invalid! 192.168.1.1:80 or :80")
+	}
+	if len(ipp[0]) > 0 {
+		ip = ipp[0]
+	}
+	ip4 := net.ParseIP(ip).To4()
+	if ip4 == nil {
+		return nil, errors.New("address is invalid! 192.168.1.1:80 or :80")
+	}
+	port, _ := strconv.ParseInt(ipp[1], 10, 64)
+	if port < 1 || port > 65535 {
+		return nil, errors.New("port must in (0, 65536)")
+	}
+	sa := &syscall.SockaddrInet4{Port: int(port)}
+	copy(sa.Addr[:], ip4)
+	return sa, nil
+}
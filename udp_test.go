@@ -0,0 +1,45 @@
+package goev
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type udpEcho struct {
+	u *UDPHandler
+}
+
+func (e *udpEcho) OnDatagram(fd int, data []byte, from syscall.Sockaddr) {
+	e.u.SendTo(data, from)
+}
+
+func TestUDPHandler(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	addr := freeAddr(t)
+	e := &udpEcho{}
+	u, err := NewUDPHandler(r, addr, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.u = u
+
+	conn, err := net.Dial("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	for _, msg := range []string{"ping", "pong"} {
+		conn.Write([]byte(msg))
+		bf := make([]byte, 64)
+		n, err := conn.Read(bf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bf[:n]) != msg {
+			t.Fatalf("got %q, want %q", bf[:n], msg)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package goev
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes, refer to RFC 6455 5.2
+const (
+	wsContinuation byte = 0x0
+
+	WsText   byte = 0x1
+	WsBinary byte = 0x2
+	WsClose  byte = 0x8
+	WsPing   byte = 0x9
+	WsPong   byte = 0xA
+)
+
+// WebSocket close status codes, refer to RFC 6455 7.4.1
+const (
+	wsCloseProtocolError   = 1002
+	wsCloseInvalidPayload  = 1007
+	wsCloseMessageTooLarge = 1009
+)
+
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxPayloadLen = 16 * 1024 * 1024 // of a message, fragments included
+)
+
+// WsHandler receives the messages of WebSocketHandler
+type WsHandler interface {
+	// OnWsMessage is called with a complete text or binary message (the fragments reassembled and
+	// unmasked), payload is valid only within it. Ping/pong/close are handled by WebSocketHandler.
+	OnWsMessage(opcode byte, payload []byte)
+}
+
+// WebSocketHandler is a websocket server connection (RFC 6455). It performs the opening handshake
+// as an HTTPHandler, and then decodes the frames incrementally from the read buffer.
+//
+// Pings are answered with pongs, a close frame is echoed and the connection is closed once it is
+// sent, a protocol error closes the connection with the status code of RFC 6455.
+//
+// For example:
+//
+//	func() goev.EvHandler {
+//	    c := new(Conn) // OnWsMessage calls c.ws.WriteWsMessage
+//	    c.ws = goev.NewWebSocketHandler(reactor, c)
+//	    return c.ws
+//	}
+type WebSocketHandler struct {
+	HTTPHandler
+
+	h     WsHandler
+	msgOp byte   // opcode of the fragmented message in progress, 0 if none
+	msg   []byte // fragments received
+}
+
+// NewWebSocketHandler return an instance
+func NewWebSocketHandler(r *Reactor, h WsHandler) *WebSocketHandler {
+	ws := &WebSocketHandler{h: h}
+	ws.handler = ws.handshake
+	ws.setReactor(r)
+	return ws
+}
+
+// OnOpen register the fd
+func (ws *WebSocketHandler) OnOpen(fd int) bool {
+	return ws.open(ws, fd)
+}
+
+// OnRead handles the opening handshake and then the frames
+func (ws *WebSocketHandler) OnRead() bool {
+	if ws.closing { // the close frame is being sent
+		return ws.discard()
+	}
+	if !ws.upgraded {
+		if ws.HTTPHandler.OnRead() == false {
+			return false
+		}
+		if !ws.upgraded || ws.rb.Len() == 0 {
+			return true
+		}
+		return ws.decode() // the frames sent along with the handshake
+	}
+	_, err := ws.rb.ReadFromHandler(ws)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	if ws.decode() == false {
+		return false
+	}
+	return err == nil
+}
+
+// OnPeerShutdown the peer MUST NOT shut down without a close frame, the frames received are handled
+func (ws *WebSocketHandler) OnPeerShutdown(fd int) bool {
+	return ws.OnRead()
+}
+
+// WriteWsMessage sends payload as a single (unmasked) frame, the opcode is WsText, WsBinary,
+// WsPing, WsPong or WsClose. Use it within the evpoll coroutine like Writer.
+func (ws *WebSocketHandler) WriteWsMessage(opcode byte, payload []byte) error {
+	if !ws.upgraded {
+		return errors.New("websocket: handshake not complete")
+	}
+	var head [10]byte
+	head[0] = 0x80 | opcode // FIN
+	n := len(payload)
+	hl := 2
+	switch {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xFFFF:
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+		hl = 4
+	default:
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+		hl = 10
+	}
+	_, err := ws.w.Writev([][]byte{head[:hl], payload})
+	return err
+}
+
+// handshake is the HTTPHandlerFunc of the opening handshake
+func (ws *WebSocketHandler) handshake(req *HTTPRequest, resp *HTTPResponse) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || req.Proto != "HTTP/1.1" || key == "" ||
+		!wsHeaderHas(req.Header, "Upgrade", "websocket") ||
+		!wsHeaderHas(req.Header, "Connection", "upgrade") {
+		resp.Status = http.StatusBadRequest
+		req.keepAlive = false
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		resp.Status = http.StatusUpgradeRequired
+		resp.Header.Set("Sec-WebSocket-Version", "13")
+		req.keepAlive = false
+		return
+	}
+	resp.Status = http.StatusSwitchingProtocols
+	resp.Header.Set("Upgrade", "websocket")
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+	ws.upgraded = true
+}
+
+// wsAcceptKey returns Sec-WebSocket-Accept of the key
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsHeaderHas returns true if the comma-separated values of key contain token (case-insensitive)
+func wsHeaderHas(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decode handles the complete frames of rb, returns false if the connection should be closed now
+func (ws *WebSocketHandler) decode() bool {
+	for !ws.closing {
+		bf := ws.rb.Bytes()
+		if len(bf) < 2 {
+			return true
+		}
+		fin := bf[0]&0x80 != 0
+		opcode := bf[0] & 0x0F
+		if bf[0]&0x70 != 0 || bf[1]&0x80 == 0 { // RSV without extension, or unmasked client frame
+			ws.fail(wsCloseProtocolError)
+			break
+		}
+		hl, n := 2, uint64(bf[1]&0x7F)
+		switch n {
+		case 126:
+			if len(bf) < 4 {
+				return true
+			}
+			hl, n = 4, uint64(binary.BigEndian.Uint16(bf[2:]))
+		case 127:
+			if len(bf) < 10 {
+				return true
+			}
+			hl, n = 10, binary.BigEndian.Uint64(bf[2:])
+		}
+		if n > wsMaxPayloadLen || uint64(len(ws.msg))+n > wsMaxPayloadLen {
+			ws.fail(wsCloseMessageTooLarge)
+			break
+		}
+		hl += 4 // masking key
+		if uint64(len(bf)) < uint64(hl)+n {
+			return true
+		}
+		mask := bf[hl-4 : hl]
+		payload := bf[hl : hl+int(n)]
+		for i := range payload {
+			payload[i] ^= mask[i&3]
+		}
+		ok := ws.frame(fin, opcode, payload)
+		ws.rb.Discard(hl + int(n))
+		if !ok {
+			return false
+		}
+	}
+	return ws.w.Buffered() > 0 // closing, close after the close frame is sent
+}
+
+// frame handles an unmasked frame, returns false on write error
+func (ws *WebSocketHandler) frame(fin bool, opcode byte, payload []byte) bool {
+	switch opcode {
+	case WsClose, WsPing, WsPong:
+		if !fin || len(payload) > 125 {
+			ws.fail(wsCloseProtocolError)
+			return true
+		}
+		if opcode == WsPing {
+			return ws.WriteWsMessage(WsPong, payload) == nil
+		} else if opcode == WsClose {
+			ws.closing = true
+			if len(payload) >= 2 { // echo the status code
+				payload = payload[:2]
+			}
+			return ws.WriteWsMessage(WsClose, payload) == nil
+		}
+		return true
+	case WsText, WsBinary:
+		if ws.msgOp != 0 { // a new message before the last fragment
+			ws.fail(wsCloseProtocolError)
+			return true
+		}
+		if !fin {
+			ws.msgOp, ws.msg = opcode, append(ws.msg[:0], payload...)
+			return true
+		}
+	case wsContinuation:
+		if ws.msgOp == 0 {
+			ws.fail(wsCloseProtocolError)
+			return true
+		}
+		ws.msg = append(ws.msg, payload...)
+		if !fin {
+			return true
+		}
+		opcode, payload = ws.msgOp, ws.msg
+		ws.msgOp, ws.msg = 0, ws.msg[:0]
+	default:
+		ws.fail(wsCloseProtocolError)
+		return true
+	}
+	if opcode == WsText && !utf8.Valid(payload) {
+		ws.fail(wsCloseInvalidPayload)
+		return true
+	}
+	ws.h.OnWsMessage(opcode, payload)
+	return true
+}
+
+// fail sends a close frame with code, the connection is closed once it is sent
+func (ws *WebSocketHandler) fail(code uint16) {
+	var bf [2]byte
+	binary.BigEndian.PutUint16(bf[:], code)
+	ws.WriteWsMessage(WsClose, bf[:])
+	ws.closing = true
+}
@@ -0,0 +1,237 @@
+package goev
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// wsEcho echoes the messages
+type wsEcho struct {
+	ws *WebSocketHandler
+}
+
+func (e *wsEcho) OnWsMessage(opcode byte, payload []byte) {
+	e.ws.WriteWsMessage(opcode, payload)
+}
+
+// wsClientFrame returns a masked client frame
+func wsClientFrame(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	bf := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		bf = append(bf, 0x80|byte(n))
+	case n <= 0xFFFF:
+		bf = append(bf, 0x80|126)
+		bf = binary.BigEndian.AppendUint16(bf, uint16(n))
+	default:
+		bf = append(bf, 0x80|127)
+		bf = binary.BigEndian.AppendUint64(bf, uint64(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	bf = append(bf, mask...)
+	for i, c := range payload {
+		bf = append(bf, c^mask[i&3])
+	}
+	return bf
+}
+
+// wsReadFrame reads an unmasked server frame
+func wsReadFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[0]&0x80 == 0 || head[1]&0x80 != 0 {
+		t.Fatalf("frame header %x", head)
+	}
+	n := uint64(head[1] & 0x7F)
+	if n == 126 || n == 127 {
+		ext := make([]byte, 2+6*(n-126))
+		io.ReadFull(br, ext)
+		if n == 126 {
+			n = uint64(binary.BigEndian.Uint16(ext))
+		} else {
+			n = binary.BigEndian.Uint64(ext)
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		e := &wsEcho{}
+		e.ws = NewWebSocketHandler(r, e)
+		return e.ws
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+
+	// the handshake and the first frame in one write
+	hello := wsClientFrame(true, WsText, []byte("hello"))
+	conn.Write(append([]byte("GET /chat HTTP/1.1\r\nHost: goev\r\nUpgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n"), hello...))
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" { // RFC 6455 1.3
+		t.Fatalf("handshake response %s %v", resp.Status, resp.Header)
+	}
+	if op, p := wsReadFrame(t, br); op != WsText || string(p) != "hello" {
+		t.Fatalf("echo %x %q", op, p)
+	}
+
+	// fragmented binary message with a ping in between, 64KB+ payload
+	big := bytes.Repeat([]byte("0123456789abcdef"), 5000)
+	conn.Write(wsClientFrame(false, WsBinary, big[:100]))
+	conn.Write(wsClientFrame(true, WsPing, []byte("ping")))
+	conn.Write(wsClientFrame(false, wsContinuation, big[100:70000]))
+	conn.Write(wsClientFrame(true, wsContinuation, big[70000:]))
+	if op, p := wsReadFrame(t, br); op != WsPong || string(p) != "ping" {
+		t.Fatalf("pong %x %q", op, p)
+	}
+	if op, p := wsReadFrame(t, br); op != WsBinary || !bytes.Equal(p, big) {
+		t.Fatalf("echo %x %d bytes", op, len(p))
+	}
+
+	// close handshake
+	conn.Write(wsClientFrame(true, WsClose, []byte{0x03, 0xE8})) // 1000
+	if op, p := wsReadFrame(t, br); op != WsClose || !bytes.Equal(p, []byte{0x03, 0xE8}) {
+		t.Fatalf("close %x %x", op, p)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after close: %v, want EOF", err)
+	}
+}
+
+func TestWebSocketHandlerProtocolError(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		e := &wsEcho{}
+		e.ws = NewWebSocketHandler(r, e)
+		return e.ws
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+
+	handshake := "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	unmasked := []byte{0x81, 0x02, 'h', 'i'}
+	for _, c := range []struct {
+		frame []byte
+		code  uint16
+	}{
+		{unmasked, wsCloseProtocolError},
+		{wsClientFrame(true, wsContinuation, []byte("x")), wsCloseProtocolError},
+		{wsClientFrame(true, WsText, []byte{0xff, 0xfe}), wsCloseInvalidPayload},
+	} {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		br := bufio.NewReader(conn)
+		conn.Write([]byte(handshake))
+		if resp, err := http.ReadResponse(br, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("handshake %v", err)
+		}
+		conn.Write(c.frame)
+		if op, p := wsReadFrame(t, br); op != WsClose || binary.BigEndian.Uint16(p) != c.code {
+			t.Fatalf("frame %x: close %x %x, want %d", c.frame, op, p, c.code)
+		}
+		if _, err = br.ReadByte(); err != io.EOF {
+			t.Fatalf("read after close: %v, want EOF", err)
+		}
+		conn.Close()
+	}
+
+	// not an upgrade request
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain request: %v %v", resp, err)
+	}
+}
+
+func TestWebSocketHandlerClosingInput(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	a, err := NewAcceptor(r, func() EvHandler {
+		e := &wsEcho{}
+		e.ws = NewWebSocketHandler(r, e)
+		return e.ws
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.Addr().String()
+	defer a.OnClose()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	br := bufio.NewReader(conn)
+	conn.Write([]byte("GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	if resp, err := http.ReadResponse(br, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake %v", err)
+	}
+
+	// the echo is larger than the socket buffers, the close frame waits behind it, the frames
+	// sent after the close are ignored
+	big := make([]byte, 8<<20)
+	conn.Write(wsClientFrame(true, WsBinary, big))
+	conn.Write(wsClientFrame(true, WsClose, nil))
+	time.Sleep(20 * time.Millisecond)
+	conn.Write(wsClientFrame(true, WsText, []byte("ignored")))
+	time.Sleep(200 * time.Millisecond)
+	if n := r.evPolls[0].stats.eventsProcessed.Load(); n > 200 {
+		t.Fatalf("%d events processed while the close frame was pending", n)
+	}
+	if op, p := wsReadFrame(t, br); op != WsBinary || len(p) != len(big) {
+		t.Fatalf("echo %x %d bytes", op, len(p))
+	}
+	if op, _ := wsReadFrame(t, br); op != WsClose {
+		t.Fatalf("close %x", op)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("read after close: %v, want EOF", err)
+	}
+}
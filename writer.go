@@ -0,0 +1,531 @@
+package goev
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/shaovie/goev/netfd"
+	"golang.org/x/sys/unix"
+)
+
+// The maximum number of iovec in a single writev (IOV_MAX in limits.h)
+const iovMax = 1024
+
+// Writer is an outbound buffer attached to a connection. It writes directly when nothing is pending,
+// keeps what the socket can't take at the moment, and enables EvOut so that evpoll calls OnWrite,
+// where Flush sends the remainder. EvOut is disabled again once the buffer is drained.
+//
+// Writer is not thread-safe, use it only within the evpoll coroutine of the connection
+// (OnOpen after AddEvHandler, OnRead, OnWrite, OnTimeout).
+//
+// For example:
+//
+//	func (x *XX) OnWrite() bool {
+//	    return x.w.Flush() == nil
+//	}
+type Writer struct {
+	eh EvHandler
+
+	buf        []byte // pending data is buf[offset:]
+	bp         *[]byte
+	offset     int
+	outEnabled bool
+
+	// queued after buf once a file is pending (SendFile), so that the order is kept
+	queue []writerSeg
+
+	// write deadline, millisecond
+	deadline   int64 // 0 disables
+	progressAt int64 // the last time the backlog started or made progress
+	timerID    TimerID
+
+	// watermarks of Buffered, see SetWatermarks
+	lowMark, highMark int
+	aboveHigh         bool
+
+	corked   bool // TCP_CORK set by Cork
+	autoCork bool // Cork on the first write, see SetAutoCork
+
+	zc *zeroCopy // MSG_ZEROCOPY, see SetZeroCopy
+}
+
+// WatermarkHandler is optionally implemented by the EvHandler of a Writer to throttle a producer
+// which writes faster than the peer reads, see Writer.SetWatermarks
+type WatermarkHandler interface {
+	// OnHighWatermark is called when the buffered data reaches the high watermark, the producer
+	// should pause (e.g. stop reading the source)
+	OnHighWatermark(buffered int)
+
+	// OnLowWatermark is called when the buffered data drops to the low watermark after
+	// OnHighWatermark, the producer can resume
+	OnLowWatermark(buffered int)
+}
+
+// writerSeg is a file (fileFd >= 0) or data queued after a file, not copied if zc is set
+type writerSeg struct {
+	data   []byte
+	fileFd int
+	offset int64
+	count  int64 // bytes left of the file
+	zc     *zeroCopyBuf
+}
+
+// NewWriter return an instance, eh must have been added to the reactor before writing.
+func NewWriter(eh EvHandler) *Writer {
+	w := &Writer{eh: eh}
+	eh.setWriter(w) // flushed by Reactor.CloseGracefully
+	return w
+}
+
+// Write sends p or buffers it (entirely or partially) if the socket is not writable.
+// It always accepts the whole p unless an error other than EAGAIN occurs.
+func (w *Writer) Write(p []byte) (int, error) {
+	defer w.checkWatermarks()
+	w.corkAuto()
+	if len(w.queue) > 0 { // keep order
+		w.enqueue(p)
+		return len(p), nil
+	}
+	if w.pending() > 0 { // keep order
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	n, err := w.write(p)
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		w.buf = append(w.backlog(len(p)-n), p[n:]...)
+		w.offset = 0
+		return len(p), w.enableOut()
+	}
+	return n, nil
+}
+
+// Writev gathers bufs into as few writev(2) calls as possible (at most iovMax buffers per call),
+// avoiding concatenation of header and payload. Unsent data is buffered in order like Write.
+func (w *Writer) Writev(bufs [][]byte) (int, error) {
+	defer w.checkWatermarks()
+	w.corkAuto()
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	if len(w.queue) > 0 { // keep order
+		for _, b := range bufs {
+			w.enqueue(b)
+		}
+		return total, nil
+	}
+	if w.pending() > 0 { // keep order
+		for _, b := range bufs {
+			w.buf = append(w.buf, b...)
+		}
+		return total, nil
+	}
+	for len(bufs) > 0 {
+		chunk := bufs
+		if len(chunk) > iovMax {
+			chunk = chunk[:iovMax]
+		}
+		want := 0
+		for _, b := range chunk {
+			want += len(b)
+		}
+		n, err := w.writev(chunk)
+		if err != nil {
+			return 0, err
+		}
+		if n < want { // partially written, buffer the rest of bufs
+			for len(bufs) > 0 && n >= len(bufs[0]) {
+				n -= len(bufs[0])
+				bufs = bufs[1:]
+			}
+			w.buf, w.offset = w.backlog(total), 0
+			w.buf = append(w.buf, bufs[0][n:]...)
+			for _, b := range bufs[1:] {
+				w.buf = append(w.buf, b...)
+			}
+			return total, w.enableOut()
+		}
+		bufs = bufs[len(chunk):]
+	}
+	return total, nil
+}
+
+// SendFile sends count bytes of fileFd from offset by sendfile(2) (zero-copy), after the data
+// buffered. The part which can't be sent at the moment is sent in OnWrite (Flush) like Write.
+//
+// Writer takes the ownership of fileFd, it is closed once it has been sent (or on Reset/error).
+func (w *Writer) SendFile(fileFd int, offset, count int64) error {
+	if fileFd < 0 || offset < 0 || count < 0 {
+		return errors.New("goev: SendFile invalid params")
+	}
+	w.corkAuto()
+	w.queue = append(w.queue, writerSeg{fileFd: fileFd, offset: offset, count: count})
+	if len(w.queue) > 1 || w.pending() > 0 { // EvOut is enabled, sent in order by Flush
+		w.checkWatermarks()
+		return nil
+	}
+	return w.Flush()
+}
+
+// SetWriteDeadline closes the connection (OnClose is called) if the buffered data makes no progress
+// within timeout (millisecond), e.g. the peer stopped reading. A slow peer which keeps taking data
+// is not closed. 0 disables it.
+//
+// It is checked by a timer while data is buffered, eh MUST have been added to the reactor.
+func (w *Writer) SetWriteDeadline(timeout int64) error {
+	if timeout < 0 {
+		return errors.New("goev: write deadline < 0")
+	}
+	w.deadline = timeout
+	w.stopDeadline()
+	if w.Buffered() > 0 {
+		w.progressAt = w.now()
+		return w.startDeadline(timeout)
+	}
+	return nil
+}
+
+// SetWatermarks enables the watermark callbacks of WatermarkHandler (implemented by eh of the Writer)
+// when Buffered reaches high and then drops to low, 0 <= low < high. high 0 disables them.
+func (w *Writer) SetWatermarks(low, high int) error {
+	if high != 0 && (low < 0 || low >= high) {
+		return errors.New("goev: watermarks MUST be 0 <= low < high")
+	}
+	w.lowMark, w.highMark, w.aboveHigh = low, high, false
+	w.checkWatermarks()
+	return nil
+}
+
+// Cork starts assembling a response: TCP_CORK is set on the socket, so that what is written until
+// Uncork (e.g. the header with Write, then the body with Write or SendFile) leaves in full
+// segments instead of a tiny segment per piece. The data is still written to the socket as usual,
+// the kernel holds the partial segment until Uncork, 200ms at most.
+//
+// TCP_CORK takes precedence over TCP_NODELAY while corked, Uncork sends the partial segment at
+// once whether TCP_NODELAY is set or not, see netfd.Cork. The socket MUST be TCP.
+func (w *Writer) Cork() error {
+	if w.corked {
+		return nil
+	}
+	if err := netfd.Cork(w.eh.Fd()); err != nil {
+		return err
+	}
+	w.corked = true
+	return nil
+}
+
+// Uncork ends the response started by Cork: TCP_CORK is cleared and the data the kernel holds is
+// sent in one go. The data the socket could not take yet stays buffered for Flush as usual, it is
+// not held by the kernel when it is sent later.
+func (w *Writer) Uncork() error {
+	if !w.corked {
+		return nil
+	}
+	w.corked = false
+	return netfd.Uncork(w.eh.Fd())
+}
+
+// SetAutoCork makes the first write after Uncork (Write, Writev, SendFile or WriteZeroCopy) Cork
+// the socket, so that a response assembled by several writes only needs Uncork once it is complete
+// (e.g. at the end of OnRead). It returns an error if the socket is not TCP. false stops it, a
+// socket corked stays so until Uncork.
+func (w *Writer) SetAutoCork(on bool) error {
+	if on {
+		if _, err := syscall.GetsockoptInt(w.eh.Fd(), syscall.IPPROTO_TCP, syscall.TCP_CORK); err != nil {
+			return errors.New("Get TCP_CORK: " + err.Error())
+		}
+	}
+	w.autoCork = on
+	return nil
+}
+
+// corkAuto corks the socket before a write if SetAutoCork is on, the data is written anyway if it
+// fails
+func (w *Writer) corkAuto() {
+	if w.autoCork && !w.corked {
+		w.Cork()
+	}
+}
+
+// Flush sends the buffered data, call it in OnWrite.
+func (w *Writer) Flush() error {
+	before := w.Buffered()
+	err := w.flush()
+	if w.deadline > 0 && w.Buffered() < before {
+		w.progressAt = w.now()
+	}
+	w.checkWatermarks()
+	return err
+}
+
+func (w *Writer) flush() error {
+	for {
+		if w.pending() > 0 {
+			n, err := w.write(w.buf[w.offset:])
+			if err != nil {
+				return err
+			}
+			w.offset += n
+			if w.pending() > 0 {
+				return w.enableOut()
+			}
+		}
+		w.buf, w.offset = w.buf[:0], 0
+		if len(w.queue) == 0 {
+			return w.disableOut()
+		}
+		seg := &w.queue[0]
+		if seg.zc != nil {
+			done, err := w.flushZeroCopy(seg)
+			if err != nil {
+				return err
+			}
+			if !done {
+				return w.enableOut()
+			}
+		} else if seg.fileFd < 0 {
+			w.buf = append(w.backlog(len(seg.data)), seg.data...)
+		} else {
+			n, err := SendFile(w.eh.Fd(), seg.fileFd, seg.offset, seg.count)
+			w.written(int(n))
+			seg.offset += n
+			seg.count -= n
+			if err != nil {
+				return err
+			}
+			if seg.count > 0 {
+				return w.enableOut()
+			}
+			syscall.Close(seg.fileFd)
+		}
+		w.queue[0] = writerSeg{}
+		w.queue = w.queue[1:]
+	}
+}
+
+// backlog returns the emptied buffer of the unsent data, taken from the pool if there is none
+func (w *Writer) backlog(size int) []byte {
+	if w.buf == nil {
+		w.bp = getBytes(size)
+		return (*w.bp)[:0]
+	}
+	return w.buf[:0]
+}
+
+// Buffered returns the number of bytes waiting to be sent
+func (w *Writer) Buffered() int {
+	n := w.pending()
+	for i := range w.queue {
+		n += len(w.queue[i].data) + int(w.queue[i].count)
+	}
+	return n
+}
+
+// Reset discards the buffered data and returns the buffer to the pool zeroed, e.g. in OnClose.
+// The buffers of WriteZeroCopy pending are dropped without OnZeroCopyDone. TCP_CORK is cleared if
+// Cork has set it and the fd is still open, the data held by the kernel is sent.
+func (w *Writer) Reset() {
+	if w.corked && w.eh.Fd() > 0 {
+		netfd.Uncork(w.eh.Fd())
+	}
+	for _, seg := range w.queue {
+		if seg.fileFd >= 0 {
+			syscall.Close(seg.fileFd)
+		}
+	}
+	w.queue = nil
+	putBytes(w.bp, w.buf)
+	w.bp, w.buf, w.offset, w.outEnabled = nil, nil, 0, false
+	w.aboveHigh, w.corked = false, false
+	if w.zc != nil { // the kernel keeps counting the sends
+		w.zc.inflight = nil
+	}
+	w.stopDeadline()
+}
+
+// checkWatermarks calls the WatermarkHandler when Buffered crosses the watermarks
+func (w *Writer) checkWatermarks() {
+	if w.highMark == 0 {
+		return
+	}
+	h, ok := w.eh.(WatermarkHandler)
+	if !ok {
+		return
+	}
+	if n := w.Buffered(); !w.aboveHigh && n >= w.highMark {
+		w.aboveHigh = true
+		h.OnHighWatermark(n)
+	} else if w.aboveHigh && n <= w.lowMark {
+		w.aboveHigh = false
+		h.OnLowWatermark(n)
+	}
+}
+
+// startDeadline schedules checkDeadline after delay
+func (w *Writer) startDeadline(delay int64) error {
+	ep := w.eh.getEvPoll()
+	if ep == nil {
+		return errors.New("goev: Writer ev handler has not been added to the reactor yet")
+	}
+	id, err := ep.scheduleTimerFunc(w.eh, delay, 0, w.checkDeadline, nil)
+	w.timerID = id
+	return err
+}
+
+func (w *Writer) stopDeadline() {
+	if w.timerID.ti != nil {
+		w.eh.getEvPoll().cancelTimerID(w.timerID)
+		w.timerID = TimerID{}
+	}
+}
+
+// checkDeadline closes the connection if the buffered data made no progress within the deadline
+func (w *Writer) checkDeadline(millisecond int64, _ any) bool {
+	w.timerID = TimerID{}
+	fd := w.eh.Fd()
+	if fd < 1 || w.deadline == 0 || w.Buffered() == 0 {
+		return false
+	}
+	if left := w.progressAt + w.deadline - millisecond; left > 0 { // progressing
+		w.startDeadline(left)
+		return false
+	}
+	ep := w.eh.getEvPoll()
+	if ed := ep.loadEvData(fd); ed != nil {
+		ep.closeEvData(ed) // the registered handler, which may embed w.eh
+	}
+	return false
+}
+
+// now returns the cached time of the evpoll, in which the deadline timer is checked
+func (w *Writer) now() int64 {
+	if ep := w.eh.getEvPoll(); ep != nil {
+		return ep.nowMilli()
+	}
+	return time.Now().UnixMilli()
+}
+
+// pending returns the number of bytes of buf waiting to be sent
+func (w *Writer) pending() int {
+	return len(w.buf) - w.offset
+}
+
+// enqueue appends p to the queue, merging with the last data segment
+func (w *Writer) enqueue(p []byte) {
+	if last := len(w.queue) - 1; w.queue[last].fileFd < 0 && w.queue[last].zc == nil {
+		w.queue[last].data = append(w.queue[last].data, p...)
+		return
+	}
+	w.queue = append(w.queue, writerSeg{data: append([]byte(nil), p...), fileFd: -1})
+}
+
+// SendFile sends count bytes of fileFd from offset to the non-blocking outFd by sendfile(2),
+// returns the number of bytes sent, it stops at EAGAIN without error (ignoring EINTR).
+//
+// io.ErrUnexpectedEOF is returned if the file is shorter than offset+count.
+func SendFile(outFd int, fileFd int, offset, count int64) (int64, error) {
+	var sent int64
+	for sent < count {
+		chunk := count - sent
+		if chunk > 1<<30 { // sendfile transfers at most 0x7ffff000 bytes at once
+			chunk = 1 << 30
+		}
+		off := offset + sent
+		n, err := syscall.Sendfile(outFd, fileFd, &off, int(chunk))
+		if n > 0 {
+			sent += int64(n)
+		}
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				return sent, nil
+			}
+			return sent, err
+		}
+		if n == 0 {
+			return sent, io.ErrUnexpectedEOF
+		}
+	}
+	return sent, nil
+}
+
+// written counts n bytes written in the handler and the stats of its evpoll
+func (w *Writer) written(n int) {
+	w.eh.countWritten(n)
+}
+
+// write returns the number of bytes written, EAGAIN is not an error
+func (w *Writer) write(p []byte) (int, error) {
+	fd := w.eh.Fd()
+	if fd < 1 {
+		return 0, syscall.EBADF
+	}
+	for {
+		n, err := syscall.Write(fd, p)
+		if err == nil {
+			w.written(n)
+			return n, nil
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return 0, nil
+		}
+		return 0, err
+	}
+}
+
+// writev returns the number of bytes written, EAGAIN is not an error
+func (w *Writer) writev(bufs [][]byte) (int, error) {
+	fd := w.eh.Fd()
+	if fd < 1 {
+		return 0, syscall.EBADF
+	}
+	for {
+		n, err := unix.Writev(fd, bufs)
+		if err == nil {
+			w.written(n)
+			return n, nil
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return 0, nil
+		}
+		return 0, err
+	}
+}
+func (w *Writer) enableOut() error {
+	if w.outEnabled {
+		return nil
+	}
+	ep := w.eh.getEvPoll()
+	if ep == nil {
+		return errors.New("goev: Writer ev handler has not been added to the reactor yet")
+	}
+	if err := ep.append(w.eh.Fd(), EvOut); err != nil {
+		return err
+	}
+	w.outEnabled = true
+	if w.deadline > 0 && w.timerID.ti == nil { // the backlog starts
+		w.progressAt = w.now()
+		return w.startDeadline(w.deadline)
+	}
+	return nil
+}
+func (w *Writer) disableOut() error {
+	if !w.outEnabled {
+		return nil
+	}
+	w.outEnabled = false
+	return w.eh.getEvPoll().subtract(w.eh.Fd(), syscall.EPOLLOUT) // keep EPOLLRDHUP
+}
@@ -0,0 +1,528 @@
+package goev
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writerConn writes data through Writer when the peer sends a byte
+type writerConn struct {
+	IOHandle
+
+	w      *Writer
+	data   []byte
+	writes int // OnWrite calls
+	doneC  chan int
+}
+
+func (c *writerConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	if _, err := c.w.Write(c.data); err != nil {
+		return false
+	}
+	if c.w.Buffered() == 0 {
+		c.doneC <- c.writes
+	}
+	return true
+}
+func (c *writerConn) OnWrite() bool {
+	c.writes++
+	if err := c.w.Flush(); err != nil {
+		return false
+	}
+	if c.w.Buffered() == 0 {
+		c.doneC <- c.writes
+	}
+	return true
+}
+func (c *writerConn) OnClose() {}
+
+func newSocketPair(t *testing.T) (int, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	syscall.SetNonblock(fds[0], true)
+	return fds[0], fds[1]
+}
+
+// readFull reads n bytes from the blocking fd
+func readFull(t *testing.T, fd, n int) []byte {
+	bf := make([]byte, n)
+	got := 0
+	for got < n {
+		m, err := syscall.Read(fd, bf[got:])
+		if err != nil || m == 0 {
+			t.Fatalf("read %d/%d: %v", got, n, err)
+		}
+		got += m
+	}
+	return bf
+}
+
+func TestWriterFlushBacklog(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := newSocketPair(t)
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	c := &writerConn{data: data, doneC: make(chan int, 1)}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(peer, []byte{'x'})
+
+	got := readFull(t, peer, len(data))
+	if !bytes.Equal(got, data) {
+		t.Fatal("data mismatch")
+	}
+	select {
+	case writes := <-c.doneC:
+		if writes < 2 {
+			t.Fatalf("backlog flushed in %d OnWrite calls, want more than 1", writes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backlog not flushed")
+	}
+}
+
+type writevConn struct {
+	IOHandle
+
+	w    *Writer
+	bufs [][]byte
+}
+
+func (c *writevConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	_, err := c.w.Writev(c.bufs)
+	return err == nil
+}
+func (c *writevConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *writevConn) OnClose()      {}
+
+func TestWriterWritev(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+
+	body := bytes.Repeat([]byte("body"), 256*1024) // larger than the socket buffer
+	small := make([][]byte, 3000)                  // more than iovMax
+	for i := range small {
+		small[i] = []byte{byte('a' + i%26)}
+	}
+	cases := [][][]byte{
+		{[]byte("HTTP/1.1 200 OK\r\nContent-Length: 1048576\r\n\r\n"), body},
+		small,
+	}
+	for _, bufs := range cases {
+		fd, peer := newSocketPair(t)
+		c := &writevConn{bufs: bufs}
+		c.w = NewWriter(c)
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			t.Fatal(err)
+		}
+		syscall.Write(peer, []byte{'x'})
+
+		want := bytes.Join(bufs, nil)
+		if got := readFull(t, peer, len(want)); !bytes.Equal(got, want) {
+			t.Fatal("peer did not receive the buffers concatenated in order")
+		}
+	}
+}
+
+// sendFileConn sends head, the file and tail when the peer sends a byte
+type sendFileConn struct {
+	IOHandle
+
+	w      *Writer
+	fileFd int
+	size   int64
+	doneC  chan error
+}
+
+func (c *sendFileConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	c.w.Write([]byte("head"))
+	if err := c.w.SendFile(c.fileFd, 0, c.size); err != nil {
+		c.doneC <- err
+		return false
+	}
+	c.w.Write([]byte("tail"))
+	return true
+}
+func (c *sendFileConn) OnWrite() bool {
+	if err := c.w.Flush(); err != nil {
+		c.doneC <- err
+		return false
+	}
+	if c.w.Buffered() == 0 {
+		c.doneC <- nil
+	}
+	return true
+}
+func (c *sendFileConn) OnClose() {}
+
+func TestWriterSendFile(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := newSocketPair(t)
+
+	data := make([]byte, 4*1024*1024+123) // larger than the socket buffer
+	rand.Read(data)
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileFd, err := syscall.Open(name, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &sendFileConn{fileFd: fileFd, size: int64(len(data)), doneC: make(chan error, 1)}
+	c.w = NewWriter(c)
+	if err = r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(peer, []byte{'x'})
+
+	got := readFull(t, peer, 4+len(data)+4)
+	if string(got[:4]) != "head" || !bytes.Equal(got[4:4+len(data)], data) || string(got[4+len(data):]) != "tail" {
+		t.Fatal("peer did not receive head, file and tail in order")
+	}
+	select {
+	case err = <-c.doneC:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("file not flushed")
+	}
+	var st syscall.Stat_t
+	if err = syscall.Fstat(fileFd, &st); err != syscall.EBADF {
+		t.Fatalf("file fd not closed after sent: %v", err)
+	}
+
+	// shorter than count
+	fileFd, _ = syscall.Open(name, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	defer syscall.Close(fileFd)
+	fd2, _ := newSocketPair(t)
+	if n, err := SendFile(fd2, fileFd, int64(len(data)-100), 200); n != 100 || err != io.ErrUnexpectedEOF {
+		t.Fatalf("SendFile %d %v, want 100 ErrUnexpectedEOF", n, err)
+	}
+}
+
+// deadlineConn writes data with a write deadline when the peer sends a byte
+type deadlineConn struct {
+	IOHandle
+
+	w      *Writer
+	data   []byte
+	closeC chan struct{}
+}
+
+func (c *deadlineConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	c.w.SetWriteDeadline(100)
+	_, err := c.w.Write(c.data)
+	return err == nil
+}
+func (c *deadlineConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *deadlineConn) OnClose() {
+	c.w.Reset()
+	close(c.closeC)
+}
+
+func TestWriterDeadline(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	data := make([]byte, 8*1024*1024) // larger than the socket buffer
+	open := func() (*deadlineConn, int) {
+		fd, peer := newSocketPair(t)
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+		c := &deadlineConn{data: data, closeC: make(chan struct{})}
+		c.w = NewWriter(c)
+		if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+			t.Fatal(err)
+		}
+		syscall.Write(peer, []byte{'x'})
+		return c, peer
+	}
+
+	// the peer does not read
+	c, _ := open()
+	begin := time.Now()
+	select {
+	case <-c.closeC:
+		if d := time.Since(begin); d < 95*time.Millisecond {
+			t.Fatalf("closed after %v, before the deadline", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stalled connection not closed")
+	}
+
+	// the peer reads slowly, progressing within the deadline
+	c, peer := open()
+	bf := make([]byte, 16*1024)
+	for i := 0; i < 8; i++ { // 400ms
+		time.Sleep(50 * time.Millisecond)
+		syscall.Read(peer, bf)
+	}
+	select {
+	case <-c.closeC:
+		t.Fatal("progressing connection closed")
+	default:
+	}
+}
+
+// watermarkConn writes data in chunks until paused by the high watermark
+type watermarkConn struct {
+	IOHandle
+
+	w      *Writer
+	chunk  []byte
+	paused bool
+	marks  chan string
+}
+
+func (c *watermarkConn) OnRead() bool {
+	_, n, _ := c.Read()
+	if n == 0 {
+		return false
+	}
+	for !c.paused { // a producer faster than the peer
+		if _, err := c.w.Write(c.chunk); err != nil {
+			return false
+		}
+	}
+	return true
+}
+func (c *watermarkConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *watermarkConn) OnClose()      {}
+func (c *watermarkConn) OnHighWatermark(buffered int) {
+	c.paused = true
+	c.marks <- "high:" + strconv.Itoa(buffered)
+}
+func (c *watermarkConn) OnLowWatermark(buffered int) {
+	c.marks <- "low:" + strconv.Itoa(buffered)
+}
+
+func TestWriterWatermarks(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, peer := newSocketPair(t)
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	const low, high = 16 * 1024, 256 * 1024
+	c := &watermarkConn{chunk: make([]byte, 8*1024), marks: make(chan string, 4)}
+	c.w = NewWriter(c)
+	if err := c.w.SetWatermarks(high, low); err == nil {
+		t.Fatal("low >= high accepted")
+	}
+	if err := c.w.SetWatermarks(low, high); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(peer, []byte{'x'})
+
+	next := func() string {
+		select {
+		case m := <-c.marks:
+			return m
+		case <-time.After(2 * time.Second):
+			return "timeout"
+		}
+	}
+	if m := next(); !strings.HasPrefix(m, "high:") {
+		t.Fatalf("got %q, want the high watermark", m)
+	} else if n, _ := strconv.Atoi(m[5:]); n < high || n >= high+len(c.chunk) {
+		t.Fatalf("high watermark called with %d buffered", n)
+	}
+	select {
+	case m := <-c.marks:
+		t.Fatalf("got %q before the peer reads", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the peer drains
+	bf := make([]byte, 64*1024)
+	var m string
+	syscall.SetsockoptTimeval(peer, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 2})
+	for m == "" {
+		if _, err := syscall.Read(peer, bf); err != nil {
+			t.Fatalf("drained without the low watermark: %v", err)
+		}
+		select {
+		case m = <-c.marks:
+		default:
+		}
+	}
+	if !strings.HasPrefix(m, "low:") {
+		t.Fatalf("got %q, want the low watermark", m)
+	}
+	if n, _ := strconv.Atoi(m[4:]); n > low {
+		t.Fatalf("low watermark called with %d buffered", n)
+	}
+}
+
+// corkConn is the server side of a TCP connection written by the test with Writer
+type corkConn struct {
+	IOHandle
+
+	w *Writer
+}
+
+func (c *corkConn) OnRead() bool  { return true }
+func (c *corkConn) OnWrite() bool { return c.w.Flush() == nil }
+func (c *corkConn) OnClose()      {}
+
+// tcpServerFd returns the non-blocking server fd of a TCP loopback connection and its client
+func tcpServerFd(t *testing.T) (int, net.Conn) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	raw, _ := server.(*net.TCPConn).SyscallConn()
+	fd := -1
+	raw.Control(func(s uintptr) { fd, err = syscall.Dup(int(s)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	syscall.SetNonblock(fd, true)
+	return fd, client
+}
+
+func TestWriterCork(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, client := tcpServerFd(t)
+	c := &corkConn{}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "hello"
+	bf := make([]byte, 256)
+	read := func(timeout time.Duration) int {
+		client.SetReadDeadline(time.Now().Add(timeout))
+		n, _ := client.Read(bf)
+		return n
+	}
+	// not corked, the header leaves alone
+	runIn(t, r, fd, func() { c.w.Write([]byte(header)) })
+	if n := read(time.Second); n != len(header) {
+		t.Fatalf("read %d bytes, want the header of %d", n, len(header))
+	}
+	runIn(t, r, fd, func() { c.w.Write([]byte(body)) })
+	if n := read(time.Second); n != len(body) {
+		t.Fatalf("read %d bytes, want the body", n)
+	}
+
+	// corked, nothing leaves until Uncork, then header and body at once
+	runIn(t, r, fd, func() {
+		c.w.Cork()
+		c.w.Write([]byte(header))
+	})
+	if n := read(50 * time.Millisecond); n != 0 {
+		t.Fatalf("%d bytes sent while corked", n)
+	}
+	runIn(t, r, fd, func() {
+		c.w.Write([]byte(body))
+		c.w.Uncork()
+	})
+	if n := read(time.Second); n != len(header)+len(body) || string(bf[:n]) != header+body {
+		t.Fatalf("read %q, want header and body in one segment", bf[:n])
+	}
+}
+
+func TestWriterAutoCork(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, client := tcpServerFd(t)
+	c := &corkConn{}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	runIn(t, r, fd, func() { err = c.w.SetAutoCork(true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "hello"
+	bf := make([]byte, 256)
+	read := func(timeout time.Duration) int {
+		client.SetReadDeadline(time.Now().Add(timeout))
+		n, _ := client.Read(bf)
+		return n
+	}
+	// corked by the first write, header and body at once on Uncork
+	runIn(t, r, fd, func() { c.w.Write([]byte(header)) })
+	if n := read(50 * time.Millisecond); n != 0 {
+		t.Fatalf("%d bytes sent while corked", n)
+	}
+	runIn(t, r, fd, func() {
+		c.w.Writev([][]byte{[]byte(body)})
+		c.w.Uncork()
+	})
+	if n := read(time.Second); n != len(header)+len(body) {
+		t.Fatalf("read %q, want header and body in one segment", bf[:n])
+	}
+
+	// Reset clears TCP_CORK
+	cork := -1
+	runIn(t, r, fd, func() {
+		c.w.Write([]byte(header))
+		c.w.Reset()
+		cork, _ = syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_CORK)
+	})
+	if cork != 0 {
+		t.Fatalf("TCP_CORK %d after Reset", cork)
+	}
+	if n := read(time.Second); n != len(header) {
+		t.Fatalf("read %d bytes, want the header held", n)
+	}
+
+	// not TCP
+	fd2, _ := newSocketPair(t)
+	c2 := &corkConn{}
+	c2.setFd(fd2)
+	if err = NewWriter(c2).SetAutoCork(true); err == nil {
+		t.Fatal("auto-cork set on a unix socket")
+	}
+}
@@ -0,0 +1,235 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ZeroCopyHandler is optionally implemented by the EvHandler of a Writer which sends with
+// Writer.WriteZeroCopy, to know when a buffer can be reused
+type ZeroCopyHandler interface {
+	// OnZeroCopyDone is called within the evpoll coroutine once the kernel has released p, which can
+	// be modified or reused from now on. copied is true if the data has been copied instead
+	// (below the threshold, or the kernel fell back to copying, e.g. over loopback).
+	OnZeroCopyDone(p []byte, copied bool)
+}
+
+// zeroCopy is the state of the MSG_ZEROCOPY sends of a Writer
+type zeroCopy struct {
+	threshold int
+	nextSeq   uint32         // of the next successful sendmsg(MSG_ZEROCOPY), counted by the kernel
+	inflight  []zeroCopySend // sends waiting for the completion
+}
+
+// zeroCopyBuf is a buffer of WriteZeroCopy, it may be sent by several sendmsg calls
+type zeroCopyBuf struct {
+	p        []byte
+	inflight int  // sends not completed yet
+	queued   bool // the rest of p waits in the queue of Writer
+	copied   bool
+}
+
+type zeroCopySend struct {
+	seq uint32
+	buf *zeroCopyBuf
+}
+
+// SetZeroCopy enables SO_ZEROCOPY on the socket, so that WriteZeroCopy sends the payloads of at
+// least threshold bytes with MSG_ZEROCOPY: the pages are pinned instead of copied into the kernel,
+// which saves CPU for large transfers (the kernel docs suggest > 10KB). The completions are read
+// from the error queue of the socket by evpoll. 0 disables it, the socket option is kept.
+//
+// It returns an error if the socket does not support it (e.g. unix socket, or kernel < 4.14).
+func (w *Writer) SetZeroCopy(threshold int) error {
+	if threshold < 0 {
+		return errors.New("goev: zero-copy threshold < 0")
+	}
+	if threshold == 0 {
+		if w.zc != nil && len(w.zc.inflight) > 0 {
+			w.zc.threshold = 0 // the completions are still awaited
+		} else {
+			w.zc = nil
+		}
+		return nil
+	}
+	if err := syscall.SetsockoptInt(w.eh.Fd(), syscall.SOL_SOCKET, unix.SO_ZEROCOPY, 1); err != nil {
+		return errors.New("Set SO_ZEROCOPY: " + err.Error())
+	}
+	if w.zc == nil {
+		w.zc = &zeroCopy{}
+	}
+	w.zc.threshold = threshold
+	return nil
+}
+
+// WriteZeroCopy sends p after the buffered data like Write, without copying it if zero-copy is
+// enabled (see SetZeroCopy) and p is at least the threshold: p MUST NOT be modified until
+// OnZeroCopyDone(p) of the ZeroCopyHandler (the eh of the Writer). Otherwise p is written (copied)
+// by Write and OnZeroCopyDone(p, true) is called before it returns.
+//
+// The buffers not completed when the connection is closed (or Reset) are not reported.
+func (w *Writer) WriteZeroCopy(p []byte) error {
+	w.corkAuto()
+	if w.zc == nil || w.zc.threshold == 0 || len(p) < w.zc.threshold {
+		_, err := w.Write(p)
+		if err == nil {
+			w.zeroCopyDone(&zeroCopyBuf{p: p, copied: true})
+		}
+		return err
+	}
+	defer w.checkWatermarks()
+	buf := &zeroCopyBuf{p: p}
+	if len(w.queue) > 0 || w.pending() > 0 { // keep order
+		buf.queued = true
+		w.queue = append(w.queue, writerSeg{data: p, fileFd: -1, zc: buf})
+		return nil
+	}
+	n, err := w.sendZeroCopy(buf, p)
+	if err != nil {
+		return err
+	}
+	if n < len(p) {
+		buf.queued = true
+		w.queue = append(w.queue, writerSeg{data: p[n:], fileFd: -1, zc: buf})
+		return w.enableOut()
+	}
+	if buf.inflight == 0 { // copied on ENOBUFS
+		w.zeroCopyDone(buf)
+	}
+	return nil
+}
+
+// sendZeroCopy sends p of buf with MSG_ZEROCOPY, returns the number of bytes sent, EAGAIN is not
+// an error. It falls back to a copy when the pinned pages exceed the optmem limit (ENOBUFS).
+func (w *Writer) sendZeroCopy(buf *zeroCopyBuf, p []byte) (int, error) {
+	fd := w.eh.Fd()
+	if fd < 1 {
+		return 0, syscall.EBADF
+	}
+	for {
+		n, err := syscall.SendmsgN(fd, p, nil, nil, unix.MSG_ZEROCOPY)
+		if err == nil {
+			if n > 0 { // each successful call takes a sequence number
+				w.zc.inflight = append(w.zc.inflight, zeroCopySend{seq: w.zc.nextSeq, buf: buf})
+				w.zc.nextSeq++
+				buf.inflight++
+			}
+			w.written(n)
+			return n, nil
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return 0, nil
+		}
+		if err == syscall.ENOBUFS {
+			buf.copied = true
+			return w.write(p)
+		}
+		return 0, err
+	}
+}
+
+// flushZeroCopy sends the zero-copy segment seg, returns true if it has been sent entirely
+func (w *Writer) flushZeroCopy(seg *writerSeg) (bool, error) {
+	n, err := w.sendZeroCopy(seg.zc, seg.data)
+	seg.data = seg.data[n:]
+	if err != nil || len(seg.data) > 0 {
+		return false, err
+	}
+	seg.zc.queued = false
+	if seg.zc.inflight == 0 {
+		w.zeroCopyDone(seg.zc)
+	}
+	return true, nil
+}
+
+// readErrQueue handles the completions of the error queue (EPOLLERR), returns an error if the
+// socket has another error
+func (w *Writer) readErrQueue() error {
+	fd := w.eh.Fd()
+	var oob [128]byte
+	for {
+		_, oobn, _, _, err := syscall.Recvmsg(fd, nil, oob[:], unix.MSG_ERRQUEUE)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if !(m.Header.Level == syscall.SOL_IP && m.Header.Type == unix.IP_RECVERR) &&
+				!(m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR) {
+				continue
+			}
+			if len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				continue
+			}
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+			if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY {
+				return syscall.Errno(ee.Errno)
+			}
+			w.zeroCopyCompleted(ee.Info, ee.Data, ee.Code&unix.SO_EE_CODE_ZEROCOPY_COPIED != 0)
+		}
+	}
+	if soErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR); err != nil {
+		return err
+	} else if soErr != 0 {
+		return syscall.Errno(soErr)
+	}
+	return nil
+}
+
+// zeroCopyCompleted handles the completion of the sends lo..hi (inclusive). OnZeroCopyDone is
+// called once the inflight sends are committed, it may call WriteZeroCopy or SetZeroCopy.
+func (w *Writer) zeroCopyCompleted(lo, hi uint32, copied bool) {
+	if w.zc == nil {
+		return
+	}
+	var done []*zeroCopyBuf
+	inflight := make([]zeroCopySend, 0, len(w.zc.inflight))
+	for _, s := range w.zc.inflight {
+		if s.seq-lo > hi-lo { // not in the range, modulo 2^32
+			inflight = append(inflight, s)
+			continue
+		}
+		s.buf.inflight--
+		s.buf.copied = s.buf.copied || copied
+		if s.buf.inflight == 0 && !s.buf.queued {
+			done = append(done, s.buf)
+		}
+	}
+	w.zc.inflight = inflight
+	if w.zc.threshold == 0 && len(inflight) == 0 { // disabled
+		w.zc = nil
+	}
+	for _, buf := range done {
+		w.zeroCopyDone(buf)
+	}
+}
+
+func (w *Writer) zeroCopyDone(buf *zeroCopyBuf) {
+	if h, ok := w.eh.(ZeroCopyHandler); ok {
+		h.OnZeroCopyDone(buf.p, buf.copied)
+	}
+}
+
+// zeroCopyNotified reads the completions of the Writer when the socket reports EPOLLERR, returns
+// true if that is all the error queue holds, i.e. the connection is fine
+func (h *IOHandle) zeroCopyNotified() bool {
+	if h._w == nil || h._w.zc == nil {
+		return false
+	}
+	return h._w.readErrQueue() == nil
+}
@@ -0,0 +1,172 @@
+package goev
+
+import (
+	"bytes"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type zeroCopyDone struct {
+	p      []byte
+	copied bool
+}
+
+// zeroCopyConn is corkConn reporting the completions of WriteZeroCopy
+type zeroCopyConn struct {
+	corkConn
+
+	doneC chan zeroCopyDone
+}
+
+func (c *zeroCopyConn) OnZeroCopyDone(p []byte, copied bool) {
+	c.doneC <- zeroCopyDone{p, copied}
+}
+
+func (c *zeroCopyConn) waitDone(t *testing.T, p []byte) bool {
+	select {
+	case d := <-c.doneC:
+		if len(d.p) != len(p) || &d.p[0] != &p[0] {
+			t.Fatalf("done %d bytes, want the buffer of %d", len(d.p), len(p))
+		}
+		return d.copied
+	case <-time.After(5 * time.Second):
+		t.Fatalf("buffer of %d bytes not completed", len(p))
+	}
+	return false
+}
+
+func TestWriterZeroCopy(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, client := tcpServerFd(t)
+	c := &zeroCopyConn{doneC: make(chan zeroCopyDone, 4)}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	runIn(t, r, fd, func() { err = c.w.SetZeroCopy(64 << 10) })
+	if err != nil {
+		t.Skip(err) // kernel < 4.14
+	}
+
+	// below the threshold, copied at once
+	small := []byte("hello")
+	runIn(t, r, fd, func() { err = c.w.WriteZeroCopy(small) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.waitDone(t, small) {
+		t.Fatal("small buffer not copied")
+	}
+	got := make([]byte, len(small))
+	if _, err = io.ReadFull(client, got); err != nil || !bytes.Equal(got, small) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	// larger than the socket buffer: partially sent, the rest and the data written after it
+	// are flushed by OnWrite in order
+	big := make([]byte, 8<<20)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	tail := []byte("tail")
+	runIn(t, r, fd, func() {
+		if err = c.w.WriteZeroCopy(big); err == nil {
+			_, err = c.w.Write(tail)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readC := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, len(big)+len(tail))
+		n, _ := io.ReadFull(client, b)
+		readC <- b[:n]
+	}()
+	c.waitDone(t, big) // copied over loopback, still completed by the error queue
+	select {
+	case b := <-readC:
+		if !bytes.Equal(b[:len(big)], big) || string(b[len(big):]) != string(tail) {
+			t.Fatalf("read %d bytes differ", len(b))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("big buffer not received")
+	}
+	runIn(t, r, fd, func() {
+		if n := c.w.Buffered(); n != 0 {
+			t.Errorf("%d bytes buffered", n)
+		}
+		if n := len(c.w.zc.inflight); n != 0 {
+			t.Errorf("%d sends not completed", n)
+		}
+	})
+	select {
+	case d := <-c.doneC:
+		t.Fatalf("buffer of %d bytes completed twice", len(d.p))
+	default:
+	}
+}
+
+// chainZeroCopyConn sends next from OnZeroCopyDone of the first buffer
+type chainZeroCopyConn struct {
+	zeroCopyConn
+
+	next []byte
+	err  error
+}
+
+func (c *chainZeroCopyConn) OnZeroCopyDone(p []byte, copied bool) {
+	c.zeroCopyConn.OnZeroCopyDone(p, copied)
+	if next := c.next; next != nil {
+		c.next = nil
+		c.err = c.w.WriteZeroCopy(next)
+	}
+}
+
+func TestWriterZeroCopyFromDone(t *testing.T) {
+	r := newTestReactor(t, EvPollNum(1))
+	fd, client := tcpServerFd(t)
+	c := &chainZeroCopyConn{zeroCopyConn: zeroCopyConn{doneC: make(chan zeroCopyDone, 4)}}
+	c.w = NewWriter(c)
+	if err := r.AddEvHandler(c, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	runIn(t, r, fd, func() { err = c.w.SetZeroCopy(1 << 10) })
+	if err != nil {
+		t.Skip(err) // kernel < 4.14
+	}
+	first, second := bytes.Repeat([]byte("a"), 64<<10), bytes.Repeat([]byte("b"), 64<<10)
+	go io.Copy(io.Discard, client)
+	runIn(t, r, fd, func() {
+		c.next = second
+		err = c.w.WriteZeroCopy(first)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.waitDone(t, first)
+	c.waitDone(t, second) // its send was lost when appended within the completion loop
+	runIn(t, r, fd, func() { err = c.err })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterZeroCopyUnsupported(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	c := &zeroCopyConn{doneC: make(chan zeroCopyDone, 1)}
+	c.setFd(fds[0])
+	c.w = NewWriter(c)
+	if err = c.w.SetZeroCopy(1); err == nil {
+		t.Fatal("SO_ZEROCOPY set on a unix socket")
+	}
+}